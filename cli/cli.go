@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Command is a ccproxy subcommand such as `ccproxy stop`
+type Command struct {
+	Name  string
+	Usage string
+	Run   func(args []string) error
+}
+
+var commands = map[string]*Command{}
+
+func register(cmd *Command) {
+	commands[cmd.Name] = cmd
+}
+
+// Lookup returns the subcommand with the given name, or nil
+func Lookup(name string) *Command {
+	return commands[name]
+}
+
+// Main runs the subcommand and exits the process when it fails
+func (c *Command) Main(args []string) {
+	if err := c.Run(args); err != nil {
+		fmt.Fprintf(os.Stderr, "ccproxy %s: %v\n", c.Name, err)
+		os.Exit(1)
+	}
+}
+
+// PrintUsage lists the available subcommands after the server flags
+func PrintUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: ccproxy [flags]\n       ccproxy <command> [args]\n\nCommands:\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "  %-10s %s\n", name, commands[name].Usage)
+	}
+
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// newFlagSet creates the flag set for a subcommand
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("ccproxy "+name, flag.ExitOnError)
+}
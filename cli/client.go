@@ -0,0 +1,158 @@
+package cli
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"ccproxy/config"
+)
+
+// instance talks to the web API of a running ccproxy
+type instance struct {
+	config  *config.Config
+	baseURL string
+	token   string
+	tls     *tls.Config
+	client  *http.Client
+}
+
+// instanceFlags registers the flags shared by commands that talk to a running instance
+type instanceFlags struct {
+	configFile *string
+	addr       *string
+	token      *string
+	insecure   *bool
+}
+
+func addInstanceFlags(fs *flag.FlagSet) *instanceFlags {
+	return &instanceFlags{
+		configFile: fs.String("config", "config.yaml", "Configuration file of the running instance"),
+		addr:       fs.String("addr", "", "Web interface address (default from config)"),
+		token:      fs.String("token", "", "Admin token (default web.admin_token from config)"),
+		insecure:   fs.Bool("insecure", false, "Skip verification of the web TLS certificate, e.g. a self-signed one"),
+	}
+}
+
+// connect resolves the web address and admin token from the flags and configuration
+func (f *instanceFlags) connect() (*instance, error) {
+	cfg, err := config.LoadConfig(*f.configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	addr := *f.addr
+	if addr == "" {
+		addr = net.JoinHostPort(dialHost(cfg.Server.Host), cfg.Web.Port)
+	}
+	baseURL := addr
+	if !strings.Contains(baseURL, "://") {
+		scheme := "http://"
+		if cfg.Web.TLS.CertFile != "" {
+			scheme = "https://"
+		}
+		baseURL = scheme + baseURL
+	}
+
+	token := *f.token
+	if token == "" {
+		token = cfg.Web.AdminToken
+	}
+
+	return &instance{
+		config:  cfg,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		tls:     &tls.Config{InsecureSkipVerify: *f.insecure},
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: *f.insecure}},
+		},
+	}, nil
+}
+
+// dialHost turns a wildcard listen host into one that can be connected to
+func dialHost(host string) string {
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		return "127.0.0.1"
+	}
+	return host
+}
+
+func (i *instance) newRequest(method, path string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequest(method, i.baseURL+path, body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range i.authHeader() {
+		req.Header[k] = v
+	}
+	return req, nil
+}
+
+// authHeader carries the dashboard credentials from web.auth and the admin token
+func (i *instance) authHeader() http.Header {
+	header := http.Header{}
+	auth := i.config.Web.Auth
+	switch auth.Type {
+	case "basic":
+		request := &http.Request{Header: header}
+		request.SetBasicAuth(auth.Username, auth.Password)
+	case "bearer":
+		header.Set("Authorization", "Bearer "+auth.Token)
+	}
+
+	if i.token != "" {
+		if header.Get("Authorization") == "" {
+			header.Set("Authorization", "Bearer "+i.token)
+		} else {
+			header.Set("X-Admin-Token", i.token)
+		}
+	}
+	return header
+}
+
+// getJSON fetches path from the running instance and decodes the JSON response
+func (i *instance) getJSON(path string, v interface{}) error {
+	req, err := i.newRequest("GET", path, nil)
+	if err != nil {
+		return err
+	}
+	return i.doJSON(req, path, v)
+}
+
+// postJSON sends body as JSON to path and decodes the JSON response
+func (i *instance) postJSON(path string, body, v interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := i.newRequest("POST", path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return i.doJSON(req, path, v)
+}
+
+func (i *instance) doJSON(req *http.Request, path string, v interface{}) error {
+
+	resp, err := i.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("ccproxy is not reachable at %s: %w", i.baseURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned %s: %s", path, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+
+	"ccproxy/config"
+)
+
+func init() {
+	register(&Command{Name: "config", Usage: "Configuration tools: validate [file]", Run: runConfig})
+}
+
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: ccproxy config validate [file]")
+	}
+
+	switch args[0] {
+	case "validate":
+		return runConfigValidate(args[1:])
+	default:
+		return fmt.Errorf("unknown config command %q", args[0])
+	}
+}
+
+func runConfigValidate(args []string) error {
+	fs := newFlagSet("config validate")
+	fs.Parse(args)
+
+	filename := "config.yaml"
+	if fs.NArg() > 0 {
+		filename = fs.Arg(0)
+	}
+
+	cfg, err := config.ValidateFile(filename)
+	var errs config.ValidationErrors
+	if errors.As(err, &errs) {
+		for _, e := range errs {
+			location := filename
+			if e.Line > 0 {
+				location = fmt.Sprintf("%s:%d", filename, e.Line)
+			}
+			if e.Field != "" {
+				location += ": " + e.Field
+			}
+			fmt.Printf("%s: %s\n", location, e.Message)
+		}
+		return fmt.Errorf("%d problem(s) found", len(errs))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s is valid (%d targets)\n", filename, len(cfg.Proxy.Targets))
+	return nil
+}
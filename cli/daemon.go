@@ -0,0 +1,132 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DaemonEnv marks the background child started by Daemonize
+const DaemonEnv = "CCPROXY_DAEMON"
+
+// DefaultPidFile is used by -daemon, stop and restart when no pid file is given
+const DefaultPidFile = "ccproxy.pid"
+
+func init() {
+	register(&Command{Name: "stop", Usage: "Stop the daemon recorded in the pid file", Run: runStop})
+	register(&Command{Name: "restart", Usage: "Restart the daemon without dropping connections", Run: runRestart})
+}
+
+// IsDaemonChild reports whether this process is the background copy started by Daemonize
+func IsDaemonChild() bool {
+	return os.Getenv(DaemonEnv) != ""
+}
+
+// Daemonize starts the current command line again detached from the terminal,
+// with output appended to logFile, and returns the pid of the background process.
+func Daemonize(logFile string) (int, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return 0, fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	output, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer output.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), DaemonEnv+"=1")
+	cmd.Stdout = output
+	cmd.Stderr = output
+	cmd.SysProcAttr = detachedProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		return 0, fmt.Errorf("failed to start background process: %w", err)
+	}
+	pid := cmd.Process.Pid
+	return pid, cmd.Process.Release()
+}
+
+// WritePidFile records the current process id
+func WritePidFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())+"\n"), 0644)
+}
+
+// RemovePidFile deletes the pid file if it still belongs to this process,
+// so a process that handed over to a new binary leaves the new pid in place.
+func RemovePidFile(path string) {
+	if pid, err := readPidFile(path); err == nil && pid == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+func readPidFile(path string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return 0, fmt.Errorf("invalid pid file %s", path)
+	}
+	return pid, nil
+}
+
+func runStop(args []string) error {
+	fs := newFlagSet("stop")
+	pidFile := fs.String("pidfile", DefaultPidFile, "Pid file written by the daemon")
+	wait := fs.Duration("wait", 30*time.Second, "How long to wait for the process to exit, 0 to return immediately")
+	fs.Parse(args)
+
+	pid, err := readPidFile(*pidFile)
+	if err != nil {
+		return err
+	}
+	if !processAlive(pid) {
+		os.Remove(*pidFile)
+		return fmt.Errorf("process %d is not running, removed stale pid file", pid)
+	}
+
+	if err := terminateProcess(pid); err != nil {
+		return fmt.Errorf("failed to stop process %d: %w", pid, err)
+	}
+	fmt.Printf("Stopping ccproxy (pid %d)...\n", pid)
+
+	deadline := time.Now().Add(*wait)
+	for time.Now().Before(deadline) {
+		if !processAlive(pid) {
+			fmt.Println("ccproxy stopped")
+			return nil
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+	if *wait > 0 {
+		fmt.Printf("ccproxy is still draining in-flight requests after %v\n", *wait)
+	}
+	return nil
+}
+
+func runRestart(args []string) error {
+	fs := newFlagSet("restart")
+	pidFile := fs.String("pidfile", DefaultPidFile, "Pid file written by the daemon")
+	fs.Parse(args)
+
+	pid, err := readPidFile(*pidFile)
+	if err != nil {
+		return err
+	}
+	if !processAlive(pid) {
+		return fmt.Errorf("process %d is not running", pid)
+	}
+
+	if err := upgradeProcess(pid); err != nil {
+		return fmt.Errorf("failed to restart process %d: %w", pid, err)
+	}
+	fmt.Printf("Restarting ccproxy (pid %d), in-flight requests finish on the old process\n", pid)
+	return nil
+}
@@ -0,0 +1,24 @@
+//go:build !windows
+
+package cli
+
+import (
+	"syscall"
+)
+
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+func processAlive(pid int) bool {
+	return syscall.Kill(pid, 0) == nil
+}
+
+func terminateProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGTERM)
+}
+
+// upgradeProcess asks the server to hand its listeners over to a new process
+func upgradeProcess(pid int) error {
+	return syscall.Kill(pid, syscall.SIGUSR2)
+}
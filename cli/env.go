@@ -0,0 +1,33 @@
+package cli
+
+import (
+	"fmt"
+	"strings"
+
+	"ccproxy/config"
+)
+
+func init() {
+	register(&Command{Name: "env", Usage: "Print environment settings that point Claude Code at the proxy", Run: runEnv})
+}
+
+func runEnv(args []string) error {
+	fs := newFlagSet("env")
+	configFile := fs.String("config", "config.yaml", "Configuration file")
+	host := fs.String("host", "", "Host clients use to reach the proxy (default server.host, or localhost)")
+	token := fs.String("token", "", "Value for ANTHROPIC_AUTH_TOKEN")
+	format := fs.String("format", "shell", "Output format: "+strings.Join(config.EnvFormats, ", "))
+	fs.Parse(args)
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	out, err := config.FormatClientEnv(config.ClientEnv(cfg, *host, *token), *format)
+	if err != nil {
+		return err
+	}
+	fmt.Print(out)
+	return nil
+}
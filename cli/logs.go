@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+
+	"ccproxy/types"
+	"ccproxy/websocket"
+)
+
+func init() {
+	register(&Command{Name: "logs", Usage: "Print recent request logs of the running instance, -f to follow", Run: runLogs})
+}
+
+// logFilter selects which request logs are printed
+type logFilter struct {
+	statuses []string // exact codes ("404") or classes ("5xx")
+	path     string
+	target   string
+}
+
+func (f *logFilter) match(msg *types.LogMessage) bool {
+	if f.path != "" && !strings.Contains(msg.Path, f.path) {
+		return false
+	}
+	if f.target != "" && !strings.Contains(msg.TargetURL, f.target) {
+		return false
+	}
+	if len(f.statuses) == 0 {
+		return true
+	}
+	code := strconv.Itoa(msg.StatusCode)
+	for _, s := range f.statuses {
+		if s == code || (strings.HasSuffix(s, "xx") && len(s) == 3 && code[0] == s[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+func runLogs(args []string) error {
+	fs := newFlagSet("logs")
+	flags := addInstanceFlags(fs)
+	follow := fs.Bool("f", false, "Follow the log stream")
+	lines := fs.Int("n", 20, "Number of recent requests to print first (max 100)")
+	status := fs.String("status", "", "Only show these status codes or classes, e.g. 5xx,429")
+	path := fs.String("path", "", "Only show requests whose path contains this string")
+	target := fs.String("target", "", "Only show requests whose target URL contains this string")
+	asJSON := fs.Bool("json", false, "Print raw JSON log messages, one per line")
+	fs.Parse(args)
+
+	inst, err := flags.connect()
+	if err != nil {
+		return err
+	}
+
+	filter := &logFilter{path: *path, target: *target}
+	for _, s := range strings.Split(*status, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			filter.statuses = append(filter.statuses, s)
+		}
+	}
+
+	emit := func(msg *types.LogMessage) {
+		if !filter.match(msg) {
+			return
+		}
+		if *asJSON {
+			msg.Stats = nil
+			data, _ := json.Marshal(msg)
+			fmt.Println(string(data))
+			return
+		}
+		fmt.Println(formatLogLine(msg))
+	}
+
+	if *lines > 0 {
+		var history []*types.LogMessage
+		if err := inst.getJSON(fmt.Sprintf("/api/history?limit=%d", *lines), &history); err != nil {
+			return err
+		}
+		// History is newest first
+		for i := len(history) - 1; i >= 0; i-- {
+			emit(history[i])
+		}
+	}
+
+	if !*follow {
+		return nil
+	}
+
+	conn, err := websocket.Dial(strings.Replace(inst.baseURL, "http", "ws", 1)+"/ws", inst.authHeader(), inst.tls)
+	if err != nil {
+		return fmt.Errorf("failed to connect to log stream: %w", err)
+	}
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	stopped := make(chan struct{})
+	go func() {
+		<-interrupt
+		close(stopped)
+		conn.Close()
+	}()
+
+	for {
+		data, err := conn.ReadMessage()
+		if err != nil {
+			if err == io.EOF {
+				return fmt.Errorf("log stream closed by server")
+			}
+			select {
+			case <-stopped:
+				return nil
+			default:
+			}
+			return err
+		}
+
+		// Periodic stats pushes carry a type, log messages do not
+		var msg struct {
+			types.LogMessage
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil || msg.Type != "" {
+			continue
+		}
+		emit(&msg.LogMessage)
+	}
+}
+
+func formatLogLine(msg *types.LogMessage) string {
+	path := msg.Path
+	if msg.Query != "" {
+		path += "?" + msg.Query
+	}
+	line := fmt.Sprintf("%s %-6s %s %d %s", msg.Timestamp, msg.Method, path, msg.StatusCode, msg.Duration)
+	if msg.TargetURL != "" {
+		line += " -> " + msg.TargetURL
+	}
+	if msg.Error != "" {
+		line += " error: " + msg.Error
+	}
+	return line
+}
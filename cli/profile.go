@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+)
+
+func init() {
+	register(&Command{Name: "profile", Usage: "List profiles or switch the running instance: [list|use <name>]", Run: runProfile})
+}
+
+type profileResponse struct {
+	Profile  string   `json:"profile"`
+	Profiles []string `json:"profiles"`
+	Targets  int      `json:"targets"`
+}
+
+func runProfile(args []string) error {
+	command := "list"
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		command, args = args[0], args[1:]
+	}
+
+	fs := newFlagSet("profile " + command)
+	flags := addInstanceFlags(fs)
+	fs.Parse(args)
+
+	inst, err := flags.connect()
+	if err != nil {
+		return err
+	}
+
+	var resp profileResponse
+	switch command {
+	case "list":
+		if err := inst.getJSON("/api/admin/profile", &resp); err != nil {
+			return err
+		}
+		if len(resp.Profiles) == 0 {
+			fmt.Println("No profiles configured, using proxy.targets")
+			return nil
+		}
+		for _, name := range resp.Profiles {
+			marker := " "
+			if name == resp.Profile {
+				marker = "*"
+			}
+			fmt.Printf("%s %s\n", marker, name)
+		}
+		return nil
+
+	case "use":
+		if fs.NArg() != 1 {
+			return fmt.Errorf("usage: ccproxy profile use <name>, use \"\" for proxy.targets")
+		}
+		if err := inst.postJSON("/api/admin/profile", map[string]string{"profile": fs.Arg(0)}, &resp); err != nil {
+			return err
+		}
+		if resp.Profile == "" {
+			fmt.Printf("Using proxy.targets (%d targets)\n", resp.Targets)
+		} else {
+			fmt.Printf("Switched to profile %s (%d targets)\n", resp.Profile, resp.Targets)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown profile command %q", command)
+	}
+}
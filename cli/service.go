@@ -0,0 +1,122 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ccproxy/config"
+)
+
+func init() {
+	register(&Command{Name: "service", Usage: "Manage the system service: install, uninstall, start, stop", Run: runService})
+}
+
+// Service is the server as seen by a system service manager
+type Service interface {
+	Listen() error
+	Stop()
+}
+
+// serviceSpec describes the service registered by `ccproxy service install`
+type serviceSpec struct {
+	Name        string
+	Executable  string
+	ConfigFile  string
+	WorkDir     string
+	LogFile     string        // Output file, only used where the service manager does not capture it
+	User        bool          // Per-user service instead of a system-wide one (systemd/launchd)
+	StopTimeout time.Duration // How long the manager waits for draining before killing, 0 for no limit
+}
+
+func runService(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: ccproxy service install|uninstall|start|stop [flags]")
+	}
+
+	action := args[0]
+	fs := newFlagSet("service " + action)
+	name := fs.String("name", "ccproxy", "Service name")
+	user := fs.Bool("user", false, "Manage a per-user service (systemd --user, ~/Library/LaunchAgents)")
+	var configFile *string
+	if action == "install" {
+		configFile = fs.String("config", "config.yaml", "Configuration file the service runs with")
+	}
+	fs.Parse(args[1:])
+
+	switch action {
+	case "install":
+		spec, err := newServiceSpec(*name, *configFile, *user)
+		if err != nil {
+			return err
+		}
+		if err := installService(spec); err != nil {
+			return err
+		}
+		fmt.Printf("Service %s installed (config: %s, logs: %s)\n", spec.Name, spec.ConfigFile, spec.LogFile)
+		fmt.Printf("Start it with: ccproxy service start -name %s%s\n", spec.Name, userFlag(*user))
+	case "uninstall":
+		if err := uninstallService(*name, *user); err != nil {
+			return err
+		}
+		fmt.Printf("Service %s uninstalled\n", *name)
+	case "start":
+		if err := startService(*name, *user); err != nil {
+			return err
+		}
+		fmt.Printf("Service %s started\n", *name)
+	case "stop":
+		if err := stopService(*name, *user); err != nil {
+			return err
+		}
+		fmt.Printf("Service %s stopped\n", *name)
+	default:
+		return fmt.Errorf("unknown service command %q", action)
+	}
+	return nil
+}
+
+// newServiceSpec resolves absolute paths, since services do not start in the current directory
+func newServiceSpec(name, configFile string, user bool) (*serviceSpec, error) {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("failed to locate executable: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(executable); err == nil {
+		executable = resolved
+	}
+
+	configFile, err = filepath.Abs(configFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	// Give in-flight requests the configured drain window before the service manager kills the process
+	var stopTimeout time.Duration
+	if cfg.Server.Timeouts.Drain >= 0 {
+		stopTimeout = time.Duration(cfg.Server.Timeouts.Drain+cfg.Server.Timeouts.Shutdown+5) * time.Second
+	}
+
+	workDir := filepath.Dir(configFile)
+	return &serviceSpec{
+		Name:        name,
+		Executable:  executable,
+		ConfigFile:  configFile,
+		WorkDir:     workDir,
+		LogFile:     filepath.Join(workDir, "ccproxy.log"),
+		User:        user,
+		StopTimeout: stopTimeout,
+	}, nil
+}
+
+func userFlag(user bool) string {
+	if user {
+		return " -user"
+	}
+	return ""
+}
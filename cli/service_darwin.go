@@ -0,0 +1,134 @@
+package cli
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var launchdPlist = template.Must(template.New("plist").Funcs(template.FuncMap{"xml": xmlEscape}).Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{xml .Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+{{- range .Args}}
+		<string>{{xml .}}</string>
+{{- end}}
+	</array>
+	<key>WorkingDirectory</key>
+	<string>{{xml .WorkDir}}</string>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<dict>
+		<key>SuccessfulExit</key>
+		<false/>
+	</dict>
+	<key>ExitTimeOut</key>
+	<integer>{{.ExitTimeout}}</integer>
+	<key>StandardOutPath</key>
+	<string>{{xml .LogFile}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{xml .LogFile}}</string>
+</dict>
+</plist>
+`))
+
+func launchdLabel(name string) string {
+	return "com.ccproxy." + name
+}
+
+func plistPath(name string, user bool) (string, error) {
+	if !user {
+		return filepath.Join("/Library/LaunchDaemons", launchdLabel(name)+".plist"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", launchdLabel(name)+".plist"), nil
+}
+
+func launchctl(args ...string) error {
+	out, err := exec.Command("launchctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("launchctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// installService writes the launchd job. It is loaded, and so started and
+// enabled at boot/login, by `service start`.
+func installService(spec *serviceSpec) error {
+	path, err := plistPath(spec.Name, spec.User)
+	if err != nil {
+		return err
+	}
+
+	// launchd captures stdout/stderr itself, so the log file flag is not passed on
+	args := []string{spec.Executable, "-config", spec.ConfigFile}
+	exitTimeout := 0
+	if spec.StopTimeout > 0 {
+		exitTimeout = int(spec.StopTimeout.Seconds())
+	}
+
+	var plist strings.Builder
+	launchdPlist.Execute(&plist, map[string]interface{}{
+		"Label":       launchdLabel(spec.Name),
+		"Args":        args,
+		"WorkDir":     spec.WorkDir,
+		"ExitTimeout": exitTimeout,
+		"LogFile":     spec.LogFile,
+	})
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(plist.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write launchd plist: %w", err)
+	}
+	return nil
+}
+
+func uninstallService(name string, user bool) error {
+	path, err := plistPath(name, user)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+
+	launchctl("unload", "-w", path)
+	return os.Remove(path)
+}
+
+func startService(name string, user bool) error {
+	path, err := plistPath(name, user)
+	if err != nil {
+		return err
+	}
+	return launchctl("load", "-w", path)
+}
+
+// stopService unloads the job, as a loaded KeepAlive job would be restarted by launchd
+func stopService(name string, user bool) error {
+	path, err := plistPath(name, user)
+	if err != nil {
+		return err
+	}
+	return launchctl("unload", path)
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,121 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+var systemdUnit = template.Must(template.New("unit").Parse(`[Unit]
+Description=Claude Code Proxy ({{.Name}})
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=simple
+NotifyAccess=all
+ExecStart={{.ExecStart}}
+ExecReload=/bin/kill -HUP $MAINPID
+WorkingDirectory={{.WorkDir}}
+Restart=on-failure
+RestartSec=5
+TimeoutStopSec={{.TimeoutStop}}
+
+[Install]
+WantedBy={{.WantedBy}}
+`))
+
+func unitPath(name string, user bool) (string, error) {
+	if !user {
+		return filepath.Join("/etc/systemd/system", name+".service"), nil
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user", name+".service"), nil
+}
+
+func systemctl(user bool, args ...string) error {
+	if user {
+		args = append([]string{"--user"}, args...)
+	}
+	out, err := exec.Command("systemctl", args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("systemctl %s: %v: %s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+func installService(spec *serviceSpec) error {
+	path, err := unitPath(spec.Name, spec.User)
+	if err != nil {
+		return err
+	}
+
+	// Output goes to the journal
+	execStart := []string{systemdQuote(spec.Executable), "-config", systemdQuote(spec.ConfigFile)}
+	timeoutStop := "infinity"
+	if spec.StopTimeout > 0 {
+		timeoutStop = fmt.Sprintf("%d", int(spec.StopTimeout.Seconds()))
+	}
+	wantedBy := "multi-user.target"
+	if spec.User {
+		wantedBy = "default.target"
+	}
+
+	var unit strings.Builder
+	systemdUnit.Execute(&unit, map[string]string{
+		"Name":        spec.Name,
+		"ExecStart":   strings.Join(execStart, " "),
+		"WorkDir":     spec.WorkDir,
+		"TimeoutStop": timeoutStop,
+		"WantedBy":    wantedBy,
+	})
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(unit.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write unit file: %w", err)
+	}
+	if err := systemctl(spec.User, "daemon-reload"); err != nil {
+		return err
+	}
+	return systemctl(spec.User, "enable", spec.Name+".service")
+}
+
+func uninstallService(name string, user bool) error {
+	path, err := unitPath(name, user)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+
+	systemctl(user, "disable", "--now", name+".service")
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	return systemctl(user, "daemon-reload")
+}
+
+func startService(name string, user bool) error {
+	return systemctl(user, "start", name+".service")
+}
+
+func stopService(name string, user bool) error {
+	return systemctl(user, "stop", name+".service")
+}
+
+// systemdQuote quotes an ExecStart argument containing spaces or quotes
+func systemdQuote(s string) string {
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,24 @@
+//go:build !linux && !darwin && !windows
+
+package cli
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func installService(spec *serviceSpec) error {
+	return fmt.Errorf("service management is not supported on %s", runtime.GOOS)
+}
+
+func uninstallService(name string, user bool) error {
+	return fmt.Errorf("service management is not supported on %s", runtime.GOOS)
+}
+
+func startService(name string, user bool) error {
+	return fmt.Errorf("service management is not supported on %s", runtime.GOOS)
+}
+
+func stopService(name string, user bool) error {
+	return fmt.Errorf("service management is not supported on %s", runtime.GOOS)
+}
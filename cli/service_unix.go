@@ -0,0 +1,16 @@
+//go:build !windows
+
+package cli
+
+import "fmt"
+
+// IsService reports whether the process was started by the Windows service manager
+func IsService() bool {
+	return false
+}
+
+// RunService serves srv under the Windows service manager. systemd and
+// launchd run the server as a normal process, so it is not used elsewhere.
+func RunService(srv Service) error {
+	return fmt.Errorf("not running under the Windows service manager")
+}
@@ -0,0 +1,135 @@
+package cli
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// IsService reports whether the process was started by the Windows service manager
+func IsService() bool {
+	isService, err := svc.IsWindowsService()
+	return err == nil && isService
+}
+
+// RunService serves srv until the service manager asks it to stop
+func RunService(srv Service) error {
+	return svc.Run("ccproxy", &serviceHandler{srv: srv})
+}
+
+type serviceHandler struct {
+	srv Service
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	if err := h.srv.Listen(); err != nil {
+		log.Printf("[ERROR] Server failed to start: %v", err)
+		return true, 1
+	}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+
+	for request := range requests {
+		switch request.Cmd {
+		case svc.Interrogate:
+			status <- request.CurrentStatus
+		case svc.Stop, svc.Shutdown:
+			status <- svc.Status{State: svc.StopPending}
+			h.srv.Stop()
+			return false, 0
+		}
+	}
+	return false, 0
+}
+
+// installService registers the service. The service manager starts it in
+// System32, -workdir moves it next to the config so ./data resolves there.
+func installService(spec *serviceSpec) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(spec.Name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", spec.Name)
+	}
+
+	s, err := m.CreateService(spec.Name, spec.Executable, mgr.Config{
+		DisplayName: "Claude Code Proxy (" + spec.Name + ")",
+		Description: "Proxy for Claude Code API requests",
+		StartType:   mgr.StartAutomatic,
+	}, "-config", spec.ConfigFile, "-logfile", spec.LogFile, "-workdir", spec.WorkDir)
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	return s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+	}, 86400)
+}
+
+func uninstallService(name string, user bool) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	s.Control(svc.Stop)
+	return s.Delete()
+}
+
+func startService(name string, user bool) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	return s.Start()
+}
+
+func stopService(name string, user bool) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed: %w", name, err)
+	}
+	defer s.Close()
+
+	current, err := s.Control(svc.Stop)
+	if err != nil {
+		return fmt.Errorf("failed to stop service: %w", err)
+	}
+	// Wait while in-flight requests drain
+	for current.State != svc.Stopped {
+		time.Sleep(500 * time.Millisecond)
+		if current, err = s.Query(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
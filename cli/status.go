@@ -0,0 +1,115 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"ccproxy/web"
+)
+
+func init() {
+	register(&Command{Name: "status", Usage: "Show whether the running instance is up, its targets and health", Run: runStatus})
+}
+
+func runStatus(args []string) error {
+	fs := newFlagSet("status")
+	flags := addInstanceFlags(fs)
+	fs.Parse(args)
+
+	inst, err := flags.connect()
+	if err != nil {
+		return err
+	}
+
+	var status web.Status
+	statusErr := inst.getJSON("/api/admin/status", &status)
+
+	proxyAddr := net.JoinHostPort(dialHost(inst.config.Server.Host), inst.config.Server.Port)
+	if statusErr == nil {
+		proxyAddr = status.ProxyAddr
+	}
+
+	out := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(out, "Proxy server:\t%s\n", reachability(proxyAddr))
+	if statusErr != nil {
+		fmt.Fprintf(out, "Web server:\tunavailable (%v)\n", statusErr)
+		out.Flush()
+		return fmt.Errorf("ccproxy is not running or the admin API is not accessible")
+	}
+
+	fmt.Fprintf(out, "Web server:\trunning on %s\n", status.WebAddr)
+	fmt.Fprintf(out, "Version:\t%s\n", status.Version.Version)
+	fmt.Fprintf(out, "Uptime:\t%s\n", status.Uptime)
+	fmt.Fprintf(out, "Config file:\t%s\n", status.ConfigFile)
+	if status.Config != nil && status.Config.Profile != "" {
+		fmt.Fprintf(out, "Profile:\t%s\n", status.Config.Profile)
+	}
+	if status.Draining {
+		fmt.Fprintf(out, "Draining:\tyes (%d in-flight requests)\n", status.Active)
+	} else {
+		fmt.Fprintf(out, "Active requests:\t%d\n", status.Active)
+	}
+	if status.Paused {
+		fmt.Fprintf(out, "Request logging:\tpaused\n")
+	}
+	if status.Stats != nil {
+		fmt.Fprintf(out, "Requests:\ttotal %d, success %d, errors %d\n",
+			status.Stats.TotalRequests, status.Stats.SuccessRequests, status.Stats.ErrorRequests)
+	}
+	out.Flush()
+
+	// Targets are missing when the server sends no config or, before the
+	// summary, the full config
+	if status.Config == nil || status.Config.Targets == nil {
+		fmt.Println("\nTargets: unknown")
+		return nil
+	}
+	fmt.Println("\nTargets:")
+	out = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	for _, target := range status.Config.Targets {
+		if target.Maintenance {
+			fmt.Fprintf(out, "  %s\t%s\tmaintenance\n", target.Route, methodList(target.Methods))
+		} else {
+			fmt.Fprintf(out, "  %s\t%s\n", target.Route, methodList(target.Methods))
+		}
+		urls := append([]string(nil), target.URLs...)
+		sort.Strings(urls)
+		for _, u := range urls {
+			health, ok := status.Upstreams[u]
+			if !ok {
+				fmt.Fprintf(out, "    %s\tunknown\t\t\n", u)
+				continue
+			}
+			state := "healthy"
+			if !health.IsHealthy {
+				state = "unhealthy"
+			}
+			if health.Override != "" {
+				state += ", manual " + health.Override
+			}
+			if health.ChecksDisabled {
+				state += ", checks off"
+			}
+			rate := 0.0
+			if health.TotalChecks > 0 {
+				rate = float64(health.SuccessChecks) / float64(health.TotalChecks) * 100
+			}
+			fmt.Fprintf(out, "    %s\t%s\tavg %v\tsuccess %.1f%%\tchecked %s ago\n",
+				u, state, health.AverageTime.Round(time.Millisecond), rate, time.Since(health.LastCheck).Round(time.Second))
+		}
+	}
+	return out.Flush()
+}
+
+func reachability(addr string) string {
+	conn, err := net.DialTimeout("tcp", addr, 2*time.Second)
+	if err != nil {
+		return fmt.Sprintf("not reachable on %s", addr)
+	}
+	conn.Close()
+	return fmt.Sprintf("running on %s", addr)
+}
@@ -0,0 +1,183 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"ccproxy/config"
+	"ccproxy/proxy"
+)
+
+func init() {
+	register(&Command{Name: "test", Usage: "Show how a request path is routed and send a test request: test [flags] [path]", Run: runTest})
+}
+
+func runTest(args []string) error {
+	fs := newFlagSet("test")
+	configFile := fs.String("config", "config.yaml", "Configuration file")
+	method := fs.String("method", "POST", "Request method used for routing and the test call")
+	host := fs.String("host", "", "Host used for routing to targets with a host, a full URL as path sets it as well")
+	var extraHeaders headerFlags
+	fs.Var(&extraHeaders, "H", `Extra request header "Name: value" used for routing and the test call, repeatable`)
+	model := fs.String("model", "claude-3-5-haiku-latest", "Model used in the test /v1/messages body")
+	apiKey := fs.String("api-key", "", "API key sent as x-api-key (default $ANTHROPIC_API_KEY, or $ANTHROPIC_AUTH_TOKEN as bearer token)")
+	noCall := fs.Bool("no-call", false, "Only resolve routing and check health, do not send the test request")
+	timeout := fs.Duration("timeout", 60*time.Second, "Timeout for the test request")
+	verbose := fs.Bool("v", false, "Show proxy log output")
+	fs.Parse(args)
+
+	path := "/v1/messages"
+	if fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	requestURL, err := url.Parse(path)
+	if err != nil {
+		return fmt.Errorf("invalid path %q: %w", path, err)
+	}
+	if *host != "" {
+		requestURL.Host = *host
+		if requestURL.Scheme == "" {
+			requestURL.Scheme = "http"
+		}
+	}
+
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("anthropic-version", "2023-06-01")
+	switch {
+	case *apiKey != "":
+		header.Set("x-api-key", *apiKey)
+	case os.Getenv("ANTHROPIC_API_KEY") != "":
+		header.Set("x-api-key", os.Getenv("ANTHROPIC_API_KEY"))
+	case os.Getenv("ANTHROPIC_AUTH_TOKEN") != "":
+		header.Set("Authorization", "Bearer "+os.Getenv("ANTHROPIC_AUTH_TOKEN"))
+	}
+
+	for _, h := range extraHeaders {
+		name, value, ok := strings.Cut(h, ":")
+		if !ok {
+			return fmt.Errorf("invalid header %q, expected \"Name: value\"", h)
+		}
+		header.Add(strings.TrimSpace(name), strings.TrimSpace(value))
+	}
+
+	cfg, err := config.LoadConfig(*configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	if !*verbose {
+		log.SetOutput(io.Discard)
+	}
+	handler := proxy.NewProxyHandler(cfg)
+	defer handler.Close()
+
+	// Routing does not depend on health, find the target before checking it
+	route, err := handler.Resolve(*method, requestURL, header)
+	if err != nil {
+		return err
+	}
+	target := route.Target
+
+	fmt.Printf("Request:   %s %s\n", *method, requestURL)
+	fmt.Printf("Target:    %s (methods: %s)\n", target.Route(), methodList(target.Methods))
+	if target.Response != nil {
+		fmt.Println("Response:  static, no upstream is contacted")
+		return nil
+	}
+	if target.Redirect != nil {
+		fmt.Printf("Redirect:  %s, no upstream is contacted\n", target.Redirect.Location)
+		return nil
+	}
+
+	fmt.Println("\nHealth:")
+	checker := handler.GetHealthChecker()
+	for _, u := range target.TargetURLs {
+		health := checker.CheckNow(u, &target)
+		state := "healthy"
+		if !health.IsHealthy {
+			state = "unhealthy"
+		}
+		fmt.Printf("  %-40s %-9s %v\n", u, state, health.ResponseTime.Round(time.Millisecond))
+	}
+
+	// Resolve again so the selection uses the fresh health data
+	route, err = handler.Resolve(*method, requestURL, header)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("\nSelected:  %s\n", route.Target.TargetURL)
+	fmt.Printf("Upstream:  %s\n", route.TargetURL)
+
+	if *noCall {
+		return nil
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"model":      *model,
+		"max_tokens": 16,
+		"messages": []map[string]string{
+			{"role": "user", "content": "ping"},
+		},
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+
+	fmt.Printf("\nTest call: %s %s\n", *method, route.TargetURL)
+	result, err := handler.Probe(ctx, route, *method, header, body)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("  Status:        %d %s\n", result.StatusCode, http.StatusText(result.StatusCode))
+	if result.ConnectionReused {
+		fmt.Println("  Connection:    reused")
+	} else {
+		fmt.Printf("  DNS lookup:    %v\n", result.DNSLookup.Round(time.Microsecond))
+		fmt.Printf("  Connect:       %v\n", result.Connect.Round(time.Microsecond))
+		if result.TLSHandshake > 0 {
+			fmt.Printf("  TLS handshake: %v\n", result.TLSHandshake.Round(time.Microsecond))
+		}
+	}
+	fmt.Printf("  First byte:    %v\n", result.FirstByte.Round(time.Microsecond))
+	fmt.Printf("  Total:         %v\n", result.Total.Round(time.Microsecond))
+
+	response := strings.TrimSpace(string(result.Body))
+	if len(response) > 500 {
+		response = response[:500] + "..."
+	}
+	fmt.Printf("  Response:      %s\n", response)
+
+	if result.StatusCode >= 400 {
+		return fmt.Errorf("test call failed with status %d", result.StatusCode)
+	}
+	return nil
+}
+
+func methodList(methods []string) string {
+	if len(methods) == 0 {
+		return "ANY"
+	}
+	return strings.Join(methods, ",")
+}
+
+// headerFlags collects repeated -H flags
+type headerFlags []string
+
+func (h *headerFlags) String() string {
+	return strings.Join(*h, ", ")
+}
+
+func (h *headerFlags) Set(value string) error {
+	*h = append(*h, value)
+	return nil
+}
@@ -0,0 +1,29 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"ccproxy/version"
+)
+
+func init() {
+	register(&Command{Name: "version", Usage: "Print version, commit and build date", Run: runVersion})
+}
+
+func runVersion(args []string) error {
+	fs := newFlagSet("version")
+	asJSON := fs.Bool("json", false, "Print as JSON")
+	fs.Parse(args)
+
+	info := version.Get()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(info)
+	}
+
+	fmt.Println(info)
+	return nil
+}
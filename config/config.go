@@ -1,8 +1,6 @@
 package config
 
 import (
-	"gopkg.in/yaml.v2"
-	"os"
 	"strings"
 )
 
@@ -15,59 +13,356 @@ type Config struct {
 			Write    int `yaml:"write"`
 			Idle     int `yaml:"idle"`
 			Shutdown int `yaml:"shutdown"`
+			Drain    int `yaml:"drain"` // Seconds to wait for in-flight requests on shutdown, -1 waits indefinitely
 		} `yaml:"timeouts"`
 	} `yaml:"server"`
 
 	Web struct {
-		Port    string `yaml:"port"`
-		Enabled bool   `yaml:"enabled"`
-		MaxLogs int    `yaml:"max_logs"`
+		Port       string  `yaml:"port"`
+		Enabled    bool    `yaml:"enabled"`
+		MaxLogs    int     `yaml:"max_logs"`
+		ReadOnly   bool    `yaml:"read_only"`   // Disable config saving and clearing history from the dashboard
+		AdminToken string  `yaml:"admin_token"` // Bearer token for /api/admin/*, loopback only when empty
+		Auth       WebAuth `yaml:"auth"`
+		TLS        struct {
+			CertFile string `yaml:"cert_file"` // PEM certificate (chain) for HTTPS, plain HTTP when empty
+			KeyFile  string `yaml:"key_file"`
+		} `yaml:"tls"`
+		CORS WebCORS `yaml:"cors"`
 	} `yaml:"web"`
 
 	Proxy struct {
 		Targets    []ProxyTarget `yaml:"targets"`
-		Timeout    int           `yaml:"timeout"`
+		Timeout    int           `yaml:"timeout"` // Seconds to wait for upstream response headers, unlimited when 0
 		MaxRetries int           `yaml:"max_retries"`
 		RetryDelay int           `yaml:"retry_delay"` // milliseconds
 		HTTPProxy  string        `yaml:"http_proxy"`  // Global HTTP proxy
+		Fallback   *ProxyTarget  `yaml:"fallback"`    // Serves requests no target matches, on any host and path
+
+		PassiveFailures int   `yaml:"passive_failures"` // Consecutive failed requests marking an upstream URL unhealthy before its next check, defaults to 3, disabled when negative
+		HealthCheck     *bool `yaml:"health_check"`     // Periodic health checks of upstream URLs, default true, health_check of a target takes precedence
+
+		Profile  string                  `yaml:"profile"`  // Active entry of profiles, proxy.targets when empty
+		Profiles map[string]ProxyProfile `yaml:"profiles"` // Named target sets that can be switched at runtime
+		defaults ProxyProfile            // proxy.targets and http_proxy as configured
 	} `yaml:"proxy"`
 
 	Logging struct {
-		Level string `yaml:"level"`
-		File  string `yaml:"file"`
+		Level          string        `yaml:"level"`
+		File           string        `yaml:"file"`             // JSON access log, one line per request without bodies
+		FileMaxSize    int           `yaml:"file_max_size"`    // Rotate the access log at this size in MB, default 100
+		FileMaxAge     int           `yaml:"file_max_age"`     // Rotate the access log after this many hours, 0 disables
+		FileMaxBackups int           `yaml:"file_max_backups"` // Rotated access logs kept, default 5
+		RedactHeaders  []string      `yaml:"redact_headers"`   // Masked in logged headers besides Authorization, X-Api-Key and cookies, "X-Secret-*" matches a prefix
+		RedactPatterns []string      `yaml:"redact_patterns"`  // Regular expressions masked in logged request and response bodies
+		MaxBodyBytes   int           `yaml:"max_body_bytes"`   // Logged bodies are truncated to this size, unlimited when 0. Relayed bodies are never cut
+		SampleRate     *float64      `yaml:"sample_rate"`      // Fraction (0-1) of requests logged with bodies, the rest as metadata. All when unset
+		ExcludePaths   []string      `yaml:"exclude_paths"`    // "[METHOD ]glob" or "[METHOD ]~regex", matching requests are never logged
+		Syslog         Syslog        `yaml:"syslog"`
+		Exporters      []LogExporter `yaml:"exporters"` // Push request logs to Loki or Elasticsearch/OpenSearch
 	} `yaml:"logging"`
 
+	History struct {
+		Backend         string         `yaml:"backend"`           // "jsonl" (rotated files, default) or "bolt" (one bbolt database), changes need a restart
+		MaxAgeDays      int            `yaml:"max_age_days"`      // Records older than this are pruned, kept forever when 0
+		MaxTotalSizeMB  int            `yaml:"max_total_size_mb"` // Oldest records are pruned while all history files are larger, unlimited when 0
+		BlobThresholdKB int            `yaml:"blob_threshold_kb"` // Larger bodies are kept in separate blob files, defaults to 64
+		Archive         HistoryArchive `yaml:"archive"`
+	} `yaml:"history"`
+
 	WebSocket struct {
-		BufferSize    int `yaml:"buffer_size"`
-		BroadcastSize int `yaml:"broadcast_size"`
+		BufferSize      int `yaml:"buffer_size"`
+		BroadcastSize   int `yaml:"broadcast_size"`
+		StatsInterval   int `yaml:"stats_interval"`    // Seconds between stats pushed to the dashboard, defaults to 5, disabled when negative
+		MaxMessageBytes int `yaml:"max_message_bytes"` // Live log messages above this size have their bodies truncated, defaults to 1 MiB, unlimited when negative
 	} `yaml:"websocket"`
+
+	Notifications Notifications `yaml:"notifications"`
+	Reports       Reports       `yaml:"reports"`
+
+	FilePath string `yaml:"-"` // File the configuration was loaded from (internal use)
+}
+
+// HistoryArchive uploads rotated history files to an S3-compatible bucket
+type HistoryArchive struct {
+	Bucket          string `yaml:"bucket"`   // Archiving is disabled when empty
+	Endpoint        string `yaml:"endpoint"` // Defaults to AWS, e.g. https://<account>.r2.cloudflarestorage.com or http://minio:9000
+	Region          string `yaml:"region"`   // Defaults to us-east-1
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"` // Temporary credentials
+	PathStyle       bool   `yaml:"path_style"`    // endpoint/bucket/key instead of bucket.endpoint/key, needed by MinIO
+	Prefix          string `yaml:"prefix"`        // Prepended to object keys, e.g. "laptop/"
+	DeleteLocal     bool   `yaml:"delete_local"`  // Remove history files once uploaded
+}
+
+// Syslog sends logs to the local syslog daemon or a remote collector
+type Syslog struct {
+	Address  string   `yaml:"address"`  // "local", udp://host:514 or tcp://host:601 (RFC 5424), disabled when empty
+	Facility string   `yaml:"facility"` // Defaults to local0
+	Tag      string   `yaml:"tag"`      // Application name, defaults to ccproxy
+	Logs     []string `yaml:"logs"`     // "access" (one entry per request) and/or "errors" ([WARN] and [ERROR] lines), both by default
+}
+
+// LogExporter pushes request logs in batches to a log store
+type LogExporter struct {
+	Type          string            `yaml:"type"`     // "loki" or "elasticsearch" (also OpenSearch)
+	URL           string            `yaml:"url"`      // Base URL, the push or _bulk path is added when missing
+	Username      string            `yaml:"username"` // Basic auth
+	Password      string            `yaml:"password"`
+	Token         string            `yaml:"token"`          // Sent as a Bearer token
+	Headers       map[string]string `yaml:"headers"`        // Extra request headers, e.g. X-Scope-OrgID for Loki tenants
+	Labels        map[string]string `yaml:"labels"`         // Loki stream labels, defaults to job=ccproxy
+	Index         string            `yaml:"index"`          // Elasticsearch index, defaults to ccproxy. Time layouts in {}: "ccproxy-{2006.01.02}"
+	Bodies        bool              `yaml:"bodies"`         // Include request and response bodies
+	BatchSize     int               `yaml:"batch_size"`     // Entries per push, default 100
+	FlushInterval int               `yaml:"flush_interval"` // Seconds between pushes of a partial batch, default 5
+}
+
+// Notifications posts proxy events to chat or other webhooks, e.g. for a
+// team sharing one instance
+type Notifications struct {
+	Webhooks    []Webhook `yaml:"webhooks"`
+	ErrorRate   float64   `yaml:"error_rate"`   // Error rate (0-1) over window minutes that sends error_rate, default 0.5, disabled when negative
+	MinRequests int64     `yaml:"min_requests"` // The error rate is not checked with fewer requests in the window, default 10
+	Window      int       `yaml:"window"`       // Minutes of requests the error rate covers, default 5
+}
+
+// Webhook receives notifications as a chat message or as a JSON document
+type Webhook struct {
+	Type     string            `yaml:"type"`     // "slack", "discord", "telegram" or "json" (the event as is)
+	URL      string            `yaml:"url"`      // Incoming webhook URL, https://api.telegram.org/bot<token>/sendMessage for Telegram
+	ChatID   string            `yaml:"chat_id"`  // Telegram chat receiving the messages
+	Events   []string          `yaml:"events"`   // Events sent to this webhook, all when empty, see NotificationEvents
+	Template string            `yaml:"template"` // Go template of the message text, or of the whole body for json, e.g. "{{.Instance}}: {{.Message}}"
+	Headers  map[string]string `yaml:"headers"`  // Extra request headers
+}
+
+// NotificationEvents are the events a webhook can subscribe to
+var NotificationEvents = []string{"start", "stop", "target_down", "target_up", "error_rate", "error_rate_resolved", "report"}
+
+// Reports summarizes the request history of each day or week, written as
+// JSON and Markdown files
+type Reports struct {
+	Daily     bool                  `yaml:"daily"`      // Report on the previous day after midnight (local time)
+	Weekly    bool                  `yaml:"weekly"`     // Report on the previous week, Monday to Sunday
+	Dir       string                `yaml:"dir"`        // Defaults to reports/ in the data directory
+	Notify    bool                  `yaml:"notify"`     // Post the summary to notifications.webhooks as the report event
+	TopErrors int                   `yaml:"top_errors"` // Most frequent errors listed, default 5
+	Prices    map[string]TokenPrice `yaml:"prices"`     // USD per million tokens by model name prefix, e.g. claude-sonnet-4. No cost without a price
+}
+
+// TokenPrice is the price of a model in USD per million tokens
+type TokenPrice struct {
+	Input      float64 `yaml:"input"`
+	Output     float64 `yaml:"output"`
+	CacheWrite float64 `yaml:"cache_write"`
+	CacheRead  float64 `yaml:"cache_read"`
+}
+
+// SyslogFacilities maps facility names to their codes, RFC 5424 section 6.2.1
+var SyslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// Streams reports which logs are sent to syslog
+func (s *Syslog) Streams() (access, errors bool) {
+	if len(s.Logs) == 0 {
+		return true, true
+	}
+	for _, name := range s.Logs {
+		access = access || name == "access"
+		errors = errors || name == "errors"
+	}
+	return access, errors
+}
+
+// WebAuth protects the dashboard, its API and the WebSocket
+type WebAuth struct {
+	Type     string `yaml:"type"` // "" (disabled), "basic" or "bearer"
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	Token    string `yaml:"token"`
+}
+
+// WebCORS lets pages from other origins use /api/* and /ws
+type WebCORS struct {
+	AllowedOrigins   []string `yaml:"allowed_origins"` // Exact origins such as https://dash.example.com, or "*"
+	AllowedMethods   []string `yaml:"allowed_methods"` // Defaults to GET, POST, DELETE, OPTIONS
+	AllowedHeaders   []string `yaml:"allowed_headers"` // Defaults to Authorization, Content-Type, X-Admin-Token
+	AllowCredentials bool     `yaml:"allow_credentials"`
+	MaxAge           int      `yaml:"max_age"` // Seconds browsers may cache preflight results
 }
 
 type ProxyTarget struct {
-	Path             string            `yaml:"path"`
-	TargetURL        string            `yaml:"target_url"`        // Supports comma-separated URLs
-	TargetURLs       []string          // Parsed URLs from TargetURL (internal use)
-	HealthCheckPath  string            `yaml:"health_check_path"` // Health check endpoint
-	HealthCheckDelay int               `yaml:"health_check_delay"` // Health check interval in seconds
-	Methods          []string          `yaml:"methods"`
-	Headers          map[string]string `yaml:"headers"`
-	HTTPProxy        string            `yaml:"http_proxy"` // Target-specific HTTP proxy
+	Path               string             `yaml:"path"`
+	PathRegex          string             `yaml:"path_regex"`           // Matched against the request path instead of path
+	Host               string             `yaml:"host"`                 // Only match requests for this host, "*.example.com" matches subdomains
+	Priority           int                `yaml:"priority"`             // Higher priorities are matched first, see proxy route ordering
+	MatchHeaders       map[string]string  `yaml:"match_headers"`        // Request headers that must match: value, "prefix*", "*" (present) or "~regex"
+	TargetURL          string             `yaml:"target_url"`           // Supports comma-separated URLs
+	TargetURLs         []string           `yaml:"-"`                    // Parsed URLs from TargetURL (internal use)
+	HealthCheck        *bool              `yaml:"health_check"`         // Periodic health checks of the URLs, proxy.health_check when unset
+	HealthCheckPath    string             `yaml:"health_check_path"`    // Health check endpoint
+	HealthCheckDelay   int                `yaml:"health_check_delay"`   // Health check interval in seconds
+	HealthCheckMethod  string             `yaml:"health_check_method"`  // GET by default
+	HealthCheckHeaders map[string]string  `yaml:"health_check_headers"` // Sent with health checks, e.g. an API key through {{env "KEY"}}
+	HealthCheckBody    string             `yaml:"health_check_body"`    // Request body of health checks
+	HealthCheckExpect  *HealthCheckExpect `yaml:"health_check_expect"`  // What a healthy response looks like
+	HealthCheckTimeout int                `yaml:"health_check_timeout"` // Seconds each health check request may take, default 3
+	UnhealthyThreshold int                `yaml:"unhealthy_threshold"`  // Consecutive failed checks before a URL is unhealthy, default 1
+	HealthyThreshold   int                `yaml:"healthy_threshold"`    // Consecutive successful checks before an unhealthy URL is healthy again, default 1
+	SlowStart          int                `yaml:"slow_start"`           // Seconds over which a recovered URL ramps up to its full share of requests, 0 disables
+	OutlierDetection   *OutlierDetection  `yaml:"outlier_detection"`    // Eject URLs failing or slower than their peers in real traffic
+	Methods            []string           `yaml:"methods"`
+	Headers            map[string]string  `yaml:"headers"`
+	RemoveHeaders      []string           `yaml:"remove_headers"`      // Client headers never forwarded, "X-Stainless-*" matches a prefix
+	HostOverride       string             `yaml:"host_override"`       // Host header and TLS server name sent upstream
+	StripPrefix        string             `yaml:"strip_prefix"`        // Removed from the request path before rewrite
+	Rewrite            []RewriteRule      `yaml:"rewrite"`             // Path rewrites, the first matching rule applies
+	AddPrefix          string             `yaml:"add_prefix"`          // Prepended to the path after rewrite
+	QueryParams        map[string]string  `yaml:"query_params"`        // Query parameters added or overridden upstream
+	RemoveQueryParams  []string           `yaml:"remove_query_params"` // Client query parameters never forwarded, "debug*" matches a prefix
+	BodyTransforms     []BodyTransform    `yaml:"body_transforms"`     // JSON request body changes, applied in order
+	Response           *StaticResponse    `yaml:"response"`            // Answer directly instead of proxying, no target_url
+	Redirect           *Redirect          `yaml:"redirect"`            // Redirect clients instead of proxying, no target_url
+	Maintenance        *Maintenance       `yaml:"maintenance"`         // Refuse requests with 503 while enabled
+	Logging            string             `yaml:"logging"`             // Dashboard logging: full (default), metadata (no bodies) or none
+	SampleRate         *float64           `yaml:"sample_rate"`         // Overrides logging.sample_rate
+	Timeout            *int               `yaml:"timeout"`             // Overrides proxy.timeout, 0 waits indefinitely
+	MaxRetries         *int               `yaml:"max_retries"`         // Overrides proxy.max_retries, 0 disables retries
+	RetryDelay         *int               `yaml:"retry_delay"`         // Overrides proxy.retry_delay, milliseconds
+	HTTPProxy          string             `yaml:"http_proxy"`          // Target-specific HTTP proxy
+}
+
+// Route describes what the target matches: its host followed by the path,
+// or ~ and path_regex, and the header conditions in brackets
+func (t *ProxyTarget) Route() string {
+	route := t.Host + t.Path
+	if t.PathRegex != "" {
+		route = t.Host + "~" + t.PathRegex
+	}
+	if len(t.MatchHeaders) > 0 {
+		conditions := make([]string, 0, len(t.MatchHeaders))
+		for _, name := range sortedKeys(t.MatchHeaders) {
+			conditions = append(conditions, name+"="+t.MatchHeaders[name])
+		}
+		route += " [" + strings.Join(conditions, ", ") + "]"
+	}
+	return route
+}
+
+// Per-target logging levels, see ProxyTarget.LogLevel
+const (
+	LogFull     = "full"
+	LogMetadata = "metadata"
+	LogNone     = "none"
+)
+
+// LogLevel returns how requests to the target are logged, logging: true and
+// false are accepted for full and none
+func (t *ProxyTarget) LogLevel() string {
+	switch t.Logging {
+	case "", "true", LogFull:
+		return LogFull
+	case "false":
+		return LogNone
+	default:
+		return t.Logging
+	}
+}
+
+// RewriteRule replaces the request path when Match matches, $1 refers to a capture group
+type RewriteRule struct {
+	Match   string `yaml:"match"`   // Regular expression such as ^/v1/(.*)$
+	Replace string `yaml:"replace"` // Replacement such as /anthropic/v1/$1
+}
+
+// BodyTransform changes a field of JSON request bodies before forwarding
+type BodyTransform struct {
+	Op    string      `yaml:"op"`    // set, delete, replace, min or max
+	Path  string      `yaml:"path"`  // Dotted field path, array indexes and * allowed: messages.*.cache_control
+	Value interface{} `yaml:"value"` // New value, or the bound for min and max
+	Match interface{} `yaml:"match"` // replace only changes fields equal to match when set
+}
+
+// HealthCheckExpect validates health check responses. Any 2xx or 3xx status
+// is healthy when Status is empty.
+type HealthCheckExpect struct {
+	Status []int                  `yaml:"status"` // Healthy status codes
+	Body   string                 `yaml:"body"`   // Substring of the response body, "~regex" for a regular expression
+	JSON   map[string]interface{} `yaml:"json"`   // Dotted field paths of the JSON response and their values: data.0.type: model
+}
+
+// CustomHealthCheck reports whether the health check request or the expected
+// response is configured. Such checks only request health_check_path, or /,
+// instead of trying common health endpoints.
+func (t *ProxyTarget) CustomHealthCheck() bool {
+	return t.HealthCheckMethod != "" || len(t.HealthCheckHeaders) > 0 || t.HealthCheckBody != "" || t.HealthCheckExpect != nil
+}
+
+// HealthCheckEnabled reports whether the URLs of t are checked periodically,
+// health_check of the target takes precedence over the global proxy.health_check
+func (t *ProxyTarget) HealthCheckEnabled(global *bool) bool {
+	if t.HealthCheck != nil {
+		return *t.HealthCheck
+	}
+	return global == nil || *global
+}
+
+// OutlierDetection ejects upstream URLs of a target whose recent requests
+// fail or take much longer than those of its other URLs
+type OutlierDetection struct {
+	Window             int     `yaml:"window"`               // Seconds of requests considered, default 60
+	MinRequests        int     `yaml:"min_requests"`         // Requests in the window before a URL is judged, default 5
+	ErrorRate          float64 `yaml:"error_rate"`           // Eject at this share (0-1) of transport errors and 5xx responses if the other URLs fail less, default 0.5
+	LatencyFactor      float64 `yaml:"latency_factor"`       // Eject when the average latency is this many times the median of the other URLs, 0 disables
+	EjectionTime       int     `yaml:"ejection_time"`        // Seconds an ejected URL is left out, default 30
+	MaxEjectionPercent int     `yaml:"max_ejection_percent"` // At most this share of the target's URLs is ejected at once, default 50
 }
 
+// StaticResponse is returned by targets that stub an endpoint instead of proxying it
+type StaticResponse struct {
+	Status  int               `yaml:"status"`  // Default 200
+	Headers map[string]string `yaml:"headers"` // Values may be templates like target headers
+	Body    string            `yaml:"body"`    // Rendered as a template when it contains {{
+	JSON    interface{}       `yaml:"json"`    // Sent as application/json instead of body
+}
+
+// Redirect sends clients of a target to another location, e.g. a new base URL
+type Redirect struct {
+	Location string `yaml:"location"` // URL or path, wildcard targets append the request path like target_url
+	Status   int    `yaml:"status"`   // 301, 302, 303, 307 or 308, default 302
+}
+
+// LoadConfig reads and validates a configuration file. Every problem found
+// is returned at once as ValidationErrors.
 func LoadConfig(filename string) (*Config, error) {
-	data, err := os.ReadFile(filename)
+	config, err := ValidateFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	return config, nil
+}
 
-	var config Config
-	err = yaml.Unmarshal(data, &config)
+// Parse decodes a configuration in the given format, expanding environment
+// variables and applying defaults
+func Parse(data []byte, format string) (*Config, error) {
+	data, err := expandEnv(data)
 	if err != nil {
 		return nil, err
 	}
 
+	var config Config
+	if err := decode(data, format, &config); err != nil {
+		return nil, err
+	}
+
 	setDefaults(&config)
 	processTargetURLs(&config)
+	applyProfile(&config)
 	return &config, nil
 }
 
@@ -90,6 +385,9 @@ func setDefaults(config *Config) {
 	if config.Server.Timeouts.Shutdown == 0 {
 		config.Server.Timeouts.Shutdown = 30
 	}
+	if config.Server.Timeouts.Drain == 0 {
+		config.Server.Timeouts.Drain = 600
+	}
 	if config.Web.Port == "" {
 		config.Web.Port = "9528"
 	}
@@ -99,30 +397,125 @@ func setDefaults(config *Config) {
 	if config.Web.MaxLogs == 0 {
 		config.Web.MaxLogs = 1000
 	}
-	if config.Proxy.Timeout == 0 {
-		config.Proxy.Timeout = 30
-	}
 	if config.Proxy.MaxRetries == 0 {
 		config.Proxy.MaxRetries = 3
 	}
 	if config.Proxy.RetryDelay == 0 {
 		config.Proxy.RetryDelay = 1000
 	}
+	if config.Proxy.PassiveFailures == 0 {
+		config.Proxy.PassiveFailures = 3
+	}
 	if config.WebSocket.BufferSize == 0 {
 		config.WebSocket.BufferSize = 1024
 	}
 	if config.WebSocket.BroadcastSize == 0 {
 		config.WebSocket.BroadcastSize = 1000
 	}
+	if config.WebSocket.StatsInterval == 0 {
+		config.WebSocket.StatsInterval = 5
+	}
+	if config.WebSocket.MaxMessageBytes == 0 {
+		config.WebSocket.MaxMessageBytes = 1 << 20
+	}
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
+	if config.Logging.FileMaxSize == 0 {
+		config.Logging.FileMaxSize = 100
+	}
+	if config.Logging.FileMaxBackups == 0 {
+		config.Logging.FileMaxBackups = 5
+	}
+	if config.Logging.Syslog.Facility == "" {
+		config.Logging.Syslog.Facility = "local0"
+	}
+	if config.Logging.Syslog.Tag == "" {
+		config.Logging.Syslog.Tag = "ccproxy"
+	}
+	if config.History.Backend == "" {
+		config.History.Backend = "jsonl"
+	}
+	if config.History.BlobThresholdKB == 0 {
+		config.History.BlobThresholdKB = 64
+	}
+	if config.Notifications.ErrorRate == 0 {
+		config.Notifications.ErrorRate = 0.5
+	}
+	if config.Notifications.MinRequests == 0 {
+		config.Notifications.MinRequests = 10
+	}
+	if config.Notifications.Window == 0 {
+		config.Notifications.Window = 5
+	}
+	if config.Reports.TopErrors == 0 {
+		config.Reports.TopErrors = 5
+	}
+	for i := range config.Logging.Exporters {
+		exporter := &config.Logging.Exporters[i]
+		if exporter.Type == "loki" && len(exporter.Labels) == 0 {
+			exporter.Labels = map[string]string{"job": "ccproxy"}
+		}
+		if exporter.Index == "" {
+			exporter.Index = "ccproxy"
+		}
+		if exporter.BatchSize == 0 {
+			exporter.BatchSize = 100
+		}
+		if exporter.FlushInterval == 0 {
+			exporter.FlushInterval = 5
+		}
+	}
+}
+
+// RouteTargets returns proxy.targets followed by proxy.fallback when configured
+func (c *Config) RouteTargets() []ProxyTarget {
+	targets := c.Proxy.Targets
+	if c.Proxy.Fallback != nil {
+		targets = append(targets[:len(targets):len(targets)], *c.Proxy.Fallback)
+	}
+	return targets
+}
+
+// RestartRequired lists the settings that differ between the running and the
+// saved configuration but only take effect after a restart
+func RestartRequired(running, saved *Config) []string {
+	fields := []string{}
+	if saved.Server.Host != running.Server.Host {
+		fields = append(fields, "server.host")
+	}
+	if saved.Server.Port != running.Server.Port {
+		fields = append(fields, "server.port")
+	}
+	if saved.Web.Port != running.Web.Port {
+		fields = append(fields, "web.port")
+	}
+	if saved.Web.TLS != running.Web.TLS {
+		fields = append(fields, "web.tls")
+	}
+	if saved.History.Backend != running.History.Backend {
+		fields = append(fields, "history.backend")
+	}
+	return fields
 }
 
 // processTargetURLs processes comma-separated target_url field into target_urls array
 func processTargetURLs(config *Config) {
-	for i := range config.Proxy.Targets {
-		target := &config.Proxy.Targets[i]
+	processTargets(config.Proxy.Targets)
+	for _, profile := range config.Proxy.Profiles {
+		processTargets(profile.Targets)
+	}
+	if config.Proxy.Fallback != nil {
+		fallback := []ProxyTarget{*config.Proxy.Fallback}
+		fallback[0].Path, fallback[0].PathRegex, fallback[0].Host, fallback[0].MatchHeaders = "/*", "", "", nil
+		processTargets(fallback)
+		config.Proxy.Fallback = &fallback[0]
+	}
+}
+
+func processTargets(targets []ProxyTarget) {
+	for i := range targets {
+		target := &targets[i]
 		
 		// Parse target_url field (supports comma-separated URLs)
 		if target.TargetURL != "" {
@@ -158,5 +551,31 @@ func processTargetURLs(config *Config) {
 		if target.HealthCheckDelay == 0 {
 			target.HealthCheckDelay = 30 // 30 seconds default
 		}
+		if target.HealthCheckTimeout == 0 {
+			target.HealthCheckTimeout = 3
+		}
+		if target.UnhealthyThreshold == 0 {
+			target.UnhealthyThreshold = 1
+		}
+		if target.HealthyThreshold == 0 {
+			target.HealthyThreshold = 1
+		}
+		if od := target.OutlierDetection; od != nil {
+			if od.Window == 0 {
+				od.Window = 60
+			}
+			if od.MinRequests == 0 {
+				od.MinRequests = 5
+			}
+			if od.ErrorRate == 0 {
+				od.ErrorRate = 0.5
+			}
+			if od.EjectionTime == 0 {
+				od.EjectionTime = 30
+			}
+			if od.MaxEjectionPercent == 0 {
+				od.MaxEjectionPercent = 50
+			}
+		}
 	}
 }
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// SetFileValue sets the scalar at a dotted path such as "web.auth.token" in a
+// YAML file, keeping comments and layout intact. Missing mappings along the
+// path are appended to their parent.
+func SetFileValue(filename, path, value string) error {
+	if format := FormatOf(filename); format != FormatYAML {
+		return fmt.Errorf("editing %s configuration files is not supported", format)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(data), "\n")
+
+	segments := strings.Split(path, ".")
+	start, end, parentIndent := 0, len(lines), -1
+	for n, key := range segments {
+		childIndent := parentIndent + 2
+		if parentIndent < 0 {
+			childIndent = 0
+		}
+		for i := start; i < end; i++ {
+			if isContentLine(lines[i]) {
+				childIndent = indentOf(lines[i])
+				break
+			}
+		}
+
+		found := -1
+		for i := start; i < end; i++ {
+			if isContentLine(lines[i]) && indentOf(lines[i]) == childIndent &&
+				strings.HasPrefix(strings.TrimSpace(lines[i]), key+":") {
+				found = i
+				break
+			}
+		}
+
+		if found < 0 {
+			// Append the rest of the path after the last line of the parent block
+			insertAt := start
+			for i := start; i < end; i++ {
+				if isContentLine(lines[i]) {
+					insertAt = i + 1
+				}
+			}
+			var added []string
+			for m, rest := range segments[n:] {
+				line := strings.Repeat(" ", childIndent+2*m) + rest + ":"
+				if n+m == len(segments)-1 {
+					line += " " + strconv.Quote(value)
+				}
+				added = append(added, line)
+			}
+			lines = append(lines[:insertAt], append(added, lines[insertAt:]...)...)
+			break
+		}
+
+		if n == len(segments)-1 {
+			colon := strings.Index(lines[found], ":")
+			line := lines[found][:colon+1] + " " + strconv.Quote(value)
+			// Keep a trailing comment unless the "#" is inside a quoted value
+			rest := lines[found][colon+1:]
+			if c := strings.Index(rest, " #"); c >= 0 && strings.Count(rest[:c], `"`)%2 == 0 {
+				line += rest[c:]
+			}
+			lines[found] = line
+			break
+		}
+
+		// Descend into the mapping under this key
+		start, end, parentIndent = found+1, found+1, childIndent
+		for end < len(lines) && (!isContentLine(lines[end]) || indentOf(lines[end]) > childIndent) {
+			end++
+		}
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filename, []byte(strings.Join(lines, "\n")), info.Mode())
+}
+
+// isContentLine reports whether a line holds a key rather than a comment or nothing
+func isContentLine(line string) bool {
+	trimmed := strings.TrimSpace(line)
+	return trimmed != "" && !strings.HasPrefix(trimmed, "#")
+}
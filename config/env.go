@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// EnvVar is a single environment variable for Claude Code clients
+type EnvVar struct {
+	Name  string
+	Value string
+}
+
+// EnvFormats lists the output formats supported by FormatClientEnv
+var EnvFormats = []string{"shell", "fish", "powershell", "cmd", "json"}
+
+// ClientEnv returns the environment that points Claude Code at this proxy.
+// host is how clients reach the proxy; wildcard and empty hosts fall back to
+// localhost. When token is empty and the targets inject their own credentials
+// a placeholder is used, since the proxy replaces it upstream.
+func ClientEnv(cfg *Config, host, token string) []EnvVar {
+	if host == "" {
+		host = cfg.Server.Host
+	}
+	if host == "" || host == "0.0.0.0" || host == "::" {
+		host = "localhost"
+	}
+
+	if token == "" {
+		token = "<your-api-key>"
+		if injectsCredentials(cfg) {
+			token = "ccproxy"
+		}
+	}
+
+	return []EnvVar{
+		{Name: "ANTHROPIC_BASE_URL", Value: "http://" + net.JoinHostPort(host, cfg.Server.Port)},
+		{Name: "ANTHROPIC_AUTH_TOKEN", Value: token},
+	}
+}
+
+// injectsCredentials reports whether every target sets its own API credentials
+func injectsCredentials(cfg *Config) bool {
+	if len(cfg.Proxy.Targets) == 0 {
+		return false
+	}
+	for _, target := range cfg.Proxy.Targets {
+		found := false
+		for key := range target.Headers {
+			switch strings.ToLower(key) {
+			case "authorization", "x-api-key":
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// FormatClientEnv renders vars for a shell or as a Claude Code settings.json fragment
+func FormatClientEnv(vars []EnvVar, format string) (string, error) {
+	var b strings.Builder
+	switch format {
+	case "", "shell":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "export %s=%s\n", v.Name, shellQuote(v.Value))
+		}
+	case "fish":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "set -gx %s %s\n", v.Name, shellQuote(v.Value))
+		}
+	case "powershell":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "$env:%s = \"%s\"\n", v.Name, strings.ReplaceAll(v.Value, "\"", "`\""))
+		}
+	case "cmd":
+		for _, v := range vars {
+			fmt.Fprintf(&b, "set %s=%s\n", v.Name, v.Value)
+		}
+	case "json":
+		env := make(map[string]string, len(vars))
+		for _, v := range vars {
+			env[v.Name] = v.Value
+		}
+		encoder := json.NewEncoder(&b)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(map[string]interface{}{"env": env}); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown format %q (supported: %s)", format, strings.Join(EnvFormats, ", "))
+	}
+	return b.String(), nil
+}
+
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./:@", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// envPattern matches ${VAR}, ${VAR:-default}, ${VAR-default}, ${VAR:?message},
+// ${VAR?message} and the escape $${ for a literal ${
+var envPattern = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(?:(:?[-?])([^}]*))?\}`)
+
+// expandEnv substitutes environment variable references in the raw
+// configuration. Whole-line comments are left alone. All missing required
+// variables are reported together with their line numbers.
+func expandEnv(data []byte) ([]byte, error) {
+	if !strings.Contains(string(data), "${") {
+		return data, nil
+	}
+
+	var errs ValidationErrors
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		lines[i] = envPattern.ReplaceAllStringFunc(line, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			m := envPattern.FindStringSubmatch(ref)
+			name, op, arg := m[1], m[2], m[3]
+			value, set := os.LookupEnv(name)
+
+			switch op {
+			case ":-":
+				if value == "" {
+					return arg
+				}
+			case "-":
+				if !set {
+					return arg
+				}
+			case ":?", "?":
+				if !set || (op == ":?" && value == "") {
+					message := arg
+					if message == "" {
+						message = "required environment variable is not set"
+					}
+					errs = append(errs, &ValidationError{Line: i + 1, Message: fmt.Sprintf("%s: %s", name, message)})
+				}
+			}
+			return value
+		})
+	}
+
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,189 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+// Supported configuration file formats
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// FormatOf detects the configuration format from the file extension, YAML by default
+func FormatOf(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".json":
+		return FormatJSON
+	case ".toml":
+		return FormatTOML
+	default:
+		return FormatYAML
+	}
+}
+
+// decode parses data in the given format into config. JSON and TOML are
+// converted to YAML first, so the yaml struct tags describe every format.
+// Decoding is strict: unknown keys and mistyped values are reported as
+// ValidationErrors, one per problem, with the line when known.
+func decode(data []byte, format string, config *Config) error {
+	source := data
+	switch format {
+	case FormatJSON:
+		var doc interface{}
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		decoder.UseNumber()
+		if err := decoder.Decode(&doc); err != nil {
+			return ValidationErrors{jsonError(err, data)}
+		}
+		converted, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		data = converted
+
+	case FormatTOML:
+		var doc map[string]interface{}
+		if err := toml.Unmarshal(data, &doc); err != nil {
+			var parseErr toml.ParseError
+			if errors.As(err, &parseErr) {
+				// Drop the "toml: line N:" prefix, the line is reported separately
+				message := strings.TrimPrefix(parseErr.Error(), "toml: ")
+				message = message[strings.Index(message, ": ")+2:]
+				return ValidationErrors{{Line: parseErr.Position.Line, Message: message}}
+			}
+			return ValidationErrors{{Message: err.Error()}}
+		}
+		converted, err := yaml.Marshal(doc)
+		if err != nil {
+			return err
+		}
+		data = converted
+	}
+
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return decodeErrors(err, source, format)
+	}
+	return nil
+}
+
+// decodeErrors splits a yaml.v2 decoding error into one ValidationError per
+// problem. Unknown keys are named, with the closest known key as a hint.
+func decodeErrors(err error, source []byte, format string) ValidationErrors {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	lines := strings.Split(string(source), "\n")
+	errs := make(ValidationErrors, 0, len(messages))
+	for _, message := range messages {
+		validationErr := yamlError(errors.New(message))
+		if format != FormatYAML {
+			// Lines of the converted document mean nothing to the user
+			validationErr.Line = 0
+		}
+
+		if match := unknownFieldPattern.FindStringSubmatch(validationErr.Message); match != nil {
+			key := match[1]
+			validationErr.Message = fmt.Sprintf("unknown field %q", key)
+			if suggestion := closestKey(key); suggestion != "" {
+				validationErr.Message += fmt.Sprintf(", did you mean %q?", suggestion)
+			}
+			for i := 0; validationErr.Line == 0 && i < len(lines); i++ {
+				if isKeyLine(lines[i], key) {
+					validationErr.Line = i + 1
+				}
+			}
+		}
+		errs = append(errs, validationErr)
+	}
+	return errs
+}
+
+var unknownFieldPattern = regexp.MustCompile(`^field (\S+) not found in type`)
+
+var (
+	knownKeysOnce sync.Once
+	knownKeys     []string
+)
+
+// closestKey returns the configuration key within two edits of key, if any
+func closestKey(key string) string {
+	knownKeysOnce.Do(func() {
+		seen := map[string]bool{}
+		collectKeys(reflect.TypeOf(Config{}), seen)
+		for k := range seen {
+			knownKeys = append(knownKeys, k)
+		}
+		sort.Strings(knownKeys)
+	})
+
+	best, bestDistance := "", 3
+	for _, known := range knownKeys {
+		if d := editDistance(key, known); d < bestDistance {
+			best, bestDistance = known, d
+		}
+	}
+	return best
+}
+
+func collectKeys(t reflect.Type, seen map[string]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		collectKeys(t.Elem(), seen)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+			if name == "" || name == "-" {
+				continue
+			}
+			seen[name] = true
+			collectKeys(t.Field(i).Type, seen)
+		}
+	}
+}
+
+// editDistance is the Levenshtein distance between a and b
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+func jsonError(err error, data []byte) *ValidationError {
+	var syntaxErr *json.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		line := bytes.Count(data[:min(int(syntaxErr.Offset), len(data))], []byte("\n")) + 1
+		return &ValidationError{Line: line, Message: syntaxErr.Error()}
+	}
+	return &ValidationError{Message: fmt.Sprintf("invalid JSON: %v", err)}
+}
@@ -0,0 +1,49 @@
+package config
+
+import "fmt"
+
+// Maintenance takes a target out of service. While enabled requests are
+// answered with 503 and Retry-After instead of reaching the upstream.
+type Maintenance struct {
+	Enabled    bool        `yaml:"enabled"`
+	RetryAfter int         `yaml:"retry_after"` // Seconds for the Retry-After header, default 300
+	Body       interface{} `yaml:"body"`        // JSON response body, default an Anthropic style overloaded_error
+}
+
+// InMaintenance reports whether requests to the target are currently refused
+func (t *ProxyTarget) InMaintenance() bool {
+	return t.Maintenance != nil && t.Maintenance.Enabled
+}
+
+// MaintenancePaths lists the routes of the targets in maintenance
+func (c *Config) MaintenancePaths() []string {
+	paths := []string{}
+	for i := range c.Proxy.Targets {
+		if c.Proxy.Targets[i].InMaintenance() {
+			paths = append(paths, c.Proxy.Targets[i].Route())
+		}
+	}
+	return paths
+}
+
+// SetMaintenance returns a copy of the configuration with maintenance of the
+// target with the given path (see ProxyTarget.Route) turned on or off.
+func (c *Config) SetMaintenance(path string, enabled bool) (*Config, error) {
+	targets := append([]ProxyTarget(nil), c.Proxy.Targets...)
+	for i := range targets {
+		if targets[i].Route() != path {
+			continue
+		}
+		maintenance := Maintenance{}
+		if targets[i].Maintenance != nil {
+			maintenance = *targets[i].Maintenance
+		}
+		maintenance.Enabled = enabled
+		targets[i].Maintenance = &maintenance
+
+		next := *c
+		next.Proxy.Targets = targets
+		return &next, nil
+	}
+	return nil, fmt.Errorf("no target with path %q", path)
+}
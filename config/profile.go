@@ -0,0 +1,55 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+)
+
+// ProxyProfile is a named set of targets under proxy.profiles. The active
+// profile (proxy.profile) replaces proxy.targets.
+type ProxyProfile struct {
+	Targets   []ProxyTarget `yaml:"targets"`
+	HTTPProxy string        `yaml:"http_proxy"` // Overrides proxy.http_proxy when set
+}
+
+// ProfileNames lists the configured profiles in sorted order
+func (c *Config) ProfileNames() []string {
+	names := make([]string, 0, len(c.Proxy.Profiles))
+	for name := range c.Proxy.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// UseProfile returns a copy of the configuration with the named profile
+// active. An empty name selects the top-level proxy.targets.
+func (c *Config) UseProfile(name string) (*Config, error) {
+	active := c.Proxy.defaults
+	if name != "" {
+		profile, ok := c.Proxy.Profiles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q", name)
+		}
+		active.Targets = profile.Targets
+		if profile.HTTPProxy != "" {
+			active.HTTPProxy = profile.HTTPProxy
+		}
+	}
+
+	next := *c
+	next.Proxy.Profile = name
+	next.Proxy.Targets = active.Targets
+	next.Proxy.HTTPProxy = active.HTTPProxy
+	return &next, nil
+}
+
+// applyProfile remembers the top-level targets and activates proxy.profile.
+// An unknown profile is left for Validate to report.
+func applyProfile(config *Config) {
+	config.Proxy.defaults = ProxyProfile{Targets: config.Proxy.Targets, HTTPProxy: config.Proxy.HTTPProxy}
+	if _, ok := config.Proxy.Profiles[config.Proxy.Profile]; ok {
+		active, _ := config.UseProfile(config.Proxy.Profile)
+		*config = *active
+	}
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the functions available to header and notification templates
+var templateFuncs = template.FuncMap{
+	"env":  os.Getenv,
+	"json": toJSON,
+}
+
+// toJSON encodes a value for JSON bodies built by templates, e.g. {"text": {{json .Message}}}
+func toJSON(v interface{}) (string, error) {
+	data, err := json.Marshal(v)
+	return string(data), err
+}
+
+// IsTemplate reports whether a header value needs to be rendered per request
+func IsTemplate(value string) bool {
+	return strings.Contains(value, "{{")
+}
+
+// ParseTemplate compiles a header value such as "{{.Host}}" or `{{env "KEY"}}`
+func ParseTemplate(name, value string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(value)
+}
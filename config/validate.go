@@ -0,0 +1,747 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidationError describes a single problem found in a configuration
+type ValidationError struct {
+	Field   string `json:"field,omitempty"` // Dotted path such as proxy.targets[0].target_url
+	Line    int    `json:"line,omitempty"`  // Line in the source file, 0 when unknown
+	Message string `json:"message"`
+}
+
+func (e *ValidationError) Error() string {
+	prefix := e.Field
+	if e.Line > 0 {
+		prefix = fmt.Sprintf("line %d", e.Line)
+		if e.Field != "" {
+			prefix += ": " + e.Field
+		}
+	}
+	if prefix == "" {
+		return e.Message
+	}
+	return prefix + ": " + e.Message
+}
+
+// ValidationErrors aggregates every problem found in a configuration
+type ValidationErrors []*ValidationError
+
+func (e ValidationErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+var validMethods = map[string]bool{
+	"GET": true, "POST": true, "PUT": true, "DELETE": true, "PATCH": true,
+	"OPTIONS": true, "HEAD": true, "CONNECT": true, "TRACE": true, "ANY": true,
+}
+
+var validLogLevels = map[string]bool{"debug": true, "info": true, "warn": true, "error": true}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// lokiLabelName is the Prometheus label name syntax used by Loki
+var lokiLabelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// ValidateFile loads a configuration file and checks it, returning the
+// loaded configuration together with every problem found.
+func ValidateFile(filename string) (*Config, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ValidateData(data, FormatOf(filename))
+	if config != nil {
+		config.FilePath = filename
+	}
+	return config, err
+}
+
+// ValidateData parses and checks a configuration in the given format. The
+// configuration is returned when it could be decoded, even if invalid.
+func ValidateData(data []byte, format string) (*Config, error) {
+	config, err := Parse(data, format)
+	if err != nil {
+		return nil, err
+	}
+
+	if errs := Validate(config); len(errs) > 0 {
+		locateErrors(errs, data)
+		return config, errs
+	}
+	return config, nil
+}
+
+// Validate checks a loaded configuration for semantic problems
+func Validate(config *Config) ValidationErrors {
+	var errs ValidationErrors
+	add := func(field, format string, args ...interface{}) {
+		errs = append(errs, &ValidationError{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+
+	if !validPort(config.Server.Port) {
+		add("server.port", "invalid port %q", config.Server.Port)
+	}
+	if !validPort(config.Web.Port) {
+		add("web.port", "invalid port %q", config.Web.Port)
+	}
+	if config.Web.Enabled && config.Web.Port == config.Server.Port {
+		add("web.port", "port %s is already used by server.port", config.Web.Port)
+	}
+	switch config.Web.Auth.Type {
+	case "":
+	case "basic":
+		if config.Web.Auth.Username == "" || config.Web.Auth.Password == "" {
+			add("web.auth", "basic authentication requires username and password")
+		}
+	case "bearer":
+		if config.Web.Auth.Token == "" {
+			add("web.auth.token", "bearer authentication requires a token")
+		}
+	default:
+		add("web.auth.type", "unknown type %q (expected basic or bearer)", config.Web.Auth.Type)
+	}
+	for i, origin := range config.Web.CORS.AllowedOrigins {
+		if origin == "*" {
+			if config.Web.CORS.AllowCredentials {
+				add(fmt.Sprintf("web.cors.allowed_origins[%d]", i), `"*" cannot be combined with allow_credentials`)
+			}
+			continue
+		}
+		if u, err := url.Parse(origin); err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") {
+			add(fmt.Sprintf("web.cors.allowed_origins[%d]", i), "invalid origin %q (expected scheme://host[:port])", origin)
+		}
+	}
+	if (config.Web.TLS.CertFile == "") != (config.Web.TLS.KeyFile == "") {
+		add("web.tls", "cert_file and key_file must be set together")
+	}
+	if !validLogLevels[config.Logging.Level] {
+		add("logging.level", "unknown level %q (expected debug, info, warn or error)", config.Logging.Level)
+	}
+	for i, name := range config.Logging.RedactHeaders {
+		if strings.TrimSuffix(name, "*") == "" {
+			add(fmt.Sprintf("logging.redact_headers[%d]", i), "header name is required")
+		}
+	}
+	if config.Logging.FileMaxSize < 0 {
+		add("logging.file_max_size", "must not be negative")
+	}
+	if config.Logging.FileMaxAge < 0 {
+		add("logging.file_max_age", "must not be negative")
+	}
+	if config.Logging.FileMaxBackups < 0 {
+		add("logging.file_max_backups", "must not be negative")
+	}
+	if config.Logging.MaxBodyBytes < 0 {
+		add("logging.max_body_bytes", "must not be negative")
+	}
+	if rate := config.Logging.SampleRate; rate != nil && (*rate < 0 || *rate > 1) {
+		add("logging.sample_rate", "must be between 0 and 1")
+	}
+	if syslog := config.Logging.Syslog; syslog.Address != "" {
+		if syslog.Address != "local" {
+			if u, err := url.Parse(syslog.Address); err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Port() == "" {
+				add("logging.syslog.address", "invalid address %q (expected local, udp://host:port or tcp://host:port)", syslog.Address)
+			}
+		}
+		if _, ok := SyslogFacilities[syslog.Facility]; !ok {
+			add("logging.syslog.facility", "unknown facility %q", syslog.Facility)
+		}
+		for i, name := range syslog.Logs {
+			if name != "access" && name != "errors" {
+				add(fmt.Sprintf("logging.syslog.logs[%d]", i), "unknown log %q (expected access or errors)", name)
+			}
+		}
+	}
+	for i, exporter := range config.Logging.Exporters {
+		field := fmt.Sprintf("logging.exporters[%d]", i)
+		if exporter.Type != "loki" && exporter.Type != "elasticsearch" {
+			add(field+".type", "unknown type %q (expected loki or elasticsearch)", exporter.Type)
+		}
+		if u, err := url.Parse(exporter.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(field+".url", "invalid URL %q (expected http:// or https://)", exporter.URL)
+		}
+		if exporter.Token != "" && (exporter.Username != "" || exporter.Password != "") {
+			add(field, "token and username/password are mutually exclusive")
+		}
+		for name := range exporter.Labels {
+			if !lokiLabelName.MatchString(name) {
+				add(field+".labels", "invalid label name %q", name)
+			}
+		}
+		if exporter.BatchSize < 0 {
+			add(field+".batch_size", "must not be negative")
+		}
+		if exporter.FlushInterval < 0 {
+			add(field+".flush_interval", "must not be negative")
+		}
+	}
+	if config.Notifications.ErrorRate > 1 {
+		add("notifications.error_rate", "must be between 0 and 1, negative disables it")
+	}
+	if config.Notifications.MinRequests < 0 {
+		add("notifications.min_requests", "must not be negative")
+	}
+	if config.Notifications.Window < 0 {
+		add("notifications.window", "must not be negative")
+	}
+	for i, webhook := range config.Notifications.Webhooks {
+		field := fmt.Sprintf("notifications.webhooks[%d]", i)
+		switch webhook.Type {
+		case "slack", "discord", "json":
+		case "telegram":
+			if webhook.ChatID == "" {
+				add(field+".chat_id", "telegram webhooks require a chat_id")
+			}
+		default:
+			add(field+".type", "unknown type %q (expected slack, discord, telegram or json)", webhook.Type)
+		}
+		if u, err := url.Parse(webhook.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add(field+".url", "invalid URL %q (expected http:// or https://)", webhook.URL)
+		}
+		for j, event := range webhook.Events {
+			if !slices.Contains(NotificationEvents, event) {
+				add(fmt.Sprintf("%s.events[%d]", field, j), "unknown event %q (expected %s)", event, strings.Join(NotificationEvents, ", "))
+			}
+		}
+		if webhook.Template != "" {
+			if _, err := ParseTemplate(field, webhook.Template); err != nil {
+				add(field+".template", "invalid template: %v", err)
+			}
+		}
+	}
+	if config.Reports.TopErrors < 0 {
+		add("reports.top_errors", "must not be negative")
+	}
+	for model, price := range config.Reports.Prices {
+		if price.Input < 0 || price.Output < 0 || price.CacheWrite < 0 || price.CacheRead < 0 {
+			add("reports.prices."+model, "prices must not be negative")
+		}
+	}
+	if backend := config.History.Backend; backend != "jsonl" && backend != "bolt" {
+		add("history.backend", "unknown backend %q (expected jsonl or bolt)", backend)
+	}
+	if config.History.MaxAgeDays < 0 {
+		add("history.max_age_days", "must not be negative")
+	}
+	if config.History.MaxTotalSizeMB < 0 {
+		add("history.max_total_size_mb", "must not be negative")
+	}
+	if config.History.BlobThresholdKB < 0 {
+		add("history.blob_threshold_kb", "must not be negative")
+	}
+	if archive := config.History.Archive; archive.Bucket != "" {
+		if archive.Endpoint != "" {
+			if u, err := url.Parse(archive.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				add("history.archive.endpoint", "invalid URL %q (expected http:// or https://)", archive.Endpoint)
+			}
+		}
+		if archive.AccessKeyID == "" || archive.SecretAccessKey == "" {
+			add("history.archive", "access_key_id and secret_access_key are required")
+		}
+		if config.History.Backend == "bolt" {
+			add("history.archive", "archives history files and needs the jsonl backend")
+		}
+	}
+	for i, entry := range config.Logging.ExcludePaths {
+		if err := checkExcludePath(entry); err != nil {
+			add(fmt.Sprintf("logging.exclude_paths[%d]", i), "%v", err)
+		}
+	}
+	for i, pattern := range config.Logging.RedactPatterns {
+		if pattern == "" {
+			add(fmt.Sprintf("logging.redact_patterns[%d]", i), "pattern is required")
+		} else if _, err := regexp.Compile(pattern); err != nil {
+			add(fmt.Sprintf("logging.redact_patterns[%d]", i), "invalid pattern %q: %v", pattern, err)
+		}
+	}
+	if config.Proxy.Timeout < 0 {
+		add("proxy.timeout", "must not be negative")
+	}
+	if config.Proxy.MaxRetries < 0 {
+		add("proxy.max_retries", "must not be negative")
+	}
+	if config.Proxy.RetryDelay < 0 {
+		add("proxy.retry_delay", "must not be negative")
+	}
+	if config.Proxy.HTTPProxy != "" {
+		if err := checkProxyURL(config.Proxy.HTTPProxy); err != nil {
+			add("proxy.http_proxy", "%v", err)
+		}
+	}
+
+	base := config.Proxy.Targets
+	if config.Proxy.Profile != "" {
+		base = config.Proxy.defaults.Targets
+		if _, ok := config.Proxy.Profiles[config.Proxy.Profile]; !ok {
+			add("proxy.profile", "unknown profile %q", config.Proxy.Profile)
+		}
+	}
+	if config.Proxy.Profile == "" && len(config.Proxy.Targets) == 0 {
+		add("proxy.targets", "no proxy targets configured")
+	}
+	validateTargets("proxy.targets", base, add)
+	if config.Proxy.Fallback != nil {
+		validateTarget("proxy.fallback", *config.Proxy.Fallback, add)
+	}
+
+	for _, name := range config.ProfileNames() {
+		profile := config.Proxy.Profiles[name]
+		field := "proxy.profiles." + name
+		if len(profile.Targets) == 0 {
+			add(field+".targets", "profile %q has no targets", name)
+		}
+		if profile.HTTPProxy != "" {
+			if err := checkProxyURL(profile.HTTPProxy); err != nil {
+				add(field+".http_proxy", "%v", err)
+			}
+		}
+		validateTargets(field+".targets", profile.Targets, add)
+	}
+
+	return errs
+}
+
+func validateTargets(prefix string, targets []ProxyTarget, add func(field, format string, args ...interface{})) {
+	for i, target := range targets {
+		validateTarget(fmt.Sprintf("%s[%d]", prefix, i), target, add)
+	}
+}
+
+func validateTarget(field string, target ProxyTarget, add func(field, format string, args ...interface{})) {
+	if target.PathRegex != "" {
+		if target.Path != "" {
+			add(field+".path_regex", "path_regex cannot be combined with path")
+		}
+		if _, err := regexp.Compile(target.PathRegex); err != nil {
+			add(field+".path_regex", "invalid pattern %q: %v", target.PathRegex, err)
+		}
+	} else if target.Path == "" {
+		add(field+".path", "path or path_regex is required")
+	} else if !strings.HasPrefix(target.Path, "/") {
+		add(field+".path", "path %q must start with /", target.Path)
+	}
+
+	if host := strings.TrimPrefix(target.Host, "*."); strings.ContainsAny(host, "/:* ") {
+		add(field+".host", "invalid host %q (expected a hostname without port, optionally starting with *.)", target.Host)
+	}
+
+	for _, name := range sortedKeys(target.MatchHeaders) {
+		if name == "" {
+			add(field+".match_headers", "header name is empty")
+		}
+		if pattern, ok := strings.CutPrefix(target.MatchHeaders[name], "~"); ok {
+			if _, err := regexp.Compile(pattern); err != nil {
+				add(field+".match_headers."+name, "invalid pattern %q: %v", pattern, err)
+			}
+		}
+	}
+
+	switch {
+	case target.Response != nil && target.Redirect != nil:
+		add(field+".redirect", "redirect cannot be combined with response")
+	case target.Response != nil:
+		validateResponse(field+".response", target.Response, add)
+		if len(target.TargetURLs) > 0 {
+			add(field+".target_url", "target_url cannot be combined with response")
+		}
+	case target.Redirect != nil:
+		validateRedirect(field+".redirect", target.Redirect, add)
+		if len(target.TargetURLs) > 0 {
+			add(field+".target_url", "target_url cannot be combined with redirect")
+		}
+	case len(target.TargetURLs) == 0:
+		add(field+".target_url", "target_url is required")
+	}
+	for _, u := range target.TargetURLs {
+		if err := checkTargetURL(u); err != nil {
+			add(field+".target_url", "%v", err)
+		}
+	}
+
+	for _, method := range target.Methods {
+		if !validMethods[strings.ToUpper(method)] {
+			add(field+".methods", "unknown HTTP method %q", method)
+		}
+	}
+
+	if target.HTTPProxy != "" {
+		if err := checkProxyURL(target.HTTPProxy); err != nil {
+			add(field+".http_proxy", "%v", err)
+		}
+	}
+
+	if target.StripPrefix != "" && !strings.HasPrefix(target.StripPrefix, "/") {
+		add(field+".strip_prefix", "prefix %q must start with /", target.StripPrefix)
+	}
+	if target.AddPrefix != "" && !strings.HasPrefix(target.AddPrefix, "/") {
+		add(field+".add_prefix", "prefix %q must start with /", target.AddPrefix)
+	}
+	for j, rule := range target.Rewrite {
+		ruleField := fmt.Sprintf("%s.rewrite[%d]", field, j)
+		if rule.Match == "" {
+			add(ruleField+".match", "match is required")
+		} else if _, err := regexp.Compile(rule.Match); err != nil {
+			add(ruleField+".match", "invalid pattern %q: %v", rule.Match, err)
+		}
+	}
+	for j, rule := range target.BodyTransforms {
+		ruleField := fmt.Sprintf("%s.body_transforms[%d]", field, j)
+		if rule.Path == "" {
+			add(ruleField+".path", "path is required")
+		}
+		switch rule.Op {
+		case "set", "delete", "replace":
+		case "min", "max":
+			switch rule.Value.(type) {
+			case int, float64:
+			default:
+				add(ruleField+".value", "%s requires a numeric value", rule.Op)
+			}
+		default:
+			add(ruleField+".op", "unknown op %q (expected set, delete, replace, min or max)", rule.Op)
+		}
+	}
+	switch target.LogLevel() {
+	case LogFull, LogMetadata, LogNone:
+	default:
+		add(field+".logging", "unknown logging %q (expected full, metadata or none)", target.Logging)
+	}
+	if rate := target.SampleRate; rate != nil && (*rate < 0 || *rate > 1) {
+		add(field+".sample_rate", "must be between 0 and 1")
+	}
+	if target.Timeout != nil && *target.Timeout < 0 {
+		add(field+".timeout", "must not be negative")
+	}
+	if target.MaxRetries != nil && *target.MaxRetries < 0 {
+		add(field+".max_retries", "must not be negative")
+	}
+	if target.RetryDelay != nil && *target.RetryDelay < 0 {
+		add(field+".retry_delay", "must not be negative")
+	}
+	if target.Maintenance != nil && target.Maintenance.RetryAfter < 0 {
+		add(field+".maintenance.retry_after", "retry_after must not be negative")
+	}
+	if strings.ContainsAny(target.HostOverride, "/ ") {
+		add(field+".host_override", "invalid host %q (expected host[:port])", target.HostOverride)
+	}
+	for _, name := range sortedKeys(target.Headers) {
+		if value := target.Headers[name]; IsTemplate(value) {
+			if _, err := ParseTemplate(name, value); err != nil {
+				add(field+".headers."+name, "invalid template: %v", err)
+			}
+		}
+	}
+	for _, name := range target.RemoveQueryParams {
+		if strings.TrimSuffix(name, "*") == "" {
+			add(field+".remove_query_params", "parameter name %q is empty", name)
+		}
+	}
+	for name := range target.QueryParams {
+		if name == "" {
+			add(field+".query_params", "parameter name is empty")
+		}
+	}
+	for _, name := range target.RemoveHeaders {
+		if strings.TrimSuffix(name, "*") == "" {
+			add(field+".remove_headers", "header name %q is empty", name)
+		}
+	}
+
+	if target.HealthCheckPath != "" && !strings.HasPrefix(target.HealthCheckPath, "/") {
+		add(field+".health_check_path", "path %q must start with /", target.HealthCheckPath)
+	}
+	if target.HealthCheckDelay < 0 {
+		add(field+".health_check_delay", "must not be negative")
+	}
+	if target.HealthCheckTimeout < 0 {
+		add(field+".health_check_timeout", "must not be negative")
+	}
+	if target.UnhealthyThreshold < 0 {
+		add(field+".unhealthy_threshold", "must not be negative")
+	}
+	if target.HealthyThreshold < 0 {
+		add(field+".healthy_threshold", "must not be negative")
+	}
+	if target.SlowStart < 0 {
+		add(field+".slow_start", "must not be negative")
+	}
+	if od := target.OutlierDetection; od != nil {
+		if od.Window < 0 {
+			add(field+".outlier_detection.window", "must not be negative")
+		}
+		if od.MinRequests < 0 {
+			add(field+".outlier_detection.min_requests", "must not be negative")
+		}
+		if od.ErrorRate < 0 || od.ErrorRate > 1 {
+			add(field+".outlier_detection.error_rate", "must be between 0 and 1")
+		}
+		if od.LatencyFactor != 0 && od.LatencyFactor <= 1 {
+			add(field+".outlier_detection.latency_factor", "must be greater than 1")
+		}
+		if od.EjectionTime < 0 {
+			add(field+".outlier_detection.ejection_time", "must not be negative")
+		}
+		if od.MaxEjectionPercent < 0 || od.MaxEjectionPercent > 100 {
+			add(field+".outlier_detection.max_ejection_percent", "must be between 0 and 100")
+		}
+	}
+	if method := target.HealthCheckMethod; method != "" && (strings.ContainsAny(method, " \t/") || method != strings.ToUpper(method)) {
+		add(field+".health_check_method", "invalid method %q", method)
+	}
+	for _, name := range sortedKeys(target.HealthCheckHeaders) {
+		if name == "" {
+			add(field+".health_check_headers", "header name is empty")
+		}
+		if value := target.HealthCheckHeaders[name]; IsTemplate(value) {
+			if _, err := ParseTemplate(name, value); err != nil {
+				add(field+".health_check_headers."+name, "invalid template: %v", err)
+			}
+		}
+	}
+	if expect := target.HealthCheckExpect; expect != nil {
+		for _, status := range expect.Status {
+			if status < 100 || status > 599 {
+				add(field+".health_check_expect.status", "invalid status code %d", status)
+			}
+		}
+		if pattern, ok := strings.CutPrefix(expect.Body, "~"); ok {
+			if _, err := regexp.Compile(pattern); err != nil {
+				add(field+".health_check_expect.body", "invalid pattern %q: %v", pattern, err)
+			}
+		}
+		for path := range expect.JSON {
+			if path == "" || strings.Contains(path, "..") || strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") {
+				add(field+".health_check_expect.json", "invalid field path %q", path)
+			}
+		}
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n < 65536
+}
+
+func validateResponse(field string, response *StaticResponse, add func(field, format string, args ...interface{})) {
+	if response.Status != 0 && (response.Status < 100 || response.Status > 599) {
+		add(field+".status", "invalid status code %d", response.Status)
+	}
+	if response.JSON != nil && response.Body != "" {
+		add(field+".json", "json cannot be combined with body")
+	}
+	if IsTemplate(response.Body) {
+		if _, err := ParseTemplate("body", response.Body); err != nil {
+			add(field+".body", "invalid template: %v", err)
+		}
+	}
+	for _, name := range sortedKeys(response.Headers) {
+		if value := response.Headers[name]; IsTemplate(value) {
+			if _, err := ParseTemplate(name, value); err != nil {
+				add(field+".headers."+name, "invalid template: %v", err)
+			}
+		}
+	}
+}
+
+func validateRedirect(field string, redirect *Redirect, add func(field, format string, args ...interface{})) {
+	if redirect.Location == "" {
+		add(field+".location", "location is required")
+	} else if u, err := url.Parse(redirect.Location); err != nil {
+		add(field+".location", "invalid location %q: %v", redirect.Location, err)
+	} else if u.Host == "" && !strings.HasPrefix(u.Path, "/") {
+		add(field+".location", "location %q must be an absolute URL or start with /", redirect.Location)
+	}
+	switch redirect.Status {
+	case 0, 301, 302, 303, 307, 308:
+	default:
+		add(field+".status", "invalid redirect status %d (expected 301, 302, 303, 307 or 308)", redirect.Status)
+	}
+}
+
+func checkTargetURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %v", raw, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("URL %q must use http or https", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("URL %q has no host", raw)
+	}
+	return nil
+}
+
+// checkExcludePath validates a logging.exclude_paths entry, "[METHOD ]glob"
+// or "[METHOD ]~regex"
+func checkExcludePath(entry string) error {
+	pattern := strings.TrimSpace(entry)
+	if method, rest, ok := strings.Cut(pattern, " "); ok {
+		if strings.ContainsFunc(strings.ToUpper(method), func(r rune) bool { return r < 'A' || r > 'Z' }) {
+			return fmt.Errorf("invalid method %q", method)
+		}
+		pattern = strings.TrimSpace(rest)
+	}
+	if expr, ok := strings.CutPrefix(pattern, "~"); ok {
+		if _, err := regexp.Compile(expr); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", expr, err)
+		}
+		return nil
+	}
+	if !strings.HasPrefix(pattern, "/") {
+		return fmt.Errorf("pattern %q must start with / or ~", pattern)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %v", pattern, err)
+	}
+	return nil
+}
+
+func checkProxyURL(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL %q: %v", raw, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return fmt.Errorf("proxy URL %q must use http, https or socks5", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("proxy URL %q has no host", raw)
+	}
+	return nil
+}
+
+// yamlError converts a YAML decoding error, which carries the line number in its text
+func yamlError(err error) *ValidationError {
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	line := 0
+	if match := yamlLinePattern.FindStringSubmatch(message); match != nil {
+		line, _ = strconv.Atoi(match[1])
+		message = strings.TrimPrefix(message, match[0]+": ")
+	}
+	return &ValidationError{Line: line, Message: message}
+}
+
+// locateErrors fills in line numbers by walking the field path through the
+// source. yaml.v2 does not keep positions, so keys (and the value, when the
+// message quotes one) are matched against the raw text.
+func locateErrors(errs ValidationErrors, data []byte) {
+	lines := strings.Split(string(data), "\n")
+	for _, err := range errs {
+		value := ""
+		if start := strings.Index(err.Message, `"`); start >= 0 {
+			if end := strings.Index(err.Message[start+1:], `"`); end >= 0 {
+				value = err.Message[start+1 : start+1+end]
+			}
+		}
+		err.Line = findLine(lines, err.Field, value)
+	}
+}
+
+// findLine locates a dotted field path such as proxy.targets[1].methods
+func findLine(lines []string, field, value string) int {
+	pos := 0
+	segments := strings.Split(field, ".")
+	for n, segment := range segments {
+		key, index := segment, -1
+		if open := strings.Index(segment, "["); open >= 0 {
+			key = segment[:open]
+			index, _ = strconv.Atoi(strings.TrimSuffix(segment[open+1:], "]"))
+		}
+
+		found := -1
+		for i := pos; i < len(lines); i++ {
+			if isKeyLine(lines[i], key) {
+				found = i
+				break
+			}
+		}
+		if found < 0 {
+			return 0
+		}
+		pos = found
+
+		if index >= 0 {
+			pos = findItem(lines, found, index)
+		}
+
+		if n == len(segments)-1 && value != "" && !strings.Contains(lines[pos], value) {
+			for i := pos; i < len(lines) && i < pos+20; i++ {
+				if strings.Contains(lines[i], value) {
+					return i + 1
+				}
+			}
+		}
+	}
+	return pos + 1
+}
+
+// findItem returns the line of item index of the YAML list under the key on
+// line key, or key when it is not found. Only items at the indentation of the
+// first one count, nested lists such as methods are skipped.
+func findItem(lines []string, key, index int) int {
+	itemIndent, item := -1, -1
+	for i := key + 1; i < len(lines); i++ {
+		trimmed := strings.TrimSpace(lines[i])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		indent := indentOf(lines[i])
+		isItem := trimmed == "-" || strings.HasPrefix(trimmed, "- ")
+		if itemIndent < 0 {
+			if !isItem || indent < indentOf(lines[key]) {
+				break
+			}
+			itemIndent = indent
+		}
+		if indent < itemIndent || (indent == itemIndent && !isItem) {
+			break // Past the end of the list
+		}
+		if indent == itemIndent {
+			if item++; item == index {
+				return i
+			}
+		}
+	}
+	return key
+}
+
+// isKeyLine reports whether a line sets key, in YAML, JSON or TOML syntax
+func isKeyLine(line, key string) bool {
+	trimmed := strings.TrimPrefix(strings.TrimSpace(line), "- ")
+	if strings.HasPrefix(trimmed, key+":") || strings.HasPrefix(trimmed, `"`+key+`":`) {
+		return true
+	}
+	rest := strings.TrimPrefix(trimmed, key)
+	return len(rest) < len(trimmed) && strings.HasPrefix(strings.TrimLeft(rest, " "), "=")
+}
+
+func indentOf(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
@@ -0,0 +1,171 @@
+// Package diff renders line based differences between two texts
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// maxCells bounds the LCS table so huge inputs degrade to a plain replacement
+const maxCells = 4 << 20
+
+type op struct {
+	kind byte // ' ', '-' or '+'
+	line string
+}
+
+// Unified returns a unified diff of a and b with the given number of context
+// lines, or "" when they are equal
+func Unified(a, b string, context int) string {
+	if a == b {
+		return ""
+	}
+	ops := lineOps(splitLines(a), splitLines(b))
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+
+		// Extend the hunk while changes are within 2*context lines of each other
+		hunkStart := max(first-context, start)
+		end := first
+		for i := first; i < len(ops); i++ {
+			if ops[i].kind != ' ' {
+				end = i + 1
+			} else if i-end >= 2*context {
+				break
+			}
+		}
+		hunkEnd := min(end+context, len(ops))
+
+		aLine, bLine := lineNumbers(ops[:hunkStart])
+		aCount, bCount := 0, 0
+		for _, o := range ops[hunkStart:hunkEnd] {
+			if o.kind != '+' {
+				aCount++
+			}
+			if o.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", hunkLine(aLine, aCount), aCount, hunkLine(bLine, bCount), bCount)
+		for _, o := range ops[hunkStart:hunkEnd] {
+			out.WriteByte(o.kind)
+			out.WriteString(o.line)
+			out.WriteByte('\n')
+		}
+		start = hunkEnd
+	}
+	return out.String()
+}
+
+// hunkLine returns the 1-based start line of a hunk; empty ranges refer to the line before
+func hunkLine(offset, count int) int {
+	if count == 0 {
+		return offset
+	}
+	return offset + 1
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(s, "\n"), "\n")
+}
+
+func lineNumbers(ops []op) (int, int) {
+	a, b := 0, 0
+	for _, o := range ops {
+		if o.kind != '+' {
+			a++
+		}
+		if o.kind != '-' {
+			b++
+		}
+	}
+	return a, b
+}
+
+// lineOps computes an edit script through the longest common subsequence of lines
+func lineOps(a, b []string) []op {
+	// Common prefix and suffix keep the table small for typical edits
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var ops []op
+	for _, line := range a[:prefix] {
+		ops = append(ops, op{' ', line})
+	}
+
+	ma, mb := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	if (len(ma)+1)*(len(mb)+1) > maxCells {
+		for _, line := range ma {
+			ops = append(ops, op{'-', line})
+		}
+		for _, line := range mb {
+			ops = append(ops, op{'+', line})
+		}
+	} else {
+		ops = append(ops, lcsOps(ma, mb)...)
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		ops = append(ops, op{' ', line})
+	}
+	return ops
+}
+
+func lcsOps(a, b []string) []op {
+	// lengths[i][j] is the LCS length of a[i:] and b[j:]
+	lengths := make([][]int, len(a)+1)
+	for i := range lengths {
+		lengths[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else {
+				lengths[i][j] = max(lengths[i+1][j], lengths[i][j+1])
+			}
+		}
+	}
+
+	var ops []op
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			ops = append(ops, op{' ', a[i]})
+			i++
+			j++
+		case lengths[i+1][j] >= lengths[i][j+1]:
+			ops = append(ops, op{'-', a[i]})
+			i++
+		default:
+			ops = append(ops, op{'+', b[j]})
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		ops = append(ops, op{'-', a[i]})
+	}
+	for ; j < len(b); j++ {
+		ops = append(ops, op{'+', b[j]})
+	}
+	return ops
+}
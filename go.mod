@@ -2,4 +2,9 @@ module ccproxy
 
 go 1.21
 
-require gopkg.in/yaml.v2 v2.4.0
+require (
+	github.com/BurntSushi/toml v1.4.0
+	go.etcd.io/bbolt v1.3.10
+	golang.org/x/sys v0.9.0
+	gopkg.in/yaml.v2 v2.4.0
+)
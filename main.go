@@ -2,17 +2,59 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"os"
 
+	"ccproxy/cli"
 	"ccproxy/config"
 	"ccproxy/server"
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd := cli.Lookup(os.Args[1]); cmd != nil {
+			cmd.Main(os.Args[2:])
+			return
+		}
+	}
+
 	var configFile = flag.String("config", "config.yaml", "Configuration file path")
+	var daemon = flag.Bool("daemon", false, "Run in the background")
+	var pidFile = flag.String("pidfile", "", "Write the process id to this file (default "+cli.DefaultPidFile+" with -daemon)")
+	var logFile = flag.String("logfile", "ccproxy.log", "Output file when running with -daemon")
+	var workDir = flag.String("workdir", "", "Working directory, relative paths such as the config file and the data directory resolve against it")
+	flag.Usage = cli.PrintUsage
 	flag.Parse()
 
+	if *daemon && *pidFile == "" {
+		*pidFile = cli.DefaultPidFile
+	}
+
+	if *daemon && !cli.IsDaemonChild() {
+		pid, err := cli.Daemonize(*logFile)
+		if err != nil {
+			log.Fatalf("Failed to start daemon: %v", err)
+		}
+		fmt.Printf("ccproxy started in background (pid %d), logs: %s, pid file: %s\n", pid, *logFile, *pidFile)
+		return
+	}
+
+	// Changed in the process that serves, a daemon child would resolve a relative -workdir twice
+	if *workDir != "" {
+		if err := os.Chdir(*workDir); err != nil {
+			log.Fatalf("Failed to change to the working directory: %v", err)
+		}
+	}
+
+	// The Windows service manager discards output, keep the log next to the config
+	if cli.IsService() {
+		output, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err == nil {
+			log.SetOutput(output)
+		}
+	}
+
 	cfg, err := config.LoadConfig(*configFile)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
@@ -21,12 +63,36 @@ func main() {
 	srv := server.NewServer(cfg)
 
 	log.Printf("Proxy targets configured:")
-	for _, target := range cfg.Proxy.Targets {
-		log.Printf("  %s -> %s (methods: %v)", target.Path, target.TargetURL, target.Methods)
+	for _, target := range cfg.RouteTargets() {
+		if target.Response != nil {
+			log.Printf("  %s -> static response (methods: %v)", target.Route(), target.Methods)
+			continue
+		}
+		if target.Redirect != nil {
+			log.Printf("  %s -> redirect %s (methods: %v)", target.Route(), target.Redirect.Location, target.Methods)
+			continue
+		}
+		log.Printf("  %s -> %s (methods: %v)", target.Route(), target.TargetURL, target.Methods)
+	}
+
+	if cli.IsService() {
+		if err := cli.RunService(srv); err != nil {
+			log.Fatalf("Service failed: %v", err)
+		}
+		return
+	}
+
+	if *pidFile != "" {
+		if err := cli.WritePidFile(*pidFile); err != nil {
+			log.Fatalf("Failed to write pid file: %v", err)
+		}
+		defer cli.RemovePidFile(*pidFile)
 	}
 
 	if err := srv.Start(); err != nil {
+		if *pidFile != "" {
+			cli.RemovePidFile(*pidFile)
+		}
 		log.Fatalf("Server failed to start: %v", err)
-		os.Exit(1)
 	}
 }
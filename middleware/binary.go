@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"mime"
+	"strings"
+	"unicode/utf8"
+)
+
+// isBinaryContentType reports whether bodies of contentType are opaque
+// (images, uploads, archives...) and only summarized in logs. Bodies
+// without a content type are treated as text.
+func isBinaryContentType(contentType string) bool {
+	if contentType == "" {
+		return false
+	}
+
+	mediaType := mediaTypeOf(contentType)
+	switch {
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return false
+	}
+	switch mediaType {
+	case "application/json", "application/x-ndjson", "application/xml", "application/javascript",
+		"application/x-www-form-urlencoded", "application/yaml", "application/x-yaml", "application/graphql":
+		return false
+	}
+	return true
+}
+
+// mediaTypeOf returns contentType without parameters such as the multipart boundary
+func mediaTypeOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType, _, _ = strings.Cut(contentType, ";")
+		mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	}
+	return mediaType
+}
+
+// isBinaryBody is isBinaryContentType for a body that was read completely,
+// bodies without a content type are binary when they are not valid UTF-8
+func isBinaryBody(contentType string, body []byte) bool {
+	if contentType == "" {
+		return !utf8.Valid(body)
+	}
+	return isBinaryContentType(contentType)
+}
+
+// binarySummary replaces a binary body in logs, the dashboard recognizes the
+// [BINARY DATA prefix
+func binarySummary(contentType string, size int64, sum []byte) string {
+	mediaType := "unknown type"
+	if contentType != "" {
+		mediaType = mediaTypeOf(contentType)
+	}
+	return fmt.Sprintf("[BINARY DATA - %s, %d bytes, sha256 %s]", mediaType, size, hex.EncodeToString(sum))
+}
+
+// binarySummaryOf summarizes a body that was read completely
+func binarySummaryOf(contentType string, body []byte) string {
+	sum := sha256.Sum256(body)
+	return binarySummary(contentType, int64(len(body)), sum[:])
+}
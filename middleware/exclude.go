@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathFilter is a compiled logging.exclude_paths entry
+type pathFilter struct {
+	method  string         // Only requests with this method, any when empty
+	pattern string         // Glob matched with path.Match, a trailing * also matches nested paths
+	re      *regexp.Regexp // Set for ~regex entries
+}
+
+// compilePathFilters parses logging.exclude_paths entries of the form
+// "[METHOD ]pattern". Invalid entries were rejected by config.Validate and
+// are skipped.
+func compilePathFilters(entries []string) []pathFilter {
+	var filters []pathFilter
+	for _, entry := range entries {
+		var filter pathFilter
+		pattern := strings.TrimSpace(entry)
+		if method, rest, ok := strings.Cut(pattern, " "); ok {
+			filter.method, pattern = strings.ToUpper(method), strings.TrimSpace(rest)
+		}
+		if expr, ok := strings.CutPrefix(pattern, "~"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				log.Printf("[WARN] Invalid exclude pattern %q: %v", entry, err)
+				continue
+			}
+			filter.re = re
+		}
+		filter.pattern = pattern
+		filters = append(filters, filter)
+	}
+	return filters
+}
+
+func (f pathFilter) match(r *http.Request) bool {
+	if f.method != "" && f.method != r.Method {
+		return false
+	}
+	if f.re != nil {
+		return f.re.MatchString(r.URL.Path)
+	}
+	if matched, _ := path.Match(f.pattern, r.URL.Path); matched {
+		return true
+	}
+	prefix, ok := strings.CutSuffix(f.pattern, "*")
+	return ok && !strings.ContainsAny(prefix, "*?[\\") && strings.HasPrefix(r.URL.Path, prefix)
+}
+
+// excluded reports whether r matches logging.exclude_paths
+func (l *LoggerMiddleware) excluded(r *http.Request) bool {
+	for _, filter := range l.excludePaths {
+		if filter.match(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,46 +2,67 @@ package middleware
 
 import (
 	"bytes"
+	"context"
 	"compress/gzip"
 	"compress/zlib"
+	"crypto/sha256"
 	"fmt"
+	"hash"
 	"io"
 	"net/http"
+	"regexp"
 	"strings"
 	"time"
 
 	"ccproxy/config"
+	"ccproxy/types"
 	"ccproxy/websocket"
 )
 
 type LoggerMiddleware struct {
-	handler http.Handler
-	hub     *websocket.Hub
-	config  *config.Config
+	handler       http.Handler
+	hub           *websocket.Hub
+	config        *config.Config
+	redactHeaders []string
+	redactBodies  []*regexp.Regexp
+	excludePaths  []pathFilter
 }
 
 func NewLoggerMiddleware(handler http.Handler, hub *websocket.Hub, config *config.Config) *LoggerMiddleware {
 	return &LoggerMiddleware{
-		handler: handler,
-		hub:     hub,
-		config:  config,
+		handler:       handler,
+		hub:           hub,
+		config:        config,
+		redactHeaders: append(defaultRedactHeaders[:len(defaultRedactHeaders):len(defaultRedactHeaders)], config.Logging.RedactHeaders...),
+		redactBodies:  compileRedactPatterns(config.Logging.RedactPatterns),
+		excludePaths:  compilePathFilters(config.Logging.ExcludePaths),
 	}
 }
 
 func (l *LoggerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	start := time.Now()
-	
 
-	var requestBody []byte
-	if r.Body != nil {
-		requestBody, _ = io.ReadAll(r.Body)
-		r.Body = io.NopCloser(bytes.NewReader(requestBody))
-	}
+	// Tag the request, the ID is forwarded upstream, returned to the client and logged
+	id := requestID(r.Header.Get(RequestIDHeader))
+	r.Header.Set(RequestIDHeader, id)
+	*r = *r.WithContext(context.WithValue(r.Context(), "request_id", id))
 
 	wrapped := &responseWriterCapture{
 		ResponseWriter: w,
 		statusCode:     http.StatusOK,
 		body:           &bytes.Buffer{},
+		requestID:      id,
+		maxBody:        l.captureLimit(),
+	}
+
+	var requestBody []byte
+	hidden := l.excluded(r) || (l.hub != nil && l.hub.CapturePaused())
+	if hidden {
+		// Only counted in the statistics, see logging.exclude_paths and Hub.SetCapturePaused
+		wrapped.logLevel = config.LogNone
+	} else if r.Body != nil {
+		requestBody, _ = io.ReadAll(r.Body)
+		r.Body = io.NopCloser(bytes.NewReader(requestBody))
 	}
 
 	l.handler.ServeHTTP(wrapped, r)
@@ -49,6 +70,11 @@ func (l *LoggerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	duration := time.Since(start)
 
+	// Log the body that was forwarded when body_transforms changed it
+	if actualBody, ok := r.Context().Value("actual_request_body").([]byte); ok {
+		requestBody = actualBody
+	}
+
 	// Use actual headers sent to target if available, otherwise use original headers
 	requestHeaders := make(map[string]string)
 	if actualHeaders := r.Context().Value("actual_request_headers"); actualHeaders != nil {
@@ -71,21 +97,41 @@ func (l *LoggerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Keep credentials out of the dashboard and the history files
+	redactHeaders(requestHeaders, l.redactHeaders)
+	redactHeaders(responseHeaders, l.redactHeaders)
+
 	// Get target URL from wrapped response writer instead of context
 	targetURL := wrapped.targetURL
 
 	// Process response body for both streaming and regular responses
-	responseBody := l.processResponseBody(wrapped.body.Bytes(), responseHeaders)
+	var responseBody string
+	if wrapped.binaryHash != nil {
+		responseBody = binarySummary(wrapped.Header().Get("Content-Type"), wrapped.size, wrapped.binaryHash.Sum(nil))
+	} else {
+		responseTruncated := int64(wrapped.body.Len()) < wrapped.size
+		responseBody = l.processResponseBody(wrapped.body.Bytes(), responseHeaders, responseTruncated)
+		// Redacted before truncating, a secret cut at the limit would no longer match
+		responseBody = redactBody(responseBody, l.redactBodies)
+		responseBody = truncateBody(responseBody, l.config.Logging.MaxBodyBytes, wrapped.size, responseTruncated)
+	}
 	
 	// Add streaming indicator to help identify the response type
 	if wrapped.isStreaming && responseBody != "" {
 		responseBody = fmt.Sprintf("[STREAMING RESPONSE - %d bytes]\n%s", 
-			len(wrapped.body.Bytes()), responseBody)
+			wrapped.size, responseBody)
+	}
+
+	loggedRequestBody := truncateBody(redactBody(string(requestBody), l.redactBodies), l.config.Logging.MaxBodyBytes, int64(len(requestBody)), false)
+	if len(requestBody) > 0 && isBinaryBody(r.Header.Get("Content-Type"), requestBody) {
+		loggedRequestBody = binarySummaryOf(r.Header.Get("Content-Type"), requestBody)
 	}
 
 	logMessage := &websocket.LogMessage{
 		Timestamp:       start.Format("2006-01-02 15:04:05.000"),
+		RequestID:       id,
 		Method:          r.Method,
+		Host:            r.Host,
 		Path:            r.URL.Path,
 		Query:           r.URL.RawQuery,
 		RequestHeaders:  requestHeaders,
@@ -94,19 +140,56 @@ func (l *LoggerMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		StatusCode:      wrapped.statusCode,
 		Duration:        duration.String(),
 		TargetURL:       targetURL,
-		RequestBody:     string(requestBody),
+		RequestBody:     loggedRequestBody,
 		ResponseBody:    responseBody,
+		RequestSize:     int64(len(requestBody)),
+		ResponseSize:    wrapped.size,
+		AttemptCount:    len(wrapped.attempts),
+		Attempts:        wrapped.attempts,
+		Error:           wrapped.errorMessage,
+		ErrorType:       wrapped.errorType,
+	}
+
+	if wrapped.logLevel == config.LogMetadata || wrapped.logLevel == config.LogNone {
+		logMessage.RequestBody = ""
+		logMessage.ResponseBody = ""
 	}
 
 	// Extract and set connection metrics if available
 	l.setConnectionMetrics(logMessage, r, duration)
 
-	if l.hub != nil {
-		l.hub.Broadcast(logMessage)
+	if l.hub == nil {
+		return
+	}
+	if !hidden {
+		// Excluded and paused requests stay out of the access log, syslog and exporters too
+		l.hub.WriteSinks(logMessage)
+	}
+	if wrapped.logLevel == config.LogNone {
+		// Counted, but kept out of the dashboard and the history
+		l.hub.RecordStats(logMessage)
+		return
+	}
+	l.hub.Broadcast(logMessage)
+}
+
+// redactMargin is captured beyond logging.max_body_bytes when
+// logging.redact_patterns are set, so a secret crossing the limit still matches
+// before the body is truncated
+const redactMargin = 4096
+
+// captureLimit is how much of a response body is kept for logging, 0 keeps everything
+func (l *LoggerMiddleware) captureLimit() int {
+	limit := l.config.Logging.MaxBodyBytes
+	if limit > 0 && len(l.redactBodies) > 0 {
+		limit += redactMargin
 	}
+	return limit
 }
 
-func (l *LoggerMiddleware) processResponseBody(body []byte, headers map[string]string) string {
+// processResponseBody decompresses a logged response body, a truncated body
+// is decompressed as far as it goes
+func (l *LoggerMiddleware) processResponseBody(body []byte, headers map[string]string, truncated bool) string {
 	if len(body) == 0 {
 		return ""
 	}
@@ -121,14 +204,14 @@ func (l *LoggerMiddleware) processResponseBody(body []byte, headers map[string]s
 	contentEncodingLower := strings.ToLower(contentEncoding)
 
 	if strings.Contains(contentEncodingLower, "gzip") {
-		if decompressed, err := l.decompressGzip(body); err == nil {
+		if decompressed, err := l.decompressGzip(body); err == nil || (truncated && len(decompressed) > 0) {
 			return string(decompressed)
 		}
 		return "[GZIP COMPRESSED DATA - Failed to decompress]\n" + string(body)
 	}
 
 	if strings.Contains(contentEncodingLower, "deflate") {
-		if decompressed, err := l.decompressDeflate(body); err == nil {
+		if decompressed, err := l.decompressDeflate(body); err == nil || (truncated && len(decompressed) > 0) {
 			return string(decompressed)
 		}
 		return "[DEFLATE COMPRESSED DATA - Failed to decompress]\n" + string(body)
@@ -146,12 +229,7 @@ func (l *LoggerMiddleware) decompressGzip(data []byte) ([]byte, error) {
 	}
 	defer gzReader.Close()
 
-	decompressed, err := io.ReadAll(gzReader)
-	if err != nil {
-		return nil, err
-	}
-
-	return decompressed, nil
+	return io.ReadAll(gzReader)
 }
 
 func (l *LoggerMiddleware) decompressDeflate(data []byte) ([]byte, error) {
@@ -162,12 +240,24 @@ func (l *LoggerMiddleware) decompressDeflate(data []byte) ([]byte, error) {
 	}
 	defer zlibReader.Close()
 
-	decompressed, err := io.ReadAll(zlibReader)
-	if err != nil {
-		return nil, err
-	}
+	return io.ReadAll(zlibReader)
+}
 
-	return decompressed, nil
+// truncateBody cuts a logged body to limit bytes and marks it when it is
+// incomplete, size is the number of bytes that were relayed
+func truncateBody(body string, limit int, size int64, truncated bool) string {
+	if limit > 0 && len(body) > limit {
+		if !truncated {
+			// Decompressed bodies are longer than what was relayed, redacted ones may be shorter
+			size = max(size, int64(len(body)))
+		}
+		body = strings.ToValidUTF8(body[:limit], "")
+		truncated = true
+	}
+	if truncated {
+		body += fmt.Sprintf("\n[TRUNCATED - %d bytes total]", size)
+	}
+	return body
 }
 
 // ConnectionMetrics represents connection timing information
@@ -273,27 +363,64 @@ type responseWriterCapture struct {
 	body       *bytes.Buffer
 	isStreaming bool
 	targetURL  string
+	logLevel   string
+	requestID  string
+	wroteHeader bool
+	attempts   []types.Attempt
+	errorType  string
+	errorMessage string
+	maxBody    int   // logging.max_body_bytes plus redactMargin, 0 captures everything
+	size       int64 // bytes written to the client
+	checkedType bool
+	binaryHash hash.Hash // Set for binary responses, which are hashed instead of captured
 }
 
 func (rw *responseWriterCapture) WriteHeader(code int) {
+	// Replaces any request ID header copied from the upstream response
+	rw.Header().Set(RequestIDHeader, rw.requestID)
+	rw.wroteHeader = true
 	rw.statusCode = code
 	rw.ResponseWriter.WriteHeader(code)
 }
 
 func (rw *responseWriterCapture) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.size += int64(n)
+	if rw.logLevel == config.LogNone || rw.logLevel == config.LogMetadata {
+		// The body is not logged, do not keep it in memory
+		return n, err
+	}
+
+	if !rw.checkedType {
+		rw.checkedType = true
+		if isBinaryContentType(rw.Header().Get("Content-Type")) {
+			rw.binaryHash = sha256.New()
+		}
+	}
+	if rw.binaryHash != nil {
+		rw.binaryHash.Write(b[:n])
+		return n, err
+	}
+
 	// For streaming responses, capture the body for logging but mark as streaming
 	contentType := rw.Header().Get("Content-Type")
 	if strings.Contains(contentType, "text/event-stream") ||
 		strings.Contains(contentType, "application/x-ndjson") ||
 		rw.Header().Get("Transfer-Encoding") == "chunked" {
 		rw.isStreaming = true
-		// Still capture the body for complete logging
-		rw.body.Write(b)
-		return rw.ResponseWriter.Write(b)
 	}
-	
-	rw.body.Write(b)
-	return rw.ResponseWriter.Write(b)
+
+	// Only the first max_body_bytes are kept, the client still receives everything
+	captured := b[:n]
+	if rw.maxBody > 0 {
+		captured = captured[:min(len(captured), max(rw.maxBody-rw.body.Len(), 0))]
+	}
+	rw.body.Write(captured)
+	return n, err
 }
 
 // Implement http.Flusher interface
@@ -307,3 +434,30 @@ func (rw *responseWriterCapture) Flush() {
 func (rw *responseWriterCapture) SetTargetURL(url string) {
 	rw.targetURL = url
 }
+
+// RecordAttempt records one forwarding attempt, the URL of a successful
+// attempt becomes the target URL of the log entry
+func (rw *responseWriterCapture) RecordAttempt(targetURL string, statusCode int, duration time.Duration, err error) {
+	attempt := types.Attempt{TargetURL: targetURL, StatusCode: statusCode, Duration: duration.String()}
+	if err != nil {
+		attempt.Error = err.Error()
+	} else {
+		rw.targetURL = targetURL
+	}
+	rw.attempts = append(rw.attempts, attempt)
+}
+
+// SetError records why the request failed
+func (rw *responseWriterCapture) SetError(errorType, message string) {
+	rw.errorType = errorType
+	rw.errorMessage = message
+}
+
+// SetLogLevel limits what is logged for the request, see config.ProxyTarget.LogLevel
+func (rw *responseWriterCapture) SetLogLevel(level string) {
+	if rw.logLevel == config.LogNone {
+		// Excluded by logging.exclude_paths
+		return
+	}
+	rw.logLevel = level
+}
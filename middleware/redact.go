@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"log"
+	"regexp"
+	"strings"
+)
+
+// Redacted replaces the value of sensitive headers in logged requests
+const Redacted = "[REDACTED]"
+
+// defaultRedactHeaders are always masked, logging.redact_headers adds more
+var defaultRedactHeaders = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"X-Api-Key",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// redactHeaders masks the values of headers matching names in place, a
+// trailing * in a name matches every header with that prefix
+func redactHeaders(headers map[string]string, names []string) {
+	for key := range headers {
+		for _, name := range names {
+			if matchHeaderName(key, name) {
+				headers[key] = Redacted
+				break
+			}
+		}
+	}
+}
+
+func matchHeaderName(key, name string) bool {
+	if prefix, ok := strings.CutSuffix(name, "*"); ok {
+		return len(key) >= len(prefix) && strings.EqualFold(key[:len(prefix)], prefix)
+	}
+	return strings.EqualFold(key, name)
+}
+
+// compileRedactPatterns compiles logging.redact_patterns once per
+// configuration. Invalid patterns were rejected by config.Validate and are skipped.
+func compileRedactPatterns(patterns []string) []*regexp.Regexp {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("[WARN] Invalid redact pattern %q: %v", pattern, err)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled
+}
+
+// redactBody replaces every match of patterns in a logged body
+func redactBody(body string, patterns []*regexp.Regexp) string {
+	for _, re := range patterns {
+		body = re.ReplaceAllLiteralString(body, Redacted)
+	}
+	return body
+}
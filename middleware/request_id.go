@@ -0,0 +1,30 @@
+package middleware
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RequestIDHeader carries the request ID to the upstream and back to the client
+const RequestIDHeader = "X-Request-ID"
+
+// requestID keeps a client supplied ID when it is safe to log and forward,
+// otherwise it generates a new one
+func requestID(incoming string) string {
+	if incoming != "" && len(incoming) <= 128 {
+		valid := true
+		for _, c := range incoming {
+			if c < '!' || c > '~' {
+				valid = false
+				break
+			}
+		}
+		if valid {
+			return incoming
+		}
+	}
+
+	buf := make([]byte, 12)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// Error types reported to the logger, see ErrorSetter
+const (
+	ErrorDNS         = "dns"
+	ErrorConnect     = "connect"
+	ErrorTLS         = "tls"
+	ErrorTimeout     = "timeout"
+	ErrorUpstream5xx = "upstream_5xx"
+	ErrorProxy       = "proxy"
+	ErrorNoRoute     = "no_route"
+)
+
+// errUpstreamTimeout is returned when target.timeout expires before the response headers
+var errUpstreamTimeout = errors.New("upstream timeout")
+
+// classifyError maps a forwarding error to one of the error types above
+func classifyError(err error) string {
+	var dnsErr *net.DNSError
+	var recordErr tls.RecordHeaderError
+	var certErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	var opErr *net.OpError
+
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrorDNS
+	case errors.Is(err, errUpstreamTimeout), errors.Is(err, context.DeadlineExceeded),
+		errors.As(err, &netErr) && netErr.Timeout():
+		return ErrorTimeout
+	case errors.As(err, &recordErr), errors.As(err, &certErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr),
+		strings.Contains(err.Error(), "tls: "):
+		return ErrorTLS
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		return ErrorConnect
+	case errors.As(err, &opErr) && (opErr.Op == "proxyconnect" || opErr.Op == "socks connect"):
+		return ErrorConnect
+	}
+	return ErrorProxy
+}
+
+// setError reports a failed request to the logger, see ErrorSetter
+func setError(w http.ResponseWriter, errorType string, err error) {
+	if setter, ok := w.(ErrorSetter); ok {
+		setter.SetError(errorType, err.Error())
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/http/httptrace"
 	"net/url"
@@ -30,29 +30,31 @@ type ConnectionMetrics struct {
 	ConnectionReused  bool
 }
 
-func (p *ProxyHandler) forwardRequest(w http.ResponseWriter, r *http.Request, target *config.ProxyTarget) error {
+// forwardRequest sends r to target and copies the response to w, it returns
+// the upstream status code, or 0 when no response was received
+func (p *ProxyHandler) forwardRequest(w http.ResponseWriter, r *http.Request, target *config.ProxyTarget) (int, error) {
 	targetURL, err := p.buildTargetURL(r.URL, target)
 	if err != nil {
-		return fmt.Errorf("build target URL error: %w", err)
+		return 0, fmt.Errorf("build target URL error: %w", err)
 	}
 
 
 	// Cache request body for potential retries
 	bodyBytes, err := p.readAndCacheBody(r)
 	if err != nil {
-		return fmt.Errorf("failed to read request body: %w", err)
+		return 0, fmt.Errorf("failed to read request body: %w", err)
 	}
 
 	// Get effective proxy URL and create client
 	proxyURL := p.getEffectiveProxy(target)
-	client, err := p.createHTTPClientWithProxy(proxyURL)
+	client, err := p.createHTTPClientWithProxy(proxyURL, target.HostOverride)
 	if err != nil {
-		return fmt.Errorf("failed to create HTTP client with proxy: %w", err)
+		return 0, fmt.Errorf("failed to create HTTP client with proxy: %w", err)
 	}
 
 	// Log proxy usage for debugging
 	if proxyURL != "" {
-		fmt.Printf("[INFO] Using HTTP proxy: %s for target: %s\n", proxyURL, targetURL)
+		logf(r, "[INFO] Using HTTP proxy: %s for target: %s", proxyURL, maskQuery(targetURL, target))
 	}
 
 	// Initialize connection metrics
@@ -88,9 +90,18 @@ func (p *ProxyHandler) forwardRequest(w http.ResponseWriter, r *http.Request, ta
 		},
 	}
 
-	req, err := http.NewRequest(r.Method, targetURL, bytes.NewReader(bodyBytes))
+	// The timeout only covers waiting for response headers, streamed bodies may take longer
+	upstreamCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var headerTimer *time.Timer
+	timeout := p.headerTimeout(target)
+	if timeout > 0 {
+		headerTimer = time.AfterFunc(time.Duration(timeout)*time.Second, cancel)
+	}
+
+	req, err := http.NewRequestWithContext(upstreamCtx, r.Method, targetURL, bytes.NewReader(bodyBytes))
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		return 0, fmt.Errorf("failed to create request: %w", err)
 	}
 
 	// Add trace to request context
@@ -99,11 +110,31 @@ func (p *ProxyHandler) forwardRequest(w http.ResponseWriter, r *http.Request, ta
 	p.copyHeaders(req, r, target)
 
 	resp, err := client.Do(req)
+	if headerTimer != nil && !headerTimer.Stop() {
+		if err == nil {
+			resp.Body.Close()
+		}
+		err = fmt.Errorf("%w: no response headers within %ds", errUpstreamTimeout, timeout)
+		p.healthChecker.ReportRequest(target, time.Since(metrics.RequestStart), err.Error())
+		return 0, err
+	}
 	if err != nil {
-		return fmt.Errorf("HTTP client error: %w", err)
+		// The error names the URL, which must not show the query_params values
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			urlErr.URL = maskQuery(urlErr.URL, target)
+		}
+		p.healthChecker.ReportRequest(target, time.Since(metrics.RequestStart), err.Error())
+		return 0, fmt.Errorf("HTTP client error: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode >= 500 {
+		p.healthChecker.ReportRequest(target, time.Since(metrics.RequestStart), resp.Status)
+	} else {
+		p.healthChecker.ReportRequest(target, time.Since(metrics.RequestStart), "")
+	}
+
 	metrics.RequestEnd = time.Now()
 
 	// Convert metrics to map to avoid import cycle issues
@@ -160,10 +191,10 @@ func (p *ProxyHandler) forwardRequest(w http.ResponseWriter, r *http.Request, ta
 	}
 
 	if err != nil {
-		return fmt.Errorf("failed to copy response body: %w", err)
+		return resp.StatusCode, fmt.Errorf("failed to copy response body: %w", err)
 	}
 
-	return nil
+	return resp.StatusCode, nil
 }
 
 func (p *ProxyHandler) streamResponse(w http.ResponseWriter, resp *http.Response) error {
@@ -259,15 +290,16 @@ func (p *ProxyHandler) buildTargetURL(requestURL *url.URL, target *config.ProxyT
 		return "", err
 	}
 
-	path := requestURL.Path
-	if strings.HasSuffix(target.Path, "*") {
-		// For wildcard paths like /v1/*, preserve the full original path
-		// and append it to the target URL's path
+	requestPath, rewritten := p.rewritePath(requestURL.Path, target)
+	path := requestPath
+	if strings.HasSuffix(target.Path, "*") || target.PathRegex != "" || rewritten {
+		// For wildcard paths like /v1/* and path_regex, preserve the full original path
+		// (or its rewrite) and append it to the target URL's path
 		targetBasePath := strings.TrimSuffix(targetURL.Path, "/")
 		if targetBasePath == "" {
-			path = requestURL.Path
+			path = requestPath
 		} else {
-			path = targetBasePath + requestURL.Path
+			path = targetBasePath + requestPath
 		}
 	} else {
 		// For exact path matches, use the target URL's path
@@ -278,7 +310,7 @@ func (p *ProxyHandler) buildTargetURL(requestURL *url.URL, target *config.ProxyT
 		Scheme:   targetURL.Scheme,
 		Host:     targetURL.Host,
 		Path:     path,
-		RawQuery: requestURL.RawQuery,
+		RawQuery: rewriteQuery(requestURL.RawQuery, target),
 	}
 
 	return finalURL.String(), nil
@@ -295,15 +327,43 @@ func (p *ProxyHandler) copyHeaders(req *http.Request, original *http.Request, ta
 		}
 	}
 
+	if target.HostOverride != "" {
+		req.Host = target.HostOverride
+	}
+
+	// Drop client headers this target must not receive, target headers below may set them again
+	for _, name := range target.RemoveHeaders {
+		removeHeader(req.Header, name)
+	}
+
 	// Add target-specific headers (these will override original headers if same key exists)
 	for key, value := range target.Headers {
-		log.Printf("[INFO] Adding target header: %s = %s", key, value)
+		value, ok := p.renderHeader(key, value, original, target)
+		if !ok {
+			continue
+		}
+		// Only the name is logged, values often carry the upstream API key
+		logf(original, "[INFO] Adding target header: %s", key)
 		req.Header.Set(key, value)
 	}
 	
 	// Log final headers for debugging
 	if len(target.Headers) > 0 {
-		log.Printf("[INFO] Request headers applied for %s. Target headers count: %d", target.TargetURL, len(target.Headers))
+		logf(original, "[INFO] Request headers applied for %s. Target headers count: %d", target.TargetURL, len(target.Headers))
+	}
+}
+
+// removeHeader deletes name from header, a trailing * removes every header with that prefix
+func removeHeader(header http.Header, name string) {
+	if !strings.HasSuffix(name, "*") {
+		header.Del(name)
+		return
+	}
+	prefix := http.CanonicalHeaderKey(strings.TrimSuffix(name, "*"))
+	for key := range header {
+		if strings.HasPrefix(key, prefix) {
+			delete(header, key)
+		}
 	}
 }
 
@@ -1,11 +1,15 @@
 package proxy
 
 import (
+	"crypto/tls"
 	"fmt"
-	"log"
+	"math/rand"
+	"net"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strings"
+	"text/template"
 	"time"
 
 	"ccproxy/config"
@@ -16,22 +20,53 @@ type TargetURLSetter interface {
 	SetTargetURL(url string)
 }
 
+// LogLevelSetter interface allows a target to limit how its requests are logged
+type LogLevelSetter interface {
+	SetLogLevel(level string)
+}
+
+// AttemptRecorder interface allows recording every forwarding attempt for logging
+type AttemptRecorder interface {
+	RecordAttempt(targetURL string, statusCode int, duration time.Duration, err error)
+}
+
+// ErrorSetter interface allows reporting why a request failed for logging,
+// errorType is one of the Error* constants
+type ErrorSetter interface {
+	SetError(errorType, message string)
+}
+
 type ProxyHandler struct {
-	config        *config.Config
-	client        *http.Client
-	healthChecker *HealthChecker
+	config          *config.Config
+	routes          []config.ProxyTarget // proxy.targets in matching order
+	client          *http.Client
+	clients         map[clientKey]*http.Client // Built once per HTTP proxy and host_override
+	healthChecker   *HealthChecker
+	headerTemplates map[string]*template.Template
+	rewrites        map[string]*regexp.Regexp
+	pathPatterns    map[string]*regexp.Regexp // path_regex and match_headers patterns
 }
 
 func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 	healthChecker := NewHealthChecker()
+	healthChecker.passiveFailures = cfg.Proxy.PassiveFailures
+	healthChecker.healthChecks = cfg.Proxy.HealthCheck
+	healthChecker.httpProxy = cfg.Proxy.HTTPProxy
+
+	targets := cfg.RouteTargets()
 	
 	// Start health checks for all target URLs
-	healthChecker.StartHealthChecks(cfg.Proxy.Targets)
+	healthChecker.StartHealthChecks(targets)
 	
 	return &ProxyHandler{
-		config:        cfg,
-		healthChecker: healthChecker,
-		client: &http.Client{
+		config:          cfg,
+		clients:         buildClients(cfg, targets),
+		routes:          sortRoutes(cfg.Proxy.Targets),
+		healthChecker:   healthChecker,
+		headerTemplates: compileHeaderTemplates(targets),
+		rewrites:        compileRewrites(targets),
+		pathPatterns:    compilePathPatterns(targets),
+		client:          &http.Client{
 			// No timeout for proxy client to support long-running requests
 			// including streaming responses, file uploads, and AI model inference
 		},
@@ -41,19 +76,42 @@ func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// Enhanced logging with request context
 	requestInfo := p.getRequestInfo(r)
-	log.Printf("[INFO] Incoming request: %s", requestInfo)
+	logf(r, "[INFO] Incoming request: %s", requestInfo)
 
-	target := p.findTarget(r.URL.Path, r.Method)
+	target := replayTarget(r, p.findTarget(r.Host, r.URL.Path, r.Method, r.Header))
 	if target == nil {
-		log.Printf("[WARN] No matching target found for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		logf(r, "[WARN] No matching target found for %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		setError(w, ErrorNoRoute, fmt.Errorf("no route for %s %s%s", r.Method, r.Host, r.URL.Path))
 		http.Error(w, "Not Found", http.StatusNotFound)
 		return
 	}
 
+	if target == p.config.Proxy.Fallback {
+		logf(r, "[WARN] No target matches %s %s, using proxy.fallback", r.Method, r.URL.Path)
+	}
+
+	if setter, ok := w.(LogLevelSetter); ok {
+		setter.SetLogLevel(p.logLevel(target))
+	}
+
+	if target.InMaintenance() {
+		p.serveMaintenance(w, r, target)
+		return
+	}
+	if target.Response != nil {
+		p.serveStatic(w, r, target)
+		return
+	}
+	if target.Redirect != nil {
+		p.serveRedirect(w, r, target)
+		return
+	}
+
 	// Select the fastest healthy URL
 	fastestURL := p.selectFastestURL(target)
 	if fastestURL == "" {
-		log.Printf("[ERROR] No available URLs for target %s", target.Path)
+		logf(r, "[ERROR] No available URLs for target %s", target.Route())
+		setError(w, ErrorProxy, fmt.Errorf("no available URLs for target %s", target.Route()))
 		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
 		return
 	}
@@ -64,32 +122,63 @@ func (p *ProxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	targetURL, err := p.buildTargetURL(r.URL, &selectedTarget)
 	if err != nil {
-		log.Printf("[ERROR] Failed to build target URL for %s: %v (Original path: %s, Target: %s)",
+		logf(r, "[ERROR] Failed to build target URL for %s: %v (Original path: %s, Target: %s)",
 			r.URL.Path, err, r.URL.String(), fastestURL)
+		setError(w, ErrorProxy, fmt.Errorf("failed to build target URL: %w", err))
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return
 	}
+	// Only logged here, forwardRequest builds the URL it sends
+	targetURL = maskQuery(targetURL, &selectedTarget)
 
-	log.Printf("[INFO] Routing %s %s -> %s", r.Method, r.URL.Path, targetURL)
+	logf(r, "[INFO] Routing %s %s -> %s", r.Method, r.URL.Path, targetURL)
 	
 	// Set target URL in response writer for logging
 	if setter, ok := w.(TargetURLSetter); ok {
 		setter.SetTargetURL(targetURL)
 	}
 
-	if err := p.forwardRequestWithRetry(w, r, &selectedTarget); err != nil {
-		log.Printf("[ERROR] Failed to forward request to %s after all retries: %v (Client: %s, UserAgent: %s)",
-			targetURL, err, r.RemoteAddr, r.Header.Get("User-Agent"))
+	if err := p.transformBody(r, &selectedTarget); err != nil {
+		logf(r, "[ERROR] Failed to transform request body for %s: %v", r.URL.Path, err)
+		setError(w, ErrorProxy, fmt.Errorf("failed to transform request body: %w", err))
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	statusCode, err := p.forwardRequestWithRetry(w, r, &selectedTarget)
+	if err != nil {
+		errorType := classifyError(err)
+		logf(r, "[ERROR] Failed to forward request to %s after all retries (%s): %v (Client: %s, UserAgent: %s)",
+			targetURL, errorType, err, r.RemoteAddr, r.Header.Get("User-Agent"))
+		setError(w, errorType, err)
 		http.Error(w, "Bad Gateway", http.StatusBadGateway)
 		return
 	}
+	if statusCode >= 500 {
+		logf(r, "[WARN] Upstream %s returned %d", targetURL, statusCode)
+		setError(w, ErrorUpstream5xx, fmt.Errorf("upstream returned %d %s", statusCode, http.StatusText(statusCode)))
+	}
+}
+
+// logLevel returns how the request is logged, requests left out by
+// logging.sample_rate or the target's sample_rate are logged without bodies
+func (p *ProxyHandler) logLevel(target *config.ProxyTarget) string {
+	level := target.LogLevel()
+	rate := p.config.Logging.SampleRate
+	if target.SampleRate != nil {
+		rate = target.SampleRate
+	}
+	if level == config.LogFull && rate != nil && rand.Float64() >= *rate {
+		return config.LogMetadata
+	}
+	return level
 }
 
 // selectFastestURL selects the fastest healthy URL from the target's URLs
 func (p *ProxyHandler) selectFastestURL(target *config.ProxyTarget) string {
 	// If there are multiple URLs, use health checker to find the fastest
 	if len(target.TargetURLs) > 1 {
-		return p.healthChecker.GetFastestHealthyURL(target.TargetURLs)
+		return p.healthChecker.GetFastestHealthyURL(target.TargetURLs, time.Duration(target.SlowStart)*time.Second)
 	}
 	
 	// If there's only one URL in TargetURLs, use it
@@ -101,6 +190,15 @@ func (p *ProxyHandler) selectFastestURL(target *config.ProxyTarget) string {
 	return target.TargetURL
 }
 
+// Close stops the background health checks owned by this handler.
+// Requests already being served keep working with the last known health data.
+func (p *ProxyHandler) Close() {
+	p.healthChecker.Stop()
+	for _, client := range p.clients {
+		client.CloseIdleConnections()
+	}
+}
+
 // GetHealthChecker returns the health checker instance for external access
 func (p *ProxyHandler) GetHealthChecker() *HealthChecker {
 	return p.healthChecker
@@ -112,15 +210,81 @@ func (p *ProxyHandler) getRequestInfo(r *http.Request) string {
 		r.Header.Get("User-Agent"), r.ContentLength)
 }
 
-func (p *ProxyHandler) findTarget(path, method string) *config.ProxyTarget {
-	for _, target := range p.config.Proxy.Targets {
-		if p.matchPath(path, target.Path) && p.matchMethod(method, target.Methods) {
+func (p *ProxyHandler) findTarget(host, path, method string, header http.Header) *config.ProxyTarget {
+	for _, target := range p.routes {
+		if matchHost(host, target.Host) && p.matchTarget(path, &target) && p.matchMethod(method, target.Methods) &&
+			p.matchHeaders(header, target.MatchHeaders) {
 			return &target
 		}
 	}
+	if fallback := p.config.Proxy.Fallback; fallback != nil && p.matchMethod(method, fallback.Methods) {
+		return fallback
+	}
 	return nil
 }
 
+// matchHost matches the request host, without port, against the host of a
+// target. An empty pattern matches every host, "*.example.com" any subdomain.
+func matchHost(requestHost, pattern string) bool {
+	if pattern == "" {
+		return true
+	}
+	requestHost = strings.TrimSuffix(hostname(requestHost), ".")
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok {
+		return len(requestHost) > len(suffix) && strings.HasSuffix(strings.ToLower(requestHost), strings.ToLower(suffix))
+	}
+	return strings.EqualFold(requestHost, pattern)
+}
+
+// matchHeaders reports whether every header condition of a target holds.
+// Comma separated header values such as anthropic-beta match when any
+// element does.
+func (p *ProxyHandler) matchHeaders(header http.Header, conditions map[string]string) bool {
+	for name, condition := range conditions {
+		if !p.matchHeader(header.Values(name), condition) {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *ProxyHandler) matchHeader(values []string, condition string) bool {
+	for _, value := range values {
+		if p.matchHeaderValue(value, condition) {
+			return true
+		}
+		for _, element := range strings.Split(value, ",") {
+			if p.matchHeaderValue(strings.TrimSpace(element), condition) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *ProxyHandler) matchHeaderValue(value, condition string) bool {
+	if pattern, ok := strings.CutPrefix(condition, "~"); ok {
+		re := p.pathPatterns[pattern]
+		return re != nil && re.MatchString(value)
+	}
+	if condition == "*" {
+		return value != ""
+	}
+	if prefix, ok := strings.CutSuffix(condition, "*"); ok {
+		return strings.HasPrefix(value, prefix)
+	}
+	return value == condition
+}
+
+// matchTarget matches path against the path_regex of target, or its path
+func (p *ProxyHandler) matchTarget(path string, target *config.ProxyTarget) bool {
+	if target.PathRegex != "" {
+		re := p.pathPatterns[target.PathRegex]
+		return re != nil && re.MatchString(path)
+	}
+	return p.matchPath(path, target.Path)
+}
+
 func (p *ProxyHandler) matchPath(requestPath, targetPath string) bool {
 	if strings.HasSuffix(targetPath, "*") {
 		prefix := strings.TrimSuffix(targetPath, "*")
@@ -142,47 +306,74 @@ func (p *ProxyHandler) matchMethod(method string, allowedMethods []string) bool
 	return false
 }
 
-func (p *ProxyHandler) forwardRequestWithRetry(w http.ResponseWriter, r *http.Request, target *config.ProxyTarget) error {
+func (p *ProxyHandler) forwardRequestWithRetry(w http.ResponseWriter, r *http.Request, target *config.ProxyTarget) (int, error) {
 	var lastErr error
-	maxRetries := p.config.Proxy.MaxRetries
-	retryDelay := time.Duration(p.config.Proxy.RetryDelay) * time.Millisecond
+	maxRetries, retryDelayMs := p.retryPolicy(target)
+	retryDelay := time.Duration(retryDelayMs) * time.Millisecond
 
 	targetURL, err := p.buildTargetURL(r.URL, target)
 	if err != nil {
-		return fmt.Errorf("failed to build target URL: %w", err)
+		return 0, fmt.Errorf("failed to build target URL: %w", err)
 	}
+	targetURL = maskQuery(targetURL, target) // Logged and recorded in the attempts
 
 	for attempt := 0; attempt <= maxRetries; attempt++ {
 		if attempt > 0 {
-			log.Printf("[WARN] Retrying request to %s (attempt %d/%d) after %dms delay",
-				targetURL, attempt, maxRetries, p.config.Proxy.RetryDelay)
+			logf(r, "[WARN] Retrying request to %s (attempt %d/%d) after %dms delay",
+				targetURL, attempt, maxRetries, retryDelayMs)
 			time.Sleep(retryDelay)
 		}
 
 		startTime := time.Now()
-		err := p.forwardRequest(w, r, target)
+		statusCode, err := p.forwardRequest(w, r, target)
 		duration := time.Since(startTime)
 
+		if recorder, ok := w.(AttemptRecorder); ok {
+			recorder.RecordAttempt(targetURL, statusCode, duration, err)
+		}
+
 		if err == nil {
 			if attempt > 0 {
-				log.Printf("[INFO] Request succeeded on retry %d to %s (took %v)", attempt, targetURL, duration)
+				logf(r, "[INFO] Request succeeded on retry %d to %s (took %v)", attempt, targetURL, duration)
 			}
-			return nil
+			return statusCode, nil
 		}
 
 		lastErr = err
 
 		// Check if the error is retryable
 		if !p.isRetryableError(err) {
-			log.Printf("[ERROR] Non-retryable error for %s after %v: %v", targetURL, duration, err)
+			logf(r, "[ERROR] Non-retryable error for %s after %v: %v", targetURL, duration, err)
 			break
 		}
 
-		log.Printf("[WARN] Retryable error for %s (attempt %d/%d, took %v): %v",
+		logf(r, "[WARN] Retryable error for %s (attempt %d/%d, took %v): %v",
 			targetURL, attempt+1, maxRetries+1, duration, err)
 	}
 
-	return fmt.Errorf("request failed after %d attempts: %w", maxRetries+1, lastErr)
+	return 0, fmt.Errorf("request failed after %d attempts: %w", maxRetries+1, lastErr)
+}
+
+// retryPolicy returns the retry count and delay in milliseconds for target,
+// its own settings override proxy.max_retries and proxy.retry_delay
+func (p *ProxyHandler) retryPolicy(target *config.ProxyTarget) (int, int) {
+	maxRetries, retryDelay := p.config.Proxy.MaxRetries, p.config.Proxy.RetryDelay
+	if target.MaxRetries != nil {
+		maxRetries = *target.MaxRetries
+	}
+	if target.RetryDelay != nil {
+		retryDelay = *target.RetryDelay
+	}
+	return maxRetries, retryDelay
+}
+
+// headerTimeout returns the seconds to wait for response headers from
+// target, its own timeout overrides proxy.timeout
+func (p *ProxyHandler) headerTimeout(target *config.ProxyTarget) int {
+	if target.Timeout != nil {
+		return *target.Timeout
+	}
+	return p.config.Proxy.Timeout
 }
 
 func (p *ProxyHandler) isRetryableError(err error) bool {
@@ -214,22 +405,74 @@ func (p *ProxyHandler) getEffectiveProxy(target *config.ProxyTarget) string {
 	return p.config.Proxy.HTTPProxy
 }
 
-func (p *ProxyHandler) createHTTPClientWithProxy(proxyURL string) (*http.Client, error) {
-	if proxyURL == "" {
+// clientKey identifies the transport settings a client is built with
+type clientKey struct {
+	proxyURL     string
+	hostOverride string
+}
+
+// buildClients creates the clients of targets when the routes load, so
+// requests reuse their transport and keep-alive connections. Invalid proxy
+// URLs are skipped and fail again on the requests using them.
+func buildClients(cfg *config.Config, targets []config.ProxyTarget) map[clientKey]*http.Client {
+	clients := map[clientKey]*http.Client{}
+	for _, target := range targets {
+		key := clientKey{proxyURL: cfg.Proxy.HTTPProxy, hostOverride: target.HostOverride}
+		if target.HTTPProxy != "" {
+			key.proxyURL = target.HTTPProxy
+		}
+		if key == (clientKey{}) || clients[key] != nil {
+			continue
+		}
+		client, err := newHTTPClient(key.proxyURL, key.hostOverride)
+		if err != nil {
+			continue
+		}
+		clients[key] = client
+	}
+	return clients
+}
+
+func (p *ProxyHandler) createHTTPClientWithProxy(proxyURL, hostOverride string) (*http.Client, error) {
+	if client := p.clients[clientKey{proxyURL: proxyURL, hostOverride: hostOverride}]; client != nil {
+		return client, nil
+	}
+	return newHTTPClient(proxyURL, hostOverride)
+}
+
+// newHTTPClient returns a client sending requests through proxyURL and
+// presenting hostOverride as TLS server name, health checks use it too so
+// they reach upstreams the same way as proxied requests
+func newHTTPClient(proxyURL, hostOverride string) (*http.Client, error) {
+	if proxyURL == "" && hostOverride == "" {
 		// Return default client without proxy
 		return &http.Client{}, nil
 	}
 
-	parsedProxyURL, err := url.Parse(proxyURL)
-	if err != nil {
-		return nil, fmt.Errorf("invalid proxy URL %s: %w", proxyURL, err)
+	transport := &http.Transport{}
+	if proxyURL == "" {
+		transport = http.DefaultTransport.(*http.Transport).Clone()
+	} else {
+		parsedProxyURL, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %s: %w", proxyURL, err)
+		}
+		transport.Proxy = http.ProxyURL(parsedProxyURL)
 	}
-
-	transport := &http.Transport{
-		Proxy: http.ProxyURL(parsedProxyURL),
+	if hostOverride != "" {
+		// Present the overridden host for SNI and certificate verification
+		transport.TLSClientConfig = &tls.Config{ServerName: hostname(hostOverride)}
 	}
 
 	return &http.Client{
 		Transport: transport,
 	}, nil
 }
+
+// hostname strips the port from a host_override value
+func hostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
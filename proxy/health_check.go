@@ -4,7 +4,9 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"math/rand"
 	"net/http"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -18,12 +20,44 @@ type URLHealth struct {
 	IsHealthy      bool
 	ResponseTime   time.Duration
 	LastCheck      time.Time
-	ErrorCount     int
+	ErrorCount     int // Consecutive failed checks
+	SuccessCount   int // Consecutive successful checks
 	TotalChecks    int
 	SuccessChecks  int
 	AverageTime    time.Duration
 	MinTime        time.Duration
 	MaxTime        time.Duration
+	Override       string    // OverrideUp or OverrideDown when set by an administrator
+	RecoveredAt    time.Time // When the URL last turned healthy again, zero if it never failed
+	EjectedUntil   time.Time // End of the ejection by outlier detection
+	ChecksDisabled bool      // No periodic health checks, the status follows proxied requests
+
+	// Outcomes of proxied requests, see ReportRequest
+	Requests       int
+	FailedRequests int
+	RequestErrors  int           // Consecutive failed requests
+	RequestLatency time.Duration // Moving average time to the response headers of successful requests
+	LastRequest    time.Time
+
+	recent []requestOutcome // Requests within the outlier detection window
+}
+
+// Manual states set through SetOverride, they take precedence over the
+// health checks which keep running meanwhile
+const (
+	OverrideUp   = "up"
+	OverrideDown = "down"
+)
+
+// Available reports whether requests may be sent to the URL
+func (h *URLHealth) Available() bool {
+	switch h.Override {
+	case OverrideUp:
+		return true
+	case OverrideDown:
+		return false
+	}
+	return h.IsHealthy && !h.ejected()
 }
 
 // HealthChecker manages health checks for multiple URLs
@@ -31,28 +65,73 @@ type HealthChecker struct {
 	urlHealthMap map[string]*URLHealth
 	mutex        sync.RWMutex
 	client       *http.Client
+	hosts        map[string]string       // host_override per URL
+	clients      map[string]*http.Client // Clients of URLs with an HTTP proxy or host_override
+	httpProxy    string                  // proxy.http_proxy, for targets without their own
+	stop         chan struct{}
+	stopOnce     sync.Once
+	onChange     func(health URLHealth, errorMsg string) // Called when a URL turns healthy or unhealthy
+
+	passiveFailures int   // Consecutive failed requests marking a URL unhealthy, never when not positive
+	healthChecks    *bool // proxy.health_check, see config.ProxyTarget.HealthCheckEnabled
 }
 
 // NewHealthChecker creates a new health checker
 func NewHealthChecker() *HealthChecker {
 	return &HealthChecker{
 		urlHealthMap: make(map[string]*URLHealth),
+		hosts:        make(map[string]string),
+		clients:      make(map[string]*http.Client),
+		stop:         make(chan struct{}),
 		client: &http.Client{
-			Timeout: 5 * time.Second, // 5 second timeout for health checks
+			// Every check sets its own timeout, see healthCheckTimeout
 		},
 	}
 }
 
-// StartHealthChecks starts periodic health checks for all target URLs
+// StartHealthChecks starts periodic health checks for all target URLs,
+// except those of targets that disabled them
 func (hc *HealthChecker) StartHealthChecks(targets []config.ProxyTarget) {
 	for _, target := range targets {
+		checks := target.HealthCheckEnabled(hc.healthChecks)
 		for _, url := range target.TargetURLs {
 			hc.initializeURLHealth(url)
-			go hc.runPeriodicHealthCheck(url, target.HealthCheckPath, target.HealthCheckDelay)
+			hc.setClient(url, &target)
+			if checks {
+				go hc.runPeriodicHealthCheck(url, target)
+			} else {
+				hc.disableChecks(url)
+				go hc.runPassiveRecovery(url, target)
+			}
 		}
 	}
 }
 
+// OnChange sets fn to be called after a check turns a URL healthy or
+// unhealthy, with the updated status and the error of a failed check
+func (hc *HealthChecker) OnChange(fn func(health URLHealth, errorMsg string)) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.onChange = fn
+}
+
+// SetOverride forces url up or down regardless of its health checks, an
+// empty state hands it back to the checks
+func (hc *HealthChecker) SetOverride(url, state string) error {
+	if state != "" && state != OverrideUp && state != OverrideDown {
+		return fmt.Errorf("invalid state %q, expected %q, %q or \"auto\"", state, OverrideUp, OverrideDown)
+	}
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	health, exists := hc.urlHealthMap[url]
+	if !exists {
+		return fmt.Errorf("unknown upstream URL %s", url)
+	}
+	health.Override = state
+	return nil
+}
+
 // initializeURLHealth initializes health status for a URL
 func (hc *HealthChecker) initializeURLHealth(url string) {
 	hc.mutex.Lock()
@@ -68,40 +147,168 @@ func (hc *HealthChecker) initializeURLHealth(url string) {
 	}
 }
 
-// runPeriodicHealthCheck runs health checks at specified intervals
-func (hc *HealthChecker) runPeriodicHealthCheck(url, healthPath string, delaySeconds int) {
-	ticker := time.NewTicker(time.Duration(delaySeconds) * time.Second)
+// runPeriodicHealthCheck runs health checks at specified intervals. The
+// intervals vary by up to a tenth so the checks of many URLs spread out.
+func (hc *HealthChecker) runPeriodicHealthCheck(url string, target config.ProxyTarget) {
+	interval := time.Duration(target.HealthCheckDelay) * time.Second
+	timer := time.NewTimer(jitter(interval / 10))
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			hc.checkURLHealth(url, &target)
+			timer.Reset(interval - interval/10 + jitter(interval/5))
+		case <-hc.stop:
+			return
+		}
+	}
+}
+
+// disableChecks marks url as not checked periodically
+func (hc *HealthChecker) disableChecks(url string) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+	hc.urlHealthMap[url].ChecksDisabled = true
+}
+
+// runPassiveRecovery stands in for the health checks of a URL whose target
+// disabled them. Only failed proxied requests mark such a URL unhealthy and
+// no requests are sent to it afterwards, so it is assumed healthy again once
+// it was left alone for a health check interval. A single failed request
+// then marks it unhealthy again.
+func (hc *HealthChecker) runPassiveRecovery(url string, target config.ProxyTarget) {
+	interval := time.Duration(target.HealthCheckDelay) * time.Second
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	// Run initial health check
-	hc.checkURLHealth(url, healthPath)
+	for {
+		select {
+		case <-ticker.C:
+			hc.readmit(url, interval)
+		case <-hc.stop:
+			return
+		}
+	}
+}
+
+// readmit marks url healthy when it is unhealthy and had no requests for idle
+func (hc *HealthChecker) readmit(url string, idle time.Duration) {
+	var changed *URLHealth
+	var onChange func(URLHealth, string)
+	defer func() {
+		if changed != nil && onChange != nil {
+			onChange(*changed, "")
+		}
+	}()
 
-	for range ticker.C {
-		hc.checkURLHealth(url, healthPath)
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	health, exists := hc.urlHealthMap[url]
+	if !exists || health.IsHealthy || time.Since(health.LastRequest) < idle {
+		return
 	}
+	health.IsHealthy = true
+	health.RecoveredAt = time.Now()
+	log.Printf("[INFO] URL %s assumed healthy again after %v without requests, health checks are disabled", url, idle)
+	healthCopy := *health
+	changed, onChange = &healthCopy, hc.onChange
+}
+
+// jitter returns a random duration below d
+func jitter(d time.Duration) time.Duration {
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d)))
+}
+
+// healthCheckTimeout returns how long each health check request of target may take
+func healthCheckTimeout(target *config.ProxyTarget) time.Duration {
+	if target.HealthCheckTimeout > 0 {
+		return time.Duration(target.HealthCheckTimeout) * time.Second
+	}
+	return 3 * time.Second
+}
+
+// Stop terminates all periodic health checks started by this checker
+func (hc *HealthChecker) Stop() {
+	hc.stopOnce.Do(func() {
+		close(hc.stop)
+	})
 }
 
 // checkURLHealth performs a health check on a specific URL
-func (hc *HealthChecker) checkURLHealth(baseURL, healthPath string) {
-	// Try multiple health check strategies
-	isHealthy, responseTime, errorMsg := hc.performHealthCheck(baseURL, healthPath)
-	hc.updateHealthStatus(baseURL, isHealthy, responseTime, errorMsg)
+func (hc *HealthChecker) checkURLHealth(baseURL string, target *config.ProxyTarget) {
+	var isHealthy bool
+	var responseTime time.Duration
+	var errorMsg string
+	if target.CustomHealthCheck() {
+		isHealthy, responseTime, errorMsg = hc.performCustomHealthCheck(baseURL, target)
+	} else {
+		// Try multiple health check strategies
+		isHealthy, responseTime, errorMsg = hc.performHealthCheck(baseURL, target.HealthCheckPath, healthCheckTimeout(target))
+	}
+	hc.updateHealthStatus(baseURL, target, isHealthy, responseTime, errorMsg)
+}
+
+// CheckNow runs a health check for url of target right away and returns the updated status
+func (hc *HealthChecker) CheckNow(url string, target *config.ProxyTarget) *URLHealth {
+	hc.initializeURLHealth(url)
+	hc.setClient(url, target)
+	hc.checkURLHealth(url, target)
+	return hc.GetURLHealth(url)
+}
+
+// setClient prepares checking url the way requests of target are forwarded,
+// through its effective HTTP proxy and presenting its host_override
+func (hc *HealthChecker) setClient(url string, target *config.ProxyTarget) {
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	proxyURL := target.HTTPProxy
+	if proxyURL == "" {
+		proxyURL = hc.httpProxy
+	}
+	if target.HostOverride != "" {
+		hc.hosts[url] = target.HostOverride
+	}
+	if (proxyURL == "" && target.HostOverride == "") || hc.clients[url] != nil {
+		return
+	}
+	client, err := newHTTPClient(proxyURL, target.HostOverride)
+	if err != nil {
+		log.Printf("[WARN] Health checks of %s do not use the HTTP proxy: %v", url, err)
+		return
+	}
+	hc.clients[url] = client
+}
+
+// clientFor returns the client and Host header to use for checking url
+func (hc *HealthChecker) clientFor(url string) (*http.Client, string) {
+	hc.mutex.RLock()
+	defer hc.mutex.RUnlock()
+	if client := hc.clients[url]; client != nil {
+		return client, hc.hosts[url]
+	}
+	return hc.client, hc.hosts[url]
 }
 
 // performHealthCheck tries different strategies to determine if a URL is healthy
-func (hc *HealthChecker) performHealthCheck(baseURL, healthPath string) (bool, time.Duration, string) {
+func (hc *HealthChecker) performHealthCheck(baseURL, healthPath string, timeout time.Duration) (bool, time.Duration, string) {
 	start := time.Now()
 	
 	// Strategy 1: Try the configured health check path
 	if healthPath != "" && healthPath != "/" {
-		if healthy, responseTime, _ := hc.tryHealthCheckURL(baseURL, healthPath, start); healthy {
+		if healthy, responseTime, _ := hc.tryHealthCheckURL(baseURL, healthPath, start, timeout); healthy {
 			return true, responseTime, ""
 		}
 	}
 	
 	// Strategy 2: Try root path "/"
 	if healthPath != "/" {
-		if healthy, responseTime, _ := hc.tryHealthCheckURL(baseURL, "/", start); healthy {
+		if healthy, responseTime, _ := hc.tryHealthCheckURL(baseURL, "/", start, timeout); healthy {
 			return true, responseTime, ""
 		}
 	}
@@ -110,7 +317,7 @@ func (hc *HealthChecker) performHealthCheck(baseURL, healthPath string) (bool, t
 	commonPaths := []string{"/health", "/ping", "/status", "/api/health"}
 	for _, path := range commonPaths {
 		if path != healthPath { // Skip if already tried
-			if healthy, responseTime, _ := hc.tryHealthCheckURL(baseURL, path, start); healthy {
+			if healthy, responseTime, _ := hc.tryHealthCheckURL(baseURL, path, start, timeout); healthy {
 				return true, responseTime, ""
 			}
 		}
@@ -118,7 +325,7 @@ func (hc *HealthChecker) performHealthCheck(baseURL, healthPath string) (bool, t
 	
 	// Strategy 4: Accept 404 as healthy for API endpoints (server is responsive)
 	// Some APIs don't have a proper health endpoint but are still functional
-	if _, responseTime, statusCode := hc.tryHealthCheckURL(baseURL, healthPath, start); statusCode == 404 {
+	if _, responseTime, statusCode := hc.tryHealthCheckURL(baseURL, healthPath, start, timeout); statusCode == 404 {
 		// 404 means the server is responding, just no health endpoint
 		return true, responseTime, ""
 	}
@@ -129,27 +336,22 @@ func (hc *HealthChecker) performHealthCheck(baseURL, healthPath string) (bool, t
 }
 
 // tryHealthCheckURL attempts a health check on a specific URL path
-func (hc *HealthChecker) tryHealthCheckURL(baseURL, path string, startTime time.Time) (bool, time.Duration, int) {
-	// Build health check URL
-	healthURL := baseURL
-	if path != "/" && path != "" {
-		// Ensure baseURL doesn't end with / and path starts with /
-		baseURL = strings.TrimSuffix(baseURL, "/")
-		if !strings.HasPrefix(path, "/") {
-			path = "/" + path
-		}
-		healthURL = baseURL + path
-	}
+func (hc *HealthChecker) tryHealthCheckURL(baseURL, path string, startTime time.Time, timeout time.Duration) (bool, time.Duration, int) {
+	healthURL := healthCheckURL(baseURL, path)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second) // Reduced timeout
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 
 	req, err := http.NewRequestWithContext(ctx, "GET", healthURL, nil)
 	if err != nil {
 		return false, time.Since(startTime), 0
 	}
+	client, host := hc.clientFor(baseURL)
+	if host != "" {
+		req.Host = host
+	}
 
-	resp, err := hc.client.Do(req)
+	resp, err := client.Do(req)
 	responseTime := time.Since(startTime)
 
 	if err != nil {
@@ -162,8 +364,31 @@ func (hc *HealthChecker) tryHealthCheckURL(baseURL, path string, startTime time.
 	return isHealthy, responseTime, resp.StatusCode
 }
 
+// healthCheckURL appends the health check path to baseURL
+func healthCheckURL(baseURL, path string) string {
+	if path == "/" || path == "" {
+		return baseURL
+	}
+	// Ensure baseURL doesn't end with / and path starts with /
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	return strings.TrimSuffix(baseURL, "/") + path
+}
+
 // updateHealthStatus updates the health status for a URL
-func (hc *HealthChecker) updateHealthStatus(url string, isHealthy bool, responseTime time.Duration, errorMsg string) {
+// The URL only turns unhealthy or healthy again after the consecutive failed
+// or successful checks the thresholds of target ask for.
+func (hc *HealthChecker) updateHealthStatus(url string, target *config.ProxyTarget, isHealthy bool, responseTime time.Duration, errorMsg string) {
+	// The listener runs after the lock is released so it can read the checker
+	var changed *URLHealth
+	var onChange func(URLHealth, string)
+	defer func() {
+		if changed != nil && onChange != nil {
+			onChange(*changed, errorMsg)
+		}
+	}()
+
 	hc.mutex.Lock()
 	defer hc.mutex.Unlock()
 
@@ -177,7 +402,6 @@ func (hc *HealthChecker) updateHealthStatus(url string, isHealthy bool, response
 	}
 
 	previousHealth := health.IsHealthy
-	health.IsHealthy = isHealthy
 	health.ResponseTime = responseTime
 	health.LastCheck = time.Now()
 	health.TotalChecks++
@@ -205,16 +429,28 @@ func (hc *HealthChecker) updateHealthStatus(url string, isHealthy bool, response
 
 	if isHealthy {
 		health.ErrorCount = 0
+		health.SuccessCount++
+		if !previousHealth && health.SuccessCount >= max(target.HealthyThreshold, 1) {
+			health.IsHealthy = true
+			health.RecoveredAt = time.Now()
+		}
 		// Only log recovery from unhealthy state with performance info
-		if !previousHealth {
+		if health.IsHealthy && !previousHealth {
 			successRate := float64(health.SuccessChecks) / float64(health.TotalChecks) * 100
 			log.Printf("[INFO] URL %s recovered (success rate: %.1f%%, avg: %v, min: %v, max: %v)", 
 				url, successRate, health.AverageTime, health.MinTime, health.MaxTime)
 		}
 	} else {
 		health.ErrorCount++
-		// Only log the first failure and every 10th failure to reduce noise
-		if previousHealth || health.ErrorCount%10 == 0 {
+		health.SuccessCount = 0
+		if previousHealth && health.ErrorCount >= max(target.UnhealthyThreshold, 1) {
+			health.IsHealthy = false
+		}
+		// Only log the failure turning the URL unhealthy and every 10th failure to reduce noise
+		if previousHealth && health.IsHealthy {
+			log.Printf("[INFO] URL %s check failed: %s (failure %d of %d before unhealthy)",
+				url, errorMsg, health.ErrorCount, target.UnhealthyThreshold)
+		} else if previousHealth || health.ErrorCount%10 == 0 {
 			successRate := float64(health.SuccessChecks) / float64(health.TotalChecks) * 100
 			if errorMsg == "" {
 				log.Printf("[WARN] URL %s unhealthy (failure #%d, success rate: %.1f%%)", 
@@ -225,10 +461,79 @@ func (hc *HealthChecker) updateHealthStatus(url string, isHealthy bool, response
 			}
 		}
 	}
+
+	if health.IsHealthy != previousHealth {
+		healthCopy := *health
+		changed, onChange = &healthCopy, hc.onChange
+	}
+}
+
+// ReportRequest feeds the outcome of a request proxied to the URL of target
+// into its health, errorMsg is empty when the upstream answered below 500.
+// After passiveFailures consecutive failures the URL is unhealthy without
+// waiting for its next check, a successful request marks it healthy again.
+// With outlier detection the URL is also compared with the other URLs of
+// target.
+func (hc *HealthChecker) ReportRequest(target *config.ProxyTarget, latency time.Duration, errorMsg string) {
+	url := target.TargetURL
+	var changed *URLHealth
+	var onChange func(URLHealth, string)
+	defer func() {
+		if changed != nil && onChange != nil {
+			onChange(*changed, errorMsg)
+		}
+	}()
+
+	hc.mutex.Lock()
+	defer hc.mutex.Unlock()
+
+	health, exists := hc.urlHealthMap[url]
+	if !exists {
+		return
+	}
+	health.Requests++
+	health.LastRequest = time.Now()
+	if od := target.OutlierDetection; od != nil {
+		health.recordOutcome(requestOutcome{time: health.LastRequest, failed: errorMsg != "", latency: latency},
+			time.Duration(od.Window)*time.Second)
+		hc.detectOutlier(health, target)
+	}
+
+	if errorMsg == "" {
+		health.RequestErrors = 0
+		if health.RequestLatency == 0 {
+			health.RequestLatency = latency
+		} else {
+			// Recent requests weigh more, latencies differ between models and prompts
+			health.RequestLatency += (latency - health.RequestLatency) / 5
+		}
+		if !health.IsHealthy && hc.passiveFailures > 0 {
+			health.IsHealthy = true
+			health.RecoveredAt = time.Now()
+			health.ErrorCount = 0
+			log.Printf("[INFO] URL %s recovered: proxied request succeeded in %v", url, latency)
+			healthCopy := *health
+			changed, onChange = &healthCopy, hc.onChange
+		}
+		return
+	}
+
+	health.FailedRequests++
+	health.RequestErrors++
+	if health.IsHealthy && hc.passiveFailures > 0 && health.RequestErrors >= hc.passiveFailures {
+		health.IsHealthy = false
+		health.SuccessCount = 0
+		log.Printf("[WARN] URL %s unhealthy: %d consecutive proxied requests failed, last: %s",
+			url, health.RequestErrors, errorMsg)
+		healthCopy := *health
+		changed, onChange = &healthCopy, hc.onChange
+	}
 }
 
-// GetFastestHealthyURL returns the fastest responding healthy URL from a list
-func (hc *HealthChecker) GetFastestHealthyURL(urls []string) string {
+// GetFastestHealthyURL returns the fastest responding healthy URL from a list.
+// A URL that recovered less than slowStart ago is only chosen for a share of
+// the requests growing with the time since, the others go to the next fastest.
+func (hc *HealthChecker) GetFastestHealthyURL(urls []string, slowStart time.Duration) string {
 	hc.mutex.RLock()
 	defer hc.mutex.RUnlock()
 
@@ -238,6 +543,7 @@ func (hc *HealthChecker) GetFastestHealthyURL(urls []string) string {
 	healthyCount := 0
 	var healthyURLs []string
 	var urlStats []string
+	var candidates []urlCandidate
 	
 	for _, url := range urls {
 		health, exists := hc.urlHealthMap[url]
@@ -248,10 +554,17 @@ func (hc *HealthChecker) GetFastestHealthyURL(urls []string) string {
 		}
 
 		statusStr := "unhealthy"
-		responseTimeToUse := health.ResponseTime
-		
 		if health.IsHealthy {
 			statusStr = "healthy"
+		}
+		if health.Override != "" {
+			statusStr += ",manual " + health.Override
+		} else if health.ejected() {
+			statusStr += ",ejected"
+		}
+		responseTimeToUse := health.ResponseTime
+
+		if health.Available() {
 			healthyCount++
 			healthyURLs = append(healthyURLs, url)
 			
@@ -264,6 +577,7 @@ func (hc *HealthChecker) GetFastestHealthyURL(urls []string) string {
 				fastestTime = responseTimeToUse
 				fastestURL = url
 			}
+			candidates = append(candidates, urlCandidate{url: url, time: responseTimeToUse, share: slowStartShare(health, slowStart)})
 		}
 		
 		// Build comprehensive stats string
@@ -289,6 +603,11 @@ func (hc *HealthChecker) GetFastestHealthyURL(urls []string) string {
 			len(urls), strings.Join(urlStats, ", "))
 			
 		if healthyCount > 1 {
+			if selected := pickCandidate(candidates); selected.url != fastestURL {
+				log.Printf("[INFO] Found %d healthy URLs, %s is slow starting, selecting %s (avg: %v)",
+					healthyCount, fastestURL, selected.url, selected.time)
+				return selected.url
+			}
 			log.Printf("[INFO] Found %d healthy URLs, selecting fastest: %s (avg: %v)", 
 				healthyCount, fastestURL, fastestTime)
 		} else if healthyCount == 1 {
@@ -296,16 +615,55 @@ func (hc *HealthChecker) GetFastestHealthyURL(urls []string) string {
 		}
 	}
 
-	// If no healthy URLs found, return the first one as fallback
+	// If no healthy URLs found, return the first one not taken down manually as fallback
 	if healthyCount == 0 && len(urls) > 0 {
-		log.Printf("[WARN] No healthy URLs found in %d candidates, using first URL as fallback: %s", 
-			len(urls), urls[0])
-		return urls[0]
+		fallback := urls[0]
+		for _, url := range urls {
+			if hc.urlHealthMap[url].Override != OverrideDown {
+				fallback = url
+				break
+			}
+		}
+		log.Printf("[WARN] No healthy URLs found in %d candidates, using %s as fallback",
+			len(urls), fallback)
+		return fallback
 	}
 
 	return fastestURL
 }
 
+// urlCandidate is a healthy URL considered by GetFastestHealthyURL
+type urlCandidate struct {
+	url   string
+	time  time.Duration
+	share float64 // Fraction of the requests the URL takes when it is the fastest
+}
+
+// slowStartShare returns the share of requests health takes while it slow
+// starts, growing linearly from its recovery until slowStart has passed
+func slowStartShare(health *URLHealth, slowStart time.Duration) float64 {
+	if slowStart <= 0 || health.RecoveredAt.IsZero() {
+		return 1
+	}
+	elapsed := time.Since(health.RecoveredAt)
+	if elapsed >= slowStart {
+		return 1
+	}
+	return float64(elapsed) / float64(slowStart)
+}
+
+// pickCandidate walks the candidates from the fastest and stops at the first
+// one taking the request, the fastest when none does
+func pickCandidate(candidates []urlCandidate) urlCandidate {
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].time < candidates[j].time })
+	for _, c := range candidates {
+		if c.share >= 1 || rand.Float64() < c.share {
+			return c
+		}
+	}
+	return candidates[0]
+}
+
 // GetURLHealth returns the health status of a specific URL
 func (hc *HealthChecker) GetURLHealth(url string) *URLHealth {
 	hc.mutex.RLock()
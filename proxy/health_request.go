@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"ccproxy/config"
+)
+
+// maxHealthBody limits how much of a health check response is read to
+// validate its body
+const maxHealthBody = 1 << 20
+
+// performCustomHealthCheck sends the health check request configured for
+// target to health_check_path and validates the response against
+// health_check_expect. Unlike performHealthCheck no other paths are tried.
+func (hc *HealthChecker) performCustomHealthCheck(baseURL string, target *config.ProxyTarget) (bool, time.Duration, string) {
+	start := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), healthCheckTimeout(target))
+	defer cancel()
+
+	method := target.HealthCheckMethod
+	if method == "" {
+		method = "GET"
+	}
+	var body io.Reader
+	if target.HealthCheckBody != "" {
+		body = strings.NewReader(target.HealthCheckBody)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, healthCheckURL(baseURL, target.HealthCheckPath), body)
+	if err != nil {
+		return false, time.Since(start), err.Error()
+	}
+	client, host := hc.clientFor(baseURL)
+	if host != "" {
+		req.Host = host
+	}
+	for name, value := range target.HealthCheckHeaders {
+		if config.IsTemplate(value) {
+			if value, err = renderHealthHeader(name, value); err != nil {
+				return false, time.Since(start), err.Error()
+			}
+		}
+		if strings.EqualFold(name, "Host") {
+			req.Host = value
+		} else {
+			req.Header.Set(name, value)
+		}
+	}
+
+	resp, err := client.Do(req)
+	responseTime := time.Since(start)
+	if err != nil {
+		return false, responseTime, err.Error()
+	}
+	defer resp.Body.Close()
+
+	if errorMsg := checkHealthResponse(resp, target.HealthCheckExpect); errorMsg != "" {
+		return false, responseTime, errorMsg
+	}
+	return true, responseTime, ""
+}
+
+// renderHealthHeader expands a templated health check header, only the env
+// function is useful as there is no client request
+func renderHealthHeader(name, value string) (string, error) {
+	tmpl, err := config.ParseTemplate(name, value)
+	if err != nil {
+		return "", fmt.Errorf("invalid template in header %s: %w", name, err)
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, nil); err != nil {
+		return "", fmt.Errorf("failed to render header %s: %w", name, err)
+	}
+	return out.String(), nil
+}
+
+// checkHealthResponse returns why resp does not meet expect, or an empty
+// string for a healthy response
+func checkHealthResponse(resp *http.Response, expect *config.HealthCheckExpect) string {
+	if expect == nil || len(expect.Status) == 0 {
+		// Consider 2xx and 3xx status codes as healthy
+		if resp.StatusCode < 200 || resp.StatusCode >= 400 {
+			return fmt.Sprintf("unexpected status %d", resp.StatusCode)
+		}
+	} else if !slices.Contains(expect.Status, resp.StatusCode) {
+		return fmt.Sprintf("unexpected status %d", resp.StatusCode)
+	}
+	if expect == nil || (expect.Body == "" && len(expect.JSON) == 0) {
+		return ""
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxHealthBody))
+	if err != nil {
+		return fmt.Sprintf("failed to read response body: %v", err)
+	}
+	if pattern, ok := strings.CutPrefix(expect.Body, "~"); ok {
+		if re, err := regexp.Compile(pattern); err != nil || !re.Match(data) {
+			return fmt.Sprintf("response body does not match %q", pattern)
+		}
+	} else if !strings.Contains(string(data), expect.Body) {
+		return fmt.Sprintf("response body does not contain %q", expect.Body)
+	}
+
+	if len(expect.JSON) > 0 {
+		var document interface{}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return "response body is not JSON"
+		}
+		for path, want := range expect.JSON {
+			got, ok := jsonField(document, strings.Split(path, "."))
+			if !ok {
+				return fmt.Sprintf("response has no field %s", path)
+			}
+			if !sameJSON(got, jsonValue(want)) {
+				return fmt.Sprintf("response field %s is %s", path, compactJSON(got))
+			}
+		}
+	}
+	return ""
+}
+
+// jsonField looks up a dotted field path in a decoded JSON document, array
+// elements are addressed by index
+func jsonField(node interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch v := node.(type) {
+		case map[string]interface{}:
+			child, ok := v[key]
+			if !ok {
+				return nil, false
+			}
+			node = child
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			node = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return node, true
+}
+
+func compactJSON(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,24 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// logf logs like log.Printf with the request ID of r after the level tag,
+// e.g. "[INFO] [3f2a...] Routing ..."
+func logf(r *http.Request, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	if id, ok := r.Context().Value("request_id").(string); ok && id != "" {
+		if strings.HasPrefix(message, "[") {
+			if end := strings.Index(message, "] "); end > 0 {
+				message = message[:end+2] + "[" + id + "] " + message[end+2:]
+			}
+		} else {
+			message = "[" + id + "] " + message
+		}
+	}
+	log.Print(message)
+}
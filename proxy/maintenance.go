@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"ccproxy/config"
+)
+
+// serveMaintenance refuses r with 503 while target is in maintenance
+func (p *ProxyHandler) serveMaintenance(w http.ResponseWriter, r *http.Request, target *config.ProxyTarget) {
+	retryAfter := target.Maintenance.RetryAfter
+	if retryAfter <= 0 {
+		retryAfter = 300
+	}
+
+	body := jsonValue(target.Maintenance.Body)
+	if body == nil {
+		// Same shape as Anthropic API errors so clients show the message
+		body = map[string]interface{}{
+			"type": "error",
+			"error": map[string]interface{}{
+				"type":    "overloaded_error",
+				"message": target.Route() + " is under maintenance, please retry later",
+			},
+		}
+	}
+
+	logf(r, "[WARN] Target %s is in maintenance, refusing %s %s", target.Route(), r.Method, r.URL.Path)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusServiceUnavailable)
+	json.NewEncoder(w).Encode(body)
+}
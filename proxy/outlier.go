@@ -0,0 +1,117 @@
+package proxy
+
+import (
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"ccproxy/config"
+)
+
+// requestOutcome is a proxied request remembered for outlier detection
+type requestOutcome struct {
+	time    time.Time
+	failed  bool
+	latency time.Duration
+}
+
+// ejected reports whether outlier detection keeps the URL out of the
+// selection at the moment
+func (h *URLHealth) ejected() bool {
+	return time.Now().Before(h.EjectedUntil)
+}
+
+// recordOutcome remembers a request, forgetting those older than window
+func (h *URLHealth) recordOutcome(outcome requestOutcome, window time.Duration) {
+	cutoff := outcome.time.Add(-window)
+	i := 0
+	for i < len(h.recent) && h.recent[i].time.Before(cutoff) {
+		i++
+	}
+	h.recent = append(h.recent[i:], outcome)
+}
+
+// windowStats counts the remembered requests since cutoff, the latency is
+// the average of the successful ones
+func (h *URLHealth) windowStats(cutoff time.Time) (requests, failures int, latency time.Duration) {
+	var total time.Duration
+	for _, outcome := range h.recent {
+		if outcome.time.Before(cutoff) {
+			continue
+		}
+		requests++
+		if outcome.failed {
+			failures++
+		} else {
+			total += outcome.latency
+		}
+	}
+	if requests > failures {
+		latency = total / time.Duration(requests-failures)
+	}
+	return requests, failures, latency
+}
+
+// detectOutlier ejects health from the selection for the ejection time of
+// target when its recent requests fail more often or take much longer than
+// those of the other URLs of target. The caller holds the lock.
+func (hc *HealthChecker) detectOutlier(health *URLHealth, target *config.ProxyTarget) {
+	od := target.OutlierDetection
+	if od == nil || len(target.TargetURLs) < 2 || health.ejected() {
+		return
+	}
+	now := time.Now()
+	cutoff := now.Add(-time.Duration(od.Window) * time.Second)
+	requests, failures, latency := health.windowStats(cutoff)
+	if requests < od.MinRequests {
+		return
+	}
+
+	// Peers without traffic count as fine, usually only the fastest URL
+	// receives requests
+	var peerRequests, peerFailures, ejected, available int
+	var peerLatencies []time.Duration
+	for _, url := range target.TargetURLs {
+		peer, exists := hc.urlHealthMap[url]
+		if !exists || peer == health {
+			continue
+		}
+		if peer.ejected() {
+			ejected++
+			continue
+		}
+		if peer.Available() {
+			available++
+		}
+		r, f, l := peer.windowStats(cutoff)
+		peerRequests += r
+		peerFailures += f
+		if r-f >= od.MinRequests {
+			peerLatencies = append(peerLatencies, l)
+		}
+	}
+	if available == 0 || (ejected+1)*100 > len(target.TargetURLs)*od.MaxEjectionPercent {
+		return
+	}
+
+	var reason string
+	errorRate := float64(failures) / float64(requests)
+	if errorRate >= od.ErrorRate && (peerRequests == 0 || errorRate > float64(peerFailures)/float64(peerRequests)) {
+		reason = fmt.Sprintf("%d of %d requests failed", failures, requests)
+	} else if od.LatencyFactor > 0 && requests-failures >= od.MinRequests && len(peerLatencies) > 0 {
+		slices.Sort(peerLatencies)
+		median := peerLatencies[len(peerLatencies)/2]
+		if float64(latency) > od.LatencyFactor*float64(median) {
+			reason = fmt.Sprintf("average latency %v against %v of other URLs", latency, median)
+		}
+	}
+	if reason == "" {
+		return
+	}
+
+	ejectionTime := time.Duration(od.EjectionTime) * time.Second
+	health.EjectedUntil = now.Add(ejectionTime)
+	health.recent = nil
+	log.Printf("[WARN] URL %s ejected for %v: %s", health.URL, ejectionTime, reason)
+}
@@ -0,0 +1,111 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"time"
+
+	"ccproxy/config"
+)
+
+// Route describes where a request would be forwarded
+type Route struct {
+	Target    config.ProxyTarget // Matched target with TargetURL set to the selected upstream
+	TargetURL string             // Final upstream request URL
+}
+
+// Resolve reports which target and upstream URL a request would be routed to,
+// using the health data collected so far. requestURL.Host and header are
+// matched against the host and match_headers of targets.
+func (p *ProxyHandler) Resolve(method string, requestURL *url.URL, header http.Header) (*Route, error) {
+	target := p.findTarget(requestURL.Host, requestURL.Path, method, header)
+	if target == nil {
+		return nil, fmt.Errorf("no matching target for %s %s", method, requestURL.Path)
+	}
+
+	selected := *target
+	if target.Response != nil || target.Redirect != nil {
+		// Static responses and redirects have no upstream
+		return &Route{Target: selected}, nil
+	}
+	selected.TargetURL = p.selectFastestURL(target)
+	if selected.TargetURL == "" {
+		return nil, fmt.Errorf("no available URLs for target %s", target.Route())
+	}
+
+	targetURL, err := p.buildTargetURL(requestURL, &selected)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build target URL: %w", err)
+	}
+
+	return &Route{Target: selected, TargetURL: targetURL}, nil
+}
+
+// ProbeResult holds the response and timing breakdown of a test request
+type ProbeResult struct {
+	StatusCode       int
+	Header           http.Header
+	Body             []byte
+	DNSLookup        time.Duration
+	Connect          time.Duration
+	TLSHandshake     time.Duration
+	FirstByte        time.Duration
+	Total            time.Duration
+	ConnectionReused bool
+}
+
+// Probe sends a single request along route the same way a proxied request
+// would be sent: target headers and the effective HTTP proxy apply, no retries.
+func (p *ProxyHandler) Probe(ctx context.Context, route *Route, method string, header http.Header, body []byte) (*ProbeResult, error) {
+	client, err := p.createHTTPClientWithProxy(p.getEffectiveProxy(&route.Target), route.Target.HostOverride)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, route.TargetURL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	original := &http.Request{Method: method, URL: req.URL, Host: req.Host, Header: header}
+	if original.Header == nil {
+		original.Header = http.Header{}
+	}
+	p.copyHeaders(req, original, &route.Target)
+
+	var dnsStart, connectStart, tlsStart time.Time
+	result := &ProbeResult{}
+	trace := &httptrace.ClientTrace{
+		DNSStart:          func(httptrace.DNSStartInfo) { dnsStart = time.Now() },
+		DNSDone:           func(httptrace.DNSDoneInfo) { result.DNSLookup = time.Since(dnsStart) },
+		ConnectStart:      func(string, string) { connectStart = time.Now() },
+		ConnectDone:       func(string, string, error) { result.Connect = time.Since(connectStart) },
+		TLSHandshakeStart: func() { tlsStart = time.Now() },
+		TLSHandshakeDone:  func(tls.ConnectionState, error) { result.TLSHandshake = time.Since(tlsStart) },
+		GotConn:           func(info httptrace.GotConnInfo) { result.ConnectionReused = info.Reused },
+	}
+
+	start := time.Now()
+	trace.GotFirstResponseByte = func() { result.FirstByte = time.Since(start) }
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP client error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	result.Body, err = io.ReadAll(resp.Body)
+	result.Total = time.Since(start)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	result.StatusCode = resp.StatusCode
+	result.Header = resp.Header
+	return result, nil
+}
@@ -0,0 +1,29 @@
+package proxy
+
+import (
+	"net/http"
+
+	"ccproxy/config"
+)
+
+// serveRedirect answers r with a redirect to the location of target. Wildcard
+// targets keep the request path and query, with the same path and query rules
+// a proxied request would get.
+func (p *ProxyHandler) serveRedirect(w http.ResponseWriter, r *http.Request, target *config.ProxyTarget) {
+	status := target.Redirect.Status
+	if status == 0 {
+		status = http.StatusFound
+	}
+
+	redirectTarget := *target
+	redirectTarget.TargetURL = target.Redirect.Location
+	location, err := p.buildTargetURL(r.URL, &redirectTarget)
+	if err != nil {
+		logf(r, "[ERROR] Failed to build redirect location for %s: %v", r.URL.Path, err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	logf(r, "[INFO] Redirecting %s %s -> %s (%d)", r.Method, r.URL.Path, location, status)
+	http.Redirect(w, r, location, status)
+}
@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"ccproxy/config"
+)
+
+// replayTargetKey holds the upstream chosen for a replayed request
+const replayTargetKey = "replay_target_url"
+
+// WithReplayTarget sends r to targetURL instead of the upstream of its route.
+// The settings of the matching route, such as headers and rewrites, still apply.
+func WithReplayTarget(r *http.Request, targetURL string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), replayTargetKey, targetURL))
+}
+
+// replayTarget returns target forwarding to the upstream chosen through
+// WithReplayTarget, or target itself. Requests no route matches use a
+// wildcard target so the path is kept.
+func replayTarget(r *http.Request, target *config.ProxyTarget) *config.ProxyTarget {
+	targetURL, _ := r.Context().Value(replayTargetKey).(string)
+	if targetURL == "" {
+		return target
+	}
+
+	replay := config.ProxyTarget{Path: "/*"}
+	if target != nil {
+		replay = *target
+	}
+	replay.TargetURL, replay.TargetURLs = targetURL, []string{targetURL}
+	replay.Response, replay.Redirect, replay.Maintenance = nil, nil, nil
+	return &replay
+}
@@ -0,0 +1,140 @@
+package proxy
+
+import (
+	"log"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"ccproxy/config"
+)
+
+// compileRewrites compiles the rewrite patterns of targets once, keyed by
+// pattern. Invalid patterns were rejected by config.Validate and are skipped.
+func compileRewrites(targets []config.ProxyTarget) map[string]*regexp.Regexp {
+	rewrites := map[string]*regexp.Regexp{}
+	for _, target := range targets {
+		for _, rule := range target.Rewrite {
+			if rewrites[rule.Match] != nil {
+				continue
+			}
+			re, err := regexp.Compile(rule.Match)
+			if err != nil {
+				log.Printf("[WARN] Invalid rewrite pattern %q: %v", rule.Match, err)
+				continue
+			}
+			rewrites[rule.Match] = re
+		}
+	}
+	return rewrites
+}
+
+// compilePathPatterns compiles the path_regex and ~ match_headers patterns
+// of targets once, keyed by pattern. Targets with an invalid pattern never match.
+func compilePathPatterns(targets []config.ProxyTarget) map[string]*regexp.Regexp {
+	patterns := map[string]*regexp.Regexp{}
+	compile := func(pattern string) {
+		if pattern == "" || patterns[pattern] != nil {
+			return
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			log.Printf("[WARN] Invalid route pattern %q: %v", pattern, err)
+			return
+		}
+		patterns[pattern] = re
+	}
+	for _, target := range targets {
+		compile(target.PathRegex)
+		for _, value := range target.MatchHeaders {
+			if pattern, ok := strings.CutPrefix(value, "~"); ok {
+				compile(pattern)
+			}
+		}
+	}
+	return patterns
+}
+
+// rewritePath maps the request path for target: strip_prefix, then the first
+// matching rewrite rule, then add_prefix. It reports whether anything changed.
+func (p *ProxyHandler) rewritePath(path string, target *config.ProxyTarget) (string, bool) {
+	original := path
+
+	if prefix := strings.TrimSuffix(target.StripPrefix, "/"); prefix != "" {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			path = strings.TrimPrefix(path, prefix)
+			if path == "" {
+				path = "/"
+			}
+		}
+	}
+
+	for _, rule := range target.Rewrite {
+		re := p.rewrites[rule.Match]
+		if re == nil || !re.MatchString(path) {
+			continue
+		}
+		path = re.ReplaceAllString(path, rule.Replace)
+		break
+	}
+
+	if prefix := strings.TrimSuffix(target.AddPrefix, "/"); prefix != "" {
+		path = prefix + path
+	}
+
+	return path, path != original || target.AddPrefix != ""
+}
+
+// rewriteQuery applies remove_query_params and then query_params to the
+// client query. The query is passed through untouched when neither is set.
+func rewriteQuery(rawQuery string, target *config.ProxyTarget) string {
+	if len(target.QueryParams) == 0 && len(target.RemoveQueryParams) == 0 {
+		return rawQuery
+	}
+
+	// ParseQuery keeps the pairs it could decode, the others are dropped so a
+	// malformed pair cannot carry a parameter past remove_query_params
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		log.Printf("[WARN] Dropping undecodable query parameters: %v", err)
+	}
+	for _, name := range target.RemoveQueryParams {
+		if !strings.HasSuffix(name, "*") {
+			query.Del(name)
+			continue
+		}
+		for key := range query {
+			if strings.HasPrefix(key, strings.TrimSuffix(name, "*")) {
+				query.Del(key)
+			}
+		}
+	}
+	for name, value := range target.QueryParams {
+		query.Set(name, value)
+	}
+	return query.Encode()
+}
+
+// maskQuery replaces the values of target.query_params in a target URL, which
+// often carry an API key. The masked URL is logged and recorded, only the
+// upstream request uses the real one.
+func maskQuery(rawURL string, target *config.ProxyTarget) string {
+	if len(target.QueryParams) == 0 {
+		return rawURL
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return rawURL
+	}
+	pairs := strings.Split(u.RawQuery, "&")
+	for i, pair := range pairs {
+		key, _, _ := strings.Cut(pair, "=")
+		if name, err := url.QueryUnescape(key); err == nil {
+			if _, ok := target.QueryParams[name]; ok {
+				pairs[i] = key + "=[REDACTED]"
+			}
+		}
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+	return u.String()
+}
@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"sort"
+	"strings"
+
+	"ccproxy/config"
+)
+
+// sortRoutes returns targets in matching order: higher priority first, then
+// the more specific route. Hosts beat wildcard hosts beat no host, more
+// match_headers beat fewer, exact paths beat path_regex beat wildcard paths,
+// and longer wildcard prefixes come first. Ties keep the configured order.
+func sortRoutes(targets []config.ProxyTarget) []config.ProxyTarget {
+	routes := append([]config.ProxyTarget(nil), targets...)
+	sort.SliceStable(routes, func(i, j int) bool {
+		a, b := &routes[i], &routes[j]
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+		if hostRank(a) != hostRank(b) {
+			return hostRank(a) < hostRank(b)
+		}
+		if len(a.MatchHeaders) != len(b.MatchHeaders) {
+			return len(a.MatchHeaders) > len(b.MatchHeaders)
+		}
+		if pathRank(a) != pathRank(b) {
+			return pathRank(a) < pathRank(b)
+		}
+		return pathRank(a) == 2 && len(a.Path) > len(b.Path)
+	})
+	return routes
+}
+
+func hostRank(target *config.ProxyTarget) int {
+	switch {
+	case target.Host == "":
+		return 2
+	case strings.HasPrefix(target.Host, "*"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+func pathRank(target *config.ProxyTarget) int {
+	switch {
+	case target.PathRegex != "":
+		return 1
+	case strings.HasSuffix(target.Path, "*"):
+		return 2
+	default:
+		return 0
+	}
+}
+
+// RouteEntry is one row of the evaluated route table
+type RouteEntry struct {
+	Order      int      `json:"order"`
+	Route      string   `json:"route"`
+	Priority   int      `json:"priority"`
+	Methods    []string `json:"methods"`
+	Action     string   `json:"action"` // proxy, response, redirect or maintenance
+	TargetURLs []string `json:"target_urls,omitempty"`
+	Location   string   `json:"location,omitempty"`
+	Fallback   bool     `json:"fallback,omitempty"`
+}
+
+// RouteTable lists the routes in the order requests are matched against
+// them, proxy.fallback last
+func (p *ProxyHandler) RouteTable() []RouteEntry {
+	routes := p.routes
+	if p.config.Proxy.Fallback != nil {
+		routes = append(routes[:len(routes):len(routes)], *p.config.Proxy.Fallback)
+	}
+
+	table := make([]RouteEntry, 0, len(routes))
+	for i, target := range routes {
+		entry := RouteEntry{
+			Order:    i + 1,
+			Route:    target.Route(),
+			Priority: target.Priority,
+			Methods:  target.Methods,
+			Action:   "proxy",
+			Fallback: i == len(p.routes),
+		}
+		switch {
+		case target.InMaintenance():
+			entry.Action = "maintenance"
+		case target.Response != nil:
+			entry.Action = "response"
+		case target.Redirect != nil:
+			entry.Action = "redirect"
+			entry.Location = target.Redirect.Location
+		default:
+			entry.TargetURLs = target.TargetURLs
+		}
+		table = append(table, entry)
+	}
+	return table
+}
@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"ccproxy/config"
+)
+
+// serveStatic answers r with the configured response of target without
+// contacting an upstream. Header values and the body may be templates.
+func (p *ProxyHandler) serveStatic(w http.ResponseWriter, r *http.Request, target *config.ProxyTarget) {
+	response := target.Response
+	status := response.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	body := []byte(response.Body)
+	if response.JSON != nil {
+		encoded, err := json.Marshal(jsonValue(response.JSON))
+		if err != nil {
+			logf(r, "[ERROR] Failed to encode static response for %s: %v", target.Route(), err)
+			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			return
+		}
+		body = encoded
+		w.Header().Set("Content-Type", "application/json")
+	} else if rendered, ok := p.renderHeader("body", response.Body, r, target); ok {
+		body = []byte(rendered)
+	} else {
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	for name, value := range response.Headers {
+		if value, ok := p.renderHeader(name, value, r, target); ok {
+			w.Header().Set(name, value)
+		}
+	}
+	if w.Header().Get("Content-Type") == "" {
+		w.Header().Set("Content-Type", http.DetectContentType(body))
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+
+	logf(r, "[INFO] Serving static response %d for %s %s", status, r.Method, r.URL.Path)
+	w.WriteHeader(status)
+	if r.Method != http.MethodHead {
+		w.Write(body)
+	}
+}
@@ -0,0 +1,97 @@
+package proxy
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"ccproxy/config"
+)
+
+// headerData is what header templates see, e.g. {{.Host}} or {{.Header "User-Agent"}}
+type headerData struct {
+	request *http.Request
+
+	Host       string // Host the client sent the request to
+	Method     string
+	Path       string
+	Query      string // Raw query string without ?
+	RemoteAddr string
+	ClientIP   string
+	TargetURL  string // Upstream base URL selected for this request
+	Unix       int64
+	UnixMillis int64
+}
+
+// Header returns the first value of a client request header
+func (d headerData) Header(name string) string {
+	return d.request.Header.Get(name)
+}
+
+func newHeaderData(r *http.Request, target *config.ProxyTarget) headerData {
+	now := time.Now()
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = host
+	}
+	return headerData{
+		request:    r,
+		Host:       r.Host,
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		Query:      r.URL.RawQuery,
+		RemoteAddr: r.RemoteAddr,
+		ClientIP:   clientIP,
+		TargetURL:  target.TargetURL,
+		Unix:       now.Unix(),
+		UnixMillis: now.UnixMilli(),
+	}
+}
+
+// compileHeaderTemplates parses every templated header value and static
+// response body of targets once, keyed by the raw value. Invalid templates
+// were rejected by config.Validate and are sent verbatim.
+func compileHeaderTemplates(targets []config.ProxyTarget) map[string]*template.Template {
+	templates := map[string]*template.Template{}
+	for _, target := range targets {
+		values := target.Headers
+		if target.Response != nil {
+			values = map[string]string{"body": target.Response.Body}
+			for name, value := range target.Headers {
+				values[name] = value
+			}
+			for name, value := range target.Response.Headers {
+				values[name] = value
+			}
+		}
+		for name, value := range values {
+			if !config.IsTemplate(value) || templates[value] != nil {
+				continue
+			}
+			tmpl, err := config.ParseTemplate(name, value)
+			if err != nil {
+				log.Printf("[WARN] Invalid template in header %s: %v", name, err)
+				continue
+			}
+			templates[value] = tmpl
+		}
+	}
+	return templates
+}
+
+// renderHeader expands a templated header value for the request
+func (p *ProxyHandler) renderHeader(name, value string, r *http.Request, target *config.ProxyTarget) (string, bool) {
+	tmpl := p.headerTemplates[value]
+	if tmpl == nil {
+		return value, true
+	}
+	var out strings.Builder
+	if err := tmpl.Execute(&out, newHeaderData(r, target)); err != nil {
+		log.Printf("[WARN] Failed to render header %s: %v", name, err)
+		return "", false
+	}
+	return out.String(), true
+}
@@ -0,0 +1,190 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"ccproxy/config"
+)
+
+// transformBody applies the body_transforms of target to a JSON request body.
+// The transformed body replaces r.Body and is stored in the request context
+// as "actual_request_body" so the logger records what was forwarded.
+func (p *ProxyHandler) transformBody(r *http.Request, target *config.ProxyTarget) error {
+	if len(target.BodyTransforms) == 0 || r.Body == nil || r.Header.Get("Content-Encoding") != "" {
+		return nil
+	}
+
+	body, err := p.readAndCacheBody(r)
+	if err != nil {
+		return fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&doc); err != nil {
+		// Not JSON, forward as is
+		return nil
+	}
+
+	for i := range target.BodyTransforms {
+		rule := &target.BodyTransforms[i]
+		doc = transformNode(doc, strings.Split(rule.Path, "."), rule)
+	}
+
+	var out bytes.Buffer
+	encoder := json.NewEncoder(&out)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(doc); err != nil {
+		return fmt.Errorf("failed to encode transformed body: %w", err)
+	}
+	transformed := bytes.TrimSuffix(out.Bytes(), []byte("\n"))
+
+	r.Body = io.NopCloser(bytes.NewReader(transformed))
+	r.ContentLength = int64(len(transformed))
+	*r = *r.WithContext(context.WithValue(r.Context(), "actual_request_body", transformed))
+	logf(r, "[INFO] Applied %d body transforms for %s (%d -> %d bytes)",
+		len(target.BodyTransforms), target.Route(), len(body), len(transformed))
+	return nil
+}
+
+// transformNode applies rule at path below node. Path segments are object
+// keys or array indexes, * matches every key or element.
+func transformNode(node interface{}, path []string, rule *config.BodyTransform) interface{} {
+	key, last := path[0], len(path) == 1
+
+	switch n := node.(type) {
+	case map[string]interface{}:
+		keys := []string{key}
+		if key == "*" {
+			keys = keys[:0]
+			for k := range n {
+				keys = append(keys, k)
+			}
+		}
+		for _, k := range keys {
+			value, exists := n[k]
+			if !exists && (rule.Op != "set" || key == "*") {
+				continue
+			}
+			if !last {
+				if !exists {
+					value = map[string]interface{}{}
+				}
+				n[k] = transformNode(value, path[1:], rule)
+				continue
+			}
+			if newValue, keep := applyOp(rule, value); keep {
+				n[k] = newValue
+			} else {
+				delete(n, k)
+			}
+		}
+		return n
+
+	case []interface{}:
+		var indexes []int
+		if key == "*" {
+			for i := range n {
+				indexes = append(indexes, i)
+			}
+		} else if i, err := strconv.Atoi(key); err == nil && i >= 0 && i < len(n) {
+			indexes = []int{i}
+		}
+
+		removed := map[int]bool{}
+		for _, i := range indexes {
+			if !last {
+				n[i] = transformNode(n[i], path[1:], rule)
+				continue
+			}
+			if newValue, keep := applyOp(rule, n[i]); keep {
+				n[i] = newValue
+			} else {
+				removed[i] = true
+			}
+		}
+		if len(removed) == 0 {
+			return n
+		}
+		kept := n[:0]
+		for i, value := range n {
+			if !removed[i] {
+				kept = append(kept, value)
+			}
+		}
+		return kept
+
+	default:
+		return node
+	}
+}
+
+// applyOp returns the new value of an existing field and whether to keep it
+func applyOp(rule *config.BodyTransform, value interface{}) (interface{}, bool) {
+	switch rule.Op {
+	case "set":
+		return jsonValue(rule.Value), true
+	case "delete":
+		return nil, false
+	case "replace":
+		if rule.Match != nil && !sameJSON(value, jsonValue(rule.Match)) {
+			return value, true
+		}
+		return jsonValue(rule.Value), true
+	case "min", "max":
+		current, ok := number(value)
+		limit, _ := number(rule.Value)
+		if ok && ((rule.Op == "max" && current > limit) || (rule.Op == "min" && current < limit)) {
+			return jsonValue(rule.Value), true
+		}
+	}
+	return value, true
+}
+
+// jsonValue converts YAML decoded maps, which have interface{} keys, so the
+// value can be encoded as JSON
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			m[fmt.Sprint(key)] = jsonValue(item)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = jsonValue(item)
+		}
+		return list
+	default:
+		return value
+	}
+}
+
+func sameJSON(a, b interface{}) bool {
+	encodedA, errA := json.Marshal(a)
+	encodedB, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(encodedA, encodedB)
+}
+
+func number(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case int:
+		return float64(v), true
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
@@ -96,7 +96,7 @@ clean_builds() {
     log_info "Cleaning previous build artifacts..."
     
     if [[ -d "$BUILD_DIR" ]]; then
-        rm -f "$BUILD_DIR"/*.zip
+        rm -f "$BUILD_DIR"/*.zip "$BUILD_DIR"/SHA256SUMS
         log_verbose "Removed existing zip files"
     fi
     
@@ -224,6 +224,14 @@ upload_release_assets() {
     
     log_info "Found ${#zip_files[@]} zip files to upload"
     
+    # The tray only installs updates listed in SHA256SUMS
+    if command -v sha256sum &>/dev/null; then
+        (cd "$BUILD_DIR" && sha256sum *.zip > SHA256SUMS)
+    else
+        (cd "$BUILD_DIR" && shasum -a 256 *.zip > SHA256SUMS)
+    fi
+    zip_files+=("$BUILD_DIR/SHA256SUMS")
+    
     # Upload each zip file
     for zip_file in "${zip_files[@]}"; do
         local filename=$(basename "$zip_file")
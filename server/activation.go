@@ -0,0 +1,82 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFdsStart is the first file descriptor passed by systemd (SD_LISTEN_FDS_START)
+const listenFdsStart = 3
+
+// activatedListeners returns the listening sockets handed over through the
+// systemd socket activation protocol (LISTEN_PID/LISTEN_FDS/LISTEN_FDNAMES),
+// or by a previous ccproxy process during Upgrade, which cannot know the
+// child's pid up front and omits LISTEN_PID.
+// Sockets named "proxy" or "web" via FileDescriptorName= are returned under
+// that name; unnamed sockets are assigned in order: proxy first, then web.
+func activatedListeners() (map[string]net.Listener, error) {
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if (err != nil || pid != os.Getpid()) && !inheritedFromUpgrade() {
+		return nil, nil
+	}
+
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+
+	var names []string
+	if fdNames := os.Getenv("LISTEN_FDNAMES"); fdNames != "" {
+		names = strings.Split(fdNames, ":")
+	}
+
+	// Do not leak the activation environment to child processes
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	listeners := make(map[string]net.Listener)
+	positional := []string{"proxy", "web"}
+	for i := 0; i < count; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		if name != "proxy" && name != "web" {
+			if len(positional) == 0 {
+				return nil, fmt.Errorf("unexpected extra activated socket #%d (%s)", i, name)
+			}
+			name = positional[0]
+		}
+		if _, ok := listeners[name]; ok {
+			return nil, fmt.Errorf("duplicate activated socket #%d (%s)", i, name)
+		}
+		positional = removeName(positional, name)
+
+		file := os.NewFile(uintptr(listenFdsStart+i), name)
+		listener, err := net.FileListener(file)
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to use activated socket %s: %w", name, err)
+		}
+		listeners[name] = listener
+	}
+
+	return listeners, nil
+}
+
+func joinNames(names []string) string {
+	return strings.Join(names, ":")
+}
+
+func removeName(names []string, name string) []string {
+	for i, n := range names {
+		if n == name {
+			return append(names[:i:i], names[i+1:]...)
+		}
+	}
+	return names
+}
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"ccproxy/proxy"
+	"ccproxy/types"
+	"ccproxy/version"
+	"ccproxy/web"
+)
+
+// Status reports the running configuration and upstream health for the admin API
+func (s *Server) Status() *web.Status {
+	current := s.routes.current.Load()
+	return &web.Status{
+		StartTime:   s.startTime,
+		Uptime:      time.Since(s.startTime).Round(time.Second).String(),
+		Draining:    s.draining.Load(),
+		Active:      s.routes.active.Load(),
+		ProxyAddr:   s.server.Addr,
+		WebAddr:     s.webServer.Addr,
+		ConfigFile:  current.config.FilePath,
+		Config:      web.SummarizeConfig(current.config),
+		Upstreams:   s.Upstreams(),
+		Maintenance: current.config.MaintenancePaths(),
+		Paused:      s.hub.CapturePaused(),
+		Stats:       s.hub.GetStats(),
+		Version:     version.Get(),
+	}
+}
+
+// SetCapturePaused pauses or resumes recording requests, see Hub.SetCapturePaused
+func (s *Server) SetCapturePaused(paused bool) {
+	s.hub.SetCapturePaused(paused)
+	if paused {
+		log.Printf("[INFO] Request capture paused, requests are proxied but not recorded")
+	} else {
+		log.Printf("[INFO] Request capture resumed")
+	}
+}
+
+// Upstreams returns the health of every upstream URL of the active configuration
+func (s *Server) Upstreams() map[string]*proxy.URLHealth {
+	return s.routes.current.Load().handler.GetHealthChecker().GetAllHealthStatuses()
+}
+
+// TimeSeries returns the request statistics of the last window in step sized
+// points, see Hub.GetTimeSeries
+func (s *Server) TimeSeries(window, step time.Duration) *types.MetricsSeries {
+	return s.hub.GetTimeSeries(window, step)
+}
+
+// Routes returns the route table of the active configuration in matching order
+func (s *Server) Routes() []proxy.RouteEntry {
+	return s.routes.current.Load().handler.RouteTable()
+}
+
+// Drain stops accepting new proxy connections while in-flight requests finish.
+// The web interface keeps running so progress can still be observed.
+func (s *Server) Drain() error {
+	if s.draining.Load() {
+		return fmt.Errorf("server is already draining")
+	}
+	s.startDrain()
+	return nil
+}
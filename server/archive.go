@@ -0,0 +1,49 @@
+package server
+
+import (
+	"log"
+
+	"ccproxy/config"
+	"ccproxy/storage"
+)
+
+// openArchiver starts archiving history to history.archive, it is restarted
+// only when the settings change so a reload does not cancel an upload
+func (s *Server) openArchiver(cfg *config.Config) {
+	settings := cfg.History.Archive
+	if s.archiver != nil && settings == s.archiveSettings {
+		return
+	}
+	s.closeArchiver()
+
+	// Validation rejects archiving with the bolt backend
+	history, ok := s.hub.HistoryStorage().(*storage.HistoryStorage)
+	if settings.Bucket == "" || !ok {
+		return
+	}
+	archiver, err := storage.NewArchiver(history, storage.ArchiveOptions{
+		S3Options: storage.S3Options{
+			Endpoint:        settings.Endpoint,
+			Region:          settings.Region,
+			Bucket:          settings.Bucket,
+			AccessKeyID:     settings.AccessKeyID,
+			SecretAccessKey: settings.SecretAccessKey,
+			SessionToken:    settings.SessionToken,
+			PathStyle:       settings.PathStyle,
+		},
+		Prefix:      settings.Prefix,
+		DeleteLocal: settings.DeleteLocal,
+	})
+	if err != nil {
+		log.Printf("[ERROR] History archive disabled: %v", err)
+		return
+	}
+	s.archiver, s.archiveSettings = archiver, settings
+}
+
+func (s *Server) closeArchiver() {
+	if s.archiver != nil {
+		s.archiver.Close()
+		s.archiver = nil
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// drainProgressInterval controls how often drain progress is logged
+const drainProgressInterval = 5 * time.Second
+
+// drainWindow returns how long to wait for in-flight requests, zero meaning no limit
+func (s *Server) drainWindow() time.Duration {
+	seconds := s.routes.current.Load().config.Server.Timeouts.Drain
+	if seconds < 0 {
+		return 0
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// startDrain begins draining the proxy server once and returns a channel
+// that is closed when all in-flight requests finished or the window elapsed.
+func (s *Server) startDrain() <-chan struct{} {
+	s.drainOnce.Do(func() {
+		s.draining.Store(true)
+		s.drainDone = make(chan struct{})
+		go func() {
+			s.drain(s.drainWindow())
+			close(s.drainDone)
+		}()
+	})
+	return s.drainDone
+}
+
+// drain stops accepting new proxy connections and waits for active requests,
+// including long-running streaming responses, to complete.
+func (s *Server) drain(window time.Duration) {
+	ctx := context.Background()
+	if window > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, window)
+		defer cancel()
+		log.Printf("[INFO] Draining proxy server: %d in-flight requests, waiting up to %v", s.routes.active.Load(), window)
+	} else {
+		log.Printf("[INFO] Draining proxy server: %d in-flight requests, waiting until they finish", s.routes.active.Load())
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.server.Shutdown(ctx)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(drainProgressInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				log.Printf("[WARN] Drain window elapsed after %v with %d requests still in flight", time.Since(start).Round(time.Second), s.routes.active.Load())
+			} else {
+				log.Printf("[INFO] Proxy server drained in %v", time.Since(start).Round(time.Second))
+			}
+			return
+		case <-ticker.C:
+			log.Printf("[INFO] Draining: %d in-flight requests remaining (%v elapsed)", s.routes.active.Load(), time.Since(start).Round(time.Second))
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"log"
+	"time"
+
+	"ccproxy/proxy"
+	"ccproxy/websocket"
+)
+
+// OnHealthChange calls fn whenever an upstream URL turns healthy or
+// unhealthy, e.g. for the tray to notify about provider outages
+func (s *Server) OnHealthChange(fn func(event websocket.HealthEvent)) {
+	s.healthMu.Lock()
+	defer s.healthMu.Unlock()
+	s.healthListeners = append(s.healthListeners, fn)
+}
+
+// healthChanged broadcasts a health transition seen by the checker of r.
+// Checks of replaced routes that finish after a reload are dropped.
+func (s *Server) healthChanged(r *routes, health proxy.URLHealth, errorMsg string) {
+	if s.routes.current.Load() != r {
+		return
+	}
+
+	event := websocket.HealthEvent{
+		Time:           time.Now(),
+		URL:            health.URL,
+		Targets:        []string{},
+		Healthy:        health.IsHealthy,
+		Error:          errorMsg,
+		ErrorCount:     health.ErrorCount,
+		ResponseTimeMs: float64(health.ResponseTime.Microseconds()) / 1000,
+	}
+	for _, target := range r.config.RouteTargets() {
+		for _, url := range target.TargetURLs {
+			if url == health.URL {
+				event.Targets = append(event.Targets, target.Path)
+				break
+			}
+		}
+	}
+	s.hub.BroadcastHealth(&event)
+
+	s.healthMu.Lock()
+	listeners := s.healthListeners
+	s.healthMu.Unlock()
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
+
+// SetUpstreamOverride forces an upstream URL of the active configuration up
+// or down regardless of its health checks, "auto" or an empty state hands it
+// back to the checks. Like SetMaintenance the file is left untouched.
+func (s *Server) SetUpstreamOverride(url, state string) error {
+	s.routes.mu.Lock()
+	defer s.routes.mu.Unlock()
+
+	if state == "auto" {
+		state = ""
+	}
+	if err := s.routes.current.Load().handler.GetHealthChecker().SetOverride(url, state); err != nil {
+		return err
+	}
+
+	if s.overrides == nil {
+		s.overrides = map[string]string{}
+	}
+	if state == "" {
+		delete(s.overrides, url)
+		log.Printf("[INFO] Upstream %s follows its health checks again", url)
+	} else {
+		s.overrides[url] = state
+		log.Printf("[INFO] Upstream %s manually marked %s", url, state)
+	}
+	return nil
+}
@@ -0,0 +1,329 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"ccproxy/config"
+	"ccproxy/storage"
+)
+
+// notifyInterval is how often target availability and the error rate are
+// checked for notifications
+const notifyInterval = 10 * time.Second
+
+// Notification is an event posted to notifications.webhooks, its fields are
+// available to webhook templates
+type Notification struct {
+	Event     string    `json:"event"` // One of config.NotificationEvents
+	Time      time.Time `json:"time"`
+	Instance  string    `json:"instance"` // Host name of the machine running the proxy
+	Message   string    `json:"message"`
+	Target    string    `json:"target,omitempty"` // Route of the target for target_down and target_up
+	URLs      []string  `json:"urls,omitempty"`
+	ErrorRate float64   `json:"error_rate,omitempty"` // Failed share (0-1) of the requests in the window
+	Requests  int64     `json:"requests,omitempty"`
+	Errors    int64     `json:"errors,omitempty"`
+
+	Report *storage.UsageReport `json:"report,omitempty"` // The report event
+}
+
+// notifier posts notifications to the configured webhooks in the background.
+// Notifications are dropped while the queue is full.
+type notifier struct {
+	webhooks []*webhook
+	client   *http.Client
+
+	queue  chan *Notification
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+type webhook struct {
+	config.Webhook
+	host     string // Logged instead of the URL, which often contains a token
+	template *template.Template
+}
+
+// instance names this machine in notifications
+var instance = func() string {
+	if name, err := os.Hostname(); err == nil {
+		return name
+	}
+	return "ccproxy"
+}()
+
+func newNotifier(settings []config.Webhook) *notifier {
+	n := &notifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan *Notification, 100),
+		done:   make(chan struct{}),
+	}
+	for i, settings := range settings {
+		w := &webhook{Webhook: settings}
+		if u, err := url.Parse(settings.URL); err == nil {
+			w.host = u.Host
+		}
+		if settings.Template != "" {
+			tmpl, err := config.ParseTemplate(fmt.Sprintf("webhook%d", i), settings.Template)
+			if err != nil {
+				log.Printf("[ERROR] Webhook %d disabled: %v", i, err)
+				continue
+			}
+			w.template = tmpl
+		}
+		n.webhooks = append(n.webhooks, w)
+	}
+	go n.run()
+	return n
+}
+
+// send queues a notification for the webhooks subscribed to its event
+func (n *notifier) send(notification *Notification) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	if n.closed {
+		return
+	}
+	select {
+	case n.queue <- notification:
+	default:
+		log.Printf("[WARN] Notification queue is full, dropping %s", notification.Event)
+	}
+}
+
+func (n *notifier) run() {
+	defer close(n.done)
+	for notification := range n.queue {
+		for _, w := range n.webhooks {
+			if len(w.Events) > 0 && !slices.Contains(w.Events, notification.Event) {
+				continue
+			}
+			if err := n.post(w, notification); err != nil {
+				log.Printf("[WARN] Failed to send %s notification to %s webhook %s: %v", notification.Event, w.Type, w.host, err)
+			}
+		}
+	}
+}
+
+// post sends one notification to a webhook
+func (n *notifier) post(w *webhook, notification *Notification) error {
+	body, err := w.body(notification)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid webhook URL")
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range w.Headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// The error names the full URL, which often contains a token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return urlErr.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	return nil
+}
+
+// body renders the payload: the message text in the format of the chat
+// service, or the notification as JSON. A template replaces the text, and the
+// whole body for json webhooks.
+func (w *webhook) body(notification *Notification) ([]byte, error) {
+	text := fmt.Sprintf("[%s] %s", notification.Instance, notification.Message)
+	if w.template != nil {
+		var buf bytes.Buffer
+		if err := w.template.Execute(&buf, notification); err != nil {
+			return nil, fmt.Errorf("failed to render template: %w", err)
+		}
+		if w.Type == "json" {
+			return buf.Bytes(), nil
+		}
+		text = buf.String()
+	}
+
+	switch w.Type {
+	case "slack":
+		return json.Marshal(map[string]string{"text": text})
+	case "discord":
+		return json.Marshal(map[string]string{"content": text})
+	case "telegram":
+		return json.Marshal(map[string]string{"chat_id": w.ChatID, "text": text})
+	}
+	return json.Marshal(notification)
+}
+
+// Close sends the queued notifications and stops the notifier
+func (n *notifier) Close() {
+	n.mu.Lock()
+	if n.closed {
+		n.mu.Unlock()
+		return
+	}
+	n.closed = true
+	close(n.queue)
+	n.mu.Unlock()
+
+	select {
+	case <-n.done:
+	case <-time.After(10 * time.Second):
+		log.Printf("[WARN] Timed out sending the remaining notifications")
+	}
+}
+
+// openNotifier replaces the webhooks with the ones of cfg
+func (s *Server) openNotifier(cfg *config.Config) {
+	var next *notifier
+	if len(cfg.Notifications.Webhooks) > 0 {
+		next = newNotifier(cfg.Notifications.Webhooks)
+	}
+	if previous := s.notifier.Swap(next); previous != nil {
+		previous.Close()
+	}
+}
+
+// closeNotifier stops watching for events and sends what is still queued
+func (s *Server) closeNotifier() {
+	if s.notifyStop != nil {
+		close(s.notifyStop)
+		s.notifyStop = nil
+	}
+	if previous := s.notifier.Swap(nil); previous != nil {
+		previous.Close()
+	}
+}
+
+// notify posts an event to the webhooks, nothing happens without webhooks
+func (s *Server) notify(notification *Notification) {
+	n := s.notifier.Load()
+	if n == nil {
+		return
+	}
+	notification.Time = time.Now()
+	notification.Instance = instance
+	n.send(notification)
+}
+
+// watchNotifications sends target_down when no upstream URL of a target is
+// available (health checks failing, ejected or marked down) and target_up once
+// one is back, and error_rate while the share of failed requests is above
+// notifications.error_rate
+func (s *Server) watchNotifications(stop <-chan struct{}) {
+	ticker := time.NewTicker(notifyInterval)
+	defer ticker.Stop()
+
+	down := map[string]bool{}
+	alerting := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+		if s.notifier.Load() == nil {
+			continue
+		}
+
+		cfg := s.routes.current.Load().config
+		upstreams := s.Upstreams()
+		current := map[string]bool{}
+		for _, target := range cfg.RouteTargets() {
+			if len(target.TargetURLs) == 0 {
+				continue
+			}
+			route := target.Route()
+			available := false
+			for _, url := range target.TargetURLs {
+				if health := upstreams[url]; health == nil || health.Available() {
+					available = true
+					break
+				}
+			}
+			switch {
+			case available && down[route]:
+				s.notify(&Notification{
+					Event:   "target_up",
+					Message: fmt.Sprintf("Target %s is available again", route),
+					Target:  route,
+					URLs:    target.TargetURLs,
+				})
+			case !available:
+				current[route] = true
+				if !down[route] {
+					s.notify(&Notification{
+						Event:   "target_down",
+						Message: fmt.Sprintf("Target %s is down, no upstream URL is available: %s", route, strings.Join(target.TargetURLs, ", ")),
+						Target:  route,
+						URLs:    target.TargetURLs,
+					})
+				}
+			}
+		}
+		down = current
+
+		settings := cfg.Notifications
+		if settings.ErrorRate < 0 {
+			alerting = false
+			continue
+		}
+		minutes := max(settings.Window, 1)
+		var requests, errors int64
+		for _, point := range s.TimeSeries(time.Duration(minutes)*time.Minute, time.Minute).Points {
+			requests += point.Requests
+			errors += point.Errors
+		}
+		var rate float64
+		if requests > 0 {
+			rate = float64(errors) / float64(requests)
+		}
+		exceeded := requests > 0 && requests >= settings.MinRequests && rate >= settings.ErrorRate
+		switch {
+		case exceeded && !alerting:
+			s.notify(&Notification{
+				Event:     "error_rate",
+				Message:   fmt.Sprintf("%.0f%% of %d requests failed in the last %d minutes", rate*100, requests, minutes),
+				ErrorRate: rate,
+				Requests:  requests,
+				Errors:    errors,
+			})
+		case !exceeded && alerting:
+			s.notify(&Notification{
+				Event:     "error_rate_resolved",
+				Message:   fmt.Sprintf("Error rate is back to %.0f%% (%d of %d requests) in the last %d minutes", rate*100, errors, requests, minutes),
+				ErrorRate: rate,
+				Requests:  requests,
+				Errors:    errors,
+			})
+		}
+		alerting = exceeded
+	}
+}
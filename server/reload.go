@@ -0,0 +1,177 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"sync/atomic"
+
+	"ccproxy/config"
+	"ccproxy/middleware"
+	"ccproxy/proxy"
+)
+
+// routes bundles everything derived from a configuration snapshot
+type routes struct {
+	config  *config.Config
+	handler *proxy.ProxyHandler
+	http    http.Handler
+}
+
+// reloadableHandler dispatches each request to the routes active when the
+// request arrived, so in-flight requests finish on the configuration they
+// started with while new requests pick up a reloaded one.
+type reloadableHandler struct {
+	current atomic.Pointer[routes]
+	active  atomic.Int64 // in-flight requests across all route generations
+	mu      sync.Mutex   // serializes reloads
+}
+
+func (s *Server) newRoutes(cfg *config.Config) *routes {
+	handler := proxy.NewProxyHandler(cfg)
+	r := &routes{
+		config:  cfg,
+		handler: handler,
+		http:    middleware.NewLoggerMiddleware(handler, s.hub, cfg),
+	}
+	checker := handler.GetHealthChecker()
+	checker.OnChange(func(health proxy.URLHealth, errorMsg string) {
+		s.healthChanged(r, health, errorMsg)
+	})
+	for url, state := range s.overrides {
+		// URLs no longer in the configuration are skipped
+		checker.SetOverride(url, state)
+	}
+	return r
+}
+
+func (h *reloadableHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.active.Add(1)
+	defer h.active.Add(-1)
+	h.current.Load().http.ServeHTTP(w, r)
+}
+
+// ServeProxy handles a request built by the dashboard, e.g. a replay, with
+// the current routes and request logging
+func (s *Server) ServeProxy(w http.ResponseWriter, r *http.Request) {
+	s.routes.ServeHTTP(w, r)
+}
+
+// swap installs new routes and releases the previous ones
+func (h *reloadableHandler) swap(next *routes) {
+	if previous := h.current.Swap(next); previous != nil {
+		previous.handler.Close()
+	}
+}
+
+// Reload re-reads the configuration file and atomically replaces routes and
+// health checks. Listener addresses cannot change without a restart.
+func (s *Server) Reload() error {
+	s.routes.mu.Lock()
+	defer s.routes.mu.Unlock()
+
+	current := s.routes.current.Load().config
+	if current.FilePath == "" {
+		return fmt.Errorf("configuration was not loaded from a file")
+	}
+
+	cfg, err := config.LoadConfig(current.FilePath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	for _, field := range config.RestartRequired(current, cfg) {
+		log.Printf("[WARN] Changes to %s in %s require a restart and were not applied", field, cfg.FilePath)
+	}
+	if s.profile != nil {
+		if active, err := cfg.UseProfile(*s.profile); err != nil {
+			log.Printf("[WARN] Profile %q is no longer configured, using %q from %s", *s.profile, cfg.Proxy.Profile, cfg.FilePath)
+			s.profile = nil
+		} else {
+			cfg = active
+		}
+	}
+	cfg = s.withMaintenance(cfg)
+
+	s.apply(cfg)
+	// Reopen the access log, after logrotate moved it or when logging changed
+	s.openLogSinks(cfg)
+	s.openArchiver(cfg)
+	s.configureHistory(cfg)
+	s.openJanitor(cfg)
+	s.openStatsPusher(cfg)
+	s.openNotifier(cfg)
+	s.openReporter(cfg)
+	s.hub.SetMaxMessageSize(cfg.WebSocket.MaxMessageBytes)
+
+	log.Printf("[INFO] Configuration reloaded from %s (%d targets)", cfg.FilePath, len(cfg.Proxy.Targets))
+	return nil
+}
+
+// SwitchProfile activates another proxy.profiles entry, an empty name selects
+// proxy.targets. The file is left untouched, the choice lasts until restart.
+func (s *Server) SwitchProfile(name string) error {
+	s.routes.mu.Lock()
+	defer s.routes.mu.Unlock()
+
+	cfg, err := s.routes.current.Load().config.UseProfile(name)
+	if err != nil {
+		return err
+	}
+	if errs := config.Validate(cfg); len(errs) > 0 {
+		return errs
+	}
+
+	s.profile = &name
+	s.apply(s.withMaintenance(cfg))
+
+	if name == "" {
+		log.Printf("[INFO] Switched to proxy.targets (%d targets)", len(cfg.Proxy.Targets))
+	} else {
+		log.Printf("[INFO] Switched to profile %q (%d targets)", name, len(cfg.Proxy.Targets))
+	}
+	return nil
+}
+
+// SetMaintenance turns maintenance of the target with the given path on or
+// off. Like SwitchProfile the file is left untouched.
+func (s *Server) SetMaintenance(path string, enabled bool) error {
+	s.routes.mu.Lock()
+	defer s.routes.mu.Unlock()
+
+	cfg, err := s.routes.current.Load().config.SetMaintenance(path, enabled)
+	if err != nil {
+		return err
+	}
+
+	if s.maintenance == nil {
+		s.maintenance = map[string]bool{}
+	}
+	s.maintenance[path] = enabled
+	s.apply(cfg)
+
+	if enabled {
+		log.Printf("[INFO] Target %s is in maintenance", path)
+	} else {
+		log.Printf("[INFO] Target %s is back in service", path)
+	}
+	return nil
+}
+
+// withMaintenance re-applies the SetMaintenance choices to a freshly loaded
+// configuration. Targets that no longer exist are skipped.
+func (s *Server) withMaintenance(cfg *config.Config) *config.Config {
+	for path, enabled := range s.maintenance {
+		if next, err := cfg.SetMaintenance(path, enabled); err == nil {
+			cfg = next
+		}
+	}
+	return cfg
+}
+
+// apply installs cfg for new requests, callers hold routes.mu
+func (s *Server) apply(cfg *config.Config) {
+	s.routes.swap(s.newRoutes(cfg))
+	s.web.SetConfig(cfg)
+}
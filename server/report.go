@@ -0,0 +1,47 @@
+package server
+
+import (
+	"path/filepath"
+
+	"ccproxy/config"
+	"ccproxy/storage"
+)
+
+// openReporter writes the daily and weekly usage reports of cfg.Reports,
+// the reporter is restarted on every reload and skips reports already written
+func (s *Server) openReporter(cfg *config.Config) {
+	s.closeReporter()
+
+	settings := cfg.Reports
+	history := s.hub.HistoryStorage()
+	if (!settings.Daily && !settings.Weekly) || history == nil {
+		return
+	}
+
+	opts := storage.ReportOptions{
+		Dir:       settings.Dir,
+		Daily:     settings.Daily,
+		Weekly:    settings.Weekly,
+		TopErrors: settings.TopErrors,
+		Prices:    make(map[string]storage.TokenPrice, len(settings.Prices)),
+	}
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join(s.dataDir, "reports")
+	}
+	for model, price := range settings.Prices {
+		opts.Prices[model] = storage.TokenPrice(price)
+	}
+	if settings.Notify {
+		opts.OnReport = func(report *storage.UsageReport) {
+			s.notify(&Notification{Event: "report", Message: report.Summary(), Report: report})
+		}
+	}
+	s.reporter = storage.NewReporter(history, opts)
+}
+
+func (s *Server) closeReporter() {
+	if s.reporter != nil {
+		s.reporter.Close()
+		s.reporter = nil
+	}
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"time"
+
+	"ccproxy/config"
+	"ccproxy/storage"
+)
+
+// openJanitor applies history.max_age_days and history.max_total_size_mb,
+// the janitor is restarted only when the limits change
+func (s *Server) openJanitor(cfg *config.Config) {
+	limits := storage.RetentionOptions{
+		MaxAge:       time.Duration(cfg.History.MaxAgeDays) * 24 * time.Hour,
+		MaxTotalSize: int64(cfg.History.MaxTotalSizeMB) << 20,
+	}
+	if s.janitor != nil && limits == s.retention {
+		return
+	}
+	s.closeJanitor()
+
+	history := s.hub.HistoryStorage()
+	if (limits.MaxAge == 0 && limits.MaxTotalSize == 0) || history == nil {
+		return
+	}
+	s.janitor, s.retention = storage.NewJanitor(history, limits), limits
+}
+
+func (s *Server) closeJanitor() {
+	if s.janitor != nil {
+		s.janitor.Close()
+		s.janitor = nil
+	}
+}
+
+// configureHistory applies the history settings that take effect on the next write
+func (s *Server) configureHistory(cfg *config.Config) {
+	// Only the JSONL files keep large bodies apart
+	if history, ok := s.hub.HistoryStorage().(*storage.HistoryStorage); ok {
+		history.SetBlobThreshold(cfg.History.BlobThresholdKB << 10)
+	}
+}
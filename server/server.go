@@ -2,47 +2,99 @@ package server
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
 	"ccproxy/config"
-	"ccproxy/middleware"
-	"ccproxy/proxy"
+	"ccproxy/storage"
 	"ccproxy/web"
 	"ccproxy/websocket"
 )
 
 type Server struct {
-	config    *config.Config
-	handler   *proxy.ProxyHandler
+	routes    *reloadableHandler
 	server    *http.Server
 	webServer *http.Server
+	web       *web.WebServer
 	hub       *websocket.Hub
+
+	profile     *string           // Profile chosen through SwitchProfile, kept across reloads
+	maintenance map[string]bool   // Target paths toggled through SetMaintenance, kept across reloads
+	overrides   map[string]string // Upstream URLs forced up or down through SetUpstreamOverride, kept across reloads
+	syslog      *storage.Syslog   // logging.syslog, also closed when only forwarding errors
+	dataDir     string            // Request history and reports
+
+	archiver        *storage.Archiver
+	archiveSettings config.HistoryArchive // Settings archiver was started with
+	janitor         *storage.Janitor
+	reporter        *storage.Reporter
+	retention       storage.RetentionOptions // Limits janitor was started with
+	statsPusher     *websocket.StatsPusher
+	statsInterval   time.Duration // Interval statsPusher was started with
+
+	notifier   atomic.Pointer[notifier] // notifications.webhooks, nil without webhooks
+	notifyStop chan struct{}            // Stops watchNotifications
+
+	healthMu        sync.Mutex
+	healthListeners []func(websocket.HealthEvent) // Added through OnHealthChange
+
+	proxyListener net.Listener
+	webListener   net.Listener
+	startTime     time.Time
+	draining      atomic.Bool
+	drainOnce     sync.Once
+	drainDone     chan struct{}
+}
+
+// Options controls where a server keeps its runtime data
+type Options struct {
+	DataDir string // Directory for request history, defaults to ./data
 }
 
 func NewServer(cfg *config.Config) *Server {
+	srv, err := New(cfg, Options{})
+	if err != nil {
+		log.Fatalf("Failed to create server: %v", err)
+	}
+	return srv
+}
+
+// New creates the proxy and web servers without starting them
+func New(cfg *config.Config, opts Options) (*Server, error) {
 	// 创建数据目录
-	dataDir := "./data"
+	dataDir := opts.DataDir
+	if dataDir == "" {
+		dataDir = "./data"
+	}
 	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
 	}
 
-	hub, err := websocket.NewHub(cfg.WebSocket.BroadcastSize, dataDir)
+	hub, err := websocket.NewHub(cfg.WebSocket.BroadcastSize, dataDir, cfg.History.Backend)
 	if err != nil {
-		log.Fatalf("Failed to create websocket hub: %v", err)
+		return nil, fmt.Errorf("failed to create websocket hub: %w", err)
 	}
 	go hub.Run()
 
-	handler := proxy.NewProxyHandler(cfg)
-	loggerHandler := middleware.NewLoggerMiddleware(handler, hub, cfg)
+	s := &Server{
+		routes:    &reloadableHandler{},
+		hub:       hub,
+		dataDir:   dataDir,
+		startTime: time.Now(),
+	}
+	s.routes.swap(s.newRoutes(cfg))
 
 	proxyMux := http.NewServeMux()
-	proxyMux.Handle("/", loggerHandler)
+	proxyMux.Handle("/", s.routes)
 
 	server := createHTTPServer(fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port), proxyMux, cfg)
 
@@ -51,38 +103,123 @@ func NewServer(cfg *config.Config) *Server {
 	webServer.SetupRoutes(webMux)
 
 	webServerInstance := createHTTPServer(fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Web.Port), webMux, cfg)
+	// Shutdown waits for active requests, end the open /api/stream responses
+	webServerInstance.RegisterOnShutdown(hub.CloseStreams)
 
-	return &Server{
-		config:    cfg,
-		handler:   handler,
-		server:    server,
-		webServer: webServerInstance,
-		hub:       hub,
-	}
+	s.server = server
+	s.webServer = webServerInstance
+	s.web = webServer
+	s.openLogSinks(cfg)
+	s.openArchiver(cfg)
+	s.configureHistory(cfg)
+	s.openJanitor(cfg)
+	s.openStatsPusher(cfg)
+	s.openNotifier(cfg)
+	s.openReporter(cfg)
+	s.hub.SetMaxMessageSize(cfg.WebSocket.MaxMessageBytes)
+	webServer.SetController(s)
+	return s, nil
 }
 
+// Start serves until SIGINT/SIGTERM is received
 func (s *Server) Start() error {
-	log.Printf("Starting proxy server on %s", s.server.Addr)
+	if err := s.Listen(); err != nil {
+		return err
+	}
+
+	s.waitForShutdown()
+	return nil
+}
+
+// Listen binds the proxy and web listeners and serves them in the background.
+// Bind errors such as a port already in use are returned immediately.
+func (s *Server) Listen() error {
+	activated, err := activatedListeners()
+	if err != nil {
+		return err
+	}
+
+	proxyListener, err := listen(activated["proxy"], s.server.Addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.server.Addr, err)
+	}
+
+	var webListener, webServeListener net.Listener
+	if cfg := s.routes.current.Load().config; cfg.Web.Enabled && s.webServer != nil {
+		tlsConfig, err := webTLSConfig(cfg)
+		if err != nil {
+			proxyListener.Close()
+			return err
+		}
+
+		webListener, err = listen(activated["web"], s.webServer.Addr)
+		if err != nil {
+			proxyListener.Close()
+			return fmt.Errorf("failed to listen on %s: %w", s.webServer.Addr, err)
+		}
+
+		// Keep the plain socket in webListener, Upgrade hands it over as is
+		webServeListener = webListener
+		if tlsConfig != nil {
+			webServeListener = tls.NewListener(webListener, tlsConfig)
+		}
+	}
 
+	s.proxyListener = proxyListener
+	s.webListener = webListener
+
+	log.Printf("Starting proxy server on %s", proxyListener.Addr())
 	go func() {
-		if err := s.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Failed to start proxy server: %v", err)
+		if err := s.server.Serve(proxyListener); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERROR] Proxy server stopped: %v", err)
 		}
 	}()
 
-	if s.config.Web.Enabled && s.webServer != nil {
-		log.Printf("Starting web interface on %s", s.webServer.Addr)
+	if webListener != nil {
+		scheme := "http"
+		if webServeListener != webListener {
+			scheme = "https"
+		}
+		log.Printf("Starting web interface on %s://%s", scheme, webListener.Addr())
 		go func() {
-			if err := s.webServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				log.Fatalf("Failed to start web server: %v", err)
+			if err := s.webServer.Serve(webServeListener); err != nil && err != http.ErrServerClosed {
+				log.Printf("[ERROR] Web server stopped: %v", err)
 			}
 		}()
 	}
 
-	s.waitForShutdown()
+	s.notifyStop = make(chan struct{})
+	go s.watchNotifications(s.notifyStop)
+	s.notify(&Notification{Event: "start", Message: fmt.Sprintf("ccproxy started, proxying on %s", proxyListener.Addr())})
+
+	notifyUpgradeParent()
 	return nil
 }
 
+// listen reuses a socket passed in by systemd when available and binds addr otherwise
+func listen(activated net.Listener, addr string) (net.Listener, error) {
+	if activated != nil {
+		log.Printf("Using inherited listener %s", activated.Addr())
+		return activated, nil
+	}
+	return net.Listen("tcp", addr)
+}
+
+// webTLSConfig loads the dashboard certificate, nil means plain HTTP
+func webTLSConfig(cfg *config.Config) (*tls.Config, error) {
+	if cfg.Web.TLS.CertFile == "" {
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(cfg.Web.TLS.CertFile, cfg.Web.TLS.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load web TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
 func createHTTPServer(addr string, handler http.Handler, cfg *config.Config) *http.Server {
 	return &http.Server{
 		Addr:    addr,
@@ -96,14 +233,49 @@ func createHTTPServer(addr string, handler http.Handler, cfg *config.Config) *ht
 
 func (s *Server) waitForShutdown() {
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	if upgradeSignal != nil {
+		signal.Notify(quit, upgradeSignal)
+	}
+
+wait:
+	for sig := range quit {
+		switch sig {
+		case syscall.SIGHUP:
+			log.Println("Received SIGHUP, reloading configuration...")
+			if err := s.Reload(); err != nil {
+				log.Printf("[ERROR] Configuration reload failed, keeping current configuration: %v", err)
+			}
+		case upgradeSignal:
+			log.Println("Received upgrade signal, starting new process...")
+			if err := s.Upgrade(); err != nil {
+				log.Printf("[ERROR] Upgrade failed, keeping current process: %v", err)
+			}
+		default:
+			break wait
+		}
+	}
 
+	s.Stop()
+}
+
+// Stop drains in-flight requests and then shuts the servers down within the
+// configured shutdown timeout, the same way SIGTERM does
+func (s *Server) Stop() {
 	log.Println("Shutting down servers...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(s.config.Server.Timeouts.Shutdown)*time.Second)
+	// Let streaming responses finish before the shutdown deadline applies
+	<-s.startDrain()
+
+	shutdown := s.routes.current.Load().config.Server.Timeouts.Shutdown
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(shutdown)*time.Second)
 	defer cancel()
 
+	s.Shutdown(ctx)
+}
+
+// Shutdown gracefully stops both servers and the background health checks
+func (s *Server) Shutdown(ctx context.Context) {
 	if err := s.server.Shutdown(ctx); err != nil {
 		log.Printf("Proxy server forced to shutdown: %v", err)
 	} else {
@@ -117,4 +289,17 @@ func (s *Server) waitForShutdown() {
 			log.Println("Web server gracefully stopped")
 		}
 	}
+
+	s.notify(&Notification{Event: "stop", Message: "ccproxy stopped"})
+	s.closeNotifier()
+
+	s.routes.current.Load().handler.Close()
+	s.closeLogSinks()
+	s.closeArchiver()
+	s.closeJanitor()
+	s.closeReporter()
+	s.closeStatsPusher()
+	if err := s.hub.Close(); err != nil {
+		log.Printf("[WARN] Failed to close history: %v", err)
+	}
 }
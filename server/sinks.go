@@ -0,0 +1,111 @@
+package server
+
+import (
+	"io"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"ccproxy/config"
+	"ccproxy/storage"
+	"ccproxy/websocket"
+)
+
+// errorLog forwards [WARN] and [ERROR] lines of the process log to syslog
+var errorLog struct {
+	once   sync.Once
+	syslog atomic.Pointer[storage.Syslog]
+}
+
+type errorLogWriter struct{}
+
+func (errorLogWriter) Write(p []byte) (int, error) {
+	if syslog := errorLog.syslog.Load(); syslog != nil {
+		syslog.WriteLine(p)
+	}
+	return len(p), nil
+}
+
+// setErrorLog sends process log errors to syslog, nil stops forwarding
+func setErrorLog(syslog *storage.Syslog) {
+	if syslog != nil {
+		errorLog.once.Do(func() {
+			log.SetOutput(io.MultiWriter(log.Writer(), errorLogWriter{}))
+		})
+	}
+	errorLog.syslog.Store(syslog)
+}
+
+// openLogSinks opens the outputs configured under logging and replaces the
+// current ones. A sink that cannot be opened is reported and skipped so the
+// proxy keeps serving.
+func (s *Server) openLogSinks(cfg *config.Config) {
+	var sinks []websocket.LogSink
+	if cfg.Logging.File != "" {
+		accessLog, err := storage.NewAccessLog(cfg.Logging.File, cfg.Logging.FileMaxSize, cfg.Logging.FileMaxAge, cfg.Logging.FileMaxBackups)
+		if err != nil {
+			log.Printf("[ERROR] Access log disabled: %v", err)
+		} else {
+			sinks = append(sinks, accessLog)
+		}
+	}
+
+	for i, settings := range cfg.Logging.Exporters {
+		exporter, err := storage.NewExporter(storage.ExporterOptions{
+			Type:          settings.Type,
+			URL:           settings.URL,
+			Username:      settings.Username,
+			Password:      settings.Password,
+			Token:         settings.Token,
+			Headers:       settings.Headers,
+			Labels:        settings.Labels,
+			Index:         settings.Index,
+			Bodies:        settings.Bodies,
+			BatchSize:     settings.BatchSize,
+			FlushInterval: time.Duration(settings.FlushInterval) * time.Second,
+		})
+		if err != nil {
+			log.Printf("[ERROR] Log exporter %d disabled: %v", i, err)
+			continue
+		}
+		sinks = append(sinks, exporter)
+	}
+
+	var syslog *storage.Syslog
+	if settings := cfg.Logging.Syslog; settings.Address != "" {
+		var err error
+		syslog, err = storage.NewSyslog(settings.Address, config.SyslogFacilities[settings.Facility], settings.Tag)
+		if err != nil {
+			log.Printf("[ERROR] Syslog disabled: %v", err)
+		}
+	}
+	var errorSyslog *storage.Syslog
+	if syslog != nil {
+		access, forwardErrors := cfg.Logging.Syslog.Streams()
+		if access {
+			sinks = append(sinks, syslog)
+		}
+		if forwardErrors {
+			errorSyslog = syslog
+		}
+	}
+
+	previous := s.syslog
+	s.syslog = syslog
+	setErrorLog(errorSyslog)
+	s.hub.SetSinks(sinks)
+	if previous != nil {
+		previous.Close()
+	}
+}
+
+// closeLogSinks flushes and closes the log outputs on shutdown
+func (s *Server) closeLogSinks() {
+	setErrorLog(nil)
+	s.hub.SetSinks(nil)
+	if s.syslog != nil {
+		s.syslog.Close()
+		s.syslog = nil
+	}
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"time"
+
+	"ccproxy/config"
+	"ccproxy/websocket"
+)
+
+// openStatsPusher pushes stats to the dashboard every websocket.stats_interval
+// seconds, the pusher is restarted only when the interval changes
+func (s *Server) openStatsPusher(cfg *config.Config) {
+	interval := time.Duration(cfg.WebSocket.StatsInterval) * time.Second
+	if s.statsPusher != nil && interval == s.statsInterval {
+		return
+	}
+	s.closeStatsPusher()
+
+	if interval <= 0 {
+		return
+	}
+	s.statsPusher, s.statsInterval = websocket.NewStatsPusher(s.hub, interval, s.fillStats), interval
+}
+
+func (s *Server) closeStatsPusher() {
+	if s.statsPusher != nil {
+		s.statsPusher.Close()
+		s.statsPusher = nil
+	}
+}
+
+// fillStats adds the in-flight requests and upstream health to a stats push
+func (s *Server) fillStats(msg *websocket.StatsMessage) {
+	msg.ActiveRequests = s.routes.active.Load()
+	for url, health := range s.Upstreams() {
+		msg.Upstreams[url] = &websocket.UpstreamStatus{
+			Healthy:        health.IsHealthy,
+			ResponseTimeMs: float64(health.ResponseTime.Microseconds()) / 1000,
+			ErrorCount:     health.ErrorCount,
+			LastCheck:      health.LastCheck,
+		}
+	}
+}
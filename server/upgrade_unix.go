@@ -0,0 +1,109 @@
+//go:build !windows
+
+package server
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// upgradeParentEnv carries the pid of the process handing over its listeners
+const upgradeParentEnv = "CCPROXY_UPGRADE_PARENT"
+
+// upgradeSignal asks a running server to hand over to a freshly started binary
+var upgradeSignal os.Signal = syscall.SIGUSR2
+
+// Upgrade starts a new copy of the current executable that inherits the
+// listening sockets. Once the new process is serving it asks this one to
+// shut down, so in-flight requests finish here while new ones go to the child.
+func (s *Server) Upgrade() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to locate executable: %w", err)
+	}
+
+	var files []*os.File
+	var names []string
+	for _, l := range []struct {
+		name     string
+		listener net.Listener
+	}{{"proxy", s.proxyListener}, {"web", s.webListener}} {
+		if l.listener == nil {
+			continue
+		}
+		filer, ok := l.listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("%s listener does not support handover", l.name)
+		}
+		file, err := filer.File()
+		if err != nil {
+			return fmt.Errorf("failed to duplicate %s listener: %w", l.name, err)
+		}
+		defer file.Close()
+		files = append(files, file)
+		names = append(names, l.name)
+	}
+
+	env := append(os.Environ(),
+		"LISTEN_FDS="+strconv.Itoa(len(files)),
+		"LISTEN_FDNAMES="+joinNames(names),
+		upgradeParentEnv+"="+strconv.Itoa(os.Getpid()),
+	)
+
+	process, err := os.StartProcess(executable, os.Args, &os.ProcAttr{
+		Env:   env,
+		Files: append([]*os.File{os.Stdin, os.Stdout, os.Stderr}, files...),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to start new process: %w", err)
+	}
+
+	log.Printf("[INFO] Started new process %d, waiting for it to take over the listeners", process.Pid)
+	return process.Release()
+}
+
+// inheritedFromUpgrade reports whether the activation sockets were handed over by Upgrade
+func inheritedFromUpgrade() bool {
+	return os.Getenv(upgradeParentEnv) != ""
+}
+
+// notifyUpgradeParent tells the previous process that this one is serving
+func notifyUpgradeParent() {
+	parent, err := strconv.Atoi(os.Getenv(upgradeParentEnv))
+	os.Unsetenv(upgradeParentEnv)
+	if err != nil || parent <= 0 {
+		return
+	}
+
+	// Under systemd the parent is the main pid, and its exit would stop the
+	// whole unit unless the service manager is told about the new one first
+	if err := notifyServiceManager("MAINPID=" + strconv.Itoa(os.Getpid())); err != nil {
+		log.Printf("[WARN] Failed to report new main pid to the service manager: %v", err)
+	}
+
+	if err := syscall.Kill(parent, syscall.SIGTERM); err != nil {
+		log.Printf("[WARN] Failed to notify previous process %d: %v", parent, err)
+		return
+	}
+	log.Printf("[INFO] Took over listeners from process %d", parent)
+}
+
+// notifyServiceManager sends a state update over NOTIFY_SOCKET, if systemd set one
+func notifyServiceManager(state string) error {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return nil
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
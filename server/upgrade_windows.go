@@ -0,0 +1,20 @@
+package server
+
+import (
+	"fmt"
+	"os"
+)
+
+// upgradeSignal is unavailable on Windows, which has no SIGUSR2
+var upgradeSignal os.Signal
+
+// Upgrade is not supported on Windows because listeners cannot be inherited
+func (s *Server) Upgrade() error {
+	return fmt.Errorf("binary upgrade is not supported on windows")
+}
+
+func inheritedFromUpgrade() bool {
+	return false
+}
+
+func notifyUpgradeParent() {}
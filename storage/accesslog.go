@@ -0,0 +1,163 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"ccproxy/types"
+)
+
+// AccessLog writes one JSON line per request, without bodies, to a file and rotates it by
+// size and age. Rotated files get a timestamp before the extension, e.g.
+// access-2006-01-02T15-04-05.000.log.
+type AccessLog struct {
+	mu         sync.Mutex
+	path       string
+	maxSize    int64         // Rotate before the file grows past this many bytes, 0 disables
+	maxAge     time.Duration // Rotate files opened longer ago, 0 disables
+	maxBackups int           // Rotated files kept, older ones are removed
+
+	file   *os.File
+	size   int64
+	opened time.Time
+}
+
+// NewAccessLog opens (or appends to) the access log at path
+func NewAccessLog(path string, maxSizeMB, maxAgeHours, maxBackups int) (*AccessLog, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create access log directory: %w", err)
+	}
+	a := &AccessLog{
+		path:       path,
+		maxSize:    int64(maxSizeMB) * 1024 * 1024,
+		maxAge:     time.Duration(maxAgeHours) * time.Hour,
+		maxBackups: maxBackups,
+	}
+	if err := a.open(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (a *AccessLog) open() error {
+	// Paths and client addresses are only readable by the owner, like the audit log
+	file, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open access log: %w", err)
+	}
+	file.Chmod(0600) // Files created before keep their mode otherwise
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat access log: %w", err)
+	}
+
+	a.file = file
+	a.size = info.Size()
+	a.opened = time.Now()
+	if a.size > 0 {
+		// Age counts from the first entry of an existing file
+		a.opened = firstEntryTime(a.path, info.ModTime())
+	}
+	return nil
+}
+
+// firstEntryTime returns the timestamp of the first line of an access log,
+// or fallback when it cannot be read
+func firstEntryTime(path string, fallback time.Time) time.Time {
+	file, err := os.Open(path)
+	if err != nil {
+		return fallback
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReaderSize(file, 64*1024).ReadSlice('\n')
+	if err != nil {
+		return fallback
+	}
+	var entry struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if json.Unmarshal(line, &entry) != nil {
+		return fallback
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.000", entry.Timestamp, time.Local)
+	if err != nil {
+		return fallback
+	}
+	return t
+}
+
+// WriteLog appends msg as a JSON line, rotating the file first when needed
+func (a *AccessLog) WriteLog(msg *types.LogMessage) error {
+	data, err := json.Marshal(msg.WithoutBodies())
+	if err != nil {
+		return fmt.Errorf("failed to marshal access log entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return fmt.Errorf("access log %s is closed", a.path)
+	}
+	if a.size > 0 && ((a.maxSize > 0 && a.size+int64(len(data)) > a.maxSize) ||
+		(a.maxAge > 0 && time.Since(a.opened) >= a.maxAge)) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := a.file.Write(data)
+	a.size += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to write access log: %w", err)
+	}
+	return nil
+}
+
+// rotate renames the current file and starts a new one, callers hold mu
+func (a *AccessLog) rotate() error {
+	a.file.Close()
+	a.file = nil
+
+	ext := filepath.Ext(a.path)
+	base := strings.TrimSuffix(a.path, ext)
+	rotated := fmt.Sprintf("%s-%s%s", base, time.Now().Format("2006-01-02T15-04-05.000"), ext)
+	if err := os.Rename(a.path, rotated); err != nil {
+		return fmt.Errorf("failed to rotate access log: %w", err)
+	}
+	if err := a.open(); err != nil {
+		return err
+	}
+
+	if a.maxBackups > 0 {
+		backups, _ := filepath.Glob(base + "-*" + ext)
+		sort.Strings(backups)
+		for i := 0; i < len(backups)-a.maxBackups; i++ {
+			os.Remove(backups[i])
+		}
+	}
+	return nil
+}
+
+// Close closes the file, later writes fail
+func (a *AccessLog) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.file == nil {
+		return nil
+	}
+	err := a.file.Close()
+	a.file = nil
+	return err
+}
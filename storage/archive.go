@@ -0,0 +1,192 @@
+package storage
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// archiveStateFile records the uploaded history files and their sizes
+const archiveStateFile = "archive-state.json"
+
+// ArchiveOptions configures an Archiver
+type ArchiveOptions struct {
+	S3Options
+	Prefix      string // Prepended to the object keys, e.g. "laptop/"
+	DeleteLocal bool   // Remove history files once they are uploaded
+}
+
+// Archiver uploads rotated history files, gzipped, to an S3-compatible
+// bucket. It runs on start, after every rotation and every 10 minutes to
+// retry failed uploads.
+type Archiver struct {
+	opts      ArchiveOptions
+	s3        *s3Client
+	history   *HistoryStorage
+	statePath string
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewArchiver starts archiving the rotated files of history
+func NewArchiver(history *HistoryStorage, opts ArchiveOptions) (*Archiver, error) {
+	client, err := newS3Client(opts.S3Options)
+	if err != nil {
+		return nil, err
+	}
+	a := &Archiver{
+		opts:      opts,
+		s3:        client,
+		history:   history,
+		statePath: filepath.Join(history.dir(), archiveStateFile),
+		wake:      make(chan struct{}, 1),
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	history.SetRotateHook(a.Trigger)
+	go a.run()
+	return a, nil
+}
+
+// Trigger schedules an archive run
+func (a *Archiver) Trigger() {
+	select {
+	case a.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (a *Archiver) run() {
+	defer close(a.done)
+
+	ticker := time.NewTicker(10 * time.Minute)
+	defer ticker.Stop()
+	for {
+		a.archive()
+		select {
+		case <-a.wake:
+		case <-ticker.C:
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// archive uploads the rotated files that are not in the bucket yet
+func (a *Archiver) archive() {
+	files, err := a.history.RotatedFiles()
+	if err != nil {
+		log.Printf("[WARN] History archive: %v", err)
+		return
+	}
+
+	uploaded := map[string]int64{}
+	if data, err := os.ReadFile(a.statePath); err == nil {
+		json.Unmarshal(data, &uploaded)
+	}
+	// Only files still on disk are kept, so the state never grows
+	for name := range uploaded {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(a.statePath), name)); err != nil {
+			delete(uploaded, name)
+		}
+	}
+
+	removed := false
+	for _, file := range files {
+		if a.stopped() {
+			break
+		}
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		name := filepath.Base(file)
+		if size, ok := uploaded[name]; !ok || size != info.Size() {
+			key, err := a.upload(file)
+			if err != nil {
+				log.Printf("[WARN] Failed to archive %s: %v", name, err)
+				continue
+			}
+			log.Printf("[INFO] Archived %s to s3://%s/%s", name, a.opts.Bucket, key)
+			uploaded[name] = info.Size()
+		}
+		if a.opts.DeleteLocal {
+			if err := os.Remove(file); err != nil {
+				log.Printf("[WARN] Failed to remove archived %s: %v", name, err)
+			} else {
+				removeIndex(file)
+				delete(uploaded, name)
+				removed = true
+			}
+		}
+	}
+	if removed {
+		if _, err := a.history.RemoveUnusedBlobs(); err != nil {
+			log.Printf("[WARN] Failed to remove unused history blobs: %v", err)
+		}
+	}
+
+	data, _ := json.Marshal(uploaded)
+	if err := os.WriteFile(a.statePath, data, 0644); err != nil {
+		log.Printf("[WARN] Failed to save history archive state: %v", err)
+	}
+}
+
+func (a *Archiver) stopped() bool {
+	select {
+	case <-a.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// upload gzips file, with the bodies kept in blob files, and stores it under
+// the prefix. It returns the object key.
+func (a *Archiver) upload(file string) (string, error) {
+	data, err := a.history.readFileWithBodies(file)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = filepath.Base(file)
+	if _, err := zw.Write(data); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-a.stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	key := a.opts.Prefix + filepath.Base(file) + ".gz"
+	if err := a.s3.putObject(ctx, key, buf.Bytes(), "application/gzip"); err != nil {
+		return "", fmt.Errorf("upload to %s: %w", a.opts.Bucket, err)
+	}
+	return key, nil
+}
+
+// Close stops archiving, an upload in progress is cancelled
+func (a *Archiver) Close() error {
+	a.history.SetRotateHook(nil)
+	close(a.stop)
+	<-a.done
+	return nil
+}
@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+
+	"ccproxy/types"
+)
+
+// History persists request logs for the monitor, see HistoryStorage (JSONL
+// files, the default) and BoltHistory (a single bbolt database)
+type History interface {
+	AppendMessage(msg *types.LogMessage) error
+	GetRecentMessages(limit int) ([]*types.LogMessage, error) // Newest first
+	Query(filter HistoryFilter) ([]*types.LogMessage, error)  // Newest first
+	// Scan passes the entries matching filter to fn one at a time, newest
+	// first, until fn returns false. Unlike Query it does not collect them or
+	// block new entries for the whole scan. filter.Limit is ignored.
+	Scan(filter HistoryFilter, fn func(msg *types.LogMessage) bool) error
+	ClearHistory() error
+	Prune(opts RetentionOptions) (PruneResult, error)
+	Close() error
+}
+
+// History backends accepted by OpenHistory
+const (
+	BackendJSONL = "jsonl"
+	BackendBolt  = "bolt"
+)
+
+// Both backends keep about as many entries, the JSONL files rotate every
+// historyFileLines lines and historyFiles of them are kept
+const (
+	historyFiles     = 10
+	historyFileLines = 10000
+)
+
+// OpenHistory opens the history of dataDir in the given backend, JSONL when empty
+func OpenHistory(dataDir, backend string) (History, error) {
+	switch backend {
+	case "", BackendJSONL:
+		return NewHistoryStorage(dataDir, historyFiles, historyFileLines)
+	case BackendBolt:
+		return OpenBoltHistory(dataDir, historyFiles*historyFileLines)
+	}
+	return nil, fmt.Errorf("unknown history backend %q", backend)
+}
@@ -0,0 +1,227 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"ccproxy/types"
+)
+
+// blobDir holds the bodies kept out of the history files, named by their
+// SHA-256 below a directory of the first two hex digits
+const blobDir = "blobs"
+
+// blobRef finds the blob references of a history line without decoding it
+var blobRef = regexp.MustCompile(`"(?:request|response)_blob":"([0-9a-f]{64})"`)
+
+// SetBlobThreshold stores bodies larger than n bytes in blob files referenced
+// from the history, so reading recent entries does not load them. Bodies are
+// kept inline when n is 0.
+func (h *HistoryStorage) SetBlobThreshold(n int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.blobThreshold = n
+}
+
+// externalizeBodies returns msg with its large bodies moved to blob files,
+// msg itself is not changed
+func (h *HistoryStorage) externalizeBodies(msg *types.LogMessage) (*types.LogMessage, error) {
+	if h.blobThreshold <= 0 || (len(msg.RequestBody) <= h.blobThreshold && len(msg.ResponseBody) <= h.blobThreshold) {
+		return msg, nil
+	}
+
+	stored := *msg
+	if len(stored.RequestBody) > h.blobThreshold {
+		sum, err := h.writeBlob(stored.RequestBody)
+		if err != nil {
+			return nil, err
+		}
+		stored.RequestBody, stored.RequestBlob = "", sum
+	}
+	if len(stored.ResponseBody) > h.blobThreshold {
+		sum, err := h.writeBlob(stored.ResponseBody)
+		if err != nil {
+			return nil, err
+		}
+		stored.ResponseBody, stored.ResponseBlob = "", sum
+	}
+	return &stored, nil
+}
+
+func (h *HistoryStorage) blobPath(sum string) string {
+	return filepath.Join(filepath.Dir(h.filePath), blobDir, sum[:2], sum)
+}
+
+// writeBlob stores body unless a blob with the same content exists
+func (h *HistoryStorage) writeBlob(body string) (string, error) {
+	hash := sha256.Sum256([]byte(body))
+	sum := hex.EncodeToString(hash[:])
+	path := h.blobPath(sum)
+	if _, err := os.Stat(path); err == nil {
+		return sum, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", fmt.Errorf("failed to create blob directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(body), 0644); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return "", fmt.Errorf("failed to write blob: %w", err)
+	}
+	return sum, nil
+}
+
+// loadBodies reads the bodies of msg kept in blob files. A missing blob
+// leaves the body empty and its reference in place.
+func (h *HistoryStorage) loadBodies(msg *types.LogMessage) {
+	if msg.RequestBlob != "" && msg.RequestBody == "" {
+		if data, err := os.ReadFile(h.blobPath(msg.RequestBlob)); err == nil {
+			msg.RequestBody, msg.RequestBlob = string(data), ""
+		}
+	}
+	if msg.ResponseBlob != "" && msg.ResponseBody == "" {
+		if data, err := os.ReadFile(h.blobPath(msg.ResponseBlob)); err == nil {
+			msg.ResponseBody, msg.ResponseBlob = string(data), ""
+		}
+	}
+}
+
+// readFileWithBodies reads a history file with the blob bodies put back
+// into the entries, e.g. to archive it on its own
+func (h *HistoryStorage) readFileWithBodies(file string) ([]byte, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	data, err := os.ReadFile(file)
+	if err != nil || !blobRef.Match(data) {
+		return data, err
+	}
+
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		var msg types.LogMessage
+		if !blobRef.Match(line) || json.Unmarshal(line, &msg) != nil {
+			buf.Write(line)
+			continue
+		}
+		h.loadBodies(&msg)
+		encoded, err := json.Marshal(&msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal message: %w", err)
+		}
+		buf.Write(encoded)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes(), nil
+}
+
+// blobsSize returns the disk space used by blob files
+func (h *HistoryStorage) blobsSize() int64 {
+	var total int64
+	filepath.WalkDir(filepath.Join(filepath.Dir(h.filePath), blobDir), func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+// lineBlobsSize returns the size of the blobs a history line references
+func (h *HistoryStorage) lineBlobsSize(line []byte) int64 {
+	var total int64
+	for _, match := range blobRef.FindAllSubmatch(line, -1) {
+		if info, err := os.Stat(h.blobPath(string(match[1]))); err == nil {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// removeUnusedBlobs deletes the blobs no history file references anymore and
+// returns the space reclaimed. The caller holds h.mu, so no entry
+// referencing a new blob is written meanwhile.
+func (h *HistoryStorage) removeUnusedBlobs() (int64, error) {
+	root := filepath.Join(filepath.Dir(h.filePath), blobDir)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return 0, nil
+	}
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(h.filePath), "history_*.jsonl"))
+	if err != nil {
+		return 0, fmt.Errorf("failed to glob history files: %w", err)
+	}
+	used := map[string]bool{}
+	for _, file := range files {
+		if err := collectBlobRefs(file, used); err != nil && !os.IsNotExist(err) {
+			// Without every reference no blob can be removed safely
+			return 0, err
+		}
+	}
+
+	var reclaimed int64
+	err = filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || used[d.Name()] {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if err := os.Remove(path); err == nil {
+			reclaimed += info.Size()
+		}
+		return nil
+	})
+	// Only empty prefix directories can be removed
+	entries, _ := os.ReadDir(root)
+	for _, entry := range entries {
+		os.Remove(filepath.Join(root, entry.Name()))
+	}
+	return reclaimed, err
+}
+
+// RemoveUnusedBlobs deletes the blobs of entries that were removed
+func (h *HistoryStorage) RemoveUnusedBlobs() (int64, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.removeUnusedBlobs()
+}
+
+func collectBlobRefs(file string, used map[string]bool) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		for _, match := range blobRef.FindAllSubmatch(line, -1) {
+			used[string(match[1])] = true
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", filepath.Base(file), err)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"ccproxy/types"
+)
+
+// boltFile is the database of the bolt backend in the data directory
+const boltFile = "history.db"
+
+var (
+	boltEntries = []byte("entries") // Entry time and sequence number -> JSON entry
+	boltIDs     = []byte("ids")     // Request ID -> key in boltEntries
+)
+
+// BoltHistory keeps the history in a bbolt database. Entries are keyed by
+// time so recent entries and time ranges are read with a cursor, and request
+// IDs are looked up through a second bucket. Every write is a transaction.
+type BoltHistory struct {
+	db         *bolt.DB
+	maxEntries int
+
+	mu    sync.Mutex // Serializes writes so count stays in step with the database
+	count int        // Entries in the database
+}
+
+// OpenBoltHistory opens or creates the database in dataDir, the oldest
+// entries are removed beyond maxEntries
+func OpenBoltHistory(dataDir string, maxEntries int) (*BoltHistory, error) {
+	if err := os.MkdirAll(dataDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create data directory: %w", err)
+	}
+
+	path := filepath.Join(dataDir, boltFile)
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: time.Second})
+	if errors.Is(err, bolt.ErrTimeout) {
+		return nil, fmt.Errorf("%s is in use by another process", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+
+	h := &BoltHistory{db: db, maxEntries: maxEntries}
+	err = db.Update(func(tx *bolt.Tx) error {
+		entries, err := tx.CreateBucketIfNotExists(boltEntries)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.CreateBucketIfNotExists(boltIDs); err != nil {
+			return err
+		}
+		h.count = entries.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize %s: %w", path, err)
+	}
+	return h, nil
+}
+
+// boltKey orders entries by time in milliseconds, the sequence number keeps
+// entries of the same millisecond apart
+func boltKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key, uint64(max(t.UnixMilli(), 0)))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
+
+// AppendMessage stores msg
+func (h *BoltHistory) AppendMessage(msg *types.LogMessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	count := h.count
+	err = h.db.Update(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(boltEntries)
+		seq, err := entries.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := boltKey(entryTime(msg), seq)
+		if err := entries.Put(key, data); err != nil {
+			return err
+		}
+		if msg.RequestID != "" {
+			if err := tx.Bucket(boltIDs).Put([]byte(msg.RequestID), key); err != nil {
+				return err
+			}
+		}
+		count++
+
+		// Like the oldest history file, the oldest entries make room
+		if count > h.maxEntries {
+			var result PruneResult
+			if err := deleteOldest(tx, func(_, _ []byte) bool { return count-result.Records > h.maxEntries }, &result); err != nil {
+				return err
+			}
+			count -= result.Records
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	h.count = count
+	return nil
+}
+
+// GetRecentMessages returns the newest limit entries
+func (h *BoltHistory) GetRecentMessages(limit int) ([]*types.LogMessage, error) {
+	return h.Query(HistoryFilter{Limit: limit})
+}
+
+// Query returns the entries matching filter, newest first. Entries are found
+// by request ID or read from the end of the time range.
+func (h *BoltHistory) Query(filter HistoryFilter) ([]*types.LogMessage, error) {
+	var messages []*types.LogMessage
+	add := func(value []byte) bool {
+		var msg types.LogMessage
+		if json.Unmarshal(value, &msg) == nil && filter.Match(&msg) {
+			messages = append(messages, &msg)
+		}
+		return filter.Limit <= 0 || len(messages) < filter.Limit
+	}
+
+	err := h.db.View(func(tx *bolt.Tx) error {
+		entries := tx.Bucket(boltEntries)
+		if len(filter.IDs) > 0 {
+			var keys [][]byte
+			for _, id := range filter.IDs {
+				if key := tx.Bucket(boltIDs).Get([]byte(id)); key != nil {
+					keys = append(keys, key)
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool { return bytes.Compare(keys[i], keys[j]) > 0 })
+			for _, key := range keys {
+				if value := entries.Get(key); value != nil && !add(value) {
+					break
+				}
+			}
+			return nil
+		}
+
+		c := entries.Cursor()
+		k, v := c.Last()
+		if !filter.Until.IsZero() {
+			// Keys are in whole milliseconds, Match drops the entries from Until on
+			if k, _ = c.Seek(boltKey(filter.Until.Add(time.Millisecond), 0)); k != nil {
+				k, v = c.Prev()
+			} else {
+				k, v = c.Last()
+			}
+		}
+		var since []byte
+		if !filter.Since.IsZero() {
+			since = boltKey(filter.Since, 0)
+		}
+		for ; k != nil; k, v = c.Prev() {
+			if since != nil && bytes.Compare(k, since) < 0 {
+				break
+			}
+			if !add(v) {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return messages, nil
+}
+
+// boltScanBatch is how many entries Scan reads per transaction
+const boltScanBatch = 100
+
+// Scan passes the entries matching filter to fn, newest first. Entries are
+// read in short transactions of boltScanBatch entries, a long read
+// transaction keeps bbolt from growing the file for new entries.
+func (h *BoltHistory) Scan(filter HistoryFilter, fn func(msg *types.LogMessage) bool) error {
+	if len(filter.IDs) > 0 {
+		messages, err := h.Query(filter)
+		if err != nil {
+			return err
+		}
+		for _, msg := range messages {
+			if !fn(msg) {
+				break
+			}
+		}
+		return nil
+	}
+
+	var since, before []byte // Entries from before on are done
+	if !filter.Since.IsZero() {
+		since = boltKey(filter.Since, 0)
+	}
+	if !filter.Until.IsZero() {
+		before = boltKey(filter.Until.Add(time.Millisecond), 0)
+	}
+	for {
+		var batch []*types.LogMessage
+		done := true
+		err := h.db.View(func(tx *bolt.Tx) error {
+			c := tx.Bucket(boltEntries).Cursor()
+			k, v := c.Last()
+			if before != nil {
+				if k, _ = c.Seek(before); k != nil {
+					k, v = c.Prev()
+				} else {
+					k, v = c.Last()
+				}
+			}
+			for read := 0; k != nil; k, v = c.Prev() {
+				if since != nil && bytes.Compare(k, since) < 0 {
+					break
+				}
+				if read == boltScanBatch {
+					done = false
+					break
+				}
+				read++
+				before = append(before[:0], k...)
+				var msg types.LogMessage
+				if json.Unmarshal(v, &msg) == nil && filter.Match(&msg) {
+					batch = append(batch, &msg)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read history: %w", err)
+		}
+		for _, msg := range batch {
+			if !fn(msg) {
+				return nil
+			}
+		}
+		if done {
+			return nil
+		}
+	}
+}
+
+// Prune removes the entries outside opts. The database file does not
+// shrink, bbolt reuses the freed pages for new entries.
+func (h *BoltHistory) Prune(opts RetentionOptions) (PruneResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var result PruneResult
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		if opts.MaxAge > 0 {
+			cutoff := boltKey(time.Now().Add(-opts.MaxAge), 0)
+			if err := deleteOldest(tx, func(k, _ []byte) bool { return bytes.Compare(k, cutoff) < 0 }, &result); err != nil {
+				return err
+			}
+		}
+		if opts.MaxTotalSize > 0 {
+			var total int64
+			tx.Bucket(boltEntries).ForEach(func(k, v []byte) error {
+				total += int64(len(k) + len(v))
+				return nil
+			})
+			excess := total - opts.MaxTotalSize
+			freed := result.Bytes
+			if err := deleteOldest(tx, func(_, _ []byte) bool { return result.Bytes-freed < excess }, &result); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return PruneResult{}, fmt.Errorf("failed to prune history: %w", err)
+	}
+	h.count -= result.Records
+	return result, nil
+}
+
+// deleteOldest removes entries from the oldest on while drop returns true
+// and adds them to result
+func deleteOldest(tx *bolt.Tx, drop func(k, v []byte) bool, result *PruneResult) error {
+	entries, ids := tx.Bucket(boltEntries), tx.Bucket(boltIDs)
+
+	// Deleting while moving the cursor skips entries, so keys are collected first
+	var keys [][]byte
+	c := entries.Cursor()
+	for k, v := c.First(); k != nil && drop(k, v); k, v = c.Next() {
+		var entry struct {
+			RequestID string `json:"request_id"`
+		}
+		json.Unmarshal(v, &entry)
+		if entry.RequestID != "" && bytes.Equal(ids.Get([]byte(entry.RequestID)), k) {
+			if err := ids.Delete([]byte(entry.RequestID)); err != nil {
+				return err
+			}
+		}
+		keys = append(keys, append([]byte(nil), k...))
+		result.Records++
+		result.Bytes += int64(len(k) + len(v))
+	}
+	for _, k := range keys {
+		if err := entries.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClearHistory removes every entry
+func (h *BoltHistory) ClearHistory() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	err := h.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{boltEntries, boltIDs} {
+			if err := tx.DeleteBucket(name); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+			if _, err := tx.CreateBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to clear history: %w", err)
+	}
+	h.count = 0
+	return nil
+}
+
+// Close closes the database
+func (h *BoltHistory) Close() error {
+	return h.db.Close()
+}
@@ -0,0 +1,289 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"ccproxy/types"
+)
+
+// ExporterOptions configures an Exporter, see config.LogExporter
+type ExporterOptions struct {
+	Type          string // loki or elasticsearch
+	URL           string
+	Username      string
+	Password      string
+	Token         string
+	Headers       map[string]string
+	Labels        map[string]string
+	Index         string
+	Bodies        bool
+	BatchSize     int
+	FlushInterval time.Duration
+}
+
+// Exporter pushes access log entries in batches to Grafana Loki or the
+// Elasticsearch/OpenSearch bulk API. Entries are queued and sent in the
+// background, they are dropped while the queue is full or the store is down.
+type Exporter struct {
+	opts     ExporterOptions
+	endpoint string
+	client   *http.Client
+
+	queue  chan *types.LogMessage
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+// indexLayout matches the time layouts in an Elasticsearch index name
+var indexLayout = regexp.MustCompile(`\{([^}]+)\}`)
+
+// NewExporter starts an exporter, the push path is added to the URL when it
+// is missing: /loki/api/v1/push for Loki and /_bulk for Elasticsearch
+func NewExporter(opts ExporterOptions) (*Exporter, error) {
+	u, err := url.Parse(opts.URL)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid %s URL %q", opts.Type, opts.URL)
+	}
+	switch opts.Type {
+	case "loki":
+		if !strings.HasSuffix(u.Path, "/push") {
+			u.Path = strings.TrimSuffix(u.Path, "/") + "/loki/api/v1/push"
+		}
+	case "elasticsearch":
+		if !strings.HasSuffix(u.Path, "/_bulk") {
+			u.Path = strings.TrimSuffix(u.Path, "/") + "/_bulk"
+		}
+	default:
+		return nil, fmt.Errorf("unknown exporter type %q", opts.Type)
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 100
+	}
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = 5 * time.Second
+	}
+
+	e := &Exporter{
+		opts:     opts,
+		endpoint: u.String(),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		queue:    make(chan *types.LogMessage, max(1000, opts.BatchSize*10)),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// WriteLog queues an entry, bodies are dropped unless the exporter includes them
+func (e *Exporter) WriteLog(msg *types.LogMessage) error {
+	if !e.opts.Bodies {
+		msg = msg.WithoutBodies()
+	}
+
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.closed {
+		return nil
+	}
+	select {
+	case e.queue <- msg:
+	default:
+	}
+	return nil
+}
+
+// QueueName names the queue in the WebSocket stats, e.g. "loki http://loki:3100/loki/api/v1/push"
+func (e *Exporter) QueueName() string {
+	return e.opts.Type + " " + e.endpoint
+}
+
+// QueueDepth returns the entries waiting to be pushed
+func (e *Exporter) QueueDepth() int {
+	return len(e.queue)
+}
+
+// run collects entries into batches and pushes them when full or on every
+// flush interval
+func (e *Exporter) run() {
+	defer close(e.done)
+
+	ticker := time.NewTicker(e.opts.FlushInterval)
+	defer ticker.Stop()
+
+	var batch []*types.LogMessage
+	healthy := true
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := e.push(batch)
+		if err != nil && healthy {
+			log.Printf("[WARN] Failed to push logs to %s, dropping entries until it is back: %v", e.endpoint, err)
+		} else if err == nil && !healthy {
+			log.Printf("[INFO] Pushing logs to %s again", e.endpoint)
+		}
+		healthy = err == nil
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case msg, ok := <-e.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, msg)
+			if len(batch) >= e.opts.BatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// push sends one batch
+func (e *Exporter) push(batch []*types.LogMessage) error {
+	var body []byte
+	var contentType string
+	var err error
+	if e.opts.Type == "loki" {
+		body, err = e.encodeLoki(batch)
+		contentType = "application/json"
+	} else {
+		body, err = e.encodeBulk(batch)
+		contentType = "application/x-ndjson"
+	}
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for key, value := range e.opts.Headers {
+		req.Header.Set(key, value)
+	}
+	if e.opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+e.opts.Token)
+	} else if e.opts.Username != "" {
+		req.SetBasicAuth(e.opts.Username, e.opts.Password)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(respBody)))
+	}
+	if e.opts.Type == "elasticsearch" {
+		// The bulk API answers 200 even when single documents are rejected
+		var result struct {
+			Errors bool `json:"errors"`
+			Items  []map[string]struct {
+				Error json.RawMessage `json:"error"`
+			} `json:"items"`
+		}
+		if json.Unmarshal(respBody, &result) == nil && result.Errors {
+			for _, item := range result.Items {
+				for _, action := range item {
+					if len(action.Error) > 0 {
+						return fmt.Errorf("bulk request rejected: %s", action.Error)
+					}
+				}
+			}
+			return fmt.Errorf("bulk request rejected")
+		}
+	}
+	return nil
+}
+
+// encodeLoki builds a push request with every entry as a JSON line of one stream
+func (e *Exporter) encodeLoki(batch []*types.LogMessage) ([]byte, error) {
+	values := make([][2]string, 0, len(batch))
+	for _, msg := range batch {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal log entry: %w", err)
+		}
+		values = append(values, [2]string{strconv.FormatInt(entryTime(msg).UnixNano(), 10), string(line)})
+	}
+
+	type stream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	return json.Marshal(map[string][]stream{
+		"streams": {{Stream: e.opts.Labels, Values: values}},
+	})
+}
+
+// encodeBulk builds a _bulk request indexing every entry with an @timestamp field
+func (e *Exporter) encodeBulk(batch []*types.LogMessage) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, msg := range batch {
+		t := entryTime(msg)
+		action := map[string]map[string]string{"index": {"_index": e.index(t)}}
+		document := struct {
+			Timestamp string `json:"@timestamp"`
+			*types.LogMessage
+		}{t.Format(time.RFC3339Nano), msg}
+
+		for _, v := range []interface{}{action, document} {
+			line, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("failed to marshal log entry: %w", err)
+			}
+			buf.Write(line)
+			buf.WriteByte('\n')
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// index expands the time layouts in the index name, e.g. ccproxy-{2006.01.02}
+func (e *Exporter) index(t time.Time) string {
+	return indexLayout.ReplaceAllStringFunc(e.opts.Index, func(layout string) string {
+		return t.UTC().Format(layout[1 : len(layout)-1])
+	})
+}
+
+// Close pushes the queued entries and stops the exporter
+func (e *Exporter) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	close(e.queue)
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+	case <-time.After(10 * time.Second):
+		log.Printf("[WARN] Timed out pushing the remaining logs to %s", e.endpoint)
+	}
+	return nil
+}
@@ -18,6 +18,9 @@ type HistoryStorage struct {
 	mu       sync.RWMutex
 	maxFiles int
 	maxLines int
+	onRotate func() // 文件轮转后调用, 用于归档
+
+	blobThreshold int // 超过该大小的请求体和响应体保存为单独的 blob 文件, 0 表示不拆分
 }
 
 // NewHistoryStorage 创建新的历史记录存储
@@ -48,6 +51,12 @@ func (h *HistoryStorage) AppendMessage(msg *types.LogMessage) error {
 		return fmt.Errorf("failed to rotate file: %w", err)
 	}
 
+	// 较大的请求体和响应体单独保存
+	msg, err := h.externalizeBodies(msg)
+	if err != nil {
+		return err
+	}
+
 	// 打开文件进行追加
 	file, err := os.OpenFile(h.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
@@ -60,12 +69,22 @@ func (h *HistoryStorage) AppendMessage(msg *types.LogMessage) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat history file: %w", err)
+	}
 
 	// 写入一行JSON数据
-	if _, err := fmt.Fprintf(file, "%s\n", data); err != nil {
+	line := append(data, '\n')
+	if _, err := file.Write(line); err != nil {
 		return fmt.Errorf("failed to write message: %w", err)
 	}
 
+	// 更新索引, 索引只用于加速读取, 失败时读取时会重建
+	if err := appendIndex(h.filePath, info.Size(), line, msg); err != nil {
+		fmt.Printf("Warning: failed to update history index: %v\n", err)
+	}
+
 	return nil
 }
 
@@ -101,56 +120,22 @@ func (h *HistoryStorage) GetRecentMessages(limit int) ([]*types.LogMessage, erro
 	return messages, nil
 }
 
-// readMessagesFromFile 从单个文件读取消息
+// readMessagesFromFile 通过索引从单个文件读取最近的 limit 条消息, 最新的在前
 func (h *HistoryStorage) readMessagesFromFile(filePath string, limit int) ([]*types.LogMessage, error) {
-	file, err := os.Open(filePath)
+	file, err := openIndexed(filePath)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
 	var messages []*types.LogMessage
-	scanner := bufio.NewScanner(file)
-	
-	// 增加缓冲区大小以处理大的JSON行
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 1024*1024) // 设置最大token大小为1MB
-
-	// 读取最后1000行（限制内存使用）
-	var lines []string
-	lineCount := 0
-	tempLines := make([]string, 1000) // 循环缓冲区
-	
-	for scanner.Scan() {
-		tempLines[lineCount%1000] = scanner.Text()
-		lineCount++
-	}
-	
-	// 如果总行数小于等于1000，直接使用
-	if lineCount <= 1000 {
-		lines = tempLines[:lineCount]
-	} else {
-		// 重新排列获取最后1000行
-		start := lineCount % 1000
-		lines = make([]string, 1000)
-		for i := 0; i < 1000; i++ {
-			lines[i] = tempLines[(start+i)%1000]
+	for i := len(file.entries) - 1; i >= 0 && len(messages) < limit; i-- {
+		line, err := file.line(i)
+		if err != nil {
+			return nil, err
 		}
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("failed to scan file: %w", err)
-	}
-
-	// 从最后开始读取（最新的消息）
-	start := 0
-	if len(lines) > limit {
-		start = len(lines) - limit
-	}
-
-	for i := len(lines) - 1; i >= start; i-- {
 		var msg types.LogMessage
-		if err := json.Unmarshal([]byte(lines[i]), &msg); err != nil {
+		if err := json.Unmarshal(line, &msg); err != nil {
 			// 跳过无法解析的行
 			continue
 		}
@@ -207,11 +192,46 @@ func (h *HistoryStorage) rotateFileIfNeeded() error {
 	dataDir := filepath.Dir(h.filePath)
 	newFilePath := filepath.Join(dataDir, fmt.Sprintf("history_%s.jsonl", timestamp))
 	h.filePath = newFilePath
+	if h.onRotate != nil {
+		h.onRotate()
+	}
 
 	// 清理旧文件
 	return h.cleanupOldFiles()
 }
 
+// SetRotateHook 设置文件轮转后的回调, 回调不能阻塞
+func (h *HistoryStorage) SetRotateHook(fn func()) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.onRotate = fn
+}
+
+// dir 返回数据目录
+func (h *HistoryStorage) dir() string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return filepath.Dir(h.filePath)
+}
+
+// RotatedFiles 返回不再写入的历史文件, 按时间顺序
+func (h *HistoryStorage) RotatedFiles() ([]string, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(h.filePath), "history_*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob history files: %w", err)
+	}
+	rotated := files[:0]
+	for _, file := range files {
+		if file != h.filePath {
+			rotated = append(rotated, file)
+		}
+	}
+	return rotated, nil
+}
+
 // countLines 计算文件行数
 func (h *HistoryStorage) countLines(filePath string) (int, error) {
 	file, err := os.Open(filePath)
@@ -251,12 +271,21 @@ func (h *HistoryStorage) cleanupOldFiles() error {
 				// 记录错误但继续处理
 				fmt.Printf("Warning: failed to remove old history file %s: %v\n", files[i], err)
 			}
+			removeIndex(files[i])
+		}
+		if _, err := h.removeUnusedBlobs(); err != nil {
+			fmt.Printf("Warning: failed to remove unused history blobs: %v\n", err)
 		}
 	}
 
 	return nil
 }
 
+// Close 实现 History 接口, 文件在每次写入后即关闭
+func (h *HistoryStorage) Close() error {
+	return nil
+}
+
 // ClearHistory 清空所有历史记录
 func (h *HistoryStorage) ClearHistory() error {
 	h.mu.Lock()
@@ -278,6 +307,10 @@ func (h *HistoryStorage) ClearHistory() error {
 				return fmt.Errorf("failed to remove history file %s: %w", file, err)
 			}
 		}
+		removeIndex(file)
+	}
+	if err := os.RemoveAll(filepath.Join(dataDir, blobDir)); err != nil {
+		return fmt.Errorf("failed to remove history blobs: %w", err)
 	}
 
 	// 更新当前文件路径，使用新的时间戳
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"ccproxy/types"
+)
+
+// Every history file has an index next to it, history_*.jsonl.idx, with
+// one fixed-size record per line so recent entries and time ranges are read
+// without scanning the file. The index is rebuilt from the file whenever it
+// does not end where the file ends, e.g. after an older version wrote the
+// file or the retention cut it.
+const (
+	indexHeader     = "CCPIDX1\n"
+	indexRecordSize = 24 // offset (8), length (4), timestamp in ms (8), status (2), reserved (2)
+)
+
+// indexEntry locates one history line
+type indexEntry struct {
+	offset int64
+	length int64
+	time   int64 // Unix milliseconds of the entry timestamp, 0 when it has none
+	status int
+}
+
+func indexPath(file string) string {
+	return file + ".idx"
+}
+
+func (e indexEntry) encode() []byte {
+	b := make([]byte, indexRecordSize)
+	binary.LittleEndian.PutUint64(b[0:], uint64(e.offset))
+	binary.LittleEndian.PutUint32(b[8:], uint32(e.length))
+	binary.LittleEndian.PutUint64(b[12:], uint64(e.time))
+	binary.LittleEndian.PutUint16(b[20:], uint16(e.status))
+	return b
+}
+
+func decodeIndexEntry(b []byte) indexEntry {
+	return indexEntry{
+		offset: int64(binary.LittleEndian.Uint64(b[0:])),
+		length: int64(binary.LittleEndian.Uint32(b[8:])),
+		time:   int64(binary.LittleEndian.Uint64(b[12:])),
+		status: int(binary.LittleEndian.Uint16(b[20:])),
+	}
+}
+
+// newIndexEntry describes a line written at offset
+func newIndexEntry(offset int64, line []byte, timestamp string, status int) indexEntry {
+	e := indexEntry{offset: offset, length: int64(len(line)), status: status}
+	if t, err := time.ParseInLocation("2006-01-02 15:04:05.000", timestamp, time.Local); err == nil {
+		e.time = t.UnixMilli()
+	}
+	return e
+}
+
+// appendIndex records the line written at offset of file. The index is
+// rebuilt instead when it does not end at offset.
+func appendIndex(file string, offset int64, line []byte, msg *types.LogMessage) error {
+	idx, err := os.OpenFile(indexPath(file), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+
+	info, err := idx.Stat()
+	if err != nil {
+		return err
+	}
+	valid := info.Size() == 0 && offset == 0
+	if size := info.Size(); size >= int64(len(indexHeader))+indexRecordSize && (size-int64(len(indexHeader)))%indexRecordSize == 0 {
+		last := make([]byte, indexRecordSize)
+		if _, err := idx.ReadAt(last, size-indexRecordSize); err == nil {
+			e := decodeIndexEntry(last)
+			valid = e.offset+e.length == offset
+		}
+	}
+	if !valid {
+		idx.Close()
+		_, err := rebuildIndex(file)
+		return err
+	}
+
+	record := newIndexEntry(offset, line, msg.Timestamp, msg.StatusCode).encode()
+	if info.Size() == 0 {
+		record = append([]byte(indexHeader), record...)
+	}
+	_, err = idx.WriteAt(record, info.Size())
+	return err
+}
+
+// loadIndex returns the index of file, rebuilt when it is missing or stale
+func loadIndex(file string) ([]indexEntry, error) {
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(indexPath(file))
+	if err == nil && bytes.HasPrefix(data, []byte(indexHeader)) && (len(data)-len(indexHeader))%indexRecordSize == 0 {
+		data = data[len(indexHeader):]
+		entries := make([]indexEntry, 0, len(data)/indexRecordSize)
+		for i := 0; i < len(data); i += indexRecordSize {
+			entries = append(entries, decodeIndexEntry(data[i:i+indexRecordSize]))
+		}
+		end := int64(0)
+		if len(entries) > 0 {
+			end = entries[len(entries)-1].offset + entries[len(entries)-1].length
+		}
+		if end == info.Size() {
+			return entries, nil
+		}
+	}
+	return rebuildIndex(file)
+}
+
+// rebuildIndex scans file and writes its index
+func rebuildIndex(file string) ([]indexEntry, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []indexEntry
+	var offset int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			var entry struct {
+				Timestamp  string `json:"timestamp"`
+				StatusCode int    `json:"status_code"`
+			}
+			json.Unmarshal(line, &entry)
+			entries = append(entries, newIndexEntry(offset, line, entry.Timestamp, entry.StatusCode))
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(file), err)
+		}
+	}
+
+	buf := bytes.NewBufferString(indexHeader)
+	for _, e := range entries {
+		buf.Write(e.encode())
+	}
+	// Readers may rebuild an index at the same time, each writes its own copy
+	tmp, err := os.CreateTemp(filepath.Dir(file), filepath.Base(indexPath(file))+".*")
+	if err != nil {
+		return entries, nil
+	}
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Chmod(0644)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), indexPath(file)) != nil {
+		// The index only speeds up reads
+		os.Remove(tmp.Name())
+	}
+	return entries, nil
+}
+
+// indexedFile reads single lines of a history file through its index
+type indexedFile struct {
+	file    *os.File
+	entries []indexEntry
+}
+
+func openIndexed(file string) (*indexedFile, error) {
+	entries, err := loadIndex(file)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	return &indexedFile{file: f, entries: entries}, nil
+}
+
+// line reads the line of entry i
+func (x *indexedFile) line(i int) ([]byte, error) {
+	line := make([]byte, x.entries[i].length)
+	if _, err := x.file.ReadAt(line, x.entries[i].offset); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(x.file.Name()), err)
+	}
+	return line, nil
+}
+
+func (x *indexedFile) Close() error {
+	return x.file.Close()
+}
+
+// removeIndex deletes the index of a history file that was removed or rewritten
+func removeIndex(file string) {
+	os.Remove(indexPath(file))
+}
@@ -0,0 +1,229 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"ccproxy/types"
+)
+
+// HistoryFilter selects history entries, empty fields match everything
+type HistoryFilter struct {
+	IDs       []string  // Request IDs
+	Method    string    // Case-insensitive
+	Path      string    // Substring of the path
+	Status    string    // Exact code such as 429, or a class such as 5xx
+	Target    string    // Substring of the target URL
+	ErrorType string    // See types.LogMessage.ErrorType
+	Text      string    // Words or "quoted phrases" that all occur in the bodies, see SearchEntry
+	Since     time.Time // Inclusive
+	Until     time.Time // Exclusive
+	Limit     int       // Maximum number of entries, unlimited when 0
+}
+
+// Match reports whether msg passes the filter
+func (f *HistoryFilter) Match(msg *types.LogMessage) bool {
+	if len(f.IDs) > 0 {
+		found := false
+		for _, id := range f.IDs {
+			found = found || id == msg.RequestID
+		}
+		if !found {
+			return false
+		}
+	}
+	if f.Method != "" && !strings.EqualFold(f.Method, msg.Method) {
+		return false
+	}
+	if f.Path != "" && !strings.Contains(msg.Path, f.Path) {
+		return false
+	}
+	if f.Status != "" && !matchStatus(f.Status, msg.StatusCode) {
+		return false
+	}
+	if f.Target != "" && !strings.Contains(msg.TargetURL, f.Target) {
+		return false
+	}
+	if f.ErrorType != "" && f.ErrorType != msg.ErrorType {
+		return false
+	}
+	if f.Text != "" && SearchEntry(msg, f.Text) == nil {
+		return false
+	}
+	if !f.Since.IsZero() || !f.Until.IsZero() {
+		t := entryTime(msg)
+		if !f.Since.IsZero() && t.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && !t.Before(f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchStatus compares code with "429" or a class such as "5xx"
+func matchStatus(pattern string, code int) bool {
+	if len(pattern) == 3 && strings.EqualFold(pattern[1:], "xx") {
+		return strconv.Itoa(code/100) == pattern[:1]
+	}
+	return pattern == strconv.Itoa(code)
+}
+
+// Query returns the entries matching filter from every history file, newest first
+func (h *HistoryStorage) Query(filter HistoryFilter) ([]*types.LogMessage, error) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(h.filePath), "history_*.jsonl"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to glob history files: %w", err)
+	}
+
+	// Lines without any of the IDs as a JSON string are not decoded
+	var needles [][]byte
+	for _, id := range filter.IDs {
+		encoded, _ := json.Marshal(id)
+		needles = append(needles, encoded)
+	}
+
+	var messages []*types.LogMessage
+	collect := func(msg *types.LogMessage) bool {
+		messages = append(messages, msg)
+		return filter.Limit <= 0 || len(messages) < filter.Limit
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		file, err := openIndexed(files[i])
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		more, err := h.scanFile(file, &filter, needles, collect)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+		if !more {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// Scan passes the entries matching filter to fn, newest first. The lock is
+// only held while a file and its index are opened, entries appended later
+// are not seen.
+func (h *HistoryStorage) Scan(filter HistoryFilter, fn func(msg *types.LogMessage) bool) error {
+	h.mu.RLock()
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(h.filePath), "history_*.jsonl"))
+	h.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("failed to glob history files: %w", err)
+	}
+
+	var needles [][]byte
+	for _, id := range filter.IDs {
+		encoded, _ := json.Marshal(id)
+		needles = append(needles, encoded)
+	}
+
+	for i := len(files) - 1; i >= 0; i-- {
+		h.mu.RLock()
+		file, err := openIndexed(files[i])
+		h.mu.RUnlock()
+		if err != nil {
+			// Removed by rotation or retention since the glob
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		more, err := h.scanFile(file, &filter, needles, fn)
+		file.Close()
+		if err != nil {
+			return err
+		}
+		if !more {
+			break
+		}
+	}
+	return nil
+}
+
+// scanFile passes the matching entries of one file to fn, newest first, and
+// reports whether fn wants more. The index skips entries outside the time
+// range or status without reading them.
+func (h *HistoryStorage) scanFile(file *indexedFile, filter *HistoryFilter, needles [][]byte, fn func(msg *types.LogMessage) bool) (bool, error) {
+	for j := len(file.entries) - 1; j >= 0; j-- {
+		if !filter.matchIndex(file.entries[j]) {
+			continue
+		}
+		line, err := file.line(j)
+		if err != nil {
+			return false, err
+		}
+		if len(needles) > 0 && !containsAny(line, needles) {
+			continue
+		}
+		var msg types.LogMessage
+		if json.Unmarshal(line, &msg) != nil {
+			continue
+		}
+
+		// Bodies in blob files are searched and returned
+		if filter.Text != "" {
+			h.loadBodies(&msg)
+		}
+		if !filter.Match(&msg) {
+			continue
+		}
+		h.loadBodies(&msg)
+		if !fn(&msg) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// matchIndex reports whether an entry may pass the filter by its index record
+func (f *HistoryFilter) matchIndex(e indexEntry) bool {
+	if f.Status != "" && !matchStatus(f.Status, e.status) {
+		return false
+	}
+	if e.time != 0 {
+		t := time.UnixMilli(e.time)
+		if !f.Since.IsZero() && t.Before(f.Since) {
+			return false
+		}
+		if !f.Until.IsZero() && !t.Before(f.Until) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsAny(line []byte, needles [][]byte) bool {
+	for _, needle := range needles {
+		if bytes.Contains(line, needle) {
+			return true
+		}
+	}
+	return false
+}
+
+// entryTime parses the local timestamp of an entry, now when it is invalid
+func entryTime(msg *types.LogMessage) time.Time {
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.000", msg.Timestamp, time.Local)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"ccproxy/types"
+)
+
+// reportCheckInterval is how often the Reporter looks for a finished period
+// without a report
+const reportCheckInterval = 10 * time.Minute
+
+// ReportOptions configures a Reporter, see config.Reports
+type ReportOptions struct {
+	Dir       string
+	Daily     bool
+	Weekly    bool
+	TopErrors int
+	Prices    map[string]TokenPrice // By model name prefix
+	OnReport  func(report *UsageReport)
+}
+
+// TokenPrice is the price of a model in USD per million tokens
+type TokenPrice struct {
+	Input      float64
+	Output     float64
+	CacheWrite float64
+	CacheRead  float64
+}
+
+// TokenUsage counts the tokens reported in the usage of responses
+type TokenUsage struct {
+	InputTokens      int64 `json:"input_tokens"`
+	OutputTokens     int64 `json:"output_tokens"`
+	CacheWriteTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadTokens  int64 `json:"cache_read_input_tokens"`
+}
+
+func (u *TokenUsage) add(other TokenUsage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheWriteTokens += other.CacheWriteTokens
+	u.CacheReadTokens += other.CacheReadTokens
+}
+
+// UsageBreakdown is the share of one model or target in a report
+type UsageBreakdown struct {
+	Name     string `json:"name,omitempty"`
+	Requests int64  `json:"requests"`
+	Errors   int64  `json:"errors"`
+	TokenUsage
+	CostUSD float64 `json:"cost_usd"`
+}
+
+func (b *UsageBreakdown) add(failed bool, usage TokenUsage, cost float64) {
+	b.Requests++
+	if failed {
+		b.Errors++
+	}
+	b.TokenUsage.add(usage)
+	b.CostUSD += cost
+}
+
+// ErrorCount is how often requests failed with the same status and error type
+type ErrorCount struct {
+	Error string `json:"error"` // e.g. "429" or "502 connect"
+	Count int64  `json:"count"`
+}
+
+// UsageReport summarizes the history of one day or week. Tokens come from
+// the usage in logged responses, requests logged without bodies count as
+// unmetered.
+type UsageReport struct {
+	Period string    `json:"period"` // daily or weekly
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"` // Exclusive
+	UsageBreakdown
+	Unmetered int64             `json:"unmetered"` // Requests without token usage
+	Models    []*UsageBreakdown `json:"models"`    // Most requests first
+	Targets   []*UsageBreakdown `json:"targets"`   // By upstream scheme://host
+	TopErrors []ErrorCount      `json:"top_errors"`
+}
+
+// BuildUsageReport summarizes the history entries between start and end
+func BuildUsageReport(history History, period string, start, end time.Time, opts ReportOptions) (*UsageReport, error) {
+	report := &UsageReport{Period: period, Start: start, End: end}
+	models := map[string]*UsageBreakdown{}
+	targets := map[string]*UsageBreakdown{}
+	errors := map[string]int64{}
+	// Only the counters are kept, the entries with their bodies are dropped one by one
+	err := history.Scan(HistoryFilter{Since: start, Until: end}, func(msg *types.LogMessage) bool {
+		failed := msg.StatusCode < 200 || msg.StatusCode >= 400
+		model, usage, metered := messageUsage(msg)
+		cost := opts.cost(model, usage)
+
+		report.add(failed, usage, cost)
+		if !metered {
+			report.Unmetered++
+		}
+		breakdown(models, model).add(failed, usage, cost)
+		breakdown(targets, targetHost(msg.TargetURL)).add(failed, usage, cost)
+		if failed {
+			errors[errorKey(msg)]++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.Models = sortedBreakdowns(models)
+	report.Targets = sortedBreakdowns(targets)
+	for key, count := range errors {
+		report.TopErrors = append(report.TopErrors, ErrorCount{Error: key, Count: count})
+	}
+	sort.Slice(report.TopErrors, func(i, j int) bool {
+		a, b := report.TopErrors[i], report.TopErrors[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Error < b.Error)
+	})
+	if opts.TopErrors > 0 && len(report.TopErrors) > opts.TopErrors {
+		report.TopErrors = report.TopErrors[:opts.TopErrors]
+	}
+	return report, nil
+}
+
+func breakdown(m map[string]*UsageBreakdown, name string) *UsageBreakdown {
+	b := m[name]
+	if b == nil {
+		b = &UsageBreakdown{Name: name}
+		m[name] = b
+	}
+	return b
+}
+
+func sortedBreakdowns(m map[string]*UsageBreakdown) []*UsageBreakdown {
+	list := make([]*UsageBreakdown, 0, len(m))
+	for _, b := range m {
+		list = append(list, b)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Requests > list[j].Requests || (list[i].Requests == list[j].Requests && list[i].Name < list[j].Name)
+	})
+	return list
+}
+
+// targetHost groups requests by upstream like the target statistics
+func targetHost(targetURL string) string {
+	u, err := url.Parse(targetURL)
+	if err != nil || u.Host == "" {
+		return "no upstream"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func errorKey(msg *types.LogMessage) string {
+	key := fmt.Sprint(msg.StatusCode)
+	if msg.StatusCode == 0 {
+		key = "no response"
+	}
+	if msg.ErrorType != "" {
+		key += " " + msg.ErrorType
+	}
+	return key
+}
+
+// cost prices usage with the longest matching model prefix, 0 without a price
+func (o *ReportOptions) cost(model string, usage TokenUsage) float64 {
+	var price *TokenPrice
+	matched := -1
+	for prefix, p := range o.Prices {
+		if strings.HasPrefix(model, prefix) && len(prefix) > matched {
+			p := p
+			price, matched = &p, len(prefix)
+		}
+	}
+	if price == nil {
+		return 0
+	}
+	return (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output +
+		float64(usage.CacheWriteTokens)*price.CacheWrite + float64(usage.CacheReadTokens)*price.CacheRead) / 1e6
+}
+
+// usageFields covers the usage of Anthropic and OpenAI style responses
+type usageFields struct {
+	InputTokens         int64 `json:"input_tokens"`
+	OutputTokens        int64 `json:"output_tokens"`
+	CacheCreationTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadTokens     int64 `json:"cache_read_input_tokens"`
+	PromptTokens        int64 `json:"prompt_tokens"`
+	CompletionTokens    int64 `json:"completion_tokens"`
+}
+
+type usagePayload struct {
+	Model   string       `json:"model"`
+	Usage   *usageFields `json:"usage"`
+	Message *struct {
+		Model string       `json:"model"`
+		Usage *usageFields `json:"usage"`
+	} `json:"message"` // Anthropic message_start event
+}
+
+// messageUsage returns the model and token usage of a logged request. Streamed
+// responses report usage in several events, e.g. input tokens in
+// message_start and the output tokens so far in each message_delta, so the
+// largest value of each count is kept.
+func messageUsage(msg *types.LogMessage) (model string, usage TokenUsage, metered bool) {
+	var request struct {
+		Model string `json:"model"`
+	}
+	if json.Unmarshal([]byte(msg.RequestBody), &request) == nil {
+		model = request.Model
+	}
+
+	apply := func(data string) {
+		var payload usagePayload
+		if json.Unmarshal([]byte(data), &payload) != nil {
+			return
+		}
+		fields := payload.Usage
+		if payload.Message != nil {
+			if model == "" {
+				model = payload.Message.Model
+			}
+			if fields == nil {
+				fields = payload.Message.Usage
+			}
+		}
+		if model == "" {
+			model = payload.Model
+		}
+		if fields == nil {
+			return
+		}
+		metered = true
+		usage.InputTokens = max(usage.InputTokens, fields.InputTokens, fields.PromptTokens)
+		usage.OutputTokens = max(usage.OutputTokens, fields.OutputTokens, fields.CompletionTokens)
+		usage.CacheWriteTokens = max(usage.CacheWriteTokens, fields.CacheCreationTokens)
+		usage.CacheReadTokens = max(usage.CacheReadTokens, fields.CacheReadTokens)
+	}
+
+	body := strings.TrimSpace(msg.ResponseBody)
+	if strings.HasPrefix(body, "{") {
+		apply(body)
+	} else {
+		scanner := bufio.NewScanner(strings.NewReader(body))
+		scanner.Buffer(make([]byte, 64*1024), 10<<20)
+		for scanner.Scan() {
+			if data, ok := strings.CutPrefix(scanner.Text(), "data:"); ok {
+				apply(strings.TrimSpace(data))
+			}
+		}
+	}
+
+	if model == "" {
+		model = "unknown"
+	}
+	return model, usage, metered
+}
+
+// Title names the report, e.g. "Daily report 2026-10-15"
+func (r *UsageReport) Title() string {
+	last := r.End.AddDate(0, 0, -1)
+	if r.Period == "weekly" {
+		return fmt.Sprintf("Weekly report %s to %s", r.Start.Format("2006-01-02"), last.Format("2006-01-02"))
+	}
+	return fmt.Sprintf("Daily report %s", r.Start.Format("2006-01-02"))
+}
+
+// Summary is a few lines for chat notifications
+func (r *UsageReport) Summary() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %d requests, %d errors (%s)\n", r.Title(), r.Requests, r.Errors, percent(r.Errors, r.Requests))
+	fmt.Fprintf(&b, "Tokens: %s\n", formatUsage(r.TokenUsage))
+	if r.CostUSD > 0 {
+		fmt.Fprintf(&b, "Cost: %s\n", formatCost(r.CostUSD))
+	}
+	for i, model := range r.Models {
+		if i == 3 {
+			break
+		}
+		fmt.Fprintf(&b, "%s: %d requests, %s output tokens\n", model.Name, model.Requests, formatTokens(model.OutputTokens))
+	}
+	if len(r.TopErrors) > 0 {
+		fmt.Fprintf(&b, "Top error: %s (%d)\n", r.TopErrors[0].Error, r.TopErrors[0].Count)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Markdown renders the report with tables for the models, targets and errors
+func (r *UsageReport) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", r.Title())
+	fmt.Fprintf(&b, "- Requests: %d\n", r.Requests)
+	fmt.Fprintf(&b, "- Errors: %d (%s)\n", r.Errors, percent(r.Errors, r.Requests))
+	fmt.Fprintf(&b, "- Tokens: %s\n", formatUsage(r.TokenUsage))
+	if r.CostUSD > 0 {
+		fmt.Fprintf(&b, "- Cost: %s (models with a price)\n", formatCost(r.CostUSD))
+	}
+	if r.Unmetered > 0 {
+		fmt.Fprintf(&b, "- Without token usage: %d requests (not logged with bodies or no usage in the response)\n", r.Unmetered)
+	}
+
+	table := func(title, column string, rows []*UsageBreakdown) {
+		fmt.Fprintf(&b, "\n## %s\n\n", title)
+		fmt.Fprintf(&b, "| %s | Requests | Errors | Input | Output | Cache write | Cache read | Cost |\n", column)
+		b.WriteString("| --- | ---: | ---: | ---: | ---: | ---: | ---: | ---: |\n")
+		for _, row := range rows {
+			fmt.Fprintf(&b, "| %s | %d | %d | %s | %s | %s | %s | %s |\n", row.Name, row.Requests, row.Errors,
+				formatTokens(row.InputTokens), formatTokens(row.OutputTokens),
+				formatTokens(row.CacheWriteTokens), formatTokens(row.CacheReadTokens), formatCost(row.CostUSD))
+		}
+	}
+	table("Models", "Model", r.Models)
+	table("Targets", "Target", r.Targets)
+
+	if len(r.TopErrors) > 0 {
+		b.WriteString("\n## Top errors\n\n| Error | Requests |\n| --- | ---: |\n")
+		for _, e := range r.TopErrors {
+			fmt.Fprintf(&b, "| %s | %d |\n", e.Error, e.Count)
+		}
+	}
+	return b.String()
+}
+
+func formatUsage(u TokenUsage) string {
+	return fmt.Sprintf("%s input, %s output, %s cache write, %s cache read",
+		formatTokens(u.InputTokens), formatTokens(u.OutputTokens), formatTokens(u.CacheWriteTokens), formatTokens(u.CacheReadTokens))
+}
+
+func formatTokens(n int64) string {
+	switch {
+	case n >= 1e6:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1fK", float64(n)/1e3)
+	}
+	return fmt.Sprint(n)
+}
+
+// formatCost shows fractions of a cent, "-" when nothing was priced
+func formatCost(usd float64) string {
+	switch {
+	case usd == 0:
+		return "-"
+	case usd < 1:
+		return fmt.Sprintf("$%.4f", usd)
+	}
+	return fmt.Sprintf("$%.2f", usd)
+}
+
+func percent(part, total int64) string {
+	if total == 0 {
+		return "0%"
+	}
+	return fmt.Sprintf("%.1f%%", float64(part)*100/float64(total))
+}
+
+// Reporter writes a report for each finished day or week, on start and every
+// few minutes, so a period missed while the proxy was down is reported once
+// it runs again. Only the most recent period is caught up.
+type Reporter struct {
+	history History
+	opts    ReportOptions
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewReporter starts writing reports to opts.Dir
+func NewReporter(history History, opts ReportOptions) *Reporter {
+	r := &Reporter{
+		history: history,
+		opts:    opts,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+func (r *Reporter) run() {
+	defer close(r.done)
+
+	ticker := time.NewTicker(reportCheckInterval)
+	defer ticker.Stop()
+	for {
+		r.check(time.Now())
+		select {
+		case <-ticker.C:
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+// check writes the reports of the periods that ended before now
+func (r *Reporter) check(now time.Time) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if r.opts.Daily {
+		r.write("daily", today.AddDate(0, 0, -1), today)
+	}
+	if r.opts.Weekly {
+		monday := today.AddDate(0, 0, -(int(today.Weekday())+6)%7)
+		r.write("weekly", monday.AddDate(0, 0, -7), monday)
+	}
+}
+
+// write builds and saves one report unless it exists, e.g. daily-2026-10-15.json
+// and .md. The JSON file is written last and marks the period as done.
+func (r *Reporter) write(period string, start, end time.Time) {
+	base := filepath.Join(r.opts.Dir, period+"-"+start.Format("2006-01-02"))
+	if _, err := os.Stat(base + ".json"); err == nil {
+		return
+	}
+
+	report, err := BuildUsageReport(r.history, period, start, end, r.opts)
+	if err != nil {
+		log.Printf("[WARN] Usage report: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		log.Printf("[WARN] Usage report: %v", err)
+		return
+	}
+	if err := os.MkdirAll(r.opts.Dir, 0755); err != nil {
+		log.Printf("[WARN] Usage report: %v", err)
+		return
+	}
+	if err := os.WriteFile(base+".md", []byte(report.Markdown()), 0644); err != nil {
+		log.Printf("[WARN] Usage report: %v", err)
+		return
+	}
+	if err := os.WriteFile(base+".json", append(data, '\n'), 0644); err != nil {
+		log.Printf("[WARN] Usage report: %v", err)
+		return
+	}
+	log.Printf("[INFO] %s written to %s.json (%d requests)", report.Title(), base, report.Requests)
+
+	if r.opts.OnReport != nil {
+		r.opts.OnReport(report)
+	}
+}
+
+// Close stops the reporter
+func (r *Reporter) Close() error {
+	close(r.stop)
+	<-r.done
+	return nil
+}
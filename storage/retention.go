@@ -0,0 +1,288 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// RetentionOptions limits how much history is kept, zero values disable a limit
+type RetentionOptions struct {
+	MaxAge       time.Duration // Records older than this are removed
+	MaxTotalSize int64         // Oldest records are removed while all history files are larger, in bytes
+}
+
+// PruneResult reports what Prune removed
+type PruneResult struct {
+	Files   int   // History files removed entirely
+	Records int   // Records removed, including those of removed files
+	Bytes   int64 // Disk space reclaimed
+}
+
+// Prune removes the records outside opts. Whole files are removed where
+// possible, otherwise the oldest records of a file are cut.
+func (h *HistoryStorage) Prune(opts RetentionOptions) (PruneResult, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var result PruneResult
+	files, err := filepath.Glob(filepath.Join(filepath.Dir(h.filePath), "history_*.jsonl"))
+	if err != nil {
+		return result, fmt.Errorf("failed to glob history files: %w", err)
+	}
+
+	// Files are in time order, so the age limit stops at the first file
+	// starting within it
+	if opts.MaxAge > 0 {
+		cutoff := time.Now().Add(-opts.MaxAge)
+		for len(files) > 0 {
+			info, err := os.Stat(files[0])
+			if err != nil {
+				files = files[1:]
+				continue
+			}
+			if info.ModTime().Before(cutoff) && files[0] != h.filePath {
+				if err := h.removeHistoryFile(files[0], info.Size(), &result); err != nil {
+					return result, err
+				}
+				files = files[1:]
+				continue
+			}
+			removed, err := cutHistoryFile(files[0], func(line []byte) bool {
+				return lineTime(line).Before(cutoff)
+			}, &result)
+			if err != nil {
+				return result, err
+			}
+			if !removed {
+				break
+			}
+			files = files[1:]
+		}
+		if err := h.pruneBlobs(&result); err != nil {
+			return result, err
+		}
+	}
+
+	// Blob files count towards the size and are removed with the last entry
+	// referencing them
+	if opts.MaxTotalSize > 0 {
+		sizes := make([]int64, len(files))
+		total := h.blobsSize()
+		for i, file := range files {
+			if info, err := os.Stat(file); err == nil {
+				sizes[i] = info.Size()
+				total += sizes[i]
+			}
+		}
+		for i, file := range files {
+			if total <= opts.MaxTotalSize {
+				break
+			}
+			before := result.Bytes
+			if file != h.filePath {
+				if err := h.removeHistoryFile(file, sizes[i], &result); err != nil {
+					return result, err
+				}
+			} else {
+				// The file being written is cut to the newest records that fit
+				excess, freed := total-opts.MaxTotalSize, int64(0)
+				if _, err := cutHistoryFile(file, func(line []byte) bool {
+					if freed >= excess {
+						return false
+					}
+					freed += int64(len(line)) + h.lineBlobsSize(line)
+					return true
+				}, &result); err != nil {
+					return result, err
+				}
+			}
+			if err := h.pruneBlobs(&result); err != nil {
+				return result, err
+			}
+			total -= result.Bytes - before
+		}
+	}
+	return result, nil
+}
+
+// removeHistoryFile deletes a history file and adds it to result
+func (h *HistoryStorage) removeHistoryFile(file string, size int64, result *PruneResult) error {
+	records, _ := h.countLines(file)
+	if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", filepath.Base(file), err)
+	}
+	removeIndex(file)
+	result.Files++
+	result.Records += records
+	result.Bytes += size
+	return nil
+}
+
+// cutHistoryFile removes the leading records of file for which drop returns
+// true. It reports whether every record
+// was dropped, the file is then removed unless it is empty already.
+func cutHistoryFile(file string, drop func(line []byte) bool, result *PruneResult) (bool, error) {
+	in, err := os.Open(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	defer in.Close()
+
+	reader := bufio.NewReader(in)
+	var offset int64
+	records := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && !drop(line) {
+			break
+		}
+		offset += int64(len(line))
+		if len(bytes.TrimSpace(line)) > 0 {
+			records++
+		}
+		if err == io.EOF {
+			in.Close()
+			if offset == 0 {
+				return true, nil
+			}
+			if err := os.Remove(file); err != nil {
+				return false, fmt.Errorf("failed to remove %s: %w", filepath.Base(file), err)
+			}
+			removeIndex(file)
+			result.Files++
+			result.Records += records
+			result.Bytes += offset
+			return true, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read %s: %w", filepath.Base(file), err)
+		}
+	}
+	if offset == 0 {
+		return false, nil
+	}
+
+	// Copy the kept records to a new file that replaces the old one
+	if _, err := in.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	tmp := file + ".tmp"
+	out, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", filepath.Base(tmp), err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to write %s: %w", filepath.Base(tmp), err)
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return false, err
+	}
+	in.Close()
+	if err := os.Rename(tmp, file); err != nil {
+		os.Remove(tmp)
+		return false, fmt.Errorf("failed to replace %s: %w", filepath.Base(file), err)
+	}
+	removeIndex(file)
+	result.Records += records
+	result.Bytes += offset
+	return false, nil
+}
+
+// pruneBlobs removes the blobs of the pruned records
+func (h *HistoryStorage) pruneBlobs(result *PruneResult) error {
+	reclaimed, err := h.removeUnusedBlobs()
+	result.Bytes += reclaimed
+	return err
+}
+
+// lineTime returns the timestamp of a history line, the zero time when it
+// has none so that invalid lines go with the records before them
+func lineTime(line []byte) time.Time {
+	var entry struct {
+		Timestamp string `json:"timestamp"`
+	}
+	if json.Unmarshal(line, &entry) != nil {
+		return time.Time{}
+	}
+	t, err := time.ParseInLocation("2006-01-02 15:04:05.000", entry.Timestamp, time.Local)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Janitor applies the retention limits to the history on start and every hour
+type Janitor struct {
+	history History
+	opts    RetentionOptions
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// NewJanitor starts pruning history
+func NewJanitor(history History, opts RetentionOptions) *Janitor {
+	j := &Janitor{
+		history: history,
+		opts:    opts,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go j.run()
+	return j
+}
+
+func (j *Janitor) run() {
+	defer close(j.done)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		j.prune()
+		select {
+		case <-ticker.C:
+		case <-j.stop:
+			return
+		}
+	}
+}
+
+func (j *Janitor) prune() {
+	result, err := j.history.Prune(j.opts)
+	if err != nil {
+		log.Printf("[WARN] History retention: %v", err)
+	}
+	if result.Records > 0 || result.Files > 0 {
+		log.Printf("[INFO] History retention removed %d records (%d files), reclaimed %s",
+			result.Records, result.Files, formatSize(result.Bytes))
+	}
+}
+
+func formatSize(n int64) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// Close stops the janitor
+func (j *Janitor) Close() error {
+	close(j.stop)
+	<-j.done
+	return nil
+}
@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Options locates a bucket on AWS S3 or an S3-compatible service such as
+// MinIO or Cloudflare R2
+type S3Options struct {
+	Endpoint        string // Defaults to https://s3.<region>.amazonaws.com
+	Region          string
+	Bucket          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	PathStyle       bool // Address the bucket as endpoint/bucket instead of bucket.endpoint
+}
+
+// s3Client uploads objects with AWS Signature Version 4
+type s3Client struct {
+	opts   S3Options
+	client *http.Client
+}
+
+func newS3Client(opts S3Options) (*s3Client, error) {
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	u, err := url.Parse(opts.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid S3 endpoint %q", opts.Endpoint)
+	}
+	return &s3Client{opts: opts, client: &http.Client{Timeout: 10 * time.Minute}}, nil
+}
+
+// objectURL returns the URL of key, path encoded the way it is signed
+func (c *s3Client) objectURL(key string) *url.URL {
+	u, _ := url.Parse(c.opts.Endpoint)
+	path := strings.TrimSuffix(u.Path, "/") + "/" + key
+	if c.opts.PathStyle {
+		path = strings.TrimSuffix(u.Path, "/") + "/" + c.opts.Bucket + "/" + key
+	} else {
+		u.Host = c.opts.Bucket + "." + u.Host
+	}
+	return &url.URL{Scheme: u.Scheme, Host: u.Host, Path: path, RawPath: s3Escape(path)}
+}
+
+// putObject uploads body as key
+func (c *s3Client) putObject(ctx context.Context, key string, body []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, c.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	hash := sha256.Sum256(body)
+	c.sign(req, hex.EncodeToString(hash[:]), time.Now())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("PUT %s: %s: %s", key, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// sign adds the Signature Version 4 Authorization header, every header
+// already set on req is signed
+func (c *s3Client) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if c.opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.opts.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := date + "/" + c.opts.Region + "/s3/aws4_request"
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+c.opts.SecretAccessKey), date)
+	for _, part := range []string{c.opts.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.opts.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// s3Escape percent-encodes everything but unreserved characters and slashes
+func s3Escape(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		ch := path[i]
+		if ch >= 'A' && ch <= 'Z' || ch >= 'a' && ch <= 'z' || ch >= '0' && ch <= '9' || strings.IndexByte("-_.~/", ch) >= 0 {
+			b.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", ch)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/json"
+	"strings"
+	"unicode/utf8"
+
+	"ccproxy/types"
+)
+
+// SearchMatch is a body of an entry containing the search terms
+type SearchMatch struct {
+	Field   string `json:"field"`   // request_body, response_body or response_text (streamed text)
+	Snippet string `json:"snippet"` // Text around the first term
+}
+
+// searchTerms splits a query into lowercase words, "quoted phrases" are kept whole
+func searchTerms(text string) []string {
+	var terms []string
+	for i, part := range strings.Split(text, `"`) {
+		if i%2 == 1 {
+			if phrase := strings.TrimSpace(part); phrase != "" {
+				terms = append(terms, strings.ToLower(phrase))
+			}
+			continue
+		}
+		for _, word := range strings.Fields(part) {
+			terms = append(terms, strings.ToLower(word))
+		}
+	}
+	return terms
+}
+
+// searchFields returns the searchable text of an entry's bodies. Streamed
+// responses also contribute the text of their deltas, so phrases split
+// across events are found.
+func searchFields(msg *types.LogMessage) [][2]string {
+	fields := [][2]string{{"request_body", msg.RequestBody}, {"response_body", msg.ResponseBody}}
+	if text := streamText(msg.ResponseBody); text != "" {
+		fields = append(fields, [2]string{"response_text", text})
+	}
+	return fields
+}
+
+// SearchEntry reports the bodies of msg containing every term of text, case
+// insensitive. It returns nil when a term occurs in none of them.
+func SearchEntry(msg *types.LogMessage, text string) []SearchMatch {
+	terms := searchTerms(text)
+	if len(terms) == 0 {
+		return nil
+	}
+
+	found := make([]bool, len(terms))
+	var matches []SearchMatch
+	for _, field := range searchFields(msg) {
+		lower := strings.ToLower(field[1])
+		first := -1
+		for i, term := range terms {
+			if at := strings.Index(lower, term); at >= 0 {
+				found[i] = true
+				if first < 0 || at < first {
+					first = at
+				}
+			}
+		}
+		if first >= 0 {
+			matches = append(matches, SearchMatch{Field: field[0], Snippet: snippet(field[1], first)})
+		}
+	}
+	for _, ok := range found {
+		if !ok {
+			return nil
+		}
+	}
+	return matches
+}
+
+// snippet cuts context around at. The offset was found in the lowercased
+// text, which can differ in length for a few runes, so the cut is clamped
+// to the text and moved to rune boundaries.
+func snippet(text string, at int) string {
+	at = min(at, len(text))
+	start, end := max(at-80, 0), min(at+160, len(text))
+	for start > 0 && !utf8.RuneStart(text[start]) {
+		start--
+	}
+	for end < len(text) && !utf8.RuneStart(text[end]) {
+		end++
+	}
+
+	s := strings.Join(strings.Fields(text[start:end]), " ")
+	if start > 0 {
+		s = "…" + s
+	}
+	if end < len(text) {
+		s += "…"
+	}
+	return s
+}
+
+// streamText joins the text deltas of an Anthropic or OpenAI event stream
+func streamText(body string) string {
+	if !strings.Contains(body, "data:") {
+		return ""
+	}
+
+	var b strings.Builder
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	scanner.Buffer(make([]byte, 64*1024), len(body)+1)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data:")
+		if !ok {
+			continue
+		}
+		var event struct {
+			Delta struct {
+				Text string `json:"text"`
+			} `json:"delta"`
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if json.Unmarshal([]byte(strings.TrimSpace(data)), &event) != nil {
+			continue
+		}
+		b.WriteString(event.Delta.Text)
+		for _, choice := range event.Choices {
+			b.WriteString(choice.Delta.Content)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,204 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"ccproxy/types"
+)
+
+// Syslog severities, RFC 5424 section 6.2.1
+const (
+	severityError   = 3
+	severityWarning = 4
+	severityInfo    = 6
+)
+
+// Syslog sends access log entries and process log lines to the local syslog
+// daemon or a remote collector. Messages are queued and sent in the
+// background, they are dropped while the queue is full.
+type Syslog struct {
+	network  string // udp, tcp, or empty for the local socket
+	address  string
+	facility int
+	tag      string
+	hostname string
+
+	queue  chan []byte
+	done   chan struct{}
+	mu     sync.RWMutex
+	closed bool
+}
+
+// NewSyslog connects to address: "local" for the system logger, or
+// udp://host:514 and tcp://host:601 for a remote RFC 5424 collector.
+// facility is a code from config.SyslogFacilities.
+func NewSyslog(address string, facility int, tag string) (*Syslog, error) {
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+
+	s := &Syslog{
+		facility: facility,
+		tag:      tag,
+		hostname: hostname,
+		queue:    make(chan []byte, 1000),
+		done:     make(chan struct{}),
+	}
+	if address != "local" {
+		u, err := url.Parse(address)
+		if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp") || u.Host == "" {
+			return nil, fmt.Errorf("invalid syslog address %q (expected local, udp://host:port or tcp://host:port)", address)
+		}
+		s.network, s.address = u.Scheme, u.Host
+	}
+
+	conn, err := s.dial()
+	if err != nil {
+		return nil, err
+	}
+	go s.run(conn)
+	return s, nil
+}
+
+// dial connects to the collector, the local socket is looked up at the usual paths
+func (s *Syslog) dial() (net.Conn, error) {
+	if s.network != "" {
+		return net.DialTimeout(s.network, s.address, 5*time.Second)
+	}
+	for _, network := range []string{"unixgram", "unix"} {
+		for _, path := range []string{"/dev/log", "/var/run/syslog", "/var/run/log"} {
+			if conn, err := net.Dial(network, path); err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.New("no local syslog socket found")
+}
+
+// run sends queued messages, reconnecting after write errors
+func (s *Syslog) run(conn net.Conn) {
+	defer close(s.done)
+
+	connected := true
+	for message := range s.queue {
+		if conn == nil {
+			var err error
+			if conn, err = s.dial(); err != nil {
+				continue
+			}
+			log.Printf("[INFO] Reconnected to syslog %s", s.describe())
+			connected = true
+		}
+		if _, err := conn.Write(s.frame(message)); err != nil {
+			conn.Close()
+			conn = nil
+			if connected {
+				log.Printf("[WARN] Lost connection to syslog %s, dropping messages until it is back: %v", s.describe(), err)
+				connected = false
+			}
+		}
+	}
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+func (s *Syslog) describe() string {
+	if s.network == "" {
+		return "local"
+	}
+	return s.network + "://" + s.address
+}
+
+// frame adds octet counting (RFC 6587) on TCP, datagrams need no framing
+func (s *Syslog) frame(message []byte) []byte {
+	if s.network == "tcp" {
+		return append([]byte(fmt.Sprintf("%d ", len(message))), message...)
+	}
+	return message
+}
+
+// format builds an RFC 5424 message, or the traditional format understood by
+// every local syslog daemon
+func (s *Syslog) format(severity int, msgID string, message []byte) []byte {
+	priority := s.facility*8 + severity
+	now := time.Now()
+	if s.network == "" {
+		return fmt.Appendf(nil, "<%d>%s %s[%d]: %s", priority, now.Format(time.Stamp), s.tag, os.Getpid(), message)
+	}
+	return fmt.Appendf(nil, "<%d>1 %s %s %s %d %s - %s",
+		priority, now.Format(time.RFC3339Nano), s.hostname, s.tag, os.Getpid(), msgID, message)
+}
+
+func (s *Syslog) send(severity int, msgID string, message []byte) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.closed {
+		return
+	}
+	select {
+	case s.queue <- s.format(severity, msgID, message):
+	default:
+	}
+}
+
+// WriteLog sends an access log entry as JSON without bodies, failed requests
+// with warning severity
+func (s *Syslog) WriteLog(msg *types.LogMessage) error {
+	data, err := json.Marshal(msg.WithoutBodies())
+	if err != nil {
+		return fmt.Errorf("failed to marshal syslog entry: %w", err)
+	}
+	severity := severityInfo
+	if msg.StatusCode >= 500 || msg.Error != "" {
+		severity = severityWarning
+	}
+	s.send(severity, "access", data)
+	return nil
+}
+
+// WriteLine sends a [WARN] or [ERROR] line of the process log, other lines are ignored
+func (s *Syslog) WriteLine(line []byte) {
+	line = bytes.TrimRight(line, "\n")
+	if i := bytes.IndexByte(line, '['); i > 0 {
+		// Drop the date of the standard logger, the syslog header has one
+		line = line[i:]
+	}
+	text := string(line)
+	switch {
+	case strings.Contains(text, "[ERROR]"):
+		s.send(severityError, "log", line)
+	case strings.Contains(text, "[WARN]"):
+		s.send(severityWarning, "log", line)
+	}
+}
+
+// Close sends the queued messages and disconnects
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return nil
+	}
+	s.closed = true
+	close(s.queue)
+	s.mu.Unlock()
+
+	select {
+	case <-s.done:
+	case <-time.After(5 * time.Second):
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// alertCheckInterval 检查告警条件的间隔
+const alertCheckInterval = 10 * time.Second
+
+// AlertConfig app.yaml 中的 alerts, 超过阈值时弹出通知并切换托盘图标
+type AlertConfig struct {
+	Enabled             bool    `yaml:"enabled"`              // 默认开启
+	ErrorRate           float64 `yaml:"error_rate"`           // 最近 window 分钟的错误率 (0-1) 达到时告警, 默认 0.5, 负数关闭
+	MinRequests         int64   `yaml:"min_requests"`         // 请求数少于此值时不计算错误率, 默认 10
+	Window              int     `yaml:"window"`               // 错误率统计的分钟数, 默认 5
+	ConsecutiveFailures int     `yaml:"consecutive_failures"` // 上游地址连续失败的请求数达到时告警, 默认 5, 负数关闭
+}
+
+// alert 一个正在发生的告警
+type alert struct {
+	title  string
+	detail string
+}
+
+// alertActive 当前有超过阈值的告警, 托盘图标显示为告警状态
+var alertActive atomic.Bool
+
+// watchAlerts 定期检查错误率、上游连续失败和目标的所有地址都不可用, 条件出现时
+// 通知一次, 全部消失后通知已恢复. 每次检查后刷新托盘图标, 部分上游不可用等
+// 变化也随之显示
+func watchAlerts() {
+	active := map[string]bool{}
+	for range time.Tick(alertCheckInterval) {
+		if !ccproxy.Running || ccproxy.server == nil {
+			active = map[string]bool{}
+			alertActive.Store(false)
+			continue
+		}
+
+		var current map[string]alert
+		if appConfig.Alerts.Enabled {
+			current = collectAlerts()
+		}
+		for key, a := range current {
+			if !active[key] {
+				showNotification(a.title, a.detail)
+			}
+		}
+		if len(current) == 0 && len(active) > 0 {
+			showNotification(tr("alert.resolved"), tr("alert.resolved.detail"))
+		}
+		alertActive.Store(len(current) > 0)
+		refreshTrayIcon()
+
+		active = map[string]bool{}
+		for key := range current {
+			active[key] = true
+		}
+	}
+}
+
+// collectAlerts 返回当前超过阈值的条件, 键用于判断是否已经通知过
+func collectAlerts() map[string]alert {
+	srv := ccproxy.server
+	if srv == nil {
+		return nil
+	}
+	settings := appConfig.Alerts
+	alerts := map[string]alert{}
+
+	if settings.ErrorRate >= 0 {
+		window := time.Duration(max(settings.Window, 1)) * time.Minute
+		var requests, errors int64
+		for _, point := range srv.TimeSeries(window, time.Minute).Points {
+			requests += point.Requests
+			errors += point.Errors
+		}
+		if requests > 0 && requests >= settings.MinRequests {
+			if rate := float64(errors) / float64(requests); rate >= settings.ErrorRate {
+				alerts["error_rate"] = alert{
+					title:  tr("alert.error_rate"),
+					detail: tr("alert.error_rate.detail", rate*100, errors, requests, max(settings.Window, 1)),
+				}
+			}
+		}
+	}
+
+	status := srv.Status()
+	upstreams := status.Upstreams
+	if settings.ConsecutiveFailures >= 0 {
+		threshold := max(settings.ConsecutiveFailures, 1)
+		for url, health := range upstreams {
+			if health.RequestErrors >= threshold {
+				alerts["failures:"+url] = alert{
+					title:  tr("alert.failures"),
+					detail: tr("alert.failures.detail", url, health.RequestErrors),
+				}
+			}
+		}
+	}
+
+	for _, target := range status.Config.Targets {
+		if len(target.URLs) == 0 {
+			continue
+		}
+		down := true
+		for _, url := range target.URLs {
+			if health := upstreams[url]; health == nil || health.Available() {
+				down = false
+				break
+			}
+		}
+		if down {
+			urls := append([]string(nil), target.URLs...)
+			sort.Strings(urls)
+			alerts["target:"+target.Route] = alert{
+				title:  tr("alert.target_down"),
+				detail: fmt.Sprintf("%s: %s", target.Route, strings.Join(urls, ", ")),
+			}
+		}
+	}
+	return alerts
+}
@@ -26,6 +26,12 @@ export CPPFLAGS="-I/usr/local/opt/openssl@3/include"
 # Go build command
 readonly GOBUILD="go build"
 
+# Version information embedded into the binary
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}
+COMMIT=${COMMIT:-$(git rev-parse --short HEAD 2>/dev/null || echo "")}
+BUILD_DATE=${BUILD_DATE:-$(date -u +%Y-%m-%dT%H:%M:%SZ)}
+readonly VERSION_LDFLAGS="-X ccproxy/version.Version=${VERSION} -X ccproxy/version.Commit=${COMMIT} -X ccproxy/version.BuildDate=${BUILD_DATE}"
+
 # Logging functions
 log_info() {
     echo -e "${BLUE}[INFO]${NC} $1"
@@ -171,7 +177,7 @@ function buildMac() {
         build_env="$build_env GOAMD64=$amd64_variant"
     fi
     
-    if ! env $build_env $GOBUILD -o "$OUTPUT_DIR/CCProxy.app/Contents/MacOS/bin" .; then
+    if ! env $build_env $GOBUILD -ldflags "$VERSION_LDFLAGS" -o "$OUTPUT_DIR/CCProxy.app/Contents/MacOS/bin" .; then
         log_error "Failed to build macOS binary for $arch"
         return 1
     fi
@@ -236,7 +242,7 @@ function buildWin() {
     
     # Build binary
     log_verbose "Compiling Go binary for windows/$arch..."
-    if ! env $build_env $GOBUILD -ldflags "-H=windowsgui" -o "$OUTPUT_DIR/CCProxy.exe" ./; then
+    if ! env $build_env $GOBUILD -ldflags "-H=windowsgui $VERSION_LDFLAGS" -o "$OUTPUT_DIR/CCProxy.exe" ./; then
         log_error "Failed to build Windows binary for $arch"
         rm -f bin.exe.syso
         return 1
@@ -290,7 +296,7 @@ function buildLinux() {
     
     # Build binary
     log_verbose "Compiling Go binary for linux/$arch..."
-    if ! env $build_env $GOBUILD -o "$OUTPUT_DIR/CCProxy-linux-${name}" .; then
+    if ! env $build_env $GOBUILD -ldflags "$VERSION_LDFLAGS" -o "$OUTPUT_DIR/CCProxy-linux-${name}" .; then
         log_error "Failed to build Linux binary for $arch"
         return 1
     fi
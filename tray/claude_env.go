@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"ccproxy/config"
+
+	"github.com/getlantern/systray"
+)
+
+// claudeEnvMarker 标记写入 shell 配置文件的行, 再次写入时替换而不是追加
+const claudeEnvMarker = "# added by ccproxy"
+
+// claudeEnv 返回 Claude Code 使用的环境变量, 与 ccproxy env 相同. 只有所有目标都
+// 自带凭据时才包含 ANTHROPIC_AUTH_TOKEN, 否则保留用户自己的 API key
+func claudeEnv(cfg *config.Config) []config.EnvVar {
+	vars := config.ClientEnv(cfg, "", "")
+	if vars[1].Value != "ccproxy" {
+		vars = vars[:1]
+	}
+	return vars
+}
+
+// addClaudeEnvMenu 复制 ANTHROPIC_BASE_URL, 或把环境变量写入 Claude Code 设置和
+// shell 配置文件, 不用再按配置文件手动拼写地址
+func addClaudeEnvMenu() {
+	parent := systray.AddMenuItem(tr("menu.claude_env"), tr("menu.claude_env"))
+
+	withEnv := func(item *systray.MenuItem, apply func(vars []config.EnvVar) (string, error)) {
+		go func() {
+			for range item.ClickedCh {
+				cfg, err := loadProxyConfig()
+				if err != nil {
+					showNotification(tr("config.load_failed"), err.Error())
+					continue
+				}
+				detail, err := apply(claudeEnv(cfg))
+				if err != nil {
+					showNotification(tr("claude_env.failed"), err.Error())
+					continue
+				}
+				showNotification(tr("claude_env.done"), detail)
+			}
+		}()
+	}
+
+	withEnv(parent.AddSubMenuItem(tr("menu.copy_base_url"), ""), func(vars []config.EnvVar) (string, error) {
+		return vars[0].Value, copyToClipboard(vars[0].Value)
+	})
+	withEnv(parent.AddSubMenuItem(tr("menu.claude_settings"), ""), writeClaudeSettings)
+	withEnv(parent.AddSubMenuItem(tr("menu.shell_profile"), ""), writeShellProfile)
+}
+
+// copyToClipboard 调用系统的剪贴板命令
+func copyToClipboard(text string) error {
+	var candidates [][]string
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = [][]string{{"pbcopy"}}
+	case "windows":
+		candidates = [][]string{{"clip"}}
+	default:
+		candidates = [][]string{
+			{"wl-copy"},
+			{"xclip", "-selection", "clipboard"},
+			{"xsel", "--clipboard", "--input"},
+		}
+	}
+
+	for _, args := range candidates {
+		if _, err := exec.LookPath(args[0]); err != nil {
+			continue
+		}
+		cmd := exec.Command(args[0], args[1:]...)
+		cmd.Stdin = strings.NewReader(text)
+		return cmd.Run()
+	}
+	return fmt.Errorf("no clipboard command found (%s)", candidates[0][0])
+}
+
+// writeClaudeSettings 在 ~/.claude/settings.json 的 env 中设置环境变量, 保留其他设置
+func writeClaudeSettings(vars []config.EnvVar) (string, error) {
+	path := filepath.Join(home, ".claude", "settings.json")
+	settings := map[string]interface{}{}
+	if data, err := os.ReadFile(path); err == nil {
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return "", fmt.Errorf("%s: %v", path, err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	env, _ := settings["env"].(map[string]interface{})
+	if env == nil {
+		env = map[string]interface{}{}
+	}
+	for _, v := range vars {
+		env[v.Name] = v.Value
+	}
+	settings["env"] = env
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// writeShellProfile 把环境变量写入当前 shell 的配置文件, 对新打开的终端生效.
+// Windows 上写入用户环境变量
+func writeShellProfile(vars []config.EnvVar) (string, error) {
+	if runtime.GOOS == "windows" {
+		for _, v := range vars {
+			if err := exec.Command("setx", v.Name, v.Value).Run(); err != nil {
+				return "", err
+			}
+		}
+		return vars[0].Name + "=" + vars[0].Value, nil
+	}
+
+	// 从 Finder 启动时可能没有 SHELL, macOS 默认使用 zsh
+	shell := filepath.Base(os.Getenv("SHELL"))
+	if os.Getenv("SHELL") == "" && runtime.GOOS == "darwin" {
+		shell = "zsh"
+	}
+	format := "shell"
+	var path string
+	switch shell {
+	case "fish":
+		path = filepath.Join(home, ".config", "fish", "config.fish")
+		format = "fish"
+	case "bash":
+		path = filepath.Join(home, ".bashrc")
+		if runtime.GOOS == "darwin" {
+			path = filepath.Join(home, ".bash_profile")
+		}
+	case "zsh":
+		path = filepath.Join(home, ".zshrc")
+	default:
+		path = filepath.Join(home, ".profile")
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	var lines []string
+	if len(data) > 0 {
+		lines = strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	}
+	for _, v := range vars {
+		out, err := config.FormatClientEnv([]config.EnvVar{v}, format)
+		if err != nil {
+			return "", err
+		}
+		line := strings.TrimSuffix(out, "\n") + " " + claudeEnvMarker
+
+		replaced := false
+		for i, existing := range lines {
+			if strings.HasSuffix(existing, claudeEnvMarker) && strings.Contains(existing, " "+v.Name) {
+				lines[i], replaced = line, true
+			}
+		}
+		if !replaced {
+			lines = append(lines, line)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
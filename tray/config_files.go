@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/getlantern/systray"
+)
+
+// configFilePath 返回 app.yaml 中 config_file 对应的路径, 相对路径位于 ~/.ccproxy
+func configFilePath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(confDir, name)
+}
+
+// configFiles 列出 ~/.ccproxy 中的配置文件, app.yaml 是托盘自身的设置, 不包括在内
+func configFiles() []string {
+	entries, err := os.ReadDir(confDir)
+	if err != nil {
+		return nil
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || name == "app.yaml" {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(name)) {
+		case ".yaml", ".yml", ".json", ".toml":
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// addConfigFileMenu 列出 ~/.ccproxy 中的配置文件, 例如官方 API 和中转各用一个,
+// 点击后由 switchTo 切换. 只有一个配置文件时不显示
+func addConfigFileMenu(switchTo func(name string) bool) {
+	names := configFiles()
+	if len(names) < 2 {
+		return
+	}
+
+	parent := systray.AddMenuItem(tr("menu.config_files"), tr("menu.config_files"))
+	items := map[string]*systray.MenuItem{}
+	for _, name := range names {
+		name := name
+		item := parent.AddSubMenuItemCheckbox(name, name, configFilePath(name) == confFile)
+		items[name] = item
+		go func() {
+			for range item.ClickedCh {
+				if configFilePath(name) == confFile || !switchTo(name) {
+					continue
+				}
+				for other, otherItem := range items {
+					if other == name {
+						otherItem.Check()
+					} else {
+						otherItem.Uncheck()
+					}
+				}
+			}
+		}()
+	}
+}
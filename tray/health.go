@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"ccproxy/proxy"
+	"ccproxy/web"
+
+	"github.com/getlantern/systray"
+)
+
+// 上游状态子菜单, 目标或地址变化时重建
+var (
+	healthMu      sync.Mutex
+	healthMenu    *systray.MenuItem
+	healthTargets []*targetHealthItem
+	healthLayout  string // 当前菜单对应的目标和地址, 用于判断是否需要重建
+)
+
+// targetHealthItem 一个目标及其上游地址的菜单项
+type targetHealthItem struct {
+	route    string
+	urls     []string
+	item     *systray.MenuItem
+	urlItems []*systray.MenuItem
+}
+
+// addHealthMenu 按目标列出上游地址的健康状态 (● 可用 / ○ 不可用) 和延迟, 并标出
+// 代理当前优先使用的地址及原因. 代理运行时定时刷新, 停止时隐藏
+func addHealthMenu() {
+	healthMenu = systray.AddMenuItem(tr("menu.health"), tr("menu.health"))
+	healthMenu.Hide()
+
+	updateHealthMenu()
+	go func() {
+		for range time.Tick(statsRefresh) {
+			updateHealthMenu()
+		}
+	}()
+}
+
+// updateHealthMenu 按运行中代理的健康检查结果刷新菜单
+func updateHealthMenu() {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+
+	srv := ccproxy.server
+	if !ccproxy.Running || srv == nil {
+		healthMenu.Hide()
+		return
+	}
+	status := srv.Status()
+	rebuildHealthMenu(status.Config.Targets)
+	if len(healthTargets) == 0 {
+		healthMenu.Hide()
+		return
+	}
+	healthMenu.Show()
+
+	for _, target := range healthTargets {
+		preferred, reason := preferredURL(target.urls, status.Upstreams)
+		symbol := "○"
+		if health := status.Upstreams[preferred]; health == nil || health.Available() {
+			symbol = "●"
+		}
+		target.item.SetTitle(fmt.Sprintf("%s %s → %s", symbol, target.route, preferred))
+		target.item.SetTooltip(reason)
+
+		for i, url := range target.urls {
+			title := healthTitle(url, status.Upstreams[url])
+			if url == preferred {
+				title += "  ← " + reason
+			}
+			target.urlItems[i].SetTitle(title)
+		}
+	}
+}
+
+// rebuildHealthMenu 目标或地址变化 (切换配置、profile 或重新加载) 时重建子菜单,
+// systray 不能删除菜单项, 旧的菜单项被隐藏
+func rebuildHealthMenu(targets []web.TargetSummary) {
+	var layout strings.Builder
+	for _, target := range targets {
+		fmt.Fprintf(&layout, "%s=%s;", target.Route, strings.Join(target.URLs, ","))
+	}
+	if layout.String() == healthLayout {
+		return
+	}
+	healthLayout = layout.String()
+
+	for _, target := range healthTargets {
+		target.item.Hide()
+	}
+	healthTargets = nil
+	for _, target := range targets {
+		if len(target.URLs) == 0 {
+			continue
+		}
+		item := &targetHealthItem{
+			route: target.Route,
+			urls:  target.URLs,
+			item:  healthMenu.AddSubMenuItem(target.Route, ""),
+		}
+		for _, url := range target.URLs {
+			urlItem := item.item.AddSubMenuItem(url, url)
+			urlItem.Disable()
+			item.urlItems = append(item.urlItems, urlItem)
+		}
+		healthTargets = append(healthTargets, item)
+	}
+}
+
+// healthTitle 上游地址的状态: 是否可用、健康检查的平均延迟和不可用的原因
+func healthTitle(url string, health *proxy.URLHealth) string {
+	if health == nil {
+		return fmt.Sprintf("● %s  %s", url, tr("health.pending"))
+	}
+
+	symbol := "●"
+	if !health.Available() {
+		symbol = "○"
+	}
+	parts := []string{symbol + " " + url}
+	if latency := healthLatency(health); latency > 0 {
+		parts = append(parts, latency.Round(time.Millisecond).String())
+	}
+	switch {
+	case health.Override == proxy.OverrideDown:
+		parts = append(parts, tr("health.manual_down"))
+	case health.Override == proxy.OverrideUp:
+		parts = append(parts, tr("health.manual_up"))
+	case time.Now().Before(health.EjectedUntil):
+		parts = append(parts, tr("health.ejected"))
+	case !health.IsHealthy:
+		parts = append(parts, tr("health.unhealthy"))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// healthLatency 选择地址时使用的延迟, 与 GetFastestHealthyURL 一致
+func healthLatency(health *proxy.URLHealth) time.Duration {
+	if health.AverageTime > 0 {
+		return health.AverageTime
+	}
+	return health.ResponseTime
+}
+
+// preferredURL 按 GetFastestHealthyURL 的规则返回代理优先使用的地址和原因.
+// 刚恢复的地址在 slow_start 期间只分到部分请求, 这里不考虑
+func preferredURL(urls []string, upstreams map[string]*proxy.URLHealth) (string, string) {
+	if len(urls) == 1 {
+		return urls[0], tr("health.only")
+	}
+
+	var fastest string
+	var fastestTime time.Duration
+	available := 0
+	for _, url := range urls {
+		health := upstreams[url]
+		if health == nil {
+			return url, tr("health.pending")
+		}
+		if !health.Available() {
+			continue
+		}
+		available++
+		if latency := healthLatency(health); fastest == "" || latency < fastestTime {
+			fastest, fastestTime = url, latency
+		}
+	}
+
+	switch available {
+	case 0:
+		for _, url := range urls {
+			if upstreams[url].Override != proxy.OverrideDown {
+				return url, tr("health.fallback")
+			}
+		}
+		return urls[0], tr("health.fallback")
+	case 1:
+		return fastest, tr("health.only_available")
+	}
+	return fastest, tr("health.fastest")
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// 托盘菜单、通知和错误信息的文本. app.yaml 的 language 可以设为 zh 或 en,
+// 默认 auto 按系统语言选择
+var messages = map[string]map[string]string{
+	"zh": {
+		"tooltip":                   "CC Proxy - HTTP代理服务器",
+		"state.running":             "运行中",
+		"state.degraded":            "部分上游不可用",
+		"state.alert":               "超过告警阈值",
+		"state.stopped":             "已停止",
+		"menu.start":                "启动代理",
+		"menu.stop":                 "停止代理",
+		"menu.restart":              "重启代理",
+		"menu.dashboard":            "打开监控界面",
+		"menu.logs":                 "查看日志",
+		"menu.claude_env":           "Claude Code 环境变量",
+		"menu.copy_base_url":        "复制 ANTHROPIC_BASE_URL",
+		"menu.claude_settings":      "写入 Claude Code 设置",
+		"menu.shell_profile":        "写入 Shell 配置文件",
+		"menu.rotate_token":         "重置访问令牌",
+		"menu.profiles":             "切换配置",
+		"menu.config_files":         "配置文件",
+		"menu.maintenance":          "维护模式",
+		"menu.upstreams":            "上游地址",
+		"menu.health":               "上游状态",
+		"menu.pause_capture":        "暂停记录请求",
+		"menu.autostart":            "开机自启动",
+		"menu.start_proxy":          "启动时启动代理",
+		"menu.auto_reload":          "配置修改后自动生效",
+		"menu.update":               "更新到 %s",
+		"menu.about":                "关于 CC Proxy",
+		"menu.quit":                 "退出",
+		"exit":                      "CC Proxy 托盘应用退出",
+		"start.failed":              "CC Proxy 启动失败",
+		"started":                   "CC Proxy 已启动",
+		"started.detail":            "代理服务器运行在 %s",
+		"stopped":                   "CC Proxy 已停止",
+		"stopped.detail":            "代理服务器已停止运行",
+		"config.load_failed":        "配置加载失败",
+		"config.reloaded":           "配置已更新",
+		"config.reloaded.detail":    "新的代理配置已生效",
+		"config.invalid":            "配置无效, 继续使用之前的配置",
+		"config.changed":            "配置文件已修改",
+		"config.changed.detail":     "自动生效已关闭, 重启代理后生效",
+		"config.rolled_back":        "已恢复之前的配置",
+		"config.rolled_back.detail": "修改后的配置无法启动, 代理使用之前的配置运行",
+		"dashboard.open_failed":     "打开失败",
+		"dashboard.open_detail":     "无法打开监控界面",
+		"logs.title":                "CC Proxy 日志",
+		"logs.open_failed":          "无法打开日志",
+		"claude_env.done":           "已设置 ANTHROPIC_BASE_URL",
+		"claude_env.failed":         "设置 ANTHROPIC_BASE_URL 失败",
+		"token.rotate_failed":       "重置访问令牌失败",
+		"token.rotated":             "访问令牌已重置",
+		"token.rotated.detail":      "旧的监控界面链接已失效",
+		"token.basic_auth":          "监控界面使用 basic 认证, 请在配置文件中修改密码",
+		"upstream.recovered":        "上游已恢复",
+		"upstream.down":             "上游不可用",
+		"upstream.enabled":          "已启用上游",
+		"upstream.disabled":         "已停用上游",
+		"upstream.toggle_failed":    "切换上游失败",
+		"profile.switch_failed":     "切换配置失败",
+		"profile.switched":          "已切换配置",
+		"config_file.switched":      "已切换配置文件",
+		"maintenance.failed":        "设置维护模式失败",
+		"maintenance.on":            "已进入维护模式",
+		"maintenance.off":           "已退出维护模式",
+		"proxy.not_running":         "代理未运行",
+		"error.load_config":         "加载配置失败: %v",
+		"error.create_server":       "创建代理服务失败: %v",
+		"error.listen":              "代理服务器启动失败: %v",
+		"stats.requests":            "今日请求: %d",
+		"stats.errors":              "错误: %d",
+		"stats.upstream":            "当前上游: %s",
+		"stats.latency":             "平均延迟: %s",
+		"update.available":          "发现新版本",
+		"update.available.detail":   "CC Proxy %s 已发布, 当前版本 %s",
+		"update.downloading":        "正在下载新版本",
+		"update.installed":          "更新完成",
+		"update.installed.detail":   "正在重新启动 CC Proxy %s",
+		"update.failed":             "更新失败",
+		"alert.error_rate":          "错误率过高",
+		"alert.error_rate.detail":   "%.0f%% 的请求失败 (%d/%d, 最近 %d 分钟)",
+		"alert.failures":            "上游连续失败",
+		"alert.failures.detail":     "%s 连续 %d 次请求失败",
+		"alert.target_down":         "目标的所有上游都不可用",
+		"alert.resolved":            "告警已恢复",
+		"alert.resolved.detail":     "错误率和上游状态已恢复正常",
+		"port.proxy":                "代理",
+		"port.web":                  "监控界面",
+		"port.in_use":               "%s端口 %s 已被占用",
+		"port.none_free":            "%s端口 %s 已被占用, 之后也没有空闲的端口",
+		"port.changed":              "端口已被占用",
+		"port.changed.detail":       "%s端口 %s 已被占用, 改用 %s",
+		"health.pending":            "等待健康检查",
+		"health.manual_down":        "已手动下线",
+		"health.manual_up":          "已手动上线",
+		"health.ejected":            "错误率过高, 暂时剔除",
+		"health.unhealthy":          "健康检查失败",
+		"health.only":               "唯一的地址",
+		"health.only_available":     "唯一可用的地址",
+		"health.fastest":            "延迟最低",
+		"health.fallback":           "都不可用, 使用第一个",
+		"capture.paused":            "已暂停记录请求",
+		"capture.paused.detail":     "代理照常转发, 请求内容不会被保存或显示",
+		"capture.resumed":           "已恢复记录请求",
+		"capture.resumed.detail":    "新的请求会再次记录到历史中",
+	},
+	"en": {
+		"tooltip":                   "CC Proxy - HTTP proxy server",
+		"state.running":             "Running",
+		"state.degraded":            "Some upstreams down",
+		"state.alert":               "Alert threshold exceeded",
+		"state.stopped":             "Stopped",
+		"menu.start":                "Start Proxy",
+		"menu.stop":                 "Stop Proxy",
+		"menu.restart":              "Restart Proxy",
+		"menu.dashboard":            "Open Dashboard",
+		"menu.logs":                 "View Logs",
+		"menu.claude_env":           "Claude Code Environment",
+		"menu.copy_base_url":        "Copy ANTHROPIC_BASE_URL",
+		"menu.claude_settings":      "Write to Claude Code Settings",
+		"menu.shell_profile":        "Write to Shell Profile",
+		"menu.rotate_token":         "Reset Access Token",
+		"menu.profiles":             "Switch Profile",
+		"menu.config_files":         "Config File",
+		"menu.maintenance":          "Maintenance Mode",
+		"menu.upstreams":            "Upstreams",
+		"menu.health":               "Upstream Health",
+		"menu.pause_capture":        "Pause Request Logging",
+		"menu.autostart":            "Launch at Login",
+		"menu.start_proxy":          "Start Proxy on Launch",
+		"menu.auto_reload":          "Apply Config Changes Automatically",
+		"menu.update":               "Update to %s",
+		"menu.about":                "About CC Proxy",
+		"menu.quit":                 "Quit",
+		"exit":                      "CC Proxy tray app exited",
+		"start.failed":              "CC Proxy failed to start",
+		"started":                   "CC Proxy started",
+		"started.detail":            "Proxy server running at %s",
+		"stopped":                   "CC Proxy stopped",
+		"stopped.detail":            "The proxy server has stopped",
+		"config.load_failed":        "Failed to load configuration",
+		"config.reloaded":           "Configuration updated",
+		"config.reloaded.detail":    "The new proxy configuration is in effect",
+		"config.invalid":            "Invalid configuration, keeping the previous one",
+		"config.changed":            "Configuration file changed",
+		"config.changed.detail":     "Automatic apply is off, restart the proxy to use it",
+		"config.rolled_back":        "Previous configuration restored",
+		"config.rolled_back.detail": "The changed configuration failed to start, the proxy runs with the previous one",
+		"dashboard.open_failed":     "Failed to open",
+		"dashboard.open_detail":     "Could not open the dashboard",
+		"logs.title":                "CC Proxy Logs",
+		"logs.open_failed":          "Failed to open logs",
+		"claude_env.done":           "ANTHROPIC_BASE_URL set",
+		"claude_env.failed":         "Failed to set ANTHROPIC_BASE_URL",
+		"token.rotate_failed":       "Failed to reset access token",
+		"token.rotated":             "Access token reset",
+		"token.rotated.detail":      "Old dashboard links no longer work",
+		"token.basic_auth":          "The dashboard uses basic auth, change the password in the config file",
+		"upstream.recovered":        "Upstream recovered",
+		"upstream.down":             "Upstream unavailable",
+		"upstream.enabled":          "Upstream enabled",
+		"upstream.disabled":         "Upstream disabled",
+		"upstream.toggle_failed":    "Failed to toggle upstream",
+		"profile.switch_failed":     "Failed to switch profile",
+		"profile.switched":          "Switched profile",
+		"config_file.switched":      "Switched config file",
+		"maintenance.failed":        "Failed to set maintenance mode",
+		"maintenance.on":            "Maintenance mode on",
+		"maintenance.off":           "Maintenance mode off",
+		"proxy.not_running":         "The proxy is not running",
+		"error.load_config":         "failed to load configuration: %v",
+		"error.create_server":       "failed to create proxy server: %v",
+		"error.listen":              "proxy server failed to start: %v",
+		"stats.requests":            "Requests today: %d",
+		"stats.errors":              "Errors: %d",
+		"stats.upstream":            "Current upstream: %s",
+		"stats.latency":             "Average latency: %s",
+		"update.available":          "Update available",
+		"update.available.detail":   "CC Proxy %s is available, you have %s",
+		"update.downloading":        "Downloading update",
+		"update.installed":          "Update installed",
+		"update.installed.detail":   "Restarting CC Proxy %s",
+		"update.failed":             "Update failed",
+		"alert.error_rate":          "High error rate",
+		"alert.error_rate.detail":   "%.0f%% of requests failed (%d/%d, last %d minutes)",
+		"alert.failures":            "Upstream failing",
+		"alert.failures.detail":     "%s failed %d requests in a row",
+		"alert.target_down":         "All upstreams of a target are down",
+		"alert.resolved":            "Alerts resolved",
+		"alert.resolved.detail":     "Error rate and upstreams are back to normal",
+		"port.proxy":                "Proxy",
+		"port.web":                  "Dashboard",
+		"port.in_use":               "%s port %s is already in use",
+		"port.none_free":            "%s port %s is already in use and no later port is free",
+		"port.changed":              "Port in use",
+		"port.changed.detail":       "%s port %s is already in use, using %s",
+		"health.pending":            "waiting for health check",
+		"health.manual_down":        "manually down",
+		"health.manual_up":          "manually up",
+		"health.ejected":            "ejected for errors",
+		"health.unhealthy":          "health check failed",
+		"health.only":               "only URL",
+		"health.only_available":     "only available URL",
+		"health.fastest":            "lowest latency",
+		"health.fallback":           "none available, using the first",
+		"capture.paused":            "Request logging paused",
+		"capture.paused.detail":     "Requests are still proxied but their contents are not kept or shown",
+		"capture.resumed":           "Request logging resumed",
+		"capture.resumed.detail":    "New requests are recorded in the history again",
+	},
+}
+
+// locale 当前使用的语言, 由 setLocale 在启动时设置
+var locale = "zh"
+
+// setLocale 按 app.yaml 的 language 选择语言, auto 或为空时检测系统语言
+func setLocale(language string) {
+	if language == "" || language == "auto" {
+		language = detectLocale()
+	}
+	language = strings.ToLower(language)
+	switch {
+	case strings.HasPrefix(language, "zh"):
+		locale = "zh"
+	case language == "":
+		// 检测不到时保持原来的中文界面
+		locale = "zh"
+	default:
+		locale = "en"
+	}
+}
+
+// detectLocale 依次读取 LC_ALL, LC_MESSAGES, LANG 和系统设置
+func detectLocale() string {
+	for _, name := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+		if value := os.Getenv(name); value != "" && value != "C" && value != "POSIX" {
+			return value
+		}
+	}
+	return systemLocale()
+}
+
+// tr 返回当前语言的文本, args 不为空时按格式化字符串处理
+func tr(key string, args ...interface{}) string {
+	text, ok := messages[locale][key]
+	if !ok {
+		text = messages["en"][key]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"runtime"
+	"sync"
+
+	"github.com/getlantern/systray"
+)
+
+// trayState 托盘图标表示的代理状态
+type trayState int
+
+const (
+	stateStopped  trayState = iota
+	stateRunning            // 所有上游地址都可用
+	stateDegraded           // 部分上游地址不可用, 但每个目标都还有可用的地址
+	stateAlert              // 超过告警阈值, 见 watchAlerts
+)
+
+var (
+	trayIconsOnce sync.Once
+	trayIcons     map[trayState][]byte
+
+	trayStateMu sync.Mutex
+	trayCurrent = trayState(-1)
+)
+
+// loadTrayIcons 准备各状态的图标. macOS 上作为模板图标只使用透明度, 随菜单栏的
+// 深色或浅色外观变色, 所以各状态用形状区分: 停止时是叉号, 部分上游不可用时右下角
+// 是圆环, 告警时是实心圆点. 其他系统上圆环和圆点显示为红色
+func loadTrayIcons() {
+	running, stopped := icon, iconOff
+	if runtime.GOOS == "windows" {
+		running, stopped = iconWin, iconOffWin
+	}
+	trayIcons = map[trayState][]byte{
+		stateStopped:  stopped,
+		stateRunning:  running,
+		stateDegraded: badgeIcon(false),
+		stateAlert:    badgeIcon(true),
+	}
+}
+
+// refreshTrayIcon 按代理的运行状态、告警和上游健康状态更新图标和提示文字
+func refreshTrayIcon() {
+	trayIconsOnce.Do(loadTrayIcons)
+
+	state := stateStopped
+	if srv := ccproxy.server; ccproxy.Running && srv != nil {
+		state = stateRunning
+		if alertActive.Load() {
+			state = stateAlert
+		} else {
+			for _, health := range srv.Upstreams() {
+				if !health.Available() {
+					state = stateDegraded
+					break
+				}
+			}
+		}
+	}
+
+	trayStateMu.Lock()
+	defer trayStateMu.Unlock()
+	if state == trayCurrent {
+		return
+	}
+	trayCurrent = state
+
+	data := trayIcons[state]
+	systray.SetTemplateIcon(data, data)
+	switch state {
+	case stateStopped:
+		systray.SetTooltip(tr("tooltip") + " - " + tr("state.stopped"))
+	case stateRunning:
+		systray.SetTooltip(tr("tooltip") + " - " + tr("state.running"))
+	case stateDegraded:
+		systray.SetTooltip(tr("tooltip") + " - " + tr("state.degraded"))
+	case stateAlert:
+		systray.SetTooltip(tr("tooltip") + " - " + tr("state.alert"))
+	}
+}
+
+// badgeIcon 在正常图标的右下角加上实心圆点或圆环. Windows 需要 ICO 格式,
+// 用 PNG 数据封装
+func badgeIcon(filled bool) []byte {
+	src, err := png.Decode(bytes.NewReader(icon))
+	if err != nil {
+		return icon
+	}
+
+	// Windows 的托盘图标缩小到 32x32, 其他系统保持原来的大小
+	b := src.Bounds()
+	width, height, scale := b.Dx(), b.Dy(), 1.0
+	if runtime.GOOS == "windows" {
+		width, height = 32, 32
+		scale = float64(max(b.Dx(), b.Dy())) / 32
+	}
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	offsetX := (float64(width) - float64(b.Dx())/scale) / 2
+	offsetY := (float64(height) - float64(b.Dy())/scale) / 2
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			sx := int((float64(x)-offsetX)*scale) + b.Min.X
+			sy := int((float64(y)-offsetY)*scale) + b.Min.Y
+			if image.Pt(sx, sy).In(b) {
+				img.Set(x, y, src.At(sx, sy))
+			}
+		}
+	}
+
+	// 先清出一圈透明的边, 圆点和图标之间留有间隔, 缩小后也能分辨
+	badge := color.NRGBA{R: 0xe5, G: 0x39, B: 0x35, A: 0xff}
+	radius := float64(min(width, height)) * 0.22
+	gap := max(radius*0.25, 1)
+	ring := max(radius*0.35, 1.5)
+	cx, cy := float64(width)-radius-1, float64(height)-radius-1
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			dx, dy := float64(x)+0.5-cx, float64(y)+0.5-cy
+			distance := dx*dx + dy*dy
+			switch {
+			case distance > (radius+gap)*(radius+gap):
+			case distance > radius*radius:
+				img.Set(x, y, color.NRGBA{})
+			case filled || distance > (radius-ring)*(radius-ring):
+				img.Set(x, y, badge)
+			default:
+				img.Set(x, y, color.NRGBA{})
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return icon
+	}
+	if runtime.GOOS != "windows" {
+		return buf.Bytes()
+	}
+
+	// ICONDIR 和一个 ICONDIRENTRY, 图像数据直接使用 PNG
+	var ico bytes.Buffer
+	binary.Write(&ico, binary.LittleEndian, []uint16{0, 1, 1})
+	ico.Write([]byte{byte(width), byte(height), 0, 0})
+	binary.Write(&ico, binary.LittleEndian, []uint16{1, 32})
+	binary.Write(&ico, binary.LittleEndian, []uint32{uint32(buf.Len()), 22})
+	ico.Write(buf.Bytes())
+	return ico.Bytes()
+}
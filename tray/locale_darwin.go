@@ -0,0 +1,16 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+)
+
+// systemLocale 返回 macOS 的区域设置, 例如 zh_CN. 从 Finder 启动的应用
+// 没有 LANG 环境变量
+func systemLocale() string {
+	out, err := exec.Command("defaults", "read", "-g", "AppleLocale").Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,8 @@
+//go:build !windows && !darwin
+
+package main
+
+// systemLocale 其他系统只通过环境变量设置语言
+func systemLocale() string {
+	return ""
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// systemLocale 返回 Windows 用户的区域设置, 例如 zh-CN
+func systemLocale() string {
+	proc := syscall.NewLazyDLL("kernel32.dll").NewProc("GetUserDefaultLocaleName")
+	buf := make([]uint16, 85) // LOCALE_NAME_MAX_LENGTH
+	if n, _, _ := proc.Call(uintptr(unsafe.Pointer(&buf[0])), uintptr(len(buf))); n == 0 {
+		return ""
+	}
+	return syscall.UTF16ToString(buf)
+}
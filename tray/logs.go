@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	logLines   = 2000     // 日志窗口保留的最近行数
+	logMaxSize = 10 << 20 // 日志文件超过这个大小时, 启动时轮转为 .1
+)
+
+// appLogs 保存托盘进程自身的日志 (不是请求记录), 从 Finder 或开始菜单启动时
+// 没有终端, 启动失败的原因只能在这里看到
+var appLogs = &logBuffer{subscribers: map[chan string]bool{}}
+
+// logBuffer 最近的日志行, 并推送给正在查看的窗口
+type logBuffer struct {
+	mu          sync.Mutex
+	lines       []string
+	partial     []byte // 还没有换行的部分
+	subscribers map[chan string]bool
+}
+
+func (b *logBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.partial = append(b.partial, p...)
+	for {
+		i := bytes.IndexByte(b.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := string(b.partial[:i])
+		b.partial = b.partial[i+1:]
+
+		b.lines = append(b.lines, line)
+		if len(b.lines) > logLines {
+			b.lines = b.lines[len(b.lines)-logLines:]
+		}
+		for ch := range b.subscribers {
+			select {
+			case ch <- line:
+			default: // 窗口跟不上时丢弃, 不阻塞写日志
+			}
+		}
+	}
+	return len(p), nil
+}
+
+// subscribe 返回已有的日志和之后新日志的通道
+func (b *logBuffer) subscribe() ([]string, chan string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	ch := make(chan string, 256)
+	b.subscribers[ch] = true
+	return append([]string(nil), b.lines...), ch
+}
+
+func (b *logBuffer) unsubscribe(ch chan string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.subscribers, ch)
+}
+
+// setupAppLog 把 log 包的输出同时写入 ~/.ccproxy/logs/ccproxy.log 和日志窗口
+func setupAppLog() {
+	writers := []io.Writer{os.Stderr, appLogs}
+
+	logDir := filepath.Join(confDir, "logs")
+	logFile := filepath.Join(logDir, "ccproxy.log")
+	if err := os.MkdirAll(logDir, 0755); err == nil {
+		if info, err := os.Stat(logFile); err == nil && info.Size() > logMaxSize {
+			_ = os.Rename(logFile, logFile+".1")
+		}
+		if file, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err == nil {
+			writers = append(writers, file)
+		}
+	}
+	log.SetOutput(io.MultiWriter(writers...))
+}
+
+var (
+	logViewerOnce sync.Once
+	logViewerURL  string
+	logViewerErr  error
+)
+
+// openLogViewer 在浏览器中打开实时滚动的日志页面. 页面由托盘自己提供, 代理
+// 启动失败时也能打开. 只监听本机的随机端口, 地址中带有随机令牌
+func openLogViewer() (string, error) {
+	logViewerOnce.Do(func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			logViewerErr = err
+			return
+		}
+		buf := make([]byte, 16)
+		if _, err := rand.Read(buf); err != nil {
+			listener.Close()
+			logViewerErr = err
+			return
+		}
+		prefix := "/" + hex.EncodeToString(buf)
+
+		mux := http.NewServeMux()
+		mux.HandleFunc(prefix+"/", serveLogPage)
+		mux.HandleFunc(prefix+"/stream", serveLogStream)
+		go http.Serve(listener, mux)
+		logViewerURL = fmt.Sprintf("http://%s%s/", listener.Addr(), prefix)
+	})
+	return logViewerURL, logViewerErr
+}
+
+var logPage = template.Must(template.New("logs").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { margin: 0; background: #1e1e1e; color: #d4d4d4; font: 12px/1.5 Menlo, Consolas, monospace; }
+pre { margin: 0; padding: 12px; white-space: pre-wrap; word-break: break-all; }
+.error { color: #f48771; }
+.warn { color: #cca700; }
+</style>
+</head>
+<body>
+<pre id="logs"></pre>
+<script>
+const logs = document.getElementById('logs');
+const source = new EventSource('stream');
+source.addEventListener('reset', () => {
+    logs.textContent = '';
+});
+source.onmessage = (event) => {
+    const atBottom = window.innerHeight + window.scrollY >= document.body.scrollHeight - 20;
+    const line = document.createElement('div');
+    line.textContent = event.data;
+    if (event.data.includes('[ERROR]') || event.data.includes('failed')) {
+        line.className = 'error';
+    } else if (event.data.includes('[WARN]')) {
+        line.className = 'warn';
+    }
+    logs.appendChild(line);
+    while (logs.childNodes.length > {{.Lines}}) {
+        logs.removeChild(logs.firstChild);
+    }
+    if (atBottom) {
+        window.scrollTo(0, document.body.scrollHeight);
+    }
+};
+</script>
+</body>
+</html>
+`))
+
+func serveLogPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	logPage.Execute(w, map[string]interface{}{"Title": tr("logs.title"), "Lines": logLines})
+}
+
+// serveLogStream 以 Server-Sent Events 先发送已有的日志, 再推送新的日志
+func serveLogStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	lines, ch := appLogs.subscribe()
+	defer appLogs.unsubscribe(ch)
+
+	// 断线重连后重新发送全部日志, 先让页面清空已显示的内容
+	fmt.Fprint(w, "event: reset\ndata:\n\n")
+	for _, line := range lines {
+		writeLogEvent(w, line)
+	}
+	flusher.Flush()
+	for {
+		select {
+		case line := <-ch:
+			writeLogEvent(w, line)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func writeLogEvent(w io.Writer, line string) {
+	fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(line, "\r", ""))
+}
@@ -2,21 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
 	_ "embed"
+	"encoding/hex"
 	"fmt"
 	"log"
-	"net"
-	"net/http"
 	"os"
 	"path/filepath"
-	"runtime"
 	"strings"
+	"sync"
 	"time"
 
 	"ccproxy/config"
-	"ccproxy/middleware"
-	"ccproxy/proxy"
-	"ccproxy/web"
+	"ccproxy/server"
 	"ccproxy/websocket"
 
 	"github.com/daodao97/xgo/xlog"
@@ -45,24 +44,41 @@ var confDir string
 var confFile string
 
 type CCProxy struct {
-	config      *config.Config
-	proxyServer *http.Server
-	webServer   *http.Server
-	hub         *websocket.Hub
-	ctx         context.Context
-	cancel      context.CancelFunc
-	Running     bool
+	config    *config.Config
+	server    *server.Server
+	Running   bool
+	proxyPort *portChange // 端口被占用时改用的端口, 没有改动时为 nil
+	webPort   *portChange
 }
 
 type AppConfig struct {
-	AutoStart  bool   `yaml:"auto_start"`
-	StartProxy bool   `yaml:"start_proxy"`
-	ConfigFile string `yaml:"config_file"`
+	AutoStart    bool        `yaml:"auto_start"`
+	StartProxy   bool        `yaml:"start_proxy"`
+	ConfigFile   string      `yaml:"config_file"`
+	Profile      string      `yaml:"profile"`       // 托盘中选择的配置 profile, 为空时使用配置文件中的 proxy.profile
+	Language     string      `yaml:"language"`      // 界面语言 zh 或 en, 默认 auto 按系统语言选择
+	AutoReload   bool        `yaml:"auto_reload"`   // 配置文件修改后自动生效, 必要时重启代理, 默认开启
+	CheckUpdates bool        `yaml:"check_updates"` // 每天检查一次 GitHub 上的新版本, 默认开启
+	Alerts       AlertConfig `yaml:"alerts"`        // 错误率和上游故障的告警阈值
+	AutoPort     bool        `yaml:"auto_port"`     // 端口被占用时改用之后第一个空闲的端口, 默认开启
 }
 
 var ccproxy *CCProxy
+
+// capturePaused 托盘菜单中暂停了记录请求, 代理重新启动后继续生效
+var capturePaused bool
 var appConfig = &AppConfig{
-	ConfigFile: "config.yaml",
+	ConfigFile:   "config.yaml",
+	AutoReload:   true,
+	CheckUpdates: true,
+	AutoPort:     true,
+	Alerts: AlertConfig{
+		Enabled:             true,
+		ErrorRate:           0.5,
+		MinRequests:         10,
+		Window:              5,
+		ConsecutiveFailures: 5,
+	},
 }
 
 type Menu struct {
@@ -79,58 +95,72 @@ func main() {
 
 	home, _ = os.UserHomeDir()
 	confDir = filepath.Join(home, ".ccproxy")
-	confFile = filepath.Join(confDir, "config.yaml")
 
 	// 确保配置目录存在
 	if err := os.MkdirAll(confDir, 0755); err != nil {
 		log.Fatal("Failed to create config directory:", err)
 	}
+	setupAppLog()
+	removeOldBinary()
 
 	loadAppConfig()
+	setLocale(appConfig.Language)
+	confFile = configFilePath(appConfig.ConfigFile)
 	ccproxy = &CCProxy{}
 
 	systray.Run(onReady, onExit)
 }
 
 func onReady() {
-	_icon := icon
-	_iconOff := iconOff
-
-	if runtime.GOOS == "windows" {
-		_icon = iconWin
-		_iconOff = iconOffWin
-	}
-
-	systray.SetTemplateIcon(_iconOff, _iconOff)
-	systray.SetTooltip("CC Proxy - HTTP代理服务器")
+	refreshTrayIcon()
 
 	var restartMenu *systray.MenuItem
+	var refreshStats func()
+
+	proxyStarted := func(m *systray.MenuItem) {
+		m.SetTitle(tr("menu.stop"))
+		refreshTrayIcon()
+		if restartMenu != nil {
+			restartMenu.Show()
+		}
+		if refreshStats != nil {
+			refreshStats()
+		}
+		if upstreamMenu != nil {
+			syncUpstreamMenu()
+		}
+		if healthMenu != nil {
+			updateHealthMenu()
+		}
+	}
 
 	startProxy := func(m *systray.MenuItem) {
 		err := ccproxy.Start()
 		if err != nil {
-			showNotification("CC Proxy 启动失败", err.Error())
+			showNotification(tr("start.failed"), err.Error())
 			return
 		}
-		m.SetTitle("停止代理")
-		systray.SetTemplateIcon(_icon, _icon)
-		if restartMenu != nil {
-			restartMenu.Show()
-		}
+		proxyStarted(m)
 	}
 
 	stopProxy := func(m *systray.MenuItem) {
 		ccproxy.Stop()
-		m.SetTitle("启动代理")
-		systray.SetTemplateIcon(_iconOff, _iconOff)
+		m.SetTitle(tr("menu.start"))
+		refreshTrayIcon()
 		if restartMenu != nil {
 			restartMenu.Hide()
 		}
+		if refreshStats != nil {
+			refreshStats()
+		}
+		if healthMenu != nil {
+			updateHealthMenu()
+		}
 	}
 
 	// 主代理控制菜单
 	proxyMenu := addMenu(&Menu{
-		Title: "启动代理",
+		Title: tr("menu.start"),
 		OnClick: func(m *systray.MenuItem) {
 			m.Disable()
 			if ccproxy.Running {
@@ -149,14 +179,24 @@ func onReady() {
 		proxyMenu.Enable()
 	}
 
-	// 重启代理菜单
+	// 重启代理菜单, 修改后的配置启动失败时 (例如新端口被占用) 用之前的配置重新启动
 	restartProxy := func() {
+		previous := ccproxy.config
 		stopProxy(proxyMenu)
 		startProxy(proxyMenu)
+		if ccproxy.Running || previous == nil {
+			return
+		}
+		if err := ccproxy.StartWith(previous); err != nil {
+			showNotification(tr("start.failed"), err.Error())
+			return
+		}
+		proxyStarted(proxyMenu)
+		showNotification(tr("config.rolled_back"), tr("config.rolled_back.detail"))
 	}
 
 	restartMenu = addMenu(&Menu{
-		Title: "重启代理",
+		Title: tr("menu.restart"),
 		OnClick: func(m *systray.MenuItem) {
 			m.Disable()
 			if ccproxy.Running {
@@ -171,31 +211,121 @@ func onReady() {
 	// 添加分隔符
 	systray.AddSeparator()
 
+	// 运行状态
+	refreshStats = addStatsMenu()
+	systray.AddSeparator()
+
 	// 打开 Web 界面
 	addMenu(&Menu{
-		Title: "打开监控界面",
+		Title: tr("menu.dashboard"),
 		OnClick: func(m *systray.MenuItem) {
 			cfg, err := loadProxyConfig()
 			if err != nil {
-				showNotification("配置加载失败", err.Error())
+				showNotification(tr("config.load_failed"), err.Error())
 				return
 			}
-			webPort := "8081"
-			if cfg.Web.Enabled && cfg.Web.Port != "" {
-				webPort = cfg.Web.Port
+			if err := open.Run(dashboardURL(cfg)); err != nil {
+				showNotification(tr("dashboard.open_failed"), tr("dashboard.open_detail"))
+			}
+		},
+	})
+
+	// 为 Claude Code 设置 ANTHROPIC_BASE_URL
+	addClaudeEnvMenu()
+
+	// 查看托盘和代理自身的日志
+	addMenu(&Menu{
+		Title: tr("menu.logs"),
+		OnClick: func(m *systray.MenuItem) {
+			url, err := openLogViewer()
+			if err == nil {
+				err = open.Run(url)
+			}
+			if err != nil {
+				showNotification(tr("logs.open_failed"), err.Error())
 			}
-			url := fmt.Sprintf("http://localhost:%s", webPort)
-			if err := open.Run(url); err != nil {
-				showNotification("打开失败", "无法打开监控界面")
+		},
+	})
+
+	// 重置监控界面访问令牌
+	addMenu(&Menu{
+		Title: tr("menu.rotate_token"),
+		OnClick: func(m *systray.MenuItem) {
+			cfg, err := rotateDashboardToken()
+			if err != nil {
+				showNotification(tr("token.rotate_failed"), err.Error())
+				return
 			}
+			showNotification(tr("token.rotated"), tr("token.rotated.detail"))
+			_ = open.Run(dashboardURL(cfg))
 		},
 	})
 
+	// 切换配置文件: 停止代理, 换用新的文件后重新启动, 启动失败时换回原来的文件
+	addConfigFileMenu(func(name string) bool {
+		if _, err := config.LoadConfig(configFilePath(name)); err != nil {
+			showNotification(tr("config.load_failed"), err.Error())
+			return false
+		}
+
+		previousFile, previousProfile := appConfig.ConfigFile, appConfig.Profile
+		running := ccproxy.Running
+		if running {
+			stopProxy(proxyMenu)
+		}
+
+		// profile 属于原来的配置文件
+		appConfig.ConfigFile, appConfig.Profile = name, ""
+		confFile = configFilePath(name)
+		if running {
+			startProxy(proxyMenu)
+			if !ccproxy.Running {
+				appConfig.ConfigFile, appConfig.Profile = previousFile, previousProfile
+				confFile = configFilePath(previousFile)
+				startProxy(proxyMenu)
+				return false
+			}
+		}
+
+		saveAppConfig()
+		refreshProfileMenu()
+		refreshMaintenanceMenu()
+		refreshUpstreamMenu()
+		showNotification(tr("config_file.switched"), name)
+		return true
+	})
+	addProfileMenu()
+	addMaintenanceMenu()
+	addUpstreamMenu()
+	addHealthMenu()
+
+	// 暂停记录请求: 粘贴敏感内容前使用, 代理照常转发但不保存请求内容.
+	// 不写入 app.yaml, 重新启动托盘后恢复记录
+	addCheckboxMenu(&Menu{
+		Title: tr("menu.pause_capture"),
+		OnClick: func(m *systray.MenuItem) {
+			capturePaused = !m.Checked()
+			if capturePaused {
+				m.Check()
+			} else {
+				m.Uncheck()
+			}
+			if srv := ccproxy.server; ccproxy.Running && srv != nil {
+				srv.SetCapturePaused(capturePaused)
+			}
+			if capturePaused {
+				showNotification(tr("capture.paused"), tr("capture.paused.detail"))
+			} else {
+				showNotification(tr("capture.resumed"), tr("capture.resumed.detail"))
+			}
+		},
+	}, false)
+
 	systray.AddSeparator()
 
 	// 开机自启动
 	addCheckboxMenu(&Menu{
-		Title: "开机自启动",
+		Title: tr("menu.autostart"),
 		OnClick: func(m *systray.MenuItem) {
 			app := &autostart.App{
 				Name:        "ccproxy-tray",
@@ -226,7 +356,7 @@ func onReady() {
 
 	// 启动时启动代理
 	addCheckboxMenu(&Menu{
-		Title: "启动时启动代理",
+		Title: tr("menu.start_proxy"),
 		OnClick: func(m *systray.MenuItem) {
 			if !m.Checked() {
 				m.Check()
@@ -238,11 +368,28 @@ func onReady() {
 		},
 	}, appConfig.StartProxy)
 
+	// 配置修改后自动生效
+	addCheckboxMenu(&Menu{
+		Title: tr("menu.auto_reload"),
+		OnClick: func(m *systray.MenuItem) {
+			if !m.Checked() {
+				m.Check()
+			} else {
+				m.Uncheck()
+			}
+			appConfig.AutoReload = m.Checked()
+			saveAppConfig()
+		},
+	}, appConfig.AutoReload)
+
 	systray.AddSeparator()
 
+	// 新版本提示, 发现新版本时显示
+	addUpdateMenu()
+
 	// 关于菜单
 	addMenu(&Menu{
-		Title: "关于 CC Proxy",
+		Title: tr("menu.about"),
 		OnClick: func(m *systray.MenuItem) {
 			_ = open.Run("https://github.com/daodao97/claude-code-proxy")
 		},
@@ -250,19 +397,22 @@ func onReady() {
 
 	// 退出菜单
 	addMenu(&Menu{
-		Title: "退出",
+		Title: tr("menu.quit"),
 		OnClick: func(m *systray.MenuItem) {
 			ccproxy.Stop()
 			systray.Quit()
 		},
 	})
 
+	// 超过告警阈值或部分上游不可用时切换托盘图标
+	go watchAlerts()
+
 	// 启动配置文件监控
 	go watchConfigFile(restartProxy)
 }
 
 func onExit() {
-	fmt.Println("CC Proxy 托盘应用退出")
+	fmt.Println(tr("exit"))
 }
 
 func (cp *CCProxy) Start() error {
@@ -275,134 +425,57 @@ func (cp *CCProxy) Start() error {
 	cfg, err := config.LoadConfig(confFile)
 	if err != nil {
 		xlog.Error("加载配置失败", xlog.Err(err))
-		return fmt.Errorf("加载配置失败: %v", err)
-	}
-
-	cp.config = cfg
-	cp.ctx, cp.cancel = context.WithCancel(context.Background())
-
-	// 创建 WebSocket Hub
-	dataDir := filepath.Join(confDir, "data")
-	if err := os.MkdirAll(dataDir, 0755); err != nil {
-		log.Fatalf("Failed to create data directory: %v", err)
-	}
-
-	hub, err := websocket.NewHub(cfg.WebSocket.BroadcastSize, dataDir)
-	if err != nil {
-		log.Fatalf("Failed to create websocket hub: %v", err)
+		return fmt.Errorf("%s", tr("error.load_config", err))
 	}
-	cp.hub = hub
-	go cp.hub.Run()
-
-	// 创建代理处理器
-	handler := proxy.NewProxyHandler(cfg)
-	loggerHandler := middleware.NewLoggerMiddleware(handler, cp.hub, cfg)
-
-	// 创建代理服务器
-	proxyMux := http.NewServeMux()
-	proxyMux.Handle("/", loggerHandler)
-
-	cp.proxyServer = &http.Server{
-		Addr:        fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
-		Handler:     proxyMux,
-		IdleTimeout: time.Duration(cfg.Server.Timeouts.Idle) * time.Second,
-	}
-
-	// 用于检测启动状态的通道
-	proxyStarted := make(chan error, 1)
-	webStarted := make(chan error, 1)
-
-	// 启动代理服务器
-	go func() {
-		xlog.Info("启动代理服务器", xlog.String("addr", cp.proxyServer.Addr))
-		if err := cp.proxyServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			xlog.Error("代理服务器启动失败", xlog.Err(err))
-			proxyStarted <- err
-			return
-		}
-	}()
-
-	// 检测代理服务器是否启动成功
-	go func() {
-		time.Sleep(200 * time.Millisecond) // 等待启动
-		if _, err := http.Get(fmt.Sprintf("http://%s:%s/health", cfg.Server.Host, cfg.Server.Port)); err == nil {
-			proxyStarted <- nil // 启动成功
+	if appConfig.Profile != "" {
+		if active, err := cfg.UseProfile(appConfig.Profile); err != nil {
+			xlog.Error("切换配置 profile 失败", xlog.Err(err))
 		} else {
-			// 如果没有 health 端点，尝试连接端口
-			conn, err := net.Dial("tcp", fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port))
-			if err != nil {
-				proxyStarted <- err
-			} else {
-				conn.Close()
-				proxyStarted <- nil
-			}
-		}
-	}()
-
-	// 如果启用了 Web 界面，创建 Web 服务器
-	var webServerEnabled bool
-	if cfg.Web.Enabled {
-		webServerEnabled = true
-		webMux := http.NewServeMux()
-		webServer := web.NewWebServer(cp.hub, cfg)
-		webServer.SetupRoutes(webMux)
-
-		cp.webServer = &http.Server{
-			Addr:        fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Web.Port),
-			Handler:     webMux,
-			IdleTimeout: time.Duration(cfg.Server.Timeouts.Idle) * time.Second,
+			cfg = active
 		}
-
-		// 启动 Web 服务器
-		go func() {
-			xlog.Info("启动Web服务器", xlog.String("addr", cp.webServer.Addr))
-			if err := cp.webServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-				xlog.Error("Web服务器启动失败", xlog.Err(err))
-				webStarted <- err
-				return
-			}
-		}()
-
-		// 检测 Web 服务器是否启动成功
-		go func() {
-			time.Sleep(200 * time.Millisecond)
-			if _, err := http.Get(fmt.Sprintf("http://%s:%s/", cfg.Server.Host, cfg.Web.Port)); err != nil {
-				webStarted <- err
-			} else {
-				webStarted <- nil
-			}
-		}()
-	} else {
-		webStarted <- nil // Web 服务器未启用，标记为成功
 	}
+	return cp.StartWith(cfg)
+}
 
-	// 等待启动结果
-	var startupErrors []string
+// StartWith 使用已加载的配置启动代理, 重启失败时用它回到之前的配置
+func (cp *CCProxy) StartWith(cfg *config.Config) error {
+	if cp.Running {
+		return nil
+	}
 
-	// 检查代理服务器启动结果
-	if err := <-proxyStarted; err != nil {
-		startupErrors = append(startupErrors, fmt.Sprintf("代理服务器启动失败: %v", err))
+	// 端口被占用时在创建服务前处理, 不用等到监听失败
+	proxyPort, webPort, err := cp.resolvePorts(cfg)
+	if err != nil {
+		xlog.Error("代理端口不可用", xlog.Err(err))
+		return err
 	}
 
-	// 检查 Web 服务器启动结果
-	if webServerEnabled {
-		if err := <-webStarted; err != nil {
-			startupErrors = append(startupErrors, fmt.Sprintf("Web服务器启动失败: %v", err))
-		}
+	srv, err := server.New(cfg, server.Options{DataDir: filepath.Join(confDir, "data")})
+	if err != nil {
+		xlog.Error("创建代理服务失败", xlog.Err(err))
+		return fmt.Errorf("%s", tr("error.create_server", err))
 	}
+	srv.OnHealthChange(notifyHealthChange)
+	srv.SetCapturePaused(capturePaused)
 
-	// 如果有启动错误，清理并返回错误
-	if len(startupErrors) > 0 {
-		cp.cleanup()
-		errorMsg := strings.Join(startupErrors, "; ")
-		showNotification("CC Proxy 启动失败", errorMsg)
-		return fmt.Errorf(errorMsg)
+	// 监听失败（如端口被占用）会立即返回
+	if err := srv.Listen(); err != nil {
+		xlog.Error("代理服务器启动失败", xlog.Err(err))
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		srv.Shutdown(shutdownCtx)
+		cancel()
+		errorMsg := tr("error.listen", err)
+		showNotification(tr("start.failed"), errorMsg)
+		return fmt.Errorf("%s", errorMsg)
 	}
 
 	// 所有服务器都启动成功
+	cp.config = cfg
+	cp.server = srv
+	cp.proxyPort, cp.webPort = proxyPort, webPort
 	cp.Running = true
 	xlog.Info("CC Proxy 已启动", xlog.String("host", cfg.Server.Host), xlog.String("port", cfg.Server.Port))
-	showNotification("CC Proxy 已启动", fmt.Sprintf("代理服务器运行在 http://%s:%s", cfg.Server.Host, cfg.Server.Port))
+	showNotification(tr("started"), tr("started.detail", fmt.Sprintf("http://%s:%s", cfg.Server.Host, cfg.Server.Port)))
 
 	return nil
 }
@@ -418,63 +491,184 @@ func (cp *CCProxy) Stop() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	// 停止代理服务器
-	if cp.proxyServer != nil {
-		if err := cp.proxyServer.Shutdown(ctx); err != nil {
-			xlog.Error("停止代理服务器失败", xlog.Err(err))
-		} else {
-			xlog.Info("代理服务器已停止")
-		}
+	if cp.server != nil {
+		cp.server.Shutdown(ctx)
 	}
 
-	// 停止 Web 服务器
-	if cp.webServer != nil {
-		if err := cp.webServer.Shutdown(ctx); err != nil {
-			xlog.Error("停止Web服务器失败", xlog.Err(err))
-		} else {
-			xlog.Info("Web服务器已停止")
-		}
+	cp.Running = false
+	cp.server = nil
+
+	showNotification(tr("stopped"), tr("stopped.detail"))
+}
+
+// notifyHealthChange 在上游地址故障或恢复时弹出通知
+func notifyHealthChange(event websocket.HealthEvent) {
+	target := event.URL
+	if len(event.Targets) > 0 {
+		target = fmt.Sprintf("%s (%s)", event.URL, strings.Join(event.Targets, ", "))
+	}
+	if event.Healthy {
+		showNotification(tr("upstream.recovered"), target)
+		return
+	}
+	if event.Error != "" {
+		target += ": " + event.Error
+	}
+	showNotification(tr("upstream.down"), target)
+}
+
+// dashboardURL 返回监控界面地址, 使用 bearer 认证时附带令牌
+func dashboardURL(cfg *config.Config) string {
+	webPort := "8081"
+	if cfg.Web.Enabled && cfg.Web.Port != "" {
+		webPort = cfg.Web.Port
 	}
+	url := fmt.Sprintf("http://localhost:%s", webPort)
+	if cfg.Web.Auth.Type == "bearer" {
+		url += "/?token=" + cfg.Web.Auth.Token
+	}
+	return url
+}
 
-	// 取消上下文
-	if cp.cancel != nil {
-		cp.cancel()
+// rotateDashboardToken 生成新的访问令牌写入配置文件, 运行中的代理立即生效
+func rotateDashboardToken() (*config.Config, error) {
+	cfg, err := loadProxyConfig()
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Web.Auth.Type == "basic" {
+		return nil, fmt.Errorf("%s", tr("token.basic_auth"))
 	}
 
-	cp.Running = false
-	cp.proxyServer = nil
-	cp.webServer = nil
-	cp.hub = nil
-	cp.cancel = nil
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := config.SetFileValue(confFile, "web.auth.type", "bearer"); err != nil {
+		return nil, err
+	}
+	if err := config.SetFileValue(confFile, "web.auth.token", token); err != nil {
+		return nil, err
+	}
 
-	showNotification("CC Proxy 已停止", "代理服务器已停止运行")
+	if ccproxy.Running && ccproxy.server != nil {
+		if err := ccproxy.server.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	xlog.Info("监控界面访问令牌已重置")
+	return loadProxyConfig()
 }
 
-func (cp *CCProxy) cleanup() {
-	// 创建带超时的上下文
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+// 切换配置文件后重建的子菜单. systray 不能删除菜单项, 旧的菜单项被隐藏
+var (
+	profileMenu      *systray.MenuItem
+	profileItems     []*systray.MenuItem
+	maintenanceMenu  *systray.MenuItem
+	maintenanceItems []*systray.MenuItem
+)
 
-	// 清理代理服务器
-	if cp.proxyServer != nil {
-		cp.proxyServer.Shutdown(ctx)
-		cp.proxyServer = nil
+// addProfileMenu 列出 proxy.profiles, 点击后立即切换运行中的代理并记住选择
+func addProfileMenu() {
+	profileMenu = systray.AddMenuItem(tr("menu.profiles"), tr("menu.profiles"))
+	refreshProfileMenu()
+}
+
+// refreshProfileMenu 按当前的配置文件重建 profile 列表, 没有 profile 时隐藏菜单
+func refreshProfileMenu() {
+	for _, item := range profileItems {
+		item.Hide()
 	}
+	profileItems = nil
 
-	// 清理 Web 服务器
-	if cp.webServer != nil {
-		cp.webServer.Shutdown(ctx)
-		cp.webServer = nil
+	cfg, err := loadProxyConfig()
+	if err != nil || len(cfg.Proxy.Profiles) == 0 {
+		profileMenu.Hide()
+		return
 	}
+	profileMenu.Show()
 
-	// 取消上下文
-	if cp.cancel != nil {
-		cp.cancel()
-		cp.cancel = nil
+	active := cfg.Proxy.Profile
+	if appConfig.Profile != "" {
+		active = appConfig.Profile
 	}
 
-	cp.hub = nil
-	cp.Running = false
+	items := map[string]*systray.MenuItem{}
+	for _, name := range cfg.ProfileNames() {
+		name := name
+		item := profileMenu.AddSubMenuItemCheckbox(name, name, name == active)
+		items[name] = item
+		profileItems = append(profileItems, item)
+		go func() {
+			for range item.ClickedCh {
+				if ccproxy.Running && ccproxy.server != nil {
+					if err := ccproxy.server.SwitchProfile(name); err != nil {
+						showNotification(tr("profile.switch_failed"), err.Error())
+						continue
+					}
+				}
+				for other, otherItem := range items {
+					if other == name {
+						otherItem.Check()
+					} else {
+						otherItem.Uncheck()
+					}
+				}
+				appConfig.Profile = name
+				saveAppConfig()
+				showNotification(tr("profile.switched"), name)
+			}
+		}()
+	}
+}
+
+// addMaintenanceMenu 列出代理目标, 勾选后该目标进入维护模式, 直接返回 503. 仅对运行中的代理生效, 不写入配置文件
+func addMaintenanceMenu() {
+	maintenanceMenu = systray.AddMenuItem(tr("menu.maintenance"), tr("menu.maintenance"))
+	refreshMaintenanceMenu()
+}
+
+// refreshMaintenanceMenu 按当前的配置文件重建代理目标列表
+func refreshMaintenanceMenu() {
+	for _, item := range maintenanceItems {
+		item.Hide()
+	}
+	maintenanceItems = nil
+
+	cfg, err := loadProxyConfig()
+	if err != nil || len(cfg.Proxy.Targets) == 0 {
+		maintenanceMenu.Hide()
+		return
+	}
+	maintenanceMenu.Show()
+
+	for _, target := range cfg.Proxy.Targets {
+		path := target.Route()
+		item := maintenanceMenu.AddSubMenuItemCheckbox(path, path, target.InMaintenance())
+		maintenanceItems = append(maintenanceItems, item)
+		go func() {
+			for range item.ClickedCh {
+				if !ccproxy.Running || ccproxy.server == nil {
+					showNotification(tr("maintenance.failed"), tr("proxy.not_running"))
+					continue
+				}
+				enabled := !item.Checked()
+				if err := ccproxy.server.SetMaintenance(path, enabled); err != nil {
+					showNotification(tr("maintenance.failed"), err.Error())
+					continue
+				}
+				if enabled {
+					item.Check()
+					showNotification(tr("maintenance.on"), path)
+				} else {
+					item.Uncheck()
+					showNotification(tr("maintenance.off"), path)
+				}
+			}
+		}()
+	}
 }
 
 func addMenu(menu *Menu) *systray.MenuItem {
@@ -498,8 +692,14 @@ func addCheckboxMenu(menu *Menu, checked bool) *systray.MenuItem {
 	return item
 }
 
+// loadProxyConfig 加载配置文件, 代理运行时使用实际监听的端口
 func loadProxyConfig() (*config.Config, error) {
-	return config.LoadConfig(confFile)
+	cfg, err := config.LoadConfig(confFile)
+	if err != nil {
+		return nil, err
+	}
+	ccproxy.applyPorts(cfg)
+	return cfg, nil
 }
 
 func loadAppConfig() {
@@ -529,6 +729,8 @@ func saveAppConfig() {
 }
 
 func showNotification(title, message string) {
+	// 通知很快消失, 同时写入日志, 可以在日志窗口中查看
+	log.Printf("%s: %s", title, message)
 	n := notify.NewNotifications()
 	err := n.Notify(&notify.Notification{
 		Title:   title,
@@ -557,21 +759,32 @@ func watchConfigFile(restartCallback func()) {
 		}
 	}
 
-	if err := watcher.Add(confFile); err != nil {
+	if data, err := os.ReadFile(confFile); err == nil {
+		configSum = sha256.Sum256(data)
+	}
+
+	// 监控所在目录, 托盘切换配置文件后不需要重新注册
+	if err := watcher.Add(filepath.Dir(confFile)); err != nil {
 		log.Printf("监控配置文件失败: %v", err)
 		return
 	}
 
+	// 编辑器保存时会连续触发多次事件, 有的先写临时文件再重命名覆盖 (Create/Rename),
+	// 有的只修改权限 (Chmod). 不区分事件类型, 停止变化 500ms 后再检查内容
+	var reloadTimer *time.Timer
 	for {
 		select {
 		case event, ok := <-watcher.Events:
 			if !ok {
 				return
 			}
-			if strings.Contains(event.String(), "WRITE") && ccproxy.Running {
-				showNotification("配置文件已更改", "请重启代理服务")
-				// 可以在这里添加自动重启逻辑
-				// restartCallback()
+			if filepath.Clean(event.Name) == confFile {
+				if reloadTimer != nil {
+					reloadTimer.Stop()
+				}
+				reloadTimer = time.AfterFunc(500*time.Millisecond, func() {
+					applyConfigChange(restartCallback)
+				})
 			}
 		case err, ok := <-watcher.Errors:
 			if !ok {
@@ -582,6 +795,63 @@ func watchConfigFile(restartCallback func()) {
 	}
 }
 
+var (
+	configMu  sync.Mutex // 串行化 applyConfigChange, 重启期间可能又有新的修改
+	configSum [sha256.Size]byte
+)
+
+// applyConfigChange 配置文件的内容变化后校验新配置, 在运行中的代理上生效.
+// 监听地址等需要重启的修改通过 restart 重启代理. 新配置不合法时代理继续使用
+// 之前的配置
+func applyConfigChange(restart func()) {
+	configMu.Lock()
+	defer configMu.Unlock()
+
+	// 重命名覆盖的过程中文件可能暂时不存在, 之后的 Create 事件会再次触发
+	data, err := os.ReadFile(confFile)
+	if err != nil {
+		return
+	}
+	sum := sha256.Sum256(data)
+	if sum == configSum {
+		return
+	}
+	configSum = sum
+
+	if !ccproxy.Running || ccproxy.config == nil {
+		return
+	}
+	if !appConfig.AutoReload {
+		showNotification(tr("config.changed"), tr("config.changed.detail"))
+		return
+	}
+
+	cfg, err := loadProxyConfig()
+	if err != nil {
+		showNotification(tr("config.invalid"), err.Error())
+		return
+	}
+	if changes := config.RestartRequired(ccproxy.config, cfg); len(changes) > 0 {
+		log.Printf("配置修改需要重启代理: %s", strings.Join(changes, ", "))
+		restart()
+		return
+	}
+	reloadProxyConfig()
+}
+
+// reloadProxyConfig 配置文件变化后在运行中的代理上生效, 监听地址的修改仍需重启
+func reloadProxyConfig() {
+	srv := ccproxy.server
+	if !ccproxy.Running || srv == nil {
+		return
+	}
+	if err := srv.Reload(); err != nil {
+		showNotification(tr("config.load_failed"), err.Error())
+		return
+	}
+	showNotification(tr("config.reloaded"), tr("config.reloaded.detail"))
+}
+
 func createDefaultConfig() error {
 	defaultConfig := `server:
   host: "0.0.0.0"
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"ccproxy/config"
+)
+
+// portSearchRange 端口被占用时向后查找空闲端口的个数
+const portSearchRange = 100
+
+// portChange 启动时因端口被占用而改用的端口
+type portChange struct {
+	configured string // 配置文件中的端口
+	chosen     string // 实际监听的端口
+}
+
+// portAvailable 端口能否监听, 权限不足等其他错误也视为不可用
+func portAvailable(host, port string) bool {
+	listener, err := net.Listen("tcp", net.JoinHostPort(host, port))
+	if err != nil {
+		return false
+	}
+	listener.Close()
+	return true
+}
+
+// nextFreePort 返回 port 之后第一个空闲的端口, 跳过 avoid
+func nextFreePort(host, port, avoid string) (string, bool) {
+	start, err := strconv.Atoi(port)
+	if err != nil {
+		return "", false
+	}
+	for candidate := start + 1; candidate <= start+portSearchRange && candidate <= 65535; candidate++ {
+		next := strconv.Itoa(candidate)
+		if next != avoid && portAvailable(host, next) {
+			return next, true
+		}
+	}
+	return "", false
+}
+
+// resolvePorts 在启动前检查代理和监控界面的端口. 被占用时按 app.yaml 的 auto_port
+// 改用之后第一个空闲的端口并通知, 否则返回说明哪个端口被占用的错误. 回滚到之前的
+// 配置时 cfg 已经是改用后的端口, 保留原来配置的端口. 返回的改动在启动成功后记录
+func (cp *CCProxy) resolvePorts(cfg *config.Config) (proxyPort, webPort *portChange, err error) {
+	host := cfg.Server.Host
+	if proxyPort, err = resolvePort(tr("port.proxy"), host, &cfg.Server.Port, cp.proxyPort, ""); err != nil {
+		return nil, nil, err
+	}
+	if cfg.Web.Enabled {
+		if webPort, err = resolvePort(tr("port.web"), host, &cfg.Web.Port, cp.webPort, cfg.Server.Port); err != nil {
+			return nil, nil, err
+		}
+	}
+	return proxyPort, webPort, nil
+}
+
+func resolvePort(name, host string, port *string, previous *portChange, avoid string) (*portChange, error) {
+	var change *portChange
+	if previous != nil && previous.chosen == *port {
+		change = previous
+	}
+	if *port != avoid && portAvailable(host, *port) {
+		return change, nil
+	}
+	if !appConfig.AutoPort {
+		return nil, fmt.Errorf("%s", tr("port.in_use", name, *port))
+	}
+
+	chosen, ok := nextFreePort(host, *port, avoid)
+	if !ok {
+		return nil, fmt.Errorf("%s", tr("port.none_free", name, *port))
+	}
+	configured := *port
+	if change != nil {
+		configured = change.configured
+	}
+	*port = chosen
+	showNotification(tr("port.changed"), tr("port.changed.detail", name, configured, chosen))
+	return &portChange{configured: configured, chosen: chosen}, nil
+}
+
+// applyPorts 把启动时改用的端口应用到从文件加载的配置, 监控界面和
+// ANTHROPIC_BASE_URL 的地址使用实际监听的端口, 修改配置文件时也不会因为端口
+// 不同而重启代理. 配置文件中的端口已经改过时不再替换
+func (cp *CCProxy) applyPorts(cfg *config.Config) {
+	if !cp.Running {
+		return
+	}
+	if cp.proxyPort != nil && cfg.Server.Port == cp.proxyPort.configured {
+		cfg.Server.Port = cp.proxyPort.chosen
+	}
+	if cp.webPort != nil && cfg.Web.Port == cp.webPort.configured {
+		cfg.Web.Port = cp.webPort.chosen
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"time"
+
+	"github.com/getlantern/systray"
+)
+
+// statsRefresh 托盘菜单中统计信息的刷新间隔
+const statsRefresh = 5 * time.Second
+
+// trayStats 菜单中展示的运行状态
+type trayStats struct {
+	requests   int64
+	errors     int64
+	upstream   string        // 最近一次请求使用的上游地址
+	avgLatency time.Duration // 今日请求的平均耗时
+	hasLatency bool
+}
+
+// addStatsMenu 在菜单中显示今日请求数、错误数、当前上游和平均延迟, 代理运行时
+// 定时刷新, 不用打开监控界面也能查看代理状态. 返回的函数立即刷新一次
+func addStatsMenu() func() {
+	requests := systray.AddMenuItem("", "")
+	errors := systray.AddMenuItem("", "")
+	upstream := systray.AddMenuItem("", "")
+	latency := systray.AddMenuItem("", "")
+	items := []*systray.MenuItem{requests, errors, upstream, latency}
+	for _, item := range items {
+		item.Disable()
+	}
+
+	update := func() {
+		stats, ok := collectStats()
+		if !ok {
+			for _, item := range items {
+				item.Hide()
+			}
+			return
+		}
+
+		requests.SetTitle(tr("stats.requests", stats.requests))
+		errors.SetTitle(tr("stats.errors", stats.errors))
+		if stats.upstream == "" {
+			upstream.SetTitle(tr("stats.upstream", "-"))
+		} else {
+			upstream.SetTitle(tr("stats.upstream", stats.upstream))
+		}
+		if stats.hasLatency {
+			latency.SetTitle(tr("stats.latency", stats.avgLatency.String()))
+		} else {
+			latency.SetTitle(tr("stats.latency", "-"))
+		}
+		for _, item := range items {
+			item.Show()
+		}
+	}
+
+	update()
+	go func() {
+		for range time.Tick(statsRefresh) {
+			update()
+		}
+	}()
+	return update
+}
+
+// collectStats 汇总运行中代理今日的请求统计, 代理未运行时返回 false.
+// 时间序列只保留 24 小时, 今日从本地时间零点或代理启动时算起
+func collectStats() (*trayStats, bool) {
+	srv := ccproxy.server
+	if !ccproxy.Running || srv == nil {
+		return nil, false
+	}
+
+	now := time.Now()
+	year, month, day := now.Date()
+	midnight := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+	window := now.Sub(midnight).Truncate(time.Minute) + time.Minute
+
+	stats := &trayStats{}
+	var latency float64
+	var measured int64
+	for _, point := range srv.TimeSeries(window, time.Minute).Points {
+		stats.requests += point.Requests
+		stats.errors += point.Errors
+		if point.AvgLatencyMs > 0 {
+			latency += point.AvgLatencyMs * float64(point.Requests)
+			measured += point.Requests
+		}
+	}
+	if measured > 0 {
+		avg := time.Duration(latency / float64(measured) * float64(time.Millisecond))
+		if avg >= time.Millisecond {
+			avg = avg.Round(time.Millisecond)
+		}
+		stats.avgLatency, stats.hasLatency = avg.Round(time.Microsecond), true
+	}
+
+	var last time.Time
+	for upstream, target := range srv.Status().Stats.Targets {
+		if target.LastRequestTime.After(last) {
+			stats.upstream, last = upstream, target.LastRequestTime
+		}
+	}
+	return stats, true
+}
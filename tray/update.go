@@ -0,0 +1,443 @@
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"ccproxy/version"
+
+	"github.com/getlantern/systray"
+	"github.com/skratchdot/open-golang/open"
+)
+
+const (
+	updateCheckInterval = 24 * time.Hour
+	latestReleaseAPI    = "https://api.github.com/repos/daodao97/claude-code-proxy/releases/latest"
+)
+
+// release GitHub releases API 返回的字段
+type release struct {
+	TagName string         `json:"tag_name"`
+	HTMLURL string         `json:"html_url"`
+	Assets  []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// addUpdateMenu 定期检查 GitHub 上的新版本, 发现后显示通知和菜单项. 点击菜单项
+// 下载当前平台的安装包, 校验后替换程序并重新启动, 没有对应的安装包时打开发布页面.
+// 开发版本无法比较版本号, 不检查
+func addUpdateMenu() {
+	item := systray.AddMenuItem("", "")
+	item.Hide()
+	if !appConfig.CheckUpdates || parseVersion(version.Version) == nil {
+		return
+	}
+
+	latest := make(chan *release, 1)
+	go func() {
+		var notified string
+		for {
+			rel, err := fetchLatestRelease()
+			if err != nil {
+				log.Printf("检查更新失败: %v", err)
+			} else if newerVersion(rel.TagName, version.Version) {
+				select {
+				case <-latest:
+				default:
+				}
+				latest <- rel
+				item.SetTitle(tr("menu.update", rel.TagName))
+				item.SetTooltip(rel.HTMLURL)
+				item.Show()
+				if notified != rel.TagName {
+					notified = rel.TagName
+					showNotification(tr("update.available"), tr("update.available.detail", rel.TagName, version.Version))
+				}
+			}
+			time.Sleep(updateCheckInterval)
+		}
+	}()
+
+	go func() {
+		for range item.ClickedCh {
+			var rel *release
+			select {
+			case rel = <-latest:
+				latest <- rel
+			default:
+				continue
+			}
+
+			asset := releaseAssetFor(rel)
+			if asset == nil {
+				_ = open.Run(rel.HTMLURL)
+				continue
+			}
+			item.Disable()
+			showNotification(tr("update.downloading"), rel.TagName)
+			exe, err := installUpdate(rel, asset)
+			if err != nil {
+				log.Printf("安装更新失败: %v", err)
+				showNotification(tr("update.failed"), err.Error())
+				_ = open.Run(rel.HTMLURL)
+				item.Enable()
+				continue
+			}
+			showNotification(tr("update.installed"), tr("update.installed.detail", rel.TagName))
+			relaunch(exe)
+		}
+	}()
+}
+
+func fetchLatestRelease() (*release, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, latestReleaseAPI, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API returned %s", resp.Status)
+	}
+
+	var rel release
+	if err := json.NewDecoder(resp.Body).Decode(&rel); err != nil {
+		return nil, err
+	}
+	return &rel, nil
+}
+
+// parseVersion 解析 v1.2.3 形式的版本号, git describe 附加的 -3-gabc123 等后缀
+// 被忽略, 不是这种形式时返回 nil
+func parseVersion(v string) []int {
+	v = strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	var parts []int
+	for _, field := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return nil
+		}
+		parts = append(parts, n)
+	}
+	return parts
+}
+
+// newerVersion latest 是否比 current 新
+func newerVersion(latest, current string) bool {
+	l, c := parseVersion(latest), parseVersion(current)
+	if l == nil || c == nil {
+		return false
+	}
+	for i := 0; i < len(l) || i < len(c); i++ {
+		var a, b int
+		if i < len(l) {
+			a = l[i]
+		}
+		if i < len(c) {
+			b = c[i]
+		}
+		if a != b {
+			return a > b
+		}
+	}
+	return false
+}
+
+// releaseAssetFor 返回当前平台的安装包, 名称与 build.sh 生成的一致
+func releaseAssetFor(rel *release) *releaseAsset {
+	var name string
+	switch runtime.GOOS {
+	case "darwin":
+		name = fmt.Sprintf("CCProxy-mac-%s.zip", runtime.GOARCH)
+	case "windows":
+		name = fmt.Sprintf("CCProxy-win-%s.zip", runtime.GOARCH)
+	case "linux":
+		name = fmt.Sprintf("CCProxy-linux-%s.zip", runtime.GOARCH)
+	default:
+		return nil
+	}
+	for i := range rel.Assets {
+		if rel.Assets[i].Name == name {
+			return &rel.Assets[i]
+		}
+	}
+	return nil
+}
+
+// checksumsAsset 发布页面上各安装包的 SHA-256, 格式与 sha256sum 的输出相同,
+// 由 scripts/auto-release.sh 上传
+const checksumsAsset = "SHA256SUMS"
+
+// updateTarget 更新时替换的路径和它在安装包中的名称. macOS 上替换整个
+// CCProxy.app, 只替换其中的程序会破坏应用的代码签名
+func updateTarget(exe string) (string, string, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		bundle := filepath.Dir(filepath.Dir(filepath.Dir(exe)))
+		if filepath.Ext(bundle) != ".app" {
+			return "", "", fmt.Errorf("%s is not inside an app bundle", exe)
+		}
+		return bundle, "CCProxy.app", nil
+	case "windows":
+		return exe, "CCProxy.exe", nil
+	default:
+		return exe, "CCProxy-linux-" + runtime.GOARCH, nil
+	}
+}
+
+// installUpdate 下载安装包, 校验 SHA256SUMS 中的摘要后替换正在运行的程序.
+// 运行中的程序不能被覆盖 (Windows), 先改名为 .old, 下次启动时删除. 返回新程序的路径
+func installUpdate(rel *release, asset *releaseAsset) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", err
+	}
+	target, name, err := updateTarget(exe)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	expected, err := fetchChecksum(ctx, rel, asset.Name)
+	if err != nil {
+		return "", err
+	}
+
+	archive, err := os.CreateTemp("", "ccproxy-update-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	resp, err := download(ctx, asset)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	digest := sha256.New()
+	size, err := io.Copy(io.MultiWriter(archive, digest), resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if actual := hex.EncodeToString(digest.Sum(nil)); actual != expected {
+		return "", fmt.Errorf("checksum mismatch for %s: expected %s, got %s", asset.Name, expected, actual)
+	}
+
+	reader, err := zip.NewReader(archive, size)
+	if err != nil {
+		return "", err
+	}
+
+	// 先解压到同一目录, 保证最后的改名不跨文件系统
+	newTarget := target + ".new"
+	os.RemoveAll(newTarget)
+	if err := extractUpdate(reader, name, newTarget); err != nil {
+		os.RemoveAll(newTarget)
+		return "", fmt.Errorf("%s: %w", asset.Name, err)
+	}
+	oldTarget := target + ".old"
+	os.RemoveAll(oldTarget)
+	if err := os.Rename(target, oldTarget); err != nil {
+		os.RemoveAll(newTarget)
+		return "", err
+	}
+	if err := os.Rename(newTarget, target); err != nil {
+		os.Rename(oldTarget, target)
+		os.RemoveAll(newTarget)
+		return "", err
+	}
+	log.Printf("已更新 %s 到 %s", target, asset.Name)
+	return exe, nil
+}
+
+func download(ctx context.Context, asset *releaseAsset) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, asset.BrowserDownloadURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("download %s: %s", asset.Name, resp.Status)
+	}
+	return resp, nil
+}
+
+// fetchChecksum 返回发布页面 SHA256SUMS 中 name 的摘要, 没有 SHA256SUMS 的版本不能自动更新
+func fetchChecksum(ctx context.Context, rel *release, name string) (string, error) {
+	var sums *releaseAsset
+	for i := range rel.Assets {
+		if rel.Assets[i].Name == checksumsAsset {
+			sums = &rel.Assets[i]
+		}
+	}
+	if sums == nil {
+		return "", fmt.Errorf("release %s has no %s", rel.TagName, checksumsAsset)
+	}
+
+	resp, err := download(ctx, sums)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	// 每行是 "<摘要>  <文件名>", 二进制模式的文件名前有 *
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s not listed in %s", name, checksumsAsset)
+}
+
+// extractUpdate 把安装包中的 name (文件或目录) 解压到 dest. 目录中的文件保留权限
+// 和符号链接, 指向目录之外的路径被拒绝
+func extractUpdate(reader *zip.Reader, name, dest string) error {
+	found := false
+	for _, file := range reader.File {
+		path := strings.TrimSuffix(file.Name, "/")
+		if path != name && !strings.HasPrefix(path, name+"/") {
+			continue
+		}
+		found = true
+		rel := strings.TrimPrefix(strings.TrimPrefix(path, name), "/")
+		if rel != "" && !filepath.IsLocal(rel) {
+			return fmt.Errorf("invalid path %s", file.Name)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(rel))
+
+		mode := file.Mode()
+		switch {
+		case mode.IsDir():
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case mode&os.ModeSymlink != 0:
+			link, err := readZipFile(file)
+			if err != nil {
+				return err
+			}
+			if filepath.IsAbs(link) || !filepath.IsLocal(filepath.Join(filepath.Dir(rel), link)) {
+				return fmt.Errorf("invalid symlink %s -> %s", file.Name, link)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			if err := os.Symlink(link, target); err != nil {
+				return err
+			}
+		default:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			perm := mode.Perm() | 0600
+			if rel == "" {
+				// 单个程序文件, Windows 上打包的 zip 可能没有可执行权限
+				perm = 0755
+			}
+			if err := extractFile(file, target, perm); err != nil {
+				return err
+			}
+		}
+	}
+	if !found {
+		return fmt.Errorf("%s not found", name)
+	}
+	return nil
+}
+
+func readZipFile(file *zip.File) (string, error) {
+	src, err := file.Open()
+	if err != nil {
+		return "", err
+	}
+	defer src.Close()
+	data, err := io.ReadAll(io.LimitReader(src, 4096))
+	return string(data), err
+}
+
+func extractFile(file *zip.File, path string, perm os.FileMode) error {
+	src, err := file.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// removeOldBinary 删除上次更新留下的旧程序, macOS 上是旧的 CCProxy.app
+func removeOldBinary() {
+	if exe, err := os.Executable(); err == nil {
+		if exe, err = filepath.EvalSymlinks(exe); err == nil {
+			if target, _, err := updateTarget(exe); err == nil {
+				os.RemoveAll(target + ".old")
+			}
+		}
+	}
+}
+
+// relaunch 停止代理后启动新的程序并退出, 新程序按 app.yaml 决定是否启动代理.
+// 替换后 os.Executable 在部分系统上指向改名后的旧程序, 所以使用替换前的路径
+func relaunch(exe string) {
+	// 先停止代理, 避免新程序启动代理时端口仍被占用
+	running := ccproxy.Running
+	ccproxy.Stop()
+	if err := exec.Command(exe).Start(); err != nil {
+		log.Printf("启动新版本失败: %v", err)
+		showNotification(tr("update.failed"), err.Error())
+		if running {
+			_ = ccproxy.Start()
+		}
+		return
+	}
+	systray.Quit()
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"strings"
+
+	"ccproxy/proxy"
+
+	"github.com/getlantern/systray"
+)
+
+// 上游地址子菜单, 切换配置文件后重建
+var (
+	upstreamMenu  *systray.MenuItem
+	upstreamItems = map[string]*systray.MenuItem{}
+)
+
+// addUpstreamMenu 列出所有上游地址, 取消勾选后该地址被手动标记为不可用, 不再
+// 转发请求, 重新勾选后交还给健康检查. 与维护模式一样只对运行中的代理生效
+func addUpstreamMenu() {
+	upstreamMenu = systray.AddMenuItem(tr("menu.upstreams"), tr("menu.upstreams"))
+	refreshUpstreamMenu()
+}
+
+// refreshUpstreamMenu 按当前的配置文件重建上游地址列表
+func refreshUpstreamMenu() {
+	for _, item := range upstreamItems {
+		item.Hide()
+	}
+	upstreamItems = map[string]*systray.MenuItem{}
+
+	cfg, err := loadProxyConfig()
+	if err != nil || len(cfg.Proxy.Targets) == 0 {
+		upstreamMenu.Hide()
+		return
+	}
+	upstreamMenu.Show()
+
+	// 同一个地址可以被多个目标使用, 健康状态按地址记录
+	var urls []string
+	routes := map[string][]string{}
+	for _, target := range cfg.Proxy.Targets {
+		for _, url := range target.TargetURLs {
+			if _, ok := routes[url]; !ok {
+				urls = append(urls, url)
+			}
+			routes[url] = append(routes[url], target.Route())
+		}
+	}
+
+	for _, url := range urls {
+		url := url
+		item := upstreamMenu.AddSubMenuItemCheckbox(url, strings.Join(routes[url], ", "), true)
+		upstreamItems[url] = item
+		go func() {
+			for range item.ClickedCh {
+				if !ccproxy.Running || ccproxy.server == nil {
+					showNotification(tr("upstream.toggle_failed"), tr("proxy.not_running"))
+					continue
+				}
+				enabled := !item.Checked()
+				state := proxy.OverrideDown
+				if enabled {
+					state = "auto"
+				}
+				if err := ccproxy.server.SetUpstreamOverride(url, state); err != nil {
+					showNotification(tr("upstream.toggle_failed"), err.Error())
+					continue
+				}
+				if enabled {
+					item.Check()
+					showNotification(tr("upstream.enabled"), url)
+				} else {
+					item.Uncheck()
+					showNotification(tr("upstream.disabled"), url)
+				}
+			}
+		}()
+	}
+	syncUpstreamMenu()
+}
+
+// syncUpstreamMenu 按运行中代理的状态更新勾选, 代理重新启动后手动设置不再保留
+func syncUpstreamMenu() {
+	var upstreams map[string]*proxy.URLHealth
+	if ccproxy.Running && ccproxy.server != nil {
+		upstreams = ccproxy.server.Upstreams()
+	}
+	for url, item := range upstreamItems {
+		if health := upstreams[url]; health != nil && health.Override == proxy.OverrideDown {
+			item.Uncheck()
+		} else {
+			item.Check()
+		}
+	}
+}
@@ -5,7 +5,9 @@ import "time"
 // LogMessage 日志消息结构体
 type LogMessage struct {
 	Timestamp       string            `json:"timestamp"`
+	RequestID       string            `json:"request_id,omitempty"`
 	Method          string            `json:"method"`
+	Host            string            `json:"host,omitempty"` // Host the client sent the request to
 	Path            string            `json:"path"`
 	Query           string            `json:"query"`
 	RequestHeaders  map[string]string `json:"request_headers"`
@@ -16,7 +18,15 @@ type LogMessage struct {
 	TargetURL       string            `json:"target_url"`
 	RequestBody     string            `json:"request_body,omitempty"`
 	ResponseBody    string            `json:"response_body,omitempty"`
+	RequestBlob     string            `json:"request_blob,omitempty"`  // SHA-256 of the request body when the history keeps it in a blob file
+	ResponseBlob    string            `json:"response_blob,omitempty"` // SHA-256 of the response body when the history keeps it in a blob file
+	BodiesOmitted   bool              `json:"bodies_omitted,omitempty"` // Live message without bodies, fetch them from /api/history/{id}
+	RequestSize     int64             `json:"request_size,omitempty"`  // Bytes relayed, the logged body may be truncated
+	ResponseSize    int64             `json:"response_size,omitempty"`
 	Error           string            `json:"error,omitempty"`
+	ErrorType       string            `json:"error_type,omitempty"` // dns, connect, tls, timeout, upstream_5xx, proxy, no_route
+	AttemptCount    int               `json:"attempt_count,omitempty"`
+	Attempts        []Attempt         `json:"attempts,omitempty"`
 	Stats           *Statistics       `json:"stats,omitempty"`
 	// Connection metrics
 	ConnectDuration   string `json:"connect_duration,omitempty"`
@@ -28,13 +38,69 @@ type LogMessage struct {
 	ConnectionReused  bool   `json:"connection_reused,omitempty"`
 }
 
+// WithoutBodies 返回不含请求体和响应体的副本, 用于访问日志等外部输出
+func (m *LogMessage) WithoutBodies() *LogMessage {
+	entry := *m
+	entry.RequestBody, entry.ResponseBody = "", ""
+	return &entry
+}
+
+// Attempt 一次转发尝试, 请求被重试时每次尝试都会记录
+type Attempt struct {
+	TargetURL  string `json:"target_url"`
+	StatusCode int    `json:"status_code,omitempty"`
+	Duration   string `json:"duration"`
+	Error      string `json:"error,omitempty"`
+}
+
 // Statistics 统计信息结构体
 type Statistics struct {
-	TotalRequests    int64     `json:"total_requests"`
-	SuccessRequests  int64     `json:"success_requests"`
-	ErrorRequests    int64     `json:"error_requests"`
-	StartTime        time.Time `json:"start_time"`
-	LastRequestTime  time.Time `json:"last_request_time"`
-	StatusCodeCounts map[int]int64 `json:"status_code_counts"`
-	MethodCounts     map[string]int64 `json:"method_counts"`
-}
\ No newline at end of file
+	TotalRequests    int64                   `json:"total_requests"`
+	SuccessRequests  int64                   `json:"success_requests"`
+	ErrorRequests    int64                   `json:"error_requests"`
+	StartTime        time.Time               `json:"start_time"`
+	LastRequestTime  time.Time               `json:"last_request_time"`
+	StatusCodeCounts map[int]int64           `json:"status_code_counts"`
+	MethodCounts     map[string]int64        `json:"method_counts"`
+	Targets          map[string]*TargetStats `json:"targets,omitempty"` // 按上游地址 (scheme://host) 统计
+	Latency          *LatencyPercentiles     `json:"latency,omitempty"` // 所有请求的耗时分位数
+}
+
+// LatencyPercentiles 请求耗时的分位数估算, 单位毫秒
+type LatencyPercentiles struct {
+	P50 float64 `json:"p50_ms"`
+	P95 float64 `json:"p95_ms"`
+	P99 float64 `json:"p99_ms"`
+}
+
+// TargetStats 单个上游地址的统计信息
+type TargetStats struct {
+	TotalRequests   int64               `json:"total_requests"`
+	SuccessRequests int64               `json:"success_requests"`
+	ErrorRequests   int64               `json:"error_requests"`
+	RequestBytes    int64               `json:"request_bytes"`
+	ResponseBytes   int64               `json:"response_bytes"`
+	AvgLatencyMs    float64             `json:"avg_latency_ms"` // 整个请求的平均耗时
+	MaxLatencyMs    float64             `json:"max_latency_ms"`
+	Latency         *LatencyPercentiles `json:"latency,omitempty"`
+	LastRequestTime time.Time           `json:"last_request_time"`
+}
+
+// MetricsPoint 时间序列中一个时间段的请求统计
+type MetricsPoint struct {
+	Time           time.Time `json:"time"` // 时间段的开始
+	Requests       int64     `json:"requests"`
+	Errors         int64     `json:"errors"`
+	RequestRate    float64   `json:"request_rate"`   // 每秒请求数
+	ErrorRate      float64   `json:"error_rate"`     // 错误请求占比 (0-1)
+	AvgLatencyMs   float64   `json:"avg_latency_ms"`
+	LatencyBuckets []int64   `json:"latency_buckets"` // 按 MetricsSeries.LatencyBucketsMs 划分的请求数
+}
+
+// MetricsSeries 请求统计的时间序列
+type MetricsSeries struct {
+	Window           string         `json:"window"`
+	Step             string         `json:"step"`
+	LatencyBucketsMs []float64      `json:"latency_buckets_ms"` // 各耗时区间的上限, 最后一个区间没有上限
+	Points           []MetricsPoint `json:"points"`             // 按时间顺序, 没有请求的时间段也会列出
+}
@@ -0,0 +1,68 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Build information, set at build time with
+// -ldflags "-X ccproxy/version.Version=v1.0.0 -X ccproxy/version.Commit=abc123 -X ccproxy/version.BuildDate=2024-01-01T00:00:00Z"
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"build_date"`
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// Get returns the build information, falling back to the VCS data recorded
+// by the Go toolchain when the values were not injected through ldflags.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+		Platform:  runtime.GOOS + "/" + runtime.GOARCH,
+	}
+
+	if buildInfo, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range buildInfo.Settings {
+			switch setting.Key {
+			case "vcs.revision":
+				if info.Commit == "" {
+					info.Commit = setting.Value
+				}
+			case "vcs.time":
+				if info.BuildDate == "" {
+					info.BuildDate = setting.Value
+				}
+			}
+		}
+	}
+
+	if len(info.Commit) > 12 {
+		info.Commit = info.Commit[:12]
+	}
+	return info
+}
+
+func (i Info) String() string {
+	commit := i.Commit
+	if commit == "" {
+		commit = "unknown"
+	}
+	date := i.BuildDate
+	if date == "" {
+		date = "unknown"
+	}
+	return fmt.Sprintf("ccproxy %s (commit %s, built %s, %s %s)", i.Version, commit, date, i.GoVersion, i.Platform)
+}
@@ -0,0 +1,328 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"ccproxy/config"
+	"ccproxy/proxy"
+	"ccproxy/types"
+	"ccproxy/version"
+)
+
+// Controller is implemented by the process hosting the web server and backs the admin API
+type Controller interface {
+	Status() *Status
+	Reload() error
+	Drain() error
+	SwitchProfile(name string) error
+	SetMaintenance(path string, enabled bool) error
+	SetUpstreamOverride(url, state string) error // state is "up", "down" or "auto"
+	SetCapturePaused(paused bool)                // Requests are proxied but not recorded while paused
+	Routes() []proxy.RouteEntry
+	Upstreams() map[string]*proxy.URLHealth            // Health of every upstream URL
+	ServeProxy(w http.ResponseWriter, r *http.Request) // Handles r like a request to the proxy port
+}
+
+// Status describes the running instance as reported by /api/admin/status
+type Status struct {
+	StartTime   time.Time                   `json:"start_time"`
+	Uptime      string                      `json:"uptime"`
+	Draining    bool                        `json:"draining"`
+	Active      int64                       `json:"active_requests"`
+	ProxyAddr   string                      `json:"proxy_addr"`
+	WebAddr     string                      `json:"web_addr"`
+	ConfigFile  string                      `json:"config_file"`
+	Config      *ConfigSummary              `json:"config"` // Never includes credentials
+	Upstreams   map[string]*proxy.URLHealth `json:"upstreams"`
+	Maintenance []string                    `json:"maintenance"`    // Paths of targets in maintenance
+	Paused      bool                        `json:"capture_paused"` // Request recording paused, see /api/admin/capture
+	Stats       *types.Statistics           `json:"stats"`
+	Version     version.Info                `json:"version"`
+}
+
+// ConfigSummary describes the active configuration for the admin status.
+// Headers, tokens and passwords are left out since ${ENV} values are already
+// expanded in the configuration.
+type ConfigSummary struct {
+	Profile     string          `json:"profile,omitempty"`
+	ReadOnly    bool            `json:"read_only"`
+	Routes      int             `json:"routes"`      // proxy.targets plus proxy.fallback
+	Upstreams   int             `json:"upstreams"`   // Distinct upstream URLs
+	Maintenance bool            `json:"maintenance"` // Some target is in maintenance
+	Targets     []TargetSummary `json:"targets"`     // In matching order
+}
+
+// TargetSummary is a route and its upstream URLs, the keys of Status.Upstreams
+type TargetSummary struct {
+	Route       string   `json:"route"`
+	Methods     []string `json:"methods,omitempty"`
+	URLs        []string `json:"urls"`
+	Maintenance bool     `json:"maintenance,omitempty"`
+}
+
+// SummarizeConfig returns the parts of cfg the status may show
+func SummarizeConfig(cfg *config.Config) *ConfigSummary {
+	summary := &ConfigSummary{
+		Profile:  cfg.Proxy.Profile,
+		ReadOnly: cfg.Web.ReadOnly,
+		Targets:  []TargetSummary{},
+	}
+	upstreams := map[string]bool{}
+	for _, target := range cfg.RouteTargets() {
+		summary.Targets = append(summary.Targets, TargetSummary{
+			Route:       target.Route(),
+			Methods:     target.Methods,
+			URLs:        append([]string{}, target.TargetURLs...),
+			Maintenance: target.InMaintenance(),
+		})
+		summary.Maintenance = summary.Maintenance || target.InMaintenance()
+		for _, url := range target.TargetURLs {
+			upstreams[url] = true
+		}
+	}
+	summary.Routes = len(summary.Targets)
+	summary.Upstreams = len(upstreams)
+	return summary
+}
+
+// SetController enables the admin API
+func (w *WebServer) SetController(controller Controller) {
+	w.controller = controller
+}
+
+// adminOnly guards admin endpoints. With web.admin_token configured a matching
+// bearer token or X-Admin-Token header is required, otherwise only loopback
+// clients are accepted.
+func (w *WebServer) adminOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		if w.controller == nil {
+			http.Error(writer, "Admin API not available", http.StatusServiceUnavailable)
+			return
+		}
+
+		token := w.currentConfig().Web.AdminToken
+		if token == "" {
+			if !isLoopback(request.RemoteAddr) {
+				http.Error(writer, "Forbidden", http.StatusForbidden)
+				return
+			}
+		} else {
+			// X-Admin-Token leaves the Authorization header to web.auth
+			provided := request.Header.Get("X-Admin-Token")
+			if provided == "" {
+				provided = strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+			}
+			if !secureEqual(provided, token) {
+				writer.Header().Set("WWW-Authenticate", `Bearer realm="ccproxy-admin"`)
+				http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+
+		next(writer, request)
+	}
+}
+
+func isLoopback(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (w *WebServer) handleAdminStatus(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(writer).Encode(w.controller.Status()); err != nil {
+		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+func (w *WebServer) handleAdminReload(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := w.controller.Reload(); err != nil {
+		http.Error(writer, fmt.Sprintf("Failed to reload configuration: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Configuration reloaded successfully",
+	}
+	json.NewEncoder(writer).Encode(response)
+}
+
+func (w *WebServer) handleAdminDrain(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := w.controller.Drain(); err != nil {
+		http.Error(writer, err.Error(), http.StatusConflict)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response := map[string]interface{}{
+		"success": true,
+		"message": "Proxy server is draining",
+	}
+	json.NewEncoder(writer).Encode(response)
+}
+
+// handleAdminProfile lists the configured profiles on GET and switches the
+// active one on POST with {"profile": "name"}
+func (w *WebServer) handleAdminProfile(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+	case "POST":
+		var body struct {
+			Profile string `json:"profile"`
+		}
+		if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+			http.Error(writer, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := w.controller.SwitchProfile(body.Profile); err != nil {
+			http.Error(writer, fmt.Sprintf("Failed to switch profile: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	cfg := w.currentConfig()
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"success":  true,
+		"profile":  cfg.Proxy.Profile,
+		"profiles": cfg.ProfileNames(),
+		"targets":  len(cfg.Proxy.Targets),
+	})
+}
+
+// handleAdminMaintenance lists the targets in maintenance on GET and turns
+// maintenance of one target on or off on POST with {"path": "/v1/*", "enabled": true}
+func (w *WebServer) handleAdminMaintenance(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+	case "POST":
+		var body struct {
+			Path    string `json:"path"`
+			Enabled bool   `json:"enabled"`
+		}
+		if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+			http.Error(writer, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := w.controller.SetMaintenance(body.Path, body.Enabled); err != nil {
+			http.Error(writer, fmt.Sprintf("Failed to set maintenance: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"success":     true,
+		"maintenance": w.currentConfig().MaintenancePaths(),
+	})
+}
+
+// handleAdminCapture reports on GET and sets on POST with {"paused": true}
+// whether requests are recorded. Paused requests are still proxied and counted,
+// their bodies are neither read nor kept in the history.
+func (w *WebServer) handleAdminCapture(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+	case "POST":
+		var body struct {
+			Paused bool `json:"paused"`
+		}
+		if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+			http.Error(writer, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		w.controller.SetCapturePaused(body.Paused)
+	default:
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"success": true,
+		"paused":  w.controller.Status().Paused,
+	})
+}
+
+// handleAdminUpstreams lists the upstream URLs forced up or down on GET and
+// sets the state of one URL on POST with {"url": "https://...", "state": "down"},
+// "auto" hands it back to the health checks
+func (w *WebServer) handleAdminUpstreams(writer http.ResponseWriter, request *http.Request) {
+	switch request.Method {
+	case "GET":
+	case "POST":
+		var body struct {
+			URL   string `json:"url"`
+			State string `json:"state"`
+		}
+		if err := json.NewDecoder(request.Body).Decode(&body); err != nil {
+			http.Error(writer, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := w.controller.SetUpstreamOverride(body.URL, body.State); err != nil {
+			http.Error(writer, fmt.Sprintf("Failed to set upstream state: %v", err), http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	overrides := map[string]string{}
+	for url, health := range w.controller.Upstreams() {
+		if health.Override != "" {
+			overrides[url] = health.Override
+		}
+	}
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"success":   true,
+		"overrides": overrides,
+	})
+}
+
+// handleAdminRoutes shows the route table in the order requests are matched
+func (w *WebServer) handleAdminRoutes(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"success": true,
+		"routes":  w.controller.Routes(),
+	})
+}
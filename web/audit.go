@@ -0,0 +1,130 @@
+package web
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"ccproxy/diff"
+)
+
+// auditFileName is kept next to the configuration file it describes
+const auditFileName = "config-audit.log"
+
+// AuditEntry records one configuration change made through the web API
+type AuditEntry struct {
+	Time         time.Time `json:"time"`
+	User         string    `json:"user"`
+	RemoteAddr   string    `json:"remote_addr"`
+	ForwardedFor string    `json:"forwarded_for,omitempty"`
+	UserAgent    string    `json:"user_agent,omitempty"`
+	ConfigFile   string    `json:"config_file"`
+	Diff         string    `json:"diff"`
+}
+
+func auditPath(configFile string) string {
+	return filepath.Join(filepath.Dir(configFile), auditFileName)
+}
+
+// recordConfigChange appends an audit entry for a saved configuration
+func (w *WebServer) recordConfigChange(request *http.Request, configFile string, before, after []byte) {
+	entry := &AuditEntry{
+		Time:         time.Now(),
+		User:         authUser(request, w.currentConfig().Web.Auth),
+		RemoteAddr:   request.RemoteAddr,
+		ForwardedFor: request.Header.Get("X-Forwarded-For"),
+		UserAgent:    request.UserAgent(),
+		ConfigFile:   configFile,
+		Diff:         redactDiff(diff.Unified(string(before), string(after), 3)),
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		log.Printf("[ERROR] Failed to encode config audit entry: %v", err)
+		return
+	}
+
+	w.auditMu.Lock()
+	defer w.auditMu.Unlock()
+
+	file, err := os.OpenFile(auditPath(configFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Printf("[ERROR] Failed to open config audit log: %v", err)
+		return
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		log.Printf("[ERROR] Failed to write config audit log: %v", err)
+	}
+}
+
+// handleConfigAudit returns recorded configuration changes, newest first
+func (w *WebServer) handleConfigAudit(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	limit := 50
+	if parsed, err := strconv.Atoi(request.URL.Query().Get("limit")); err == nil && parsed > 0 {
+		limit = parsed
+	}
+
+	configFile, err := w.getConfigFilePath()
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Failed to get config file path: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	entries, err := readAuditLog(auditPath(configFile), limit)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Failed to read config audit log: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(entries)
+}
+
+func readAuditLog(path string, limit int) ([]*AuditEntry, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return []*AuditEntry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []*AuditEntry
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var entry AuditEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		// Entries written before diffs were redacted
+		entry.Diff = redactDiff(entry.Diff)
+		entries = append(entries, &entry)
+		if len(entries) > limit {
+			entries = entries[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	result := make([]*AuditEntry, len(entries))
+	for i, entry := range entries {
+		result[len(entries)-1-i] = entry
+	}
+	return result, nil
+}
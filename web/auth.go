@@ -0,0 +1,117 @@
+package web
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"ccproxy/config"
+	"ccproxy/websocket"
+)
+
+// authCookie carries the bearer token for browsers, which cannot attach
+// headers to page loads or WebSocket connections
+const authCookie = "ccproxy_token"
+
+// requireAuth guards dashboard routes with the authentication configured in web.auth
+func (w *WebServer) requireAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		auth := w.currentConfig().Web.Auth
+
+		if !authenticated(request, auth) {
+			switch auth.Type {
+			case "basic":
+				writer.Header().Set("WWW-Authenticate", `Basic realm="ccproxy", charset="UTF-8"`)
+				http.Error(writer, "Unauthorized", http.StatusUnauthorized)
+			case "bearer":
+				writer.Header().Set("WWW-Authenticate", `Bearer realm="ccproxy"`)
+				http.Error(writer, "Unauthorized: open the dashboard with ?token=<web.auth.token>", http.StatusUnauthorized)
+			}
+			return
+		}
+
+		// A token in the query string, e.g. a link opened from the tray, is
+		// exchanged for a cookie and removed from the address bar
+		if token := request.URL.Query().Get("token"); auth.Type == "bearer" && token != "" && secureEqual(token, auth.Token) {
+			http.SetCookie(writer, &http.Cookie{
+				Name:     authCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   request.TLS != nil,
+				SameSite: http.SameSiteStrictMode,
+			})
+			if request.Method == "GET" && request.URL.Path == "/" {
+				http.Redirect(writer, request, "/", http.StatusFound)
+				return
+			}
+		}
+
+		next(writer, request)
+	}
+}
+
+// authenticated reports whether request carries the credentials of web.auth,
+// the bearer token may also be given as the token query parameter
+func authenticated(request *http.Request, auth config.WebAuth) bool {
+	switch auth.Type {
+	case "basic":
+		username, password, ok := request.BasicAuth()
+		return ok && secureEqual(username, auth.Username) && secureEqual(password, auth.Password)
+	case "bearer":
+		if token := request.URL.Query().Get("token"); token != "" && secureEqual(token, auth.Token) {
+			return true
+		}
+		return secureEqual(bearerToken(request), auth.Token)
+	}
+	return true
+}
+
+// handleWS serves the live log stream on /ws. Clients connecting without
+// credentials, such as dashboards on other origins which do not get the auth
+// cookie, are held back until their first message authenticates them.
+func (w *WebServer) handleWS(writer http.ResponseWriter, request *http.Request) {
+	if authenticated(request, w.currentConfig().Web.Auth) {
+		w.hub.ServeWS(writer, request, nil)
+		return
+	}
+	w.hub.ServeWS(writer, request, func(msg websocket.AuthMessage) bool {
+		// Read again, the token may have been reset since the upgrade
+		auth := w.currentConfig().Web.Auth
+		switch auth.Type {
+		case "basic":
+			return secureEqual(msg.Username, auth.Username) && secureEqual(msg.Password, auth.Password)
+		case "bearer":
+			return secureEqual(msg.Token, auth.Token)
+		}
+		return true
+	})
+}
+
+// bearerToken returns the token from the Authorization header or the auth cookie
+func bearerToken(request *http.Request) string {
+	if header := request.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimPrefix(header, "Bearer ")
+	}
+	if cookie, err := request.Cookie(authCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
+
+// authUser names the authenticated dashboard user for audit records
+func authUser(request *http.Request, auth config.WebAuth) string {
+	switch auth.Type {
+	case "basic":
+		if username, _, ok := request.BasicAuth(); ok {
+			return username
+		}
+	case "bearer":
+		return "token"
+	}
+	return "anonymous"
+}
+
+func secureEqual(provided, expected string) bool {
+	return subtle.ConstantTimeCompare([]byte(provided), []byte(expected)) == 1
+}
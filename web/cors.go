@@ -0,0 +1,104 @@
+package web
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+var (
+	defaultCORSMethods = []string{"GET", "POST", "DELETE", "OPTIONS"}
+	defaultCORSHeaders = []string{"Authorization", "Content-Type", "X-Admin-Token"}
+)
+
+// api wraps API and WebSocket routes with CORS handling and authentication
+func (w *WebServer) api(handler http.HandlerFunc) http.HandlerFunc {
+	return w.cors(w.requireAuth(handler))
+}
+
+// cors applies web.cors to API routes. Preflight requests are answered here,
+// before authentication, since browsers send them without credentials.
+func (w *WebServer) cors(next http.HandlerFunc) http.HandlerFunc {
+	return func(writer http.ResponseWriter, request *http.Request) {
+		origin := request.Header.Get("Origin")
+		if origin == "" || sameOrigin(request, origin) {
+			next(writer, request)
+			return
+		}
+
+		cors := w.currentConfig().Web.CORS
+		allowed := originAllowed(cors.AllowedOrigins, origin)
+
+		// WebSocket connections are not subject to CORS in browsers, so check the origin here
+		if strings.EqualFold(request.Header.Get("Upgrade"), "websocket") {
+			if !allowed {
+				http.Error(writer, "Origin not allowed", http.StatusForbidden)
+				return
+			}
+			next(writer, request)
+			return
+		}
+
+		if !allowed {
+			switch request.Method {
+			case "GET", "HEAD":
+				// Browsers withhold the response from the page without CORS headers
+				next(writer, request)
+			case "OPTIONS":
+				writer.WriteHeader(http.StatusNoContent)
+			default:
+				// Refuse cross-site form posts that would otherwise change state
+				http.Error(writer, "Origin not allowed", http.StatusForbidden)
+			}
+			return
+		}
+
+		header := writer.Header()
+		header.Add("Vary", "Origin")
+		if len(cors.AllowedOrigins) == 1 && cors.AllowedOrigins[0] == "*" && !cors.AllowCredentials {
+			header.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			header.Set("Access-Control-Allow-Origin", origin)
+		}
+		if cors.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+		header.Set("Access-Control-Expose-Headers", "X-Config-Path, X-Read-Only")
+
+		if request.Method == "OPTIONS" && request.Header.Get("Access-Control-Request-Method") != "" {
+			methods := cors.AllowedMethods
+			if len(methods) == 0 {
+				methods = defaultCORSMethods
+			}
+			headers := cors.AllowedHeaders
+			if len(headers) == 0 {
+				headers = defaultCORSHeaders
+			}
+			header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+			header.Set("Access-Control-Allow-Headers", strings.Join(headers, ", "))
+			if cors.MaxAge > 0 {
+				header.Set("Access-Control-Max-Age", strconv.Itoa(cors.MaxAge))
+			}
+			writer.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		next(writer, request)
+	}
+}
+
+func originAllowed(allowedOrigins []string, origin string) bool {
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin reports whether origin points at the host serving the request
+func sameOrigin(request *http.Request, origin string) bool {
+	u, err := url.Parse(origin)
+	return err == nil && strings.EqualFold(u.Host, request.Host)
+}
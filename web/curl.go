@@ -0,0 +1,173 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"ccproxy/storage"
+	"ccproxy/websocket"
+)
+
+// Headers curl sets itself or that would break the reproduced request
+var curlSkipHeaders = map[string]bool{
+	"Host":              true,
+	"Content-Length":    true,
+	"Accept-Encoding":   true,
+	"Connection":        true,
+	"Transfer-Encoding": true,
+	"X-Request-Id":      true,
+}
+
+// handleHistoryItem serves the per-request history APIs under /api/history/{id}
+func (w *WebServer) handleHistoryItem(writer http.ResponseWriter, request *http.Request) {
+	id, action, _ := strings.Cut(strings.TrimPrefix(request.URL.Path, "/api/history/"), "/")
+	if id == "" {
+		http.NotFound(writer, request)
+		return
+	}
+
+	switch action {
+	case "":
+		w.handleHistoryEntry(writer, request, id)
+	case "curl":
+		w.handleHistoryCurl(writer, request, id)
+	case "replay":
+		w.handleHistoryReplay(writer, request, id)
+	default:
+		http.NotFound(writer, request)
+	}
+}
+
+// findHistory looks up a stored request by its request ID
+func (w *WebServer) findHistory(id string) (*websocket.LogMessage, error) {
+	history, err := w.hub.QueryHistory(storage.HistoryFilter{IDs: []string{id}, Limit: 1})
+	if err != nil || len(history) == 0 {
+		return nil, err
+	}
+	return history[0], nil
+}
+
+// handleHistoryCurl serves GET /api/history/{id}/curl, a curl command sending
+// the stored request to its upstream again. Options: shell=posix|powershell,
+// placeholders=false keeps [REDACTED] instead of environment variables, and
+// via=proxy sends it through ccproxy instead.
+func (w *WebServer) handleHistoryCurl(writer http.ResponseWriter, request *http.Request, id string) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := request.URL.Query()
+	shell := query.Get("shell")
+	if shell == "" {
+		shell = "posix"
+	}
+	if shell != "posix" && shell != "powershell" {
+		http.Error(writer, fmt.Sprintf("unsupported shell %q (expected posix or powershell)", shell), http.StatusBadRequest)
+		return
+	}
+
+	msg, err := w.findHistory(id)
+	if err != nil {
+		http.Error(writer, "Failed to get history", http.StatusInternalServerError)
+		return
+	}
+	if msg == nil {
+		http.Error(writer, "request not found", http.StatusNotFound)
+		return
+	}
+
+	targetURL := msg.TargetURL
+	if query.Get("via") == "proxy" || targetURL == "" {
+		cfg := w.currentConfig()
+		host := cfg.Server.Host
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		proxyURL := url.URL{Scheme: "http", Host: host + ":" + cfg.Server.Port, Path: msg.Path, RawQuery: msg.Query}
+		targetURL = proxyURL.String()
+	}
+
+	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(writer, renderCurl(msg, targetURL, shell, query.Get("placeholders") != "false"))
+}
+
+// renderCurl formats msg as a curl command line for shell. Redacted header
+// values become environment variables named after the header when
+// placeholders is set, e.g. $X_API_KEY.
+func renderCurl(msg *websocket.LogMessage, targetURL, shell string, placeholders bool) string {
+	quote, variable, program, continuation := posixQuote, "$%s", "curl", " \\\n  "
+	if shell == "powershell" {
+		quote, variable, program, continuation = powershellQuote, "$env:%s", "curl.exe", " `\n  "
+	}
+
+	var notes []string
+	args := []string{program}
+	if (msg.RequestBody == "" && msg.Method != "GET") || (msg.RequestBody != "" && msg.Method != "POST") {
+		args = append(args, "-X "+msg.Method)
+	}
+	args = append(args, quote(targetURL))
+
+	names := make([]string, 0, len(msg.RequestHeaders))
+	for name := range msg.RequestHeaders {
+		if !curlSkipHeaders[http.CanonicalHeaderKey(name)] {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := msg.RequestHeaders[name]
+		if value == redactedValue && placeholders {
+			env := fmt.Sprintf(variable, strings.ToUpper(strings.ReplaceAll(name, "-", "_")))
+			args = append(args, "-H "+interpolate(name+": "+env, shell))
+			notes = append(notes, fmt.Sprintf("%s is read from %s", name, env))
+			continue
+		}
+		args = append(args, "-H "+quote(name+": "+value))
+	}
+
+	if msg.RequestBody != "" {
+		if strings.HasPrefix(msg.RequestBody, binaryDataPrefix) {
+			notes = append(notes, "the binary request body was not logged")
+		} else {
+			if strings.Contains(msg.RequestBody, "\n[TRUNCATED - ") {
+				notes = append(notes, "the request body was truncated in the log")
+			}
+			if strings.Contains(msg.RequestBody, redactedValue) {
+				notes = append(notes, "parts of the request body were redacted")
+			}
+			args = append(args, "--data-raw "+quote(msg.RequestBody))
+		}
+	}
+	if strings.Contains(headerValue(msg.ResponseHeaders, "Content-Type"), "text/event-stream") {
+		args = append(args, "--no-buffer")
+	}
+
+	var b strings.Builder
+	for _, note := range notes {
+		b.WriteString("# " + note + "\n")
+	}
+	b.WriteString(strings.Join(args, continuation))
+	return b.String()
+}
+
+// redactedValue replaces masked header values and body matches in the log
+const redactedValue = "[REDACTED]"
+
+// interpolate quotes s so the shell expands its variables
+func interpolate(s, shell string) string {
+	if shell == "powershell" {
+		return `"` + strings.ReplaceAll(s, `"`, "`\"") + `"`
+	}
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+func posixQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func powershellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
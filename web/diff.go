@@ -0,0 +1,295 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ccproxy/websocket"
+)
+
+// maxBodyChanges limits the body changes reported for each side
+const maxBodyChanges = 500
+
+// historyDiff is the answer of GET /api/history/diff
+type historyDiff struct {
+	A               string         `json:"a"`
+	B               string         `json:"b"`
+	Fields          []valueChange  `json:"fields"`           // Top-level fields such as status_code and target_url
+	RequestHeaders  []valueChange  `json:"request_headers"`  // By header name
+	ResponseHeaders []valueChange  `json:"response_headers"` // By header name
+	RequestBody     bodyDiff       `json:"request_body"`
+	ResponseBody    bodyDiff       `json:"response_body"`
+	Timing          []timingChange `json:"timing"`
+}
+
+// valueChange is one difference, the value is null on the side it was added or removed
+type valueChange struct {
+	Name   string      `json:"name"`   // Field, header name or JSON pointer into a body
+	Change string      `json:"change"` // added, removed or changed
+	A      interface{} `json:"a"`
+	B      interface{} `json:"b"`
+}
+
+// bodyDiff compares two bodies by JSON value when both parse as JSON,
+// otherwise as a whole
+type bodyDiff struct {
+	Equal     bool          `json:"equal"`
+	JSON      bool          `json:"json"`
+	Changes   []valueChange `json:"changes,omitempty"`
+	Truncated bool          `json:"truncated,omitempty"` // More than maxBodyChanges differences
+}
+
+// timingChange compares one duration in milliseconds, missing phases are null
+type timingChange struct {
+	Name  string   `json:"name"`
+	A     *float64 `json:"a"`
+	B     *float64 `json:"b"`
+	Delta *float64 `json:"delta"` // b - a
+}
+
+// handleHistoryDiff serves GET /api/history/diff?a={id}&b={id}. Headers
+// listed in ignore (comma separated, default X-Request-Id,Date) are left out
+// as they differ for every request.
+func (w *WebServer) handleHistoryDiff(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	query := request.URL.Query()
+	if query.Get("a") == "" || query.Get("b") == "" {
+		http.Error(writer, "a and b request IDs are required", http.StatusBadRequest)
+		return
+	}
+
+	var entries [2]*websocket.LogMessage
+	for i, id := range []string{query.Get("a"), query.Get("b")} {
+		msg, err := w.findHistory(id)
+		if err != nil {
+			http.Error(writer, "Failed to get history", http.StatusInternalServerError)
+			return
+		}
+		if msg == nil {
+			http.Error(writer, fmt.Sprintf("request %s not found", id), http.StatusNotFound)
+			return
+		}
+		entries[i] = msg
+	}
+
+	ignore := map[string]bool{}
+	names := "X-Request-Id,Date"
+	if query.Has("ignore") {
+		names = query.Get("ignore")
+	}
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			ignore[http.CanonicalHeaderKey(name)] = true
+		}
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(diffHistory(entries[0], entries[1], ignore))
+}
+
+// diffHistory compares two history entries
+func diffHistory(a, b *websocket.LogMessage, ignoreHeaders map[string]bool) historyDiff {
+	diff := historyDiff{
+		A:               a.RequestID,
+		B:               b.RequestID,
+		Fields:          []valueChange{},
+		RequestHeaders:  diffHeaders(a.RequestHeaders, b.RequestHeaders, ignoreHeaders),
+		ResponseHeaders: diffHeaders(a.ResponseHeaders, b.ResponseHeaders, ignoreHeaders),
+		RequestBody:     diffBodies(a.RequestBody, b.RequestBody),
+		ResponseBody:    diffBodies(a.ResponseBody, b.ResponseBody),
+	}
+
+	fields := []struct {
+		name string
+		a, b interface{}
+	}{
+		{"method", a.Method, b.Method},
+		{"host", a.Host, b.Host},
+		{"path", a.Path, b.Path},
+		{"query", a.Query, b.Query},
+		{"target_url", a.TargetURL, b.TargetURL},
+		{"status_code", a.StatusCode, b.StatusCode},
+		{"error", a.Error, b.Error},
+		{"error_type", a.ErrorType, b.ErrorType},
+		{"request_size", a.RequestSize, b.RequestSize},
+		{"response_size", a.ResponseSize, b.ResponseSize},
+	}
+	for _, field := range fields {
+		if field.a != field.b {
+			diff.Fields = append(diff.Fields, valueChange{Name: field.name, Change: "changed", A: field.a, B: field.b})
+		}
+	}
+
+	timings := []struct{ name, a, b string }{
+		{"duration", a.Duration, b.Duration},
+		{"dns_lookup", a.DNSLookupDuration, b.DNSLookupDuration},
+		{"connect", a.ConnectDuration, b.ConnectDuration},
+		{"tls_handshake", a.TLSHandshakeDuration, b.TLSHandshakeDuration},
+		{"first_byte", a.FirstByteDuration, b.FirstByteDuration},
+	}
+	for _, timing := range timings {
+		change := timingChange{Name: timing.name, A: durationMs(timing.a), B: durationMs(timing.b)}
+		if change.A != nil && change.B != nil {
+			delta := math.Round((*change.B-*change.A)*1000) / 1000
+			change.Delta = &delta
+		}
+		diff.Timing = append(diff.Timing, change)
+	}
+	return diff
+}
+
+// durationMs parses a logged duration, nil when it was not recorded
+func durationMs(value string) *float64 {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return nil
+	}
+	ms := float64(d.Microseconds()) / 1000
+	return &ms
+}
+
+// diffHeaders compares headers case-insensitively, sorted by name
+func diffHeaders(a, b map[string]string, ignore map[string]bool) []valueChange {
+	values := func(headers map[string]string) map[string]string {
+		canonical := make(map[string]string, len(headers))
+		for name, value := range headers {
+			if name = http.CanonicalHeaderKey(name); !ignore[name] {
+				canonical[name] = value
+			}
+		}
+		return canonical
+	}
+	left, right := values(a), values(b)
+
+	changes := []valueChange{}
+	for name, value := range left {
+		if other, ok := right[name]; !ok {
+			changes = append(changes, valueChange{Name: name, Change: "removed", A: value})
+		} else if other != value {
+			changes = append(changes, valueChange{Name: name, Change: "changed", A: value, B: other})
+		}
+	}
+	for name, value := range right {
+		if _, ok := left[name]; !ok {
+			changes = append(changes, valueChange{Name: name, Change: "added", B: value})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
+
+// diffBodies compares two bodies, JSON changes are named by JSON pointer
+// (RFC 6901) such as /messages/0/content
+func diffBodies(a, b string) bodyDiff {
+	if a == b {
+		return bodyDiff{Equal: true, JSON: json.Valid([]byte(a)) && a != ""}
+	}
+
+	left, leftErr := decodeJSON(a)
+	right, rightErr := decodeJSON(b)
+	if leftErr != nil || rightErr != nil {
+		return bodyDiff{Changes: []valueChange{{Name: "", Change: "changed", A: a, B: b}}}
+	}
+
+	diff := bodyDiff{JSON: true}
+	diffJSON("", left, right, &diff)
+	diff.Equal = len(diff.Changes) == 0
+	return diff
+}
+
+func decodeJSON(body string) (interface{}, error) {
+	decoder := json.NewDecoder(strings.NewReader(body))
+	decoder.UseNumber()
+	var value interface{}
+	if err := decoder.Decode(&value); err != nil {
+		return nil, err
+	}
+	if decoder.More() {
+		return nil, fmt.Errorf("trailing data after JSON value")
+	}
+	return value, nil
+}
+
+// diffJSON appends the differences between a and b below pointer to diff
+func diffJSON(pointer string, a, b interface{}, diff *bodyDiff) {
+	add := func(change valueChange) {
+		if len(diff.Changes) >= maxBodyChanges {
+			diff.Truncated = true
+			return
+		}
+		diff.Changes = append(diff.Changes, change)
+	}
+
+	switch left := a.(type) {
+	case map[string]interface{}:
+		right, ok := b.(map[string]interface{})
+		if !ok {
+			break
+		}
+		keys := make([]string, 0, len(left)+len(right))
+		for key := range left {
+			keys = append(keys, key)
+		}
+		for key := range right {
+			if _, ok := left[key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			child := pointer + "/" + strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+			leftValue, inLeft := left[key]
+			rightValue, inRight := right[key]
+			switch {
+			case !inRight:
+				add(valueChange{Name: child, Change: "removed", A: leftValue})
+			case !inLeft:
+				add(valueChange{Name: child, Change: "added", B: rightValue})
+			default:
+				diffJSON(child, leftValue, rightValue, diff)
+			}
+		}
+		return
+	case []interface{}:
+		right, ok := b.([]interface{})
+		if !ok {
+			break
+		}
+		for i := 0; i < max(len(left), len(right)); i++ {
+			child := pointer + "/" + strconv.Itoa(i)
+			switch {
+			case i >= len(right):
+				add(valueChange{Name: child, Change: "removed", A: left[i]})
+			case i >= len(left):
+				add(valueChange{Name: child, Change: "added", B: right[i]})
+			default:
+				diffJSON(child, left[i], right[i], diff)
+			}
+		}
+		return
+	}
+
+	if !jsonEqual(a, b) {
+		add(valueChange{Name: pointer, Change: "changed", A: a, B: b})
+	}
+}
+
+// jsonEqual compares two decoded values, numbers by their text
+func jsonEqual(a, b interface{}) bool {
+	left, err := json.Marshal(a)
+	if err != nil {
+		return false
+	}
+	right, err := json.Marshal(b)
+	return err == nil && bytes.Equal(left, right)
+}
@@ -0,0 +1,321 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"ccproxy/storage"
+	"ccproxy/version"
+	"ccproxy/websocket"
+)
+
+// binaryDataPrefix starts the summary logged instead of binary bodies
+const binaryDataPrefix = "[BINARY DATA - "
+
+// HTTP Archive 1.2, http://www.softwareishard.com/blog/har-12-spec/
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime string      `json:"startedDateTime"`
+	Time            float64     `json:"time"`
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+	Comment         string      `json:"comment,omitempty"`
+	RequestID       string      `json:"_requestId,omitempty"`
+	Error           string      `json:"_error,omitempty"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+}
+
+type harTimings struct {
+	Blocked float64 `json:"blocked"`
+	DNS     float64 `json:"dns"`
+	Connect float64 `json:"connect"`
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+	SSL     float64 `json:"ssl"`
+}
+
+// handleHistoryExport serves GET /api/history/export?format=har with the
+// history filters of parseHistoryFilter
+func (w *WebServer) handleHistoryExport(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if format := request.URL.Query().Get("format"); format != "" && format != "har" {
+		http.Error(writer, fmt.Sprintf("unsupported format %q (expected har)", format), http.StatusBadRequest)
+		return
+	}
+
+	filter, err := parseHistoryFilter(request.URL.Query(), 1000, 10000)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	history, err := w.hub.QueryHistory(filter)
+	if err != nil {
+		http.Error(writer, "Failed to get history", http.StatusInternalServerError)
+		return
+	}
+
+	// HAR entries are in chronological order
+	archive := harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "ccproxy", Version: version.Get().Version},
+		Entries: make([]harEntry, 0, len(history)),
+	}
+	for i := len(history) - 1; i >= 0; i-- {
+		archive.Entries = append(archive.Entries, newHAREntry(history[i]))
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="ccproxy-%s.har"`, time.Now().Format("20060102-150405")))
+	if err := json.NewEncoder(writer).Encode(map[string]harLog{"log": archive}); err != nil {
+		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
+	}
+}
+
+// parseHistoryFilter reads the history filters shared by the history APIs:
+// id (repeated or comma separated), method, path, status (429 or 5xx),
+// target, error_type, q (full-text search of the bodies), since and until (RFC 3339, "2006-01-02 15:04:05" or a
+// duration such as 1h before now) and limit
+func parseHistoryFilter(query url.Values, defaultLimit, maxLimit int) (storage.HistoryFilter, error) {
+	filter := storage.HistoryFilter{
+		Method:    query.Get("method"),
+		Path:      query.Get("path"),
+		Status:    query.Get("status"),
+		Target:    query.Get("target"),
+		ErrorType: query.Get("error_type"),
+		Text:      query.Get("q"),
+		Limit:     defaultLimit,
+	}
+	for _, ids := range query["id"] {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				filter.IDs = append(filter.IDs, id)
+			}
+		}
+	}
+
+	var err error
+	if filter.Since, err = parseHistoryTime(query.Get("since")); err != nil {
+		return filter, fmt.Errorf("invalid since: %v", err)
+	}
+	if filter.Until, err = parseHistoryTime(query.Get("until")); err != nil {
+		return filter, fmt.Errorf("invalid until: %v", err)
+	}
+	if limit := query.Get("limit"); limit != "" {
+		if filter.Limit, err = strconv.Atoi(limit); err != nil || filter.Limit <= 0 {
+			return filter, fmt.Errorf("invalid limit %q", limit)
+		}
+	}
+	filter.Limit = min(filter.Limit, maxLimit)
+	return filter, nil
+}
+
+func parseHistoryTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is not a time or duration", value)
+}
+
+func newHAREntry(msg *websocket.LogMessage) harEntry {
+	started, err := time.ParseInLocation("2006-01-02 15:04:05.000", msg.Timestamp, time.Local)
+	if err != nil {
+		started = time.Now()
+	}
+
+	// The history keeps the URL sent upstream, requests that were not
+	// forwarded only have the path the client used
+	requestURL := msg.TargetURL
+	if requestURL == "" {
+		requestURL = "http://localhost" + msg.Path
+		if msg.Query != "" {
+			requestURL += "?" + msg.Query
+		}
+	}
+	var queryString []harNameValue
+	if u, err := url.Parse(requestURL); err == nil {
+		for name, values := range u.Query() {
+			for _, value := range values {
+				queryString = append(queryString, harNameValue{name, value})
+			}
+		}
+		sort.Slice(queryString, func(i, j int) bool { return queryString[i].Name < queryString[j].Name })
+	}
+
+	entry := harEntry{
+		StartedDateTime: started.Format(time.RFC3339Nano),
+		Request: harRequest{
+			Method:      msg.Method,
+			URL:         requestURL,
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(msg.RequestHeaders),
+			QueryString: append([]harNameValue{}, queryString...),
+			HeadersSize: -1,
+			BodySize:    msg.RequestSize,
+		},
+		Response: harResponse{
+			Status:      msg.StatusCode,
+			StatusText:  http.StatusText(msg.StatusCode),
+			HTTPVersion: "HTTP/1.1",
+			Cookies:     []harNameValue{},
+			Headers:     harHeaders(msg.ResponseHeaders),
+			Content: harContent{
+				Size:     msg.ResponseSize,
+				MimeType: headerValue(msg.ResponseHeaders, "Content-Type"),
+				Text:     msg.ResponseBody,
+			},
+			RedirectURL: headerValue(msg.ResponseHeaders, "Location"),
+			HeadersSize: -1,
+			BodySize:    msg.ResponseSize,
+		},
+		RequestID: msg.RequestID,
+		Error:     msg.Error,
+	}
+	if msg.RequestBody != "" {
+		mimeType := headerValue(msg.RequestHeaders, "Content-Type")
+		if mimeType == "" {
+			mimeType = "application/octet-stream"
+		}
+		entry.Request.PostData = &harPostData{MimeType: mimeType, Text: msg.RequestBody}
+	}
+	if entry.Response.Content.MimeType == "" {
+		entry.Response.Content.MimeType = "x-unknown"
+	}
+
+	// Binary bodies are logged as a summary only, which is no valid content
+	var comments []string
+	if strings.HasPrefix(msg.RequestBody, binaryDataPrefix) {
+		entry.Request.PostData.Text = ""
+		comments = append(comments, "request "+msg.RequestBody)
+	}
+	if strings.HasPrefix(msg.ResponseBody, binaryDataPrefix) {
+		entry.Response.Content.Text = ""
+		comments = append(comments, "response "+msg.ResponseBody)
+	}
+	entry.Comment = strings.Join(comments, "; ")
+
+	entry.Timings, entry.Time = harTimingsOf(msg)
+	return entry
+}
+
+// harTimingsOf splits the request time into the HAR phases, connect includes
+// the TLS handshake as the specification requires
+func harTimingsOf(msg *websocket.LogMessage) (harTimings, float64) {
+	ms := func(value string) float64 {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return -1
+		}
+		return float64(d.Microseconds()) / 1000
+	}
+
+	total := ms(msg.Duration)
+	if total < 0 {
+		total = 0
+	}
+	timings := harTimings{Blocked: -1, DNS: ms(msg.DNSLookupDuration), Connect: -1, SSL: ms(msg.TLSHandshakeDuration)}
+	if tcp := ms(msg.ConnectDuration); tcp >= 0 {
+		timings.Connect = tcp + max(timings.SSL, 0)
+	}
+
+	setup := max(timings.DNS, 0) + max(timings.Connect, 0)
+	if firstByte := ms(msg.FirstByteDuration); firstByte >= 0 {
+		timings.Wait = max(firstByte-setup, 0)
+		timings.Receive = max(total-setup-timings.Wait, 0)
+	} else {
+		timings.Wait = max(total-setup, 0)
+	}
+
+	round := func(v float64) float64 { return math.Round(v*1000) / 1000 }
+	timings.Connect, timings.Wait, timings.Receive = round(timings.Connect), round(timings.Wait), round(timings.Receive)
+	return timings, round(setup + timings.Wait + timings.Receive)
+}
+
+func harHeaders(headers map[string]string) []harNameValue {
+	list := make([]harNameValue, 0, len(headers))
+	for name, value := range headers {
+		list = append(list, harNameValue{name, value})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// headerValue looks a header up case-insensitively
+func headerValue(headers map[string]string, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,81 @@
+package web
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	// secretLine sets a credential in YAML, JSON or TOML: keys ending in token,
+	// password, secret or key such as admin_token and x-api-key, or an
+	// Authorization header
+	secretLine = regexp.MustCompile(`(?i)^(\s*(?:- )?"?[\w.-]*(?:token|password|secret|key|key_id|authorization)"?\s*[:=]\s*)\S.*?(,?)$`)
+	// valueLine is any key and value, used inside headers blocks
+	valueLine = regexp.MustCompile(`^(\s*(?:- )?"?[^\s"':=]+"?\s*[:=]\s*)\S.*?(,?)$`)
+	// headersLine starts a headers block, or sets the headers inline
+	headersLine = regexp.MustCompile(`(?i)^(\s*(?:- )?"?headers"?\s*[:=]\s*)(.*)$`)
+	// bearerValue matches bearer tokens anywhere else
+	bearerValue = regexp.MustCompile(`(?i)(bearer\s+)[^\s"']+`)
+)
+
+// quotedRedacted is valid as a value in YAML, JSON and TOML
+const quotedRedacted = `"` + redactedValue + `"`
+
+// redactConfig masks the credentials in configuration text for read-only
+// dashboards: every header value, auth passwords and tokens, admin_token and
+// exporter keys. The text keeps its format and layout.
+func redactConfig(text string) string {
+	lines := strings.Split(text, "\n")
+	redactLines(lines, 0)
+	return strings.Join(lines, "\n")
+}
+
+// redactDiff masks the credentials in a configuration diff, changed values
+// still show up as a removed and an added line
+func redactDiff(unified string) string {
+	lines := strings.Split(unified, "\n")
+	redactLines(lines, 1)
+	return strings.Join(lines, "\n")
+}
+
+// redactLines masks credentials in place, the first prefix bytes of each line
+// (the diff markers) are kept as they are
+func redactLines(lines []string, prefix int) {
+	headers := -1 // Indentation of the headers key whose block is masked
+	for i, line := range lines {
+		if prefix > 0 && strings.HasPrefix(line, "@@") {
+			headers = -1
+			continue
+		}
+		if len(line) < prefix {
+			continue
+		}
+		marker, body := line[:prefix], line[prefix:]
+		trimmed := strings.TrimSpace(body)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		indent := len(body) - len(strings.TrimLeft(body, " \t"))
+		if headers >= 0 && indent <= headers {
+			headers = -1
+		}
+		switch match := headersLine.FindStringSubmatch(body); {
+		case headers >= 0:
+			body = valueLine.ReplaceAllString(body, "${1}"+quotedRedacted+"${2}")
+		case match != nil:
+			rest := strings.TrimSpace(match[2])
+			if rest == "" || rest == "{" {
+				headers = indent
+			} else if rest != "{}" {
+				body = match[1] + "{}" // Inline headers such as { x-api-key = "..." }
+				if strings.HasSuffix(rest, ",") {
+					body += ","
+				}
+			}
+		default:
+			body = secretLine.ReplaceAllString(body, "${1}"+quotedRedacted+"${2}")
+		}
+		lines[i] = marker + bearerValue.ReplaceAllString(body, "${1}"+redactedValue)
+	}
+}
@@ -0,0 +1,205 @@
+package web
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"ccproxy/middleware"
+	"ccproxy/proxy"
+	"ccproxy/websocket"
+)
+
+// replayRequest is the optional body of POST /api/history/{id}/replay
+type replayRequest struct {
+	TargetURL string            `json:"target_url"` // Upstream replacing the target_url of the matching route
+	Headers   map[string]string `json:"headers"`    // Added or replaced, e.g. keys that were redacted in the log
+}
+
+// replayResult answers a replay with the new history entry
+type replayResult struct {
+	RequestID      string                `json:"request_id"`
+	OriginalID     string                `json:"original_id"`
+	StatusCode     int                   `json:"status_code"`
+	DroppedHeaders []string              `json:"dropped_headers,omitempty"` // Redacted in the log and not given in headers
+	Result         *websocket.LogMessage `json:"result"`
+}
+
+// handleHistoryReplay serves POST /api/history/{id}/replay. The stored
+// request is sent again through the proxy routes, so it is logged as a new
+// request and uses the current configuration.
+func (w *WebServer) handleHistoryReplay(writer http.ResponseWriter, request *http.Request, id string) {
+	if request.Method != "POST" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if w.rejectReadOnly(writer) {
+		return
+	}
+	if w.controller == nil {
+		http.Error(writer, "Replay not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var options replayRequest
+	if body, err := io.ReadAll(io.LimitReader(request.Body, 1<<20)); err != nil {
+		http.Error(writer, "Failed to read request body", http.StatusBadRequest)
+		return
+	} else if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &options); err != nil {
+			http.Error(writer, "Invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if options.TargetURL != "" {
+		if u, err := url.Parse(options.TargetURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(writer, fmt.Sprintf("invalid target_url %q", options.TargetURL), http.StatusBadRequest)
+			return
+		}
+	}
+
+	msg, err := w.findHistory(id)
+	if err != nil {
+		http.Error(writer, "Failed to get history", http.StatusInternalServerError)
+		return
+	}
+	if msg == nil {
+		http.Error(writer, "request not found", http.StatusNotFound)
+		return
+	}
+	if reason := unreplayableBody(msg); reason != "" {
+		http.Error(writer, "request cannot be replayed: "+reason, http.StatusConflict)
+		return
+	}
+
+	replay, dropped, err := newReplayRequest(request, msg, options)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	recorder := &replayRecorder{header: http.Header{}}
+	w.controller.ServeProxy(recorder, replay)
+
+	result := replayResult{
+		RequestID:      recorder.header.Get(middleware.RequestIDHeader),
+		OriginalID:     id,
+		StatusCode:     recorder.statusCode(),
+		DroppedHeaders: dropped,
+	}
+	if result.RequestID != "" {
+		result.Result, _ = w.findHistory(result.RequestID)
+	}
+	if result.Result == nil {
+		// Not in the history, e.g. logging: none for the route
+		result.Result = recorder.logMessage(replay, result.RequestID)
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(result)
+}
+
+// unreplayableBody explains why the logged body differs from the one sent
+func unreplayableBody(msg *websocket.LogMessage) string {
+	switch {
+	case strings.HasPrefix(msg.RequestBody, binaryDataPrefix):
+		return "the binary request body was not logged"
+	case strings.Contains(msg.RequestBody, "\n[TRUNCATED - "):
+		return "the request body was truncated in the log"
+	case msg.RequestBody == "" && msg.RequestSize > 0:
+		return "the request body was not logged"
+	}
+	return ""
+}
+
+// newReplayRequest rebuilds the client request of msg. Headers redacted in the
+// log are left out unless options supplies them.
+func newReplayRequest(request *http.Request, msg *websocket.LogMessage, options replayRequest) (*http.Request, []string, error) {
+	target := &url.URL{Path: msg.Path, RawQuery: msg.Query}
+	replay, err := http.NewRequestWithContext(request.Context(), msg.Method, target.String(), strings.NewReader(msg.RequestBody))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid stored request: %w", err)
+	}
+	replay.RequestURI = target.RequestURI()
+	replay.RemoteAddr = request.RemoteAddr
+	replay.Host = msg.Host
+	if replay.Host == "" {
+		replay.Host = "localhost"
+	}
+
+	var dropped []string
+	for name, value := range msg.RequestHeaders {
+		if curlSkipHeaders[http.CanonicalHeaderKey(name)] {
+			continue
+		}
+		if value == redactedValue {
+			if headerValue(options.Headers, name) == "" {
+				dropped = append(dropped, name)
+			}
+			continue
+		}
+		replay.Header.Set(name, value)
+	}
+	for name, value := range options.Headers {
+		replay.Header.Set(name, value)
+	}
+	sort.Strings(dropped)
+
+	if options.TargetURL != "" {
+		replay = proxy.WithReplayTarget(replay, options.TargetURL)
+	}
+	return replay, dropped, nil
+}
+
+// replayRecorder collects the response of a replayed request
+type replayRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *replayRecorder) Header() http.Header { return r.header }
+
+func (r *replayRecorder) WriteHeader(statusCode int) {
+	if r.status == 0 {
+		r.status = statusCode
+	}
+}
+
+func (r *replayRecorder) Write(p []byte) (int, error) {
+	r.WriteHeader(http.StatusOK)
+	return r.body.Write(p)
+}
+
+func (r *replayRecorder) Flush() {}
+
+func (r *replayRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// logMessage describes the response when the replay was not logged, without bodies
+func (r *replayRecorder) logMessage(replay *http.Request, requestID string) *websocket.LogMessage {
+	headers := make(map[string]string, len(r.header))
+	for name := range r.header {
+		headers[name] = r.header.Get(name)
+	}
+	return &websocket.LogMessage{
+		Timestamp:       time.Now().Format("2006-01-02 15:04:05.000"),
+		RequestID:       requestID,
+		Method:          replay.Method,
+		Host:            replay.Host,
+		Path:            replay.URL.Path,
+		Query:           replay.URL.RawQuery,
+		StatusCode:      r.statusCode(),
+		ResponseHeaders: headers,
+		ResponseSize:    int64(r.body.Len()),
+	}
+}
@@ -0,0 +1,60 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"ccproxy/storage"
+)
+
+// searchResult is an entry found by GET /api/history/search
+type searchResult struct {
+	RequestID  string                `json:"request_id"`
+	Timestamp  string                `json:"timestamp"`
+	Method     string                `json:"method"`
+	Path       string                `json:"path"`
+	TargetURL  string                `json:"target_url,omitempty"`
+	StatusCode int                   `json:"status_code"`
+	Matches    []storage.SearchMatch `json:"matches"`
+}
+
+// handleHistorySearch serves GET /api/history/search?q=..., a full-text
+// search of the stored request and response bodies, newest first. The other
+// history filters of parseHistoryFilter narrow the search down.
+func (w *WebServer) handleHistorySearch(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	filter, err := parseHistoryFilter(request.URL.Query(), 50, 500)
+	if err != nil {
+		http.Error(writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.Trim(filter.Text, `" `) == "" {
+		http.Error(writer, "q is required", http.StatusBadRequest)
+		return
+	}
+
+	history, err := w.hub.QueryHistory(filter)
+	if err != nil {
+		http.Error(writer, "Failed to get history", http.StatusInternalServerError)
+		return
+	}
+	results := make([]searchResult, 0, len(history))
+	for _, msg := range history {
+		results = append(results, searchResult{
+			RequestID:  msg.RequestID,
+			Timestamp:  msg.Timestamp,
+			Method:     msg.Method,
+			Path:       msg.Path,
+			TargetURL:  msg.TargetURL,
+			StatusCode: msg.StatusCode,
+			Matches:    storage.SearchEntry(msg, filter.Text),
+		})
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(results)
+}
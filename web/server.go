@@ -5,23 +5,28 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
+	"sync"
 
 	"ccproxy/config"
+	"ccproxy/version"
 	"ccproxy/websocket"
-	
-	"gopkg.in/yaml.v2"
 )
 
 //go:embed static/*
 var staticFiles embed.FS
 
 type WebServer struct {
-	hub    *websocket.Hub
-	config *config.Config
+	hub        *websocket.Hub
+	config     *config.Config
+	configMu   sync.RWMutex
+	controller Controller
+	auditMu    sync.Mutex
 }
 
 func NewWebServer(hub *websocket.Hub, cfg *config.Config) *WebServer {
@@ -31,8 +36,26 @@ func NewWebServer(hub *websocket.Hub, cfg *config.Config) *WebServer {
 	}
 }
 
+// SetConfig replaces the configuration after a reload
+func (w *WebServer) SetConfig(cfg *config.Config) {
+	w.configMu.Lock()
+	defer w.configMu.Unlock()
+	w.config = cfg
+}
+
+func (w *WebServer) currentConfig() *config.Config {
+	w.configMu.RLock()
+	defer w.configMu.RUnlock()
+	return w.config
+}
+
 // getConfigFilePath returns the correct config file path based on user home directory
 func (w *WebServer) getConfigFilePath() (string, error) {
+	// Edit the file the running configuration came from, whatever its format
+	if filePath := w.currentConfig().FilePath; filePath != "" {
+		return filepath.Abs(filePath)
+	}
+
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", fmt.Errorf("failed to get user home directory: %w", err)
@@ -45,13 +68,34 @@ func (w *WebServer) getConfigFilePath() (string, error) {
 }
 
 func (w *WebServer) SetupRoutes(mux *http.ServeMux) {
-	mux.HandleFunc("/", w.handleIndex)
-	mux.HandleFunc("/ws", w.hub.ServeWS)
-	mux.HandleFunc("/app.js", w.handleAppJS)
-	mux.HandleFunc("/api/config", w.handleConfig)
-	mux.HandleFunc("/api/history", w.handleHistory)
-	mux.HandleFunc("/api/clear-history", w.handleClearHistory)
-	mux.Handle("/static/", http.StripPrefix("/static/", http.FileServer(http.FS(staticFiles))))
+	mux.HandleFunc("/", w.requireAuth(w.handleIndex))
+	mux.HandleFunc("/ws", w.cors(w.handleWS))
+	mux.HandleFunc("/app.js", w.requireAuth(w.handleAppJS))
+	mux.HandleFunc("/api/config", w.api(w.handleConfig))
+	mux.HandleFunc("/api/config/audit", w.api(w.handleConfigAudit))
+	mux.HandleFunc("/api/config/validate", w.api(w.handleValidateConfig))
+	mux.HandleFunc("/api/history", w.api(w.handleHistory))
+	mux.HandleFunc("/api/history/export", w.api(w.handleHistoryExport))
+	mux.HandleFunc("/api/history/diff", w.api(w.handleHistoryDiff))
+	mux.HandleFunc("/api/history/search", w.api(w.handleHistorySearch))
+	mux.HandleFunc("/api/history/", w.api(w.handleHistoryItem))
+	mux.HandleFunc("/api/clear-history", w.api(w.handleClearHistory))
+	mux.HandleFunc("/api/upstreams", w.api(w.handleUpstreams))
+	mux.HandleFunc("/api/stream", w.api(w.handleStream))
+	mux.HandleFunc("/api/stats", w.api(w.handleStats))
+	mux.HandleFunc("/api/stats/targets", w.api(w.handleTargetStats))
+	mux.HandleFunc("/api/metrics/timeseries", w.api(w.handleMetricsTimeseries))
+	mux.HandleFunc("/api/version", w.api(w.handleVersion))
+	mux.HandleFunc("/api/env", w.api(w.handleEnv))
+	mux.HandleFunc("/api/admin/status", w.api(w.adminOnly(w.handleAdminStatus)))
+	mux.HandleFunc("/api/admin/reload", w.api(w.adminOnly(w.handleAdminReload)))
+	mux.HandleFunc("/api/admin/drain", w.api(w.adminOnly(w.handleAdminDrain)))
+	mux.HandleFunc("/api/admin/profile", w.api(w.adminOnly(w.handleAdminProfile)))
+	mux.HandleFunc("/api/admin/maintenance", w.api(w.adminOnly(w.handleAdminMaintenance)))
+	mux.HandleFunc("/api/admin/upstreams", w.api(w.adminOnly(w.handleAdminUpstreams)))
+	mux.HandleFunc("/api/admin/capture", w.api(w.adminOnly(w.handleAdminCapture)))
+	mux.HandleFunc("/api/admin/routes", w.api(w.adminOnly(w.handleAdminRoutes)))
+	mux.Handle("/static/", w.requireAuth(http.StripPrefix("/static/", http.FileServer(http.FS(staticFiles))).ServeHTTP))
 }
 
 func (w *WebServer) handleIndex(writer http.ResponseWriter, request *http.Request) {
@@ -86,6 +130,9 @@ func (w *WebServer) handleConfig(writer http.ResponseWriter, request *http.Reque
 	case "GET":
 		w.handleGetConfig(writer, request)
 	case "POST":
+		if w.rejectReadOnly(writer) {
+			return
+		}
 		w.handleSaveConfig(writer, request)
 	default:
 		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
@@ -108,7 +155,13 @@ func (w *WebServer) handleGetConfig(writer http.ResponseWriter, request *http.Re
 
 	// Always return the raw YAML content
 	writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if w.currentConfig().Web.ReadOnly {
+		writer.Header().Set("X-Read-Only", "true") // Lets the dashboard hide editing controls
+		// Viewers must not learn the admin token or upstream keys
+		data = []byte(redactConfig(string(data)))
+	}
 	writer.Header().Set("X-Config-Path", configFile) // Add header to show which path was used
+	writer.Header().Set("X-Config-Format", config.FormatOf(configFile))
 	writer.Write(data)
 }
 
@@ -121,19 +174,20 @@ func (w *WebServer) handleSaveConfig(writer http.ResponseWriter, request *http.R
 	}
 	defer request.Body.Close()
 	
-	// Validate YAML syntax
-	var yamlData interface{}
-	if err := yaml.Unmarshal(body, &yamlData); err != nil {
-		http.Error(writer, fmt.Sprintf("Invalid YAML format: %v", err), http.StatusBadRequest)
-		return
-	}
-	
 	// Get the correct config file path
 	configFile, err := w.getConfigFilePath()
 	if err != nil {
 		http.Error(writer, fmt.Sprintf("Failed to get config file path: %v", err), http.StatusInternalServerError)
 		return
 	}
+
+	// Validate the whole configuration in the format of the existing file
+	format := config.FormatOf(configFile)
+	saved, err := config.ValidateData(body, format)
+	if err != nil {
+		http.Error(writer, fmt.Sprintf("Invalid %s configuration:\n%v", strings.ToUpper(format), err), http.StatusBadRequest)
+		return
+	}
 	
 	// Ensure config directory exists
 	configDir := filepath.Dir(configFile)
@@ -142,22 +196,44 @@ func (w *WebServer) handleSaveConfig(writer http.ResponseWriter, request *http.R
 		return
 	}
 	
+	// Keep the previous content for the audit log
+	previous, _ := os.ReadFile(configFile)
+
 	// Save to config file
 	if err := os.WriteFile(configFile, body, 0644); err != nil {
 		http.Error(writer, fmt.Sprintf("Failed to save config file to %s: %v", configFile, err), http.StatusInternalServerError)
 		return
 	}
-	
-	// Try to reload the configuration
-	// Note: In a production environment, you might want to validate the config
-	// against your expected schema before applying it
-	
+	w.recordConfigChange(request, configFile, previous, body)
+
 	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
 	response := map[string]interface{}{
 		"status": "success",
 		"message": "Configuration saved successfully",
 		"config_path": configFile,
+		"applied": false,
+	}
+
+	// Apply the saved file to the running proxy when it is the one it was loaded from
+	current := w.currentConfig()
+	if w.controller == nil || current.FilePath == "" {
+		response["message"] = "Configuration saved, restart ccproxy to apply it"
+		json.NewEncoder(writer).Encode(response)
+		return
+	}
+	if err := w.controller.Reload(); err != nil {
+		response["message"] = "Configuration saved but could not be applied"
+		response["error"] = err.Error()
+		json.NewEncoder(writer).Encode(response)
+		return
 	}
+
+	applied := w.currentConfig()
+	response["message"] = "Configuration saved and applied"
+	response["applied"] = true
+	response["profile"] = applied.Proxy.Profile
+	response["targets"] = len(applied.Proxy.Targets)
+	response["restart_required"] = config.RestartRequired(current, saved)
 	json.NewEncoder(writer).Encode(response)
 }
 
@@ -195,12 +271,37 @@ func (w *WebServer) handleHistory(writer http.ResponseWriter, request *http.Requ
 	}
 }
 
+// handleHistoryEntry serves GET /api/history/{id}, the entry with its full
+// bodies. /api/history leaves out the bodies kept in blob files.
+func (w *WebServer) handleHistoryEntry(writer http.ResponseWriter, request *http.Request, id string) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	msg, err := w.findHistory(id)
+	if err != nil {
+		http.Error(writer, "Failed to get history", http.StatusInternalServerError)
+		return
+	}
+	if msg == nil {
+		http.Error(writer, "request not found", http.StatusNotFound)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(msg)
+}
+
 func (w *WebServer) handleClearHistory(writer http.ResponseWriter, request *http.Request) {
 	if request.Method != "POST" && request.Method != "DELETE" {
 		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
+	if w.rejectReadOnly(writer) {
+		return
+	}
+
 	// 清空历史记录
 	if err := w.hub.ClearHistory(); err != nil {
 		http.Error(writer, "Failed to clear history", http.StatusInternalServerError)
@@ -219,3 +320,75 @@ func (w *WebServer) handleClearHistory(writer http.ResponseWriter, request *http
 		return
 	}
 }
+
+func (w *WebServer) handleVersion(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if err := json.NewEncoder(writer).Encode(version.Get()); err != nil {
+		http.Error(writer, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+}
+
+// rejectReadOnly answers 403 for modifying requests when web.read_only is set
+func (w *WebServer) rejectReadOnly(writer http.ResponseWriter) bool {
+	if !w.currentConfig().Web.ReadOnly {
+		return false
+	}
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	writer.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"success": false,
+		"message": "Dashboard is read-only",
+	})
+	return true
+}
+
+// handleEnv returns the Claude Code environment for reaching the proxy from
+// the host the dashboard was opened on, as plain text when format is given
+func (w *WebServer) handleEnv(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := request.URL.Query()
+	host := query.Get("host")
+	if host == "" {
+		host = request.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+	}
+	vars := config.ClientEnv(w.currentConfig(), host, query.Get("token"))
+
+	if format := query.Get("format"); format != "" {
+		out, err := config.FormatClientEnv(vars, format)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		writer.Write([]byte(out))
+		return
+	}
+
+	env := make(map[string]string, len(vars))
+	snippets := make(map[string]string, len(config.EnvFormats))
+	for _, v := range vars {
+		env[v.Name] = v.Value
+	}
+	for _, format := range config.EnvFormats {
+		snippets[format], _ = config.FormatClientEnv(vars, format)
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"env":      env,
+		"snippets": snippets,
+	})
+}
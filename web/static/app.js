@@ -3,6 +3,7 @@ class LogViewer {
         this.ws = null;
         this.logs = [];
         this.isPaused = false;
+        this.errorsOnly = false;
         this.autoScroll = true;
         this.maxLogs = 1000;
         this.wasConnected = false;
@@ -14,10 +15,12 @@ class LogViewer {
         // Config editor state
         this.isEditingConfig = false;
         this.originalConfigYaml = '';
+        this.readOnly = false;
 
         this.initElements();
         this.bindEvents();
         this.loadConfig();
+        this.loadVersion();
         this.loadHistory();
         this.connect();
         this.initKeyboardShortcuts();
@@ -28,7 +31,9 @@ class LogViewer {
         this.connectionText = document.getElementById('connectionText');
         this.logsContainer = document.getElementById('logsContainer');
         this.clearBtn = document.getElementById('clearBtn');
+        this.exportBtn = document.getElementById('exportBtn');
         this.pauseBtn = document.getElementById('pauseBtn');
+        this.errorsOnlyBtn = document.getElementById('errorsOnlyBtn');
         this.autoScrollBtn = document.getElementById('autoScrollBtn');
         this.modal = document.getElementById('logModal');
         this.modalBody = document.getElementById('modalBody');
@@ -54,7 +59,9 @@ class LogViewer {
 
     bindEvents() {
         this.clearBtn.addEventListener('click', () => this.clearLogs());
+        this.exportBtn.addEventListener('click', () => this.exportHAR());
         this.pauseBtn.addEventListener('click', () => this.togglePause());
+        this.errorsOnlyBtn.addEventListener('click', () => this.toggleErrorsOnly());
         this.autoScrollBtn.addEventListener('click', () => this.toggleAutoScroll());
         
         window.addEventListener('beforeunload', () => {
@@ -101,6 +108,9 @@ class LogViewer {
         try {
             const response = await fetch('/api/config');
             if (response.ok) {
+                this.readOnly = response.headers.get('X-Read-Only') === 'true';
+                this.configFormat = response.headers.get('X-Config-Format') || 'yaml';
+                this.applyReadOnly();
                 const contentType = response.headers.get('content-type');
                 if (contentType && contentType.includes('application/json')) {
                     // Handle JSON response (fallback)
@@ -122,6 +132,21 @@ class LogViewer {
         }
     }
 
+    async loadVersion() {
+        try {
+            const response = await fetch('/api/version');
+            if (response.ok) {
+                const info = await response.json();
+                const title = document.querySelector('.header h1');
+                if (title) {
+                    title.title = `${info.version} (${info.commit || 'unknown'}, ${info.build_date || 'unknown'})`;
+                }
+            }
+        } catch (error) {
+            console.error('Failed to load version:', error);
+        }
+    }
+
     parseBasicConfigFromYaml(yamlText) {
         // Simple YAML parsing for basic server info
         const lines = yamlText.split('\n');
@@ -148,10 +173,10 @@ class LogViewer {
 
     updateProxyAddress() {
         if (this.config && this.config.Server) {
-            const protocol = window.location.protocol === 'https:' ? 'https' : 'http';
+            // The proxy port serves plain HTTP even when the dashboard uses HTTPS
             const host = this.config.Server.Host === '0.0.0.0' ? window.location.hostname : this.config.Server.Host;
             const port = this.config.Server.Port;
-            this.proxyAddressEl.textContent = `${protocol}://${host}:${port}`;
+            this.proxyAddressEl.textContent = `http://${host}:${port}`;
         } else {
             this.proxyAddressEl.textContent = '配置未加载';
         }
@@ -195,13 +220,26 @@ class LogViewer {
 
         this.ws.onopen = () => {
             this.updateConnectionStatus(true);
+            if (this.errorsOnly) {
+                this.subscribe();
+            }
         };
 
         this.ws.onmessage = (event) => {
+            const data = JSON.parse(event.data);
+            // Periodic stats keep the header current even while paused or idle
+            if (data.type === 'stats') {
+                this.updateStats(data.stats);
+                this.updateLiveStats(data);
+                return;
+            }
+            if (data.type === 'health') {
+                this.showHealthChange(data);
+                return;
+            }
             if (!this.isPaused) {
-                const logData = JSON.parse(event.data);
-                this.addLog(logData);
-                this.updateStats(logData.stats);
+                this.addLog(data);
+                this.updateStats(data.stats);
             }
         };
 
@@ -215,6 +253,17 @@ class LogViewer {
         };
     }
 
+    // Upstream outages and recoveries are pushed as they are detected
+    showHealthChange(event) {
+        const targets = event.targets && event.targets.length ? ` (${event.targets.join(', ')})` : '';
+        if (event.healthy) {
+            this.showNotification(`上游已恢复: ${event.url}${targets}`, 'success');
+        } else {
+            const reason = event.error ? `: ${event.error}` : '';
+            this.showNotification(`上游不可用: ${event.url}${targets}${reason}`, 'error');
+        }
+    }
+
     updateConnectionStatus(connected) {
         if (connected) {
             this.connectionStatus.classList.add('connected');
@@ -285,6 +334,10 @@ class LogViewer {
         this.totalRequestsEl.textContent = stats.total_requests.toLocaleString();
         this.successRequestsEl.textContent = stats.success_requests.toLocaleString();
         this.errorRequestsEl.textContent = stats.error_requests.toLocaleString();
+        this.totalRequestsEl.parentElement.title = this.formatTargetStats(stats.targets);
+        this.avgLatencyEl.parentElement.title = stats.latency
+            ? `p50 ${Math.round(stats.latency.p50_ms)}ms, p95 ${Math.round(stats.latency.p95_ms)}ms, p99 ${Math.round(stats.latency.p99_ms)}ms`
+            : '';
         
         // Calculate and display uptime
         if (stats.start_time) {
@@ -296,6 +349,32 @@ class LogViewer {
         }
     }
 
+    // Live state shown when hovering the connection status
+    updateLiveStats(live) {
+        const lines = [
+            `进行中的请求: ${live.active_requests}`,
+            `请求速率: ${live.request_rate.toFixed(2)}/s`,
+            `客户端: ${live.clients}`
+        ];
+        for (const [url, health] of Object.entries(live.upstreams || {})) {
+            lines.push(`${health.healthy ? '✅' : '❌'} ${url} ${Math.round(health.response_time_ms)}ms`);
+        }
+        for (const [name, depth] of Object.entries(live.queues || {})) {
+            if (depth > 0) lines.push(`队列 ${name}: ${depth}`);
+        }
+        this.connectionText.parentElement.title = lines.join('\n');
+    }
+
+    // Per-upstream breakdown shown when hovering the request count
+    formatTargetStats(targets) {
+        if (!targets) return '';
+        return Object.entries(targets)
+            .sort((a, b) => b[1].total_requests - a[1].total_requests)
+            .map(([target, s]) => `${target}: ${s.total_requests} 请求, ${s.error_requests} 错误, 平均 ${Math.round(s.avg_latency_ms)}ms`
+                + (s.latency ? `, p95 ${Math.round(s.latency.p95_ms)}ms` : ''))
+            .join('\n');
+    }
+
     formatUptime(ms) {
         const seconds = Math.floor(ms / 1000);
         const minutes = Math.floor(seconds / 60);
@@ -366,6 +445,41 @@ class LogViewer {
             `;
         }
 
+        if (log.attempt_count > 1) {
+            details += `
+                <div class="detail-section">
+                    <div class="detail-title" data-section="attempts">
+                        <div class="detail-title-text">
+                            <span class="collapse-icon">▼</span>
+                            <span>🔁 转发尝试 (${log.attempt_count})</span>
+                        </div>
+                        <button class="copy-section-btn" data-copy-type="attempts">📋 复制</button>
+                    </div>
+                    <div class="detail-content" data-section-content="attempts">${this.escapeHtml(this.formatAttemptsDetails(log))}</div>
+                </div>
+            `;
+        }
+
+        if (log.request_id) {
+            details += `
+                <div class="detail-section">
+                    <div class="detail-title" data-section="request-id">
+                        <div class="detail-title-text">
+                            <span class="collapse-icon">▼</span>
+                            <span>🏷️ 请求 ID</span>
+                        </div>
+                        <div>
+                            <button class="copy-section-btn" data-copy-type="curl">📋 curl</button>
+                            <button class="copy-section-btn" data-copy-type="har">📦 HAR</button>
+                            ${this.readOnly ? '' : '<button class="copy-section-btn" data-copy-type="replay">🔁 重放</button>'}
+                            <button class="copy-section-btn" data-copy-type="request-id">📋 复制</button>
+                        </div>
+                    </div>
+                    <div class="detail-content" data-section-content="request-id">${this.escapeHtml(log.request_id)}</div>
+                </div>
+            `;
+        }
+
         if (log.target_url) {
             details += `
                 <div class="detail-section">
@@ -436,7 +550,7 @@ class LogViewer {
                         </div>
                         <button class="copy-section-btn" data-copy-type="request-body">📋 复制</button>
                     </div>
-                    <div class="detail-content" data-section-content="request-body">${this.escapeHtml(log.request_body)}</div>
+                    <div class="detail-content${log.request_body.startsWith('[BINARY DATA') ? ' binary-data' : ''}" data-section-content="request-body">${this.escapeHtml(log.request_body)}</div>
                 </div>
             `;
         }
@@ -468,11 +582,11 @@ class LogViewer {
                     <div class="detail-title" data-section="error">
                         <div class="detail-title-text">
                             <span class="collapse-icon">▼</span>
-                            <span>❌ 错误信息</span>
+                            <span>❌ 错误信息${log.error_type ? ` (${log.error_type})` : ''}</span>
                         </div>
                         <button class="copy-section-btn" data-copy-type="error">📋 复制</button>
                     </div>
-                    <div class="detail-content" data-section-content="error" style="color: #e74c3c;">${log.error}</div>
+                    <div class="detail-content" data-section-content="error" style="color: #e74c3c;">${this.escapeHtml(log.error)}</div>
                 </div>
             `;
         }
@@ -506,6 +620,11 @@ class LogViewer {
     }
 
     async clearLogs() {
+        if (this.readOnly) {
+            this.showNotification('只读模式下不能清空日志', 'info');
+            return;
+        }
+
         // Add confirmation with smooth animation
         if (this.logs.length === 0) {
             this.showNotification('没有日志可清空', 'info');
@@ -578,6 +697,55 @@ class LogViewer {
         }, 100);
     }
 
+    // 实时推送的日志不含请求体和响应体, 历史记录中较大的内容保存在单独的文件中,
+    // 都在查看时再加载
+    async loadBodies(log) {
+        if (!log.request_id || (!log.bodies_omitted && !log.request_blob && !log.response_blob)) {
+            return;
+        }
+        try {
+            const response = await fetch(`/api/history/${encodeURIComponent(log.request_id)}`);
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+            const entry = await response.json();
+            log.request_body = entry.request_body;
+            log.response_body = entry.response_body;
+            delete log.bodies_omitted;
+            delete log.request_blob;
+            delete log.response_blob;
+        } catch (error) {
+            this.showNotification(`加载请求内容失败: ${error.message}`, 'error');
+        }
+    }
+
+    // 下载 HAR 文件, 不指定请求 ID 时导出最近的历史记录
+    exportHAR(requestId) {
+        const params = new URLSearchParams({ format: 'har' });
+        if (requestId) {
+            params.set('id', requestId);
+        }
+        window.location.href = `/api/history/export?${params}`;
+    }
+
+    // 通过代理重新发送已记录的请求, 新请求会出现在日志列表中
+    async replayRequest(requestId) {
+        try {
+            const response = await fetch(`/api/history/${encodeURIComponent(requestId)}/replay`, { method: 'POST' });
+            if (!response.ok) {
+                throw new Error(await response.text());
+            }
+            const result = await response.json();
+            let message = `重放完成: ${result.status_code}`;
+            if (result.dropped_headers && result.dropped_headers.length > 0) {
+                message += ` (未发送已脱敏的请求头: ${result.dropped_headers.join(', ')})`;
+            }
+            this.showNotification(message, result.status_code < 400 ? 'success' : 'error');
+        } catch (error) {
+            this.showNotification(`重放请求失败: ${error.message}`, 'error');
+        }
+    }
+
     togglePause() {
         this.isPaused = !this.isPaused;
         this.pauseBtn.innerHTML = this.isPaused ? '▶️ 继续' : '⏸️ 暂停';
@@ -592,6 +760,22 @@ class LogViewer {
         this.showNotification(this.isPaused ? '日志已暂停' : '日志已恢复', 'info');
     }
 
+    // The hub filters server-side, so only failed requests cross the network
+    subscribe() {
+        if (!this.ws || this.ws.readyState !== WebSocket.OPEN) {
+            return;
+        }
+        const subscription = this.errorsOnly ? { type: 'subscribe', min_status: 400 } : { type: 'subscribe' };
+        this.ws.send(JSON.stringify(subscription));
+    }
+
+    toggleErrorsOnly() {
+        this.errorsOnly = !this.errorsOnly;
+        this.errorsOnlyBtn.classList.toggle('active', this.errorsOnly);
+        this.subscribe();
+        this.showNotification(this.errorsOnly ? '只接收失败的请求' : '接收全部请求', 'info');
+    }
+
     toggleAutoScroll() {
         this.autoScroll = !this.autoScroll;
         this.autoScrollBtn.classList.toggle('active', this.autoScroll);
@@ -647,6 +831,14 @@ class LogViewer {
         if (log.first_byte_duration) {
             connectionInfo += `<span class="connection-metric first-byte" title="首字节延迟">🏃 ${log.first_byte_duration}</span>`;
         }
+
+        if (log.error_type) {
+            connectionInfo += `<span class="connection-metric error-type" title="${this.escapeHtml(log.error || '')}">❌ ${log.error_type}</span>`;
+        }
+
+        if (log.attempt_count > 1) {
+            connectionInfo += `<span class="connection-metric retries" title="转发尝试次数">🔁 ${log.attempt_count}</span>`;
+        }
         
         return connectionInfo;
     }
@@ -733,6 +925,13 @@ class LogViewer {
         return metrics.join('\n');
     }
 
+    formatAttemptsDetails(log) {
+        return (log.attempts || []).map((attempt, index) => {
+            const outcome = attempt.error ? `失败: ${attempt.error}` : `状态码 ${attempt.status_code}`;
+            return `#${index + 1} ${attempt.target_url} (${attempt.duration}) ${outcome}`;
+        }).join('\n');
+    }
+
     trackLatency(logData) {
         // Extract latency from upstream_latency or duration
         let latencyMs = 0;
@@ -791,6 +990,7 @@ class LogViewer {
     }
 
     async copyLogAsJSON(log) {
+        await this.loadBodies(log);
         try {
             const jsonString = JSON.stringify(log, null, 2);
             
@@ -855,7 +1055,8 @@ class LogViewer {
         }, 2000);
     }
 
-    showModal(log) {
+    async showModal(log) {
+        await this.loadBodies(log);
         const details = this.renderLogDetails(log);
         this.modalBody.innerHTML = details;
         this.modal.classList.add('show');
@@ -1099,11 +1300,38 @@ class LogViewer {
     async copySectionContent(log, button) {
         const copyType = button.getAttribute('data-copy-type');
         let content = '';
+
+        if (copyType === 'har') {
+            this.exportHAR(log.request_id);
+            return;
+        }
+        if (copyType === 'replay') {
+            this.replayRequest(log.request_id);
+            return;
+        }
         
         switch (copyType) {
             case 'connection-metrics':
                 content = this.formatConnectionMetricsDetails(log);
                 break;
+            case 'attempts':
+                content = this.formatAttemptsDetails(log);
+                break;
+            case 'request-id':
+                content = log.request_id || '';
+                break;
+            case 'curl':
+                try {
+                    const response = await fetch(`/api/history/${encodeURIComponent(log.request_id)}/curl`);
+                    if (!response.ok) {
+                        throw new Error(await response.text());
+                    }
+                    content = await response.text();
+                } catch (error) {
+                    this.showNotification(`生成 curl 命令失败: ${error.message}`, 'error');
+                    return;
+                }
+                break;
             case 'target-url':
                 content = log.target_url || '';
                 break;
@@ -1546,7 +1774,7 @@ ${aggregatedContent.text ? this.escapeHtml(aggregatedContent.text.trim()) : '<em
         if (textarea) {
             textarea.focus();
             // Add syntax validation
-            textarea.addEventListener('input', () => this.validateYamlSyntax(textarea.value));
+            textarea.addEventListener('input', () => this.scheduleConfigValidation(textarea.value));
         }
         
         this.showNotification('已进入编辑模式', 'info');
@@ -1573,11 +1801,17 @@ ${aggregatedContent.text ? this.escapeHtml(aggregatedContent.text.trim()) : '<em
         
         const newConfigYaml = textarea.value;
         
-        // Validate YAML syntax
-        if (!this.isValidYaml(newConfigYaml)) {
+        // Validate YAML syntax, JSON and TOML are checked by the server
+        if (this.configFormat === 'yaml' && !this.isValidYaml(newConfigYaml)) {
             this.showNotification('YAML 格式错误，请检查语法', 'error');
             return;
         }
+
+        const validation = await this.validateConfigRemote(newConfigYaml);
+        if (validation && !validation.valid) {
+            this.showNotification('配置校验失败，请根据提示修改', 'error');
+            return;
+        }
         
         try {
             // Show saving state
@@ -1610,7 +1844,14 @@ ${aggregatedContent.text ? this.escapeHtml(aggregatedContent.text.trim()) : '<em
                 this.configModalBody.innerHTML = configHtml;
                 this.bindConfigModalEvents();
                 
-                this.showNotification('配置保存成功', 'success');
+                const result = await response.json();
+                if (!result.applied) {
+                    this.showNotification(`配置已保存, 但未生效: ${result.error || result.message}`, 'warning');
+                } else if (result.restart_required && result.restart_required.length > 0) {
+                    this.showNotification(`配置已生效, ${result.restart_required.join(', ')} 需重启后生效`, 'warning');
+                } else {
+                    this.showNotification('配置已保存并生效', 'success');
+                }
             } else {
                 const errorText = await response.text();
                 this.showNotification(`保存失败: ${errorText}`, 'error');
@@ -1625,8 +1866,18 @@ ${aggregatedContent.text ? this.escapeHtml(aggregatedContent.text.trim()) : '<em
         }
     }
     
+    // 只读模式下隐藏修改配置和清空日志的入口
+    applyReadOnly() {
+        this.clearBtn.style.display = this.readOnly ? 'none' : '';
+        this.updateConfigButtonStates();
+    }
+
     updateConfigButtonStates() {
-        if (this.isEditingConfig) {
+        if (this.readOnly) {
+            this.editConfigBtn.style.display = 'none';
+            this.saveConfigBtn.style.display = 'none';
+            this.cancelEditBtn.style.display = 'none';
+        } else if (this.isEditingConfig) {
             this.editConfigBtn.style.display = 'none';
             this.saveConfigBtn.style.display = 'inline-block';
             this.cancelEditBtn.style.display = 'inline-block';
@@ -1637,6 +1888,54 @@ ${aggregatedContent.text ? this.escapeHtml(aggregatedContent.text.trim()) : '<em
         }
     }
     
+    // 编辑时延迟调用服务端校验, 保存前即可看到完整的错误列表
+    scheduleConfigValidation(content) {
+        if (this.configFormat === 'yaml') {
+            this.validateYamlSyntax(content);
+        }
+        clearTimeout(this.validateTimer);
+        this.validateTimer = setTimeout(() => this.validateConfigRemote(content), 500);
+    }
+
+    async validateConfigRemote(content) {
+        const seq = this.validateSeq = (this.validateSeq || 0) + 1;
+        try {
+            const response = await fetch('/api/config/validate', {
+                method: 'POST',
+                body: content
+            });
+            if (!response.ok) {
+                return null;
+            }
+            const result = await response.json();
+            if (seq === this.validateSeq) {
+                this.renderValidationResult(result);
+            }
+            return result;
+        } catch (error) {
+            console.error('Validate config failed:', error);
+            return null;
+        }
+    }
+
+    renderValidationResult(result) {
+        const statusEl = document.getElementById('configStatus');
+        if (!statusEl) return;
+
+        if (result.valid) {
+            statusEl.className = 'config-status';
+            statusEl.innerHTML = '<span>✅ 配置校验通过</span>';
+            return;
+        }
+
+        const items = result.errors.map(error => {
+            const location = [error.line ? `第 ${error.line} 行` : '', error.field || ''].filter(Boolean).join(' ');
+            return `<div>${this.escapeHtml(location ? `${location}: ${error.message}` : error.message)}</div>`;
+        }).join('');
+        statusEl.className = 'config-status error';
+        statusEl.innerHTML = `<span>⚠️ 配置有 ${result.errors.length} 处错误</span>${items}`;
+    }
+
     validateYamlSyntax(yamlContent) {
         const statusEl = document.getElementById('configStatus');
         if (!statusEl) return;
@@ -0,0 +1,74 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"ccproxy/websocket"
+)
+
+// handleStats serves GET /api/stats, the statistics since start including
+// latency percentiles and the breakdown by upstream
+func (w *WebServer) handleStats(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(w.hub.GetStats())
+}
+
+// handleTargetStats serves GET /api/stats/targets, the statistics of every
+// upstream (scheme://host) since start
+func (w *WebServer) handleTargetStats(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"start_time": w.hub.GetStats().StartTime,
+		"targets":    w.hub.GetTargetStats(),
+	})
+}
+
+// handleMetricsTimeseries serves GET /api/metrics/timeseries?window=1h&step=1m,
+// request and error rates and latency buckets for charts. The step is whole
+// minutes and the window at most 24h, both default to the values above.
+func (w *WebServer) handleMetricsTimeseries(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	window, step := time.Hour, time.Minute
+	query := request.URL.Query()
+	for name, value := range map[string]*time.Duration{"window": &window, "step": &step} {
+		if text := query.Get(name); text != "" {
+			d, err := time.ParseDuration(text)
+			if err != nil || d <= 0 {
+				http.Error(writer, fmt.Sprintf("invalid %s %q", name, text), http.StatusBadRequest)
+				return
+			}
+			*value = d
+		}
+	}
+	switch {
+	case step%websocket.SeriesResolution != 0:
+		http.Error(writer, "step must be a whole number of minutes", http.StatusBadRequest)
+		return
+	case window > websocket.SeriesRetention:
+		http.Error(writer, "window must not exceed 24h", http.StatusBadRequest)
+		return
+	case window < step || window%step != 0:
+		http.Error(writer, "window must be a multiple of step", http.StatusBadRequest)
+		return
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(w.hub.GetTimeSeries(window, step))
+}
@@ -0,0 +1,49 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+
+	"ccproxy/websocket"
+)
+
+// handleStream serves GET /api/stream, the live log as Server-Sent Events for
+// networks where proxies block WebSocket upgrades. The query parameters
+// min_status, status, method, path_prefix and target filter the log like a
+// WebSocket subscription, and bodies=true includes the request and response
+// bodies.
+func (w *WebServer) handleStream(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := request.URL.Query()
+	var sub *websocket.Subscription
+	if len(query) > 0 {
+		sub = &websocket.Subscription{
+			Status:     query.Get("status"),
+			Method:     query.Get("method"),
+			PathPrefix: query.Get("path_prefix"),
+			Target:     query.Get("target"),
+		}
+		if minStatus := query.Get("min_status"); minStatus != "" {
+			n, err := strconv.Atoi(minStatus)
+			if err != nil {
+				http.Error(writer, "invalid min_status", http.StatusBadRequest)
+				return
+			}
+			sub.MinStatus = n
+		}
+		if bodies := query.Get("bodies"); bodies != "" {
+			b, err := strconv.ParseBool(bodies)
+			if err != nil {
+				http.Error(writer, "invalid bodies", http.StatusBadRequest)
+				return
+			}
+			sub.Bodies = &b
+		}
+	}
+
+	w.hub.ServeSSE(writer, request, sub)
+}
@@ -0,0 +1,103 @@
+package web
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// upstreamHealth is an upstream URL in GET /api/upstreams. Latencies are
+// those of the health checks, the averages count successful checks only.
+type upstreamHealth struct {
+	URL            string     `json:"url"`
+	Targets        []string   `json:"targets"` // Paths of the targets forwarding to this URL
+	Healthy        bool       `json:"healthy"`
+	Override       string     `json:"override,omitempty"`        // "up" or "down" when set through /api/admin/upstreams
+	ChecksDisabled bool       `json:"checks_disabled,omitempty"` // health_check is off, the status follows proxied requests
+	ResponseTimeMs float64    `json:"response_time_ms"`          // Last check
+	AvgLatencyMs   float64    `json:"avg_latency_ms"`
+	MinLatencyMs   float64    `json:"min_latency_ms"`
+	MaxLatencyMs   float64    `json:"max_latency_ms"`
+	TotalChecks    int        `json:"total_checks"`
+	SuccessChecks  int        `json:"success_checks"`
+	SuccessRate    float64    `json:"success_rate"` // 0-1, 0 before the first check
+	ErrorCount     int        `json:"error_count"`  // Consecutive failed checks
+	LastCheck      time.Time  `json:"last_check"`
+	RecoveredAt    *time.Time `json:"recovered_at,omitempty"`  // Last time the URL turned healthy again
+	EjectedUntil   *time.Time `json:"ejected_until,omitempty"` // While outlier detection leaves the URL out
+
+	// Proxied requests, they mark the URL unhealthy or healthy between checks
+	Requests         int        `json:"requests"`
+	FailedRequests   int        `json:"failed_requests"`    // Transport errors and 5xx responses
+	RequestErrors    int        `json:"request_errors"`     // Consecutive failed requests
+	RequestLatencyMs float64    `json:"request_latency_ms"` // Moving average time to the response headers
+	LastRequest      *time.Time `json:"last_request,omitempty"`
+}
+
+// handleUpstreams serves GET /api/upstreams, the health checker state of
+// every upstream URL sorted by URL
+func (w *WebServer) handleUpstreams(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "GET" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if w.controller == nil {
+		http.Error(writer, "Upstream health not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	targets := map[string][]string{}
+	for _, target := range w.currentConfig().RouteTargets() {
+		for _, url := range target.TargetURLs {
+			targets[url] = append(targets[url], target.Path)
+		}
+	}
+
+	upstreams := []upstreamHealth{}
+	for url, health := range w.controller.Upstreams() {
+		upstream := upstreamHealth{
+			URL:            url,
+			Targets:        targets[url],
+			Healthy:        health.IsHealthy,
+			Override:       health.Override,
+			ChecksDisabled: health.ChecksDisabled,
+			ResponseTimeMs: durationMilliseconds(health.ResponseTime),
+			AvgLatencyMs:   durationMilliseconds(health.AverageTime),
+			MinLatencyMs:   durationMilliseconds(health.MinTime),
+			MaxLatencyMs:   durationMilliseconds(health.MaxTime),
+			TotalChecks:    health.TotalChecks,
+			SuccessChecks:  health.SuccessChecks,
+			ErrorCount:     health.ErrorCount,
+			LastCheck:      health.LastCheck,
+
+			Requests:         health.Requests,
+			FailedRequests:   health.FailedRequests,
+			RequestErrors:    health.RequestErrors,
+			RequestLatencyMs: durationMilliseconds(health.RequestLatency),
+		}
+		if !health.RecoveredAt.IsZero() {
+			upstream.RecoveredAt = &health.RecoveredAt
+		}
+		if health.EjectedUntil.After(time.Now()) {
+			upstream.EjectedUntil = &health.EjectedUntil
+		}
+		if !health.LastRequest.IsZero() {
+			upstream.LastRequest = &health.LastRequest
+		}
+		if health.TotalChecks > 0 {
+			upstream.SuccessRate = float64(health.SuccessChecks) / float64(health.TotalChecks)
+		}
+		upstreams = append(upstreams, upstream)
+	}
+	sort.Slice(upstreams, func(i, j int) bool { return upstreams[i].URL < upstreams[j].URL })
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(map[string]interface{}{
+		"upstreams": upstreams,
+	})
+}
+
+func durationMilliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
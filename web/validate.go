@@ -0,0 +1,184 @@
+package web
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"ccproxy/config"
+	"ccproxy/proxy"
+)
+
+// ValidationResult is the response of POST /api/config/validate
+type ValidationResult struct {
+	Valid   bool                     `json:"valid"`
+	Format  string                   `json:"format"`
+	Errors  config.ValidationErrors  `json:"errors"`
+	Targets []*ValidationTargetCheck `json:"targets,omitempty"`
+}
+
+// ValidationTargetCheck lists the upstream URLs a target resolves to
+type ValidationTargetCheck struct {
+	Profile string      `json:"profile,omitempty"`
+	Path    string      `json:"path"`
+	Methods []string    `json:"methods,omitempty"`
+	URLs    []*URLCheck `json:"urls"`
+}
+
+// URLCheck reports DNS resolution and, when probing, the health of an upstream
+type URLCheck struct {
+	URL          string   `json:"url"`
+	Addresses    []string `json:"addresses,omitempty"`
+	Error        string   `json:"error,omitempty"`
+	Healthy      *bool    `json:"healthy,omitempty"`
+	ResponseTime string   `json:"response_time,omitempty"`
+}
+
+// handleValidateConfig checks a candidate configuration without saving it.
+// The body uses the format of the current config file unless ?format= is
+// given, and ?probe=true additionally health checks every upstream. Probing
+// connects to the hosts of the submitted config, read-only dashboards may
+// not do it.
+func (w *WebServer) handleValidateConfig(writer http.ResponseWriter, request *http.Request) {
+	if request.Method != "POST" {
+		http.Error(writer, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	probe := request.URL.Query().Get("probe") == "true"
+	if probe && w.rejectReadOnly(writer) {
+		return
+	}
+
+	body, err := io.ReadAll(request.Body)
+	if err != nil {
+		http.Error(writer, "Failed to read request body", http.StatusBadRequest)
+		return
+	}
+	defer request.Body.Close()
+
+	format := request.URL.Query().Get("format")
+	switch format {
+	case "":
+		configFile, err := w.getConfigFilePath()
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("Failed to get config file path: %v", err), http.StatusInternalServerError)
+			return
+		}
+		format = config.FormatOf(configFile)
+	case config.FormatYAML, config.FormatJSON, config.FormatTOML:
+	default:
+		http.Error(writer, fmt.Sprintf("Unknown format %q", format), http.StatusBadRequest)
+		return
+	}
+
+	result := &ValidationResult{Format: format, Errors: config.ValidationErrors{}}
+	cfg, err := config.ValidateData(body, format)
+	var errs config.ValidationErrors
+	switch {
+	case errors.As(err, &errs):
+		result.Errors = errs
+	case err != nil:
+		result.Errors = config.ValidationErrors{{Message: err.Error()}}
+	}
+	result.Valid = len(result.Errors) == 0
+
+	if cfg != nil {
+		result.Targets = checkTargets(request.Context(), cfg, probe)
+	}
+
+	writer.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewEncoder(writer).Encode(result)
+}
+
+// checkTargets resolves the upstreams of the active targets and of every
+// profile, each distinct URL is looked up (and probed) once in parallel
+func checkTargets(ctx context.Context, cfg *config.Config, probe bool) []*ValidationTargetCheck {
+	type profileTarget struct {
+		profile string
+		target  config.ProxyTarget
+	}
+	// Probes go through the HTTP proxy the target would use in its profile
+	var all []profileTarget
+	for _, target := range cfg.RouteTargets() {
+		if target.HTTPProxy == "" {
+			target.HTTPProxy = cfg.Proxy.HTTPProxy
+		}
+		all = append(all, profileTarget{cfg.Proxy.Profile, target})
+	}
+	for _, name := range cfg.ProfileNames() {
+		if name == cfg.Proxy.Profile {
+			continue
+		}
+		profile, _ := cfg.UseProfile(name)
+		for _, target := range profile.Proxy.Targets {
+			if target.HTTPProxy == "" {
+				target.HTTPProxy = profile.Proxy.HTTPProxy
+			}
+			all = append(all, profileTarget{name, target})
+		}
+	}
+
+	targets := make([]*ValidationTargetCheck, len(all))
+	checks := map[string]*URLCheck{}
+	urlTargets := map[string]config.ProxyTarget{}
+	for i, pt := range all {
+		targets[i] = &ValidationTargetCheck{Profile: pt.profile, Path: pt.target.Route(), Methods: pt.target.Methods}
+		for _, u := range pt.target.TargetURLs {
+			if checks[u] == nil {
+				checks[u] = &URLCheck{URL: u}
+				urlTargets[u] = pt.target
+			}
+			targets[i].URLs = append(targets[i].URLs, checks[u])
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var checker *proxy.HealthChecker
+	if probe {
+		checker = proxy.NewHealthChecker()
+	}
+
+	var wg sync.WaitGroup
+	for u, check := range checks {
+		wg.Add(1)
+		go func(u string, check *URLCheck) {
+			defer wg.Done()
+			resolveURL(ctx, check)
+			if checker != nil && check.Error == "" {
+				target := urlTargets[u]
+				health := checker.CheckNow(u, &target)
+				check.Healthy = &health.IsHealthy
+				check.ResponseTime = health.ResponseTime.Round(time.Millisecond).String()
+			}
+		}(u, check)
+	}
+	wg.Wait()
+	return targets
+}
+
+func resolveURL(ctx context.Context, check *URLCheck) {
+	u, err := url.Parse(check.URL)
+	if err != nil || u.Hostname() == "" {
+		check.Error = "invalid URL"
+		return
+	}
+	if ip := net.ParseIP(u.Hostname()); ip != nil {
+		check.Addresses = []string{ip.String()}
+		return
+	}
+	addresses, err := net.DefaultResolver.LookupHost(ctx, u.Hostname())
+	if err != nil {
+		check.Error = err.Error()
+		return
+	}
+	check.Addresses = addresses
+}
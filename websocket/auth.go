@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// authTimeout 未认证的连接必须在这段时间内发送认证消息
+const authTimeout = 10 * time.Second
+
+// AuthMessage 连接时无法携带凭据的客户端 (例如其他来源的面板, 浏览器不会
+// 发送认证 cookie) 用第一条消息认证, 认证前收不到任何推送
+type AuthMessage struct {
+	Type     string `json:"type"`  // 固定为 "auth"
+	Token    string `json:"token"` // web.auth.type 为 bearer 时
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Authenticator 检查认证消息中的凭据
+type Authenticator func(msg AuthMessage) bool
+
+// handleAuth 处理认证消息, 成功后客户端加入 Hub 并收到
+// {"type":"auth","authenticated":true}, 失败时以 1008 关闭连接
+func (c *Client) handleAuth(data []byte) {
+	c.mu.Lock()
+	authenticate := c.authenticate
+	c.mu.Unlock()
+	if authenticate == nil {
+		return
+	}
+
+	var msg AuthMessage
+	if err := json.Unmarshal(data, &msg); err != nil || !authenticate(msg) {
+		log.Printf("[WARN] WebSocket client %s failed to authenticate", c.conn.RemoteAddr())
+		c.closeWith(closePolicyViolation, "authentication failed")
+		return
+	}
+
+	c.mu.Lock()
+	c.authenticate = nil
+	c.mu.Unlock()
+	c.hub.addClient(c)
+	c.send(newPreparedMessage([]byte(`{"type":"auth","authenticated":true}`)))
+}
+
+// requireAuth 在 authTimeout 后关闭仍未认证的连接
+func (c *Client) requireAuth() {
+	time.AfterFunc(authTimeout, func() {
+		c.mu.Lock()
+		pending := c.authenticate != nil
+		c.mu.Unlock()
+		if pending {
+			c.closeWith(closePolicyViolation, "authentication required")
+		}
+	})
+}
@@ -0,0 +1,13 @@
+package websocket
+
+// SetCapturePaused 暂停或恢复记录请求. 暂停期间代理照常转发, 请求只计入统计,
+// 不读取请求体和响应体, 也不推送或写入历史记录, 与 logging.exclude_paths 相同.
+// 只在内存中生效, 重启后恢复记录
+func (h *Hub) SetCapturePaused(paused bool) {
+	h.capturePaused.Store(paused)
+}
+
+// CapturePaused 是否暂停记录请求
+func (h *Hub) CapturePaused() bool {
+	return h.capturePaused.Load()
+}
@@ -0,0 +1,165 @@
+package websocket
+
+import (
+	"bufio"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxServerMessageSize bounds the messages the client accepts, well above
+// the 1 MiB that websocket.max_message_bytes allows by default
+const maxServerMessageSize = 64 << 20
+
+// Conn is a minimal client side WebSocket connection, enough to follow the
+// log stream served by Hub.ServeWS
+type Conn struct {
+	conn    net.Conn
+	reader  *bufio.Reader
+	deflate bool // The server compresses messages with permessage-deflate
+}
+
+// Dial opens a WebSocket connection to rawURL (ws://, wss://, http:// or
+// https://) with the given extra headers. tlsConfig is used for secure
+// connections and may be nil.
+func Dial(rawURL string, header http.Header, tlsConfig *tls.Config) (*Conn, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	secure := false
+	switch u.Scheme {
+	case "ws", "http":
+	case "wss", "https":
+		secure = true
+	default:
+		return nil, fmt.Errorf("unsupported websocket scheme %q", u.Scheme)
+	}
+
+	host := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if secure {
+			port = "443"
+		}
+		host = net.JoinHostPort(u.Hostname(), port)
+	}
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	if secure {
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+		conn, err = tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", host)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	key := base64.StdEncoding.EncodeToString(nonce)
+
+	req, _ := http.NewRequest("GET", "http://"+u.Host+u.RequestURI(), nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Extensions", "permessage-deflate; server_no_context_takeover")
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake failed: %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != computeAcceptKey(key) {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake failed: invalid Sec-WebSocket-Accept")
+	}
+
+	deflate := strings.HasPrefix(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate")
+	return &Conn{conn: conn, reader: reader, deflate: deflate}, nil
+}
+
+// ReadMessage returns the payload of the next text or binary message,
+// answering pings on the way. io.EOF is returned once the server closes the
+// connection.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var message []byte
+	var compressed bool
+	for {
+		f, err := readFrame(c.reader, false, maxServerMessageSize)
+		if err != nil {
+			return nil, err
+		}
+
+		switch f.opcode {
+		case opClose:
+			// Echo the status code to complete the closing handshake
+			code, _, err := parseClose(f.payload)
+			if err != nil {
+				code = closeProtocolError
+			}
+			c.writeFrame(opClose, closePayload(code, ""))
+			c.conn.Close()
+			return nil, io.EOF
+		case opPing:
+			if err := c.writeFrame(opPong, f.payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		}
+
+		if f.opcode != opContinuation {
+			compressed = c.deflate && f.rsv&rsvCompressed != 0
+		}
+		if len(message)+len(f.payload) > maxServerMessageSize {
+			return nil, fmt.Errorf("message exceeds %d bytes", maxServerMessageSize)
+		}
+		message = append(message, f.payload...)
+		if f.fin {
+			if compressed {
+				return inflateMessage(message, maxServerMessageSize)
+			}
+			return message, nil
+		}
+	}
+}
+
+// Close sends a close frame and closes the underlying connection
+func (c *Conn) Close() error {
+	c.writeFrame(opClose, closePayload(closeNormal, ""))
+	return c.conn.Close()
+}
+
+// writeFrame sends a single masked frame, as required for client to server frames
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	mask := make([]byte, 4)
+	rand.Read(mask)
+	f := &frame{fin: true, opcode: opcode, payload: payload}
+	_, err := c.conn.Write(f.appendTo(nil, mask))
+	return err
+}
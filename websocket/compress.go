@@ -0,0 +1,121 @@
+package websocket
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// permessage-deflate 扩展 (RFC 7692). 每条消息单独压缩, 不保留上下文,
+// 广播时同一条消息只压缩一次
+const (
+	rsvCompressed = 0x40 // RSV1 表示消息已压缩
+
+	// compressThreshold 小于这个长度的消息不压缩, 收益不抵开销
+	compressThreshold = 512
+
+	// deflateResponse 握手响应中的扩展参数, 双方都不保留压缩上下文
+	deflateResponse = "permessage-deflate; server_no_context_takeover; client_no_context_takeover"
+)
+
+// deflateTail 每条压缩消息末尾省略的同步块, 解压时补上, 再加一个空的结束
+// 块让 flate 正常读到结尾
+var (
+	deflateTail = []byte{0x00, 0x00, 0xff, 0xff}
+	finalBlock  = []byte{0x01, 0x00, 0x00, 0xff, 0xff}
+)
+
+var flateWriters = sync.Pool{
+	New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return w
+	},
+}
+
+// negotiateDeflate 客户端是否提供了可以接受的 permessage-deflate 参数.
+// flate 总是使用 32K 窗口, 要求更小 server_max_window_bits 的提议被忽略
+func negotiateDeflate(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, offer := range strings.Split(value, ",") {
+			params := strings.Split(offer, ";")
+			if strings.TrimSpace(params[0]) != "permessage-deflate" {
+				continue
+			}
+			acceptable := true
+			for _, param := range params[1:] {
+				name, val, _ := strings.Cut(strings.TrimSpace(param), "=")
+				switch name {
+				case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+				case "server_max_window_bits":
+					acceptable = strings.Trim(val, `"`) == "15"
+				default:
+					acceptable = false
+				}
+				if !acceptable {
+					break
+				}
+			}
+			if acceptable {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deflateMessage 压缩一条消息的负载
+func deflateMessage(data []byte) []byte {
+	var buf bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&buf)
+	w.Write(data)
+	w.Flush()
+	flateWriters.Put(w)
+	return bytes.TrimSuffix(buf.Bytes(), deflateTail)
+}
+
+// inflateMessage 解压一条消息的负载, limit 大于 0 时限制解压后的大小
+func inflateMessage(data []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), bytes.NewReader(deflateTail), bytes.NewReader(finalBlock)))
+	defer r.Close()
+
+	var reader io.Reader = r
+	if limit > 0 {
+		reader = io.LimitReader(r, limit+1)
+	}
+	message, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("invalid compressed message: %w", err)
+	}
+	if limit > 0 && int64(len(message)) > limit {
+		return nil, &protocolError{closeTooLarge, "message too large"}
+	}
+	return message, nil
+}
+
+// preparedMessage 要发送给多个客户端的消息, 压缩结果在第一次需要时计算
+// 并共享
+type preparedMessage struct {
+	data     []byte
+	once     sync.Once
+	deflated []byte
+}
+
+func newPreparedMessage(data []byte) *preparedMessage {
+	return &preparedMessage{data: data}
+}
+
+// frame 返回发给客户端的文本帧, deflate 为客户端是否协商了压缩
+func (m *preparedMessage) frame(deflate bool) *frame {
+	if !deflate || len(m.data) < compressThreshold {
+		return &frame{fin: true, opcode: opText, payload: m.data}
+	}
+	m.once.Do(func() {
+		m.deflated = deflateMessage(m.data)
+	})
+	return &frame{fin: true, rsv: rsvCompressed, opcode: opText, payload: m.deflated}
+}
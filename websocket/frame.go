@@ -0,0 +1,196 @@
+package websocket
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf8"
+)
+
+// 帧的操作码 (RFC 6455 5.2)
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// 关闭帧的状态码 (RFC 6455 7.4.1)
+const (
+	closeNormal          = 1000
+	closeGoingAway       = 1001
+	closeProtocolError   = 1002
+	closeNoStatus        = 1005 // 只在本地表示对方没有给出状态码, 不能发送
+	closeInvalidPayload  = 1007
+	closePolicyViolation = 1008
+	closeTooLarge        = 1009
+)
+
+// maxControlPayload 控制帧的负载上限
+const maxControlPayload = 125
+
+// frame 一个 WebSocket 帧, payload 已去掉掩码
+type frame struct {
+	fin     bool
+	rsv     byte // RSV1-3 位, 没有协商扩展时必须为 0
+	opcode  byte
+	payload []byte
+}
+
+// isControl 控制帧 (close, ping, pong) 不能分片, 可以插在分片消息中间
+func (f *frame) isControl() bool {
+	return f.opcode&0x8 != 0
+}
+
+// protocolError 对方违反协议, 以 code 关闭连接
+type protocolError struct {
+	code   int
+	reason string
+}
+
+func (e *protocolError) Error() string {
+	return fmt.Sprintf("websocket: %s (%d)", e.reason, e.code)
+}
+
+// readFrame 读取一帧. 客户端发来的帧必须带掩码, fromClient 为 false 时
+// 不做要求. maxPayload 大于 0 时限制负载大小
+func readFrame(r io.Reader, fromClient bool, maxPayload int64) (*frame, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	f := &frame{
+		fin:    header[0]&0x80 != 0,
+		rsv:    header[0] & 0x70,
+		opcode: header[0] & 0x0f,
+	}
+	masked := header[1]&0x80 != 0
+
+	switch f.opcode {
+	case opContinuation, opText, opBinary, opClose, opPing, opPong:
+	default:
+		return nil, &protocolError{closeProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode)}
+	}
+	if fromClient && !masked {
+		return nil, &protocolError{closeProtocolError, "client frame is not masked"}
+	}
+
+	length := uint64(header[1] & 0x7f)
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(r, ext[:]); err != nil {
+			return nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+		if length>>63 != 0 {
+			return nil, &protocolError{closeProtocolError, "invalid payload length"}
+		}
+	}
+	if f.isControl() && (!f.fin || length > maxControlPayload) {
+		return nil, &protocolError{closeProtocolError, "invalid control frame"}
+	}
+	if maxPayload > 0 && length > uint64(maxPayload) {
+		return nil, &protocolError{closeTooLarge, "message too large"}
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err := io.ReadFull(r, mask[:]); err != nil {
+			return nil, err
+		}
+	}
+	f.payload = make([]byte, length)
+	if _, err := io.ReadFull(r, f.payload); err != nil {
+		return nil, err
+	}
+	if masked {
+		for i := range f.payload {
+			f.payload[i] ^= mask[i%4]
+		}
+	}
+	return f, nil
+}
+
+// appendTo 把帧编码后追加到 buf. 客户端发出的帧需要 mask, 服务端发出的
+// 帧 mask 为 nil
+func (f *frame) appendTo(buf []byte, mask []byte) []byte {
+	first := f.rsv | f.opcode
+	if f.fin {
+		first |= 0x80
+	}
+	var maskBit byte
+	if mask != nil {
+		maskBit = 0x80
+	}
+
+	n := len(f.payload)
+	switch {
+	case n < 126:
+		buf = append(buf, first, maskBit|byte(n))
+	case n < 65536:
+		buf = append(buf, first, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, first, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if mask == nil {
+		return append(buf, f.payload...)
+	}
+	buf = append(buf, mask...)
+	for i, b := range f.payload {
+		buf = append(buf, b^mask[i%4])
+	}
+	return buf
+}
+
+// closePayload 编码关闭帧的状态码和原因, 原因会截断到控制帧的长度限制内
+func closePayload(code int, reason string) []byte {
+	if code == closeNoStatus {
+		return nil
+	}
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	for len(reason) > maxControlPayload-2 || !utf8.ValidString(reason) {
+		reason = reason[:len(reason)-1]
+	}
+	return append(payload, reason...)
+}
+
+// parseClose 解析收到的关闭帧, 没有状态码时返回 closeNoStatus
+func parseClose(payload []byte) (int, string, error) {
+	if len(payload) == 0 {
+		return closeNoStatus, "", nil
+	}
+	if len(payload) == 1 {
+		return 0, "", &protocolError{closeProtocolError, "invalid close frame"}
+	}
+	code := int(binary.BigEndian.Uint16(payload))
+	if !validCloseCode(code) {
+		return 0, "", &protocolError{closeProtocolError, fmt.Sprintf("invalid close code %d", code)}
+	}
+	if !utf8.Valid(payload[2:]) {
+		return 0, "", &protocolError{closeInvalidPayload, "invalid close reason"}
+	}
+	return code, string(payload[2:]), nil
+}
+
+// validCloseCode 是否是可以出现在关闭帧中的状态码 (RFC 6455 7.4)
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1011:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,22 @@
+package websocket
+
+import "time"
+
+// HealthEvent 上游地址在健康和不健康之间切换时推送给所有客户端,
+// 面板不必等到用户请求失败才发现上游故障
+type HealthEvent struct {
+	Type           string    `json:"type"` // 固定为 "health"
+	Time           time.Time `json:"time"`
+	URL            string    `json:"url"`
+	Targets        []string  `json:"targets"` // 转发到该地址的目标路径
+	Healthy        bool      `json:"healthy"`
+	Error          string    `json:"error,omitempty"`  // 失败检查的原因
+	ErrorCount     int       `json:"error_count"`      // 连续失败的检查次数
+	ResponseTimeMs float64   `json:"response_time_ms"` // 本次检查的耗时
+}
+
+// BroadcastHealth 推送上游健康状态的变化, 没有客户端时直接丢弃
+func (h *Hub) BroadcastHealth(event *HealthEvent) {
+	event.Type = "health"
+	h.broadcastJSON("health", event)
+}
@@ -0,0 +1,69 @@
+package websocket
+
+import (
+	"math"
+	"time"
+
+	"ccproxy/types"
+)
+
+// latencyHistogram 以固定内存估算耗时分位数. 桶按 2% 递增 (类似两位有效数字的
+// HDR histogram), 估算值与实际值相差不超过 1%
+type latencyHistogram struct {
+	counts   []uint64 // 第 i 个桶覆盖 [1.02^i, 1.02^(i+1)) 微秒
+	total    uint64
+	min, max time.Duration
+}
+
+const (
+	histogramGrowth  = 1.02
+	histogramBuckets = 1200 // 最大约 5.8 小时, 更长的耗时计入最后一个桶
+)
+
+var histogramLogGrowth = math.Log(histogramGrowth)
+
+func (h *latencyHistogram) record(d time.Duration) {
+	if h.counts == nil {
+		h.counts = make([]uint64, histogramBuckets)
+	}
+	i := 0
+	if us := float64(d.Microseconds()); us > 1 {
+		i = min(int(math.Log(us)/histogramLogGrowth), histogramBuckets-1)
+	}
+	h.counts[i]++
+
+	if h.total == 0 || d < h.min {
+		h.min = d
+	}
+	h.max = max(h.max, d)
+	h.total++
+}
+
+// quantile 返回 q (0-1) 分位的耗时, 取桶的几何中点并限制在实际的最小值和最大值之间
+func (h *latencyHistogram) quantile(q float64) time.Duration {
+	if h.total == 0 {
+		return 0
+	}
+	rank := uint64(math.Ceil(q * float64(h.total)))
+	var seen uint64
+	for i, count := range h.counts {
+		seen += count
+		if seen >= rank {
+			d := time.Duration(math.Pow(histogramGrowth, float64(i)+0.5) * float64(time.Microsecond))
+			return min(max(d, h.min), h.max)
+		}
+	}
+	return h.max
+}
+
+// percentiles 返回 p50/p95/p99, 没有记录时返回 nil
+func (h *latencyHistogram) percentiles() *types.LatencyPercentiles {
+	if h.total == 0 {
+		return nil
+	}
+	return &types.LatencyPercentiles{
+		P50: milliseconds(h.quantile(0.50)),
+		P95: milliseconds(h.quantile(0.95)),
+		P99: milliseconds(h.quantile(0.99)),
+	}
+}
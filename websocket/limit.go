@@ -0,0 +1,63 @@
+package websocket
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SetMaxMessageSize 设置推送的日志消息序列化后的上限, 超过时截断请求体和
+// 响应体. 一个巨大的响应不会拖住所有客户端的写入. 不大于 0 时不限制
+func (h *Hub) SetMaxMessageSize(n int) {
+	h.maxMessageSize.Store(int64(n))
+}
+
+// prepareLog 序列化要推送的日志, 超过上限时截断内容后重新序列化
+func (h *Hub) prepareLog(message *LogMessage) *preparedMessage {
+	prepared := prepareMessage(message)
+	limit := int(h.maxMessageSize.Load())
+	if prepared == nil || limit <= 0 || len(prepared.data) <= limit {
+		return prepared
+	}
+
+	// JSON 转义会让内容变长, 按比例缩小内容直到放得下
+	budget := len(message.RequestBody) + len(message.ResponseBody)
+	for size := len(prepared.data); size > limit && budget > 0; size = len(prepared.data) {
+		budget = int(int64(budget)*int64(limit)/int64(size)) - 2*len(truncatedMarker)
+		truncated := truncatedCopy(message, max(budget, 0))
+		if prepared = prepareMessage(truncated); prepared == nil {
+			return nil
+		}
+	}
+	return prepared
+}
+
+// truncatedMarker 与日志内容截断时的标记一致, %d 为内容原来的字节数
+const truncatedMarker = "\n[TRUNCATED - %d bytes total]"
+
+// truncatedCopy 返回请求体和响应体合计不超过 budget 字节的副本. 较短的一方
+// 尽量完整保留, 剩下的留给较长的一方
+func truncatedCopy(message *LogMessage, budget int) *LogMessage {
+	entry := *message
+	request, response := len(entry.RequestBody), len(entry.ResponseBody)
+	switch {
+	case request+response <= budget:
+		return &entry
+	case request <= budget/2:
+		response = budget - request
+	case response <= budget/2:
+		request = budget - response
+	default:
+		request, response = budget/2, budget-budget/2
+	}
+	entry.RequestBody = truncateBody(entry.RequestBody, request, entry.RequestSize)
+	entry.ResponseBody = truncateBody(entry.ResponseBody, response, entry.ResponseSize)
+	return &entry
+}
+
+// truncateBody 截断到 limit 字节并加上标记, size 为转发的字节数
+func truncateBody(body string, limit int, size int64) string {
+	if len(body) <= limit {
+		return body
+	}
+	return strings.ToValidUTF8(body[:limit], "") + fmt.Sprintf(truncatedMarker, max(size, int64(len(body))))
+}
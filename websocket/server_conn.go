@@ -0,0 +1,174 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	writeWait      = 10 * time.Second // 单次写入的超时, 卡住的客户端不会一直占用发送
+	pongWait       = 60 * time.Second // 这段时间内没有收到任何帧即认为连接已断开
+	pingPeriod     = 25 * time.Second // 发送 ping 的间隔, 必须小于 pongWait
+	maxMessageSize = 64 << 10         // 客户端消息的上限, 面板只会发送订阅消息
+)
+
+// readLoop 读取客户端发来的帧直到连接断开: 回复 ping, 完成关闭握手, 拼接
+// 分片的消息并交给 handleMessage. 每收到一帧都会延长读超时, 浏览器会自动
+// 回复 pingLoop 发出的 ping
+func (c *Client) readLoop(reader *bufio.Reader) {
+	var message []byte
+	var messageOpcode byte // 正在接收的分片消息, 没有时为 0
+	var compressed bool    // 消息的第一帧设置了 RSV1
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(pongWait))
+		f, err := readFrame(reader, true, maxMessageSize)
+		if err != nil {
+			var perr *protocolError
+			if errors.As(err, &perr) {
+				log.Printf("[WARN] WebSocket client %s: %v", c.conn.RemoteAddr(), err)
+				c.closeWith(perr.code, perr.reason)
+			}
+			return
+		}
+		// 只有协商了压缩时, 数据消息的第一帧可以设置 RSV1
+		if f.rsv != 0 && (f.rsv != rsvCompressed || !c.deflate || (f.opcode != opText && f.opcode != opBinary)) {
+			c.closeWith(closeProtocolError, "unexpected reserved bits")
+			return
+		}
+
+		switch f.opcode {
+		case opPing:
+			c.write(&frame{fin: true, opcode: opPong, payload: f.payload})
+			continue
+		case opPong:
+			continue
+		case opClose:
+			// 按协议回复对方给出的状态码后关闭连接
+			code, _, err := parseClose(f.payload)
+			if err != nil {
+				var perr *protocolError
+				errors.As(err, &perr)
+				code = perr.code
+			}
+			c.closeWith(code, "")
+			return
+		case opText, opBinary:
+			if messageOpcode != 0 {
+				c.closeWith(closeProtocolError, "expected continuation frame")
+				return
+			}
+			messageOpcode, message, compressed = f.opcode, f.payload, f.rsv != 0
+		case opContinuation:
+			if messageOpcode == 0 {
+				c.closeWith(closeProtocolError, "unexpected continuation frame")
+				return
+			}
+			if len(message)+len(f.payload) > maxMessageSize {
+				c.closeWith(closeTooLarge, "message too large")
+				return
+			}
+			message = append(message, f.payload...)
+		}
+
+		if f.fin {
+			if compressed {
+				if message, err = inflateMessage(message, maxMessageSize); err != nil {
+					var perr *protocolError
+					if errors.As(err, &perr) {
+						c.closeWith(perr.code, perr.reason)
+					} else {
+						c.closeWith(closeInvalidPayload, "invalid compressed message")
+					}
+					return
+				}
+			}
+			if messageOpcode == opText {
+				if !utf8.Valid(message) {
+					c.closeWith(closeInvalidPayload, "invalid UTF-8 in text message")
+					return
+				}
+				c.handleMessage(message)
+			}
+			messageOpcode, message = 0, nil
+		}
+	}
+}
+
+// handleMessage 处理客户端发来的文本消息, 按 type 分发, 其他消息忽略
+func (c *Client) handleMessage(data []byte) {
+	var envelope struct {
+		Type string `json:"type"`
+	}
+	if json.Unmarshal(data, &envelope) != nil {
+		return
+	}
+	switch envelope.Type {
+	case "auth":
+		c.handleAuth(data)
+	case "subscribe":
+		c.subscribe(data)
+	}
+}
+
+// pingLoop 定时发送 ping, 中间的代理不会把连接当作空闲而断开, 已经失效的
+// 连接也会因为收不到回应而被 readLoop 清理
+func (c *Client) pingLoop() {
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if !c.write(&frame{fin: true, opcode: opPing}) {
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// write 发送一帧, 失败时关闭连接并从 Hub 中移除. 返回是否发送成功
+func (c *Client) write(f *frame) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return false
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	if _, err := c.conn.Write(f.appendTo(nil, nil)); err != nil {
+		log.Printf("[ERROR] Failed to write to WebSocket connection: %v", err)
+		c.closeLocked()
+		go c.hub.removeClient(c)
+		return false
+	}
+	return true
+}
+
+// closeWith 发送关闭帧后关闭连接. 服务端先关闭 TCP 连接, 不等待对方回复
+func (c *Client) closeWith(code int, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	closeFrame := &frame{fin: true, opcode: opClose, payload: closePayload(code, reason)}
+	c.conn.Write(closeFrame.appendTo(nil, nil))
+	c.closeLocked()
+}
+
+// closeLocked 关闭连接, 调用方持有 c.mu
+func (c *Client) closeLocked() {
+	if !c.closed {
+		c.conn.Close()
+		c.closed = true
+		close(c.done)
+	}
+}
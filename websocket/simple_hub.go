@@ -1,15 +1,18 @@
 package websocket
 
 import (
+	"bufio"
 	"crypto/sha1"
 	"encoding/base64"
-	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net"
 	"net/http"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"ccproxy/storage"
@@ -24,37 +27,52 @@ type Statistics = types.Statistics
 
 type Hub struct {
 	clients       map[*Client]bool
+	streams       map[*Stream]bool // SSE 客户端, 和 clients 一样由 mu 保护
 	broadcast     chan *LogMessage
 	mu            sync.RWMutex
 	stats         *Statistics
 	history       []*LogMessage  // 保留少量内存缓存用于快速访问
 	historyMu     sync.RWMutex
 	maxHistory    int
-	historyStorage *storage.HistoryStorage // 持久化存储
+	historyStorage storage.History       // 持久化存储
 	statsMu        sync.RWMutex          // 统计信息的锁
+	targets        map[string]*targetCounter // 按上游地址的统计, 由 statsMu 保护
+	latency        latencyHistogram          // 所有请求的耗时分布, 由 statsMu 保护
+	series         timeSeries                // 最近 24 小时按分钟的统计, 由 statsMu 保护
+	sinks          []LogSink             // 访问日志等外部输出
+	sinksMu        sync.RWMutex
+	maxMessageSize atomic.Int64 // 推送的日志消息的上限, 见 SetMaxMessageSize
+	capturePaused  atomic.Bool  // 暂停记录请求, 见 SetCapturePaused
 }
 
 type Client struct {
-	conn   net.Conn
-	hub    *Hub
-	closed bool
-	mu     sync.Mutex
+	conn    net.Conn
+	hub     *Hub
+	closed  bool
+	deflate bool          // 协商了 permessage-deflate
+	done    chan struct{} // 连接关闭时关闭
+	mu      sync.Mutex    // 保护 closed 和 subscription, 串行化写入
+
+	subscription *Subscription // 客户端的订阅条件, nil 时推送全部日志
+	authenticate Authenticator // 等待认证消息时不为 nil, 此时客户端还没有加入 Hub
 }
 
 
-func NewHub(broadcastSize int, dataDir string) (*Hub, error) {
+func NewHub(broadcastSize int, dataDir, historyBackend string) (*Hub, error) {
 	// 创建持久化存储
-	historyStorage, err := storage.NewHistoryStorage(dataDir, 10, 10000) // 最多10个文件，每个文件10000行
+	historyStorage, err := storage.OpenHistory(dataDir, historyBackend)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create history storage: %w", err)
 	}
 
 	return &Hub{
 		clients:        make(map[*Client]bool),
+		streams:        make(map[*Stream]bool),
 		broadcast:      make(chan *LogMessage, broadcastSize),
 		history:        make([]*LogMessage, 0),
 		maxHistory:     20, // 内存中只保留最近20条用于快速访问
 		historyStorage: historyStorage,
+		targets:        make(map[string]*targetCounter),
 		stats: &types.Statistics{
 			StartTime:        time.Now(),
 			StatusCodeCounts: make(map[int]int64),
@@ -65,9 +83,33 @@ func NewHub(broadcastSize int, dataDir string) (*Hub, error) {
 
 func (h *Hub) Run() {
 	for message := range h.broadcast {
+		// 每条消息只序列化和压缩一次, 不含内容的版本在有客户端需要时生成
+		full := h.prepareLog(message)
+		var withoutBodies *preparedMessage
+		prepared := func(bodies bool) *preparedMessage {
+			if bodies {
+				return full
+			}
+			if withoutBodies == nil {
+				withoutBodies = h.prepareLog(metadataOnly(message))
+			}
+			return withoutBodies
+		}
+
 		h.mu.RLock()
 		for client := range h.clients {
-			go client.sendMessage(message)
+			if wanted, bodies := client.wants(message); wanted {
+				if m := prepared(bodies); m != nil {
+					go client.send(m)
+				}
+			}
+		}
+		for stream := range h.streams {
+			if wanted, bodies := subscriptionWants(stream.subscription, message); wanted {
+				if m := prepared(bodies); m != nil {
+					stream.push(streamEvent{id: message.RequestID, data: m.data})
+				}
+			}
 		}
 		h.mu.RUnlock()
 	}
@@ -90,6 +132,12 @@ func (h *Hub) Broadcast(message *LogMessage) {
 	}
 }
 
+// RecordStats counts a request in the statistics without storing or
+// broadcasting it, for targets with logging disabled
+func (h *Hub) RecordStats(message *LogMessage) {
+	h.updateStats(message)
+}
+
 func (h *Hub) updateStats(message *LogMessage) {
 	h.statsMu.Lock()
 	defer h.statsMu.Unlock()
@@ -109,6 +157,13 @@ func (h *Hub) updateStats(message *LogMessage) {
 	} else {
 		h.stats.ErrorRequests++
 	}
+	d, err := time.ParseDuration(message.Duration)
+	if err == nil {
+		h.latency.record(d)
+	}
+	h.series.record(time.Now(), message.StatusCode < 200 || message.StatusCode >= 400, d, err == nil)
+
+	h.updateTargetStats(message)
 }
 
 func (h *Hub) GetStats() *Statistics {
@@ -124,6 +179,8 @@ func (h *Hub) GetStats() *Statistics {
 		LastRequestTime:  h.stats.LastRequestTime,
 		StatusCodeCounts: make(map[int]int64),
 		MethodCounts:     make(map[string]int64),
+		Targets:          h.targetStats(),
+		Latency:          h.latency.percentiles(),
 	}
 	
 	// Copy maps
@@ -141,7 +198,9 @@ func (h *Hub) addToHistory(message *LogMessage) {
 	// 深拷贝消息以避免后续修改影响历史记录
 	messageCopy := &LogMessage{
 		Timestamp:            message.Timestamp,
+		RequestID:            message.RequestID,
 		Method:               message.Method,
+		Host:                 message.Host,
 		Path:                 message.Path,
 		Query:                message.Query,
 		RequestHeaders:       make(map[string]string),
@@ -152,7 +211,12 @@ func (h *Hub) addToHistory(message *LogMessage) {
 		TargetURL:            message.TargetURL,
 		RequestBody:          message.RequestBody,
 		ResponseBody:         message.ResponseBody,
+		RequestSize:          message.RequestSize,
+		ResponseSize:         message.ResponseSize,
 		Error:                message.Error,
+		ErrorType:            message.ErrorType,
+		AttemptCount:         message.AttemptCount,
+		Attempts:             append([]types.Attempt(nil), message.Attempts...),
 		ConnectDuration:      message.ConnectDuration,
 		DNSLookupDuration:    message.DNSLookupDuration,
 		TLSHandshakeDuration: message.TLSHandshakeDuration,
@@ -208,6 +272,46 @@ func (h *Hub) GetHistory(limit int) ([]*LogMessage, error) {
 	return h.historyStorage.GetRecentMessages(limit)
 }
 
+// QueryHistory 返回符合条件的历史记录, 最新的在前
+func (h *Hub) QueryHistory(filter storage.HistoryFilter) ([]*LogMessage, error) {
+	if h.historyStorage != nil {
+		return h.historyStorage.Query(filter)
+	}
+
+	h.historyMu.RLock()
+	defer h.historyMu.RUnlock()
+	var history []*LogMessage
+	for i := len(h.history) - 1; i >= 0; i-- {
+		if filter.Match(h.history[i]) {
+			history = append(history, h.history[i])
+			if filter.Limit > 0 && len(history) >= filter.Limit {
+				break
+			}
+		}
+	}
+	return history, nil
+}
+
+// HistoryStorage 返回持久化存储, 未启用时为 nil
+func (h *Hub) HistoryStorage() storage.History {
+	return h.historyStorage
+}
+
+// Close 通知所有客户端服务端即将关闭, 结束 SSE 响应, 然后关闭持久化存储
+func (h *Hub) Close() error {
+	h.mu.RLock()
+	for client := range h.clients {
+		client.closeWith(closeGoingAway, "server shutting down")
+	}
+	h.mu.RUnlock()
+	h.CloseStreams()
+
+	if h.historyStorage != nil {
+		return h.historyStorage.Close()
+	}
+	return nil
+}
+
 // ClearHistory 清空所有历史记录
 func (h *Hub) ClearHistory() error {
 	// 清空内存中的历史记录
@@ -238,6 +342,15 @@ func (h *Hub) sendHistoryToClient(client *Client) {
 	}
 }
 
+func (h *Hub) addClient(client *Client) {
+	h.mu.Lock()
+	h.clients[client] = true
+	clientCount := len(h.clients)
+	h.mu.Unlock()
+
+	log.Printf("[INFO] WebSocket client connected. Total: %d", clientCount)
+}
+
 func (h *Hub) removeClient(client *Client) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
@@ -251,30 +364,37 @@ func (h *Hub) removeClient(client *Client) {
 func (c *Client) close() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	if !c.closed {
-		c.conn.Close()
-		c.closed = true
-	}
+	c.closeLocked()
 }
 
-func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
-	conn, err := h.upgradeConnection(w, r)
+// ServeWS 接受 WebSocket 连接. authenticate 不为 nil 时连接没有携带凭据,
+// 客户端必须先发送认证消息才会收到推送
+func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request, authenticate Authenticator) {
+	deflate := negotiateDeflate(r.Header)
+	conn, reader, err := h.upgradeConnection(w, r, deflate)
+	if errors.Is(err, errUnsupportedVersion) {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, err.Error(), http.StatusUpgradeRequired)
+		return
+	}
 	if err != nil {
 		http.Error(w, "Could not upgrade connection", http.StatusBadRequest)
 		return
 	}
 
 	client := &Client{
-		conn: conn,
-		hub:  h,
+		conn:    conn,
+		hub:     h,
+		deflate: deflate,
+		done:    make(chan struct{}),
 	}
 
-	h.mu.Lock()
-	h.clients[client] = true
-	clientCount := len(h.clients)
-	h.mu.Unlock()
-
-	log.Printf("[INFO] WebSocket client connected. Total: %d", clientCount)
+	if authenticate == nil {
+		h.addClient(client)
+	} else {
+		client.authenticate = authenticate
+		client.requireAuth()
+	}
 
 	// 不再自动发送历史消息，由前端通过API获取
 	// go h.sendHistoryToClient(client)
@@ -282,86 +402,109 @@ func (h *Hub) ServeWS(w http.ResponseWriter, r *http.Request) {
 	go func() {
 		defer func() {
 			h.mu.Lock()
+			_, added := h.clients[client]
 			delete(h.clients, client)
 			totalClients := len(h.clients)
 			h.mu.Unlock()
 			client.close()
-			log.Printf("[INFO] WebSocket client disconnected. Total: %d", totalClients)
+			if added {
+				log.Printf("[INFO] WebSocket client disconnected. Total: %d", totalClients)
+			}
 		}()
 
-		buf := make([]byte, 1024)
-		for {
-			_, err := conn.Read(buf)
-			if err != nil {
-				break
-			}
-		}
+		client.readLoop(reader)
 	}()
+	go client.pingLoop()
 }
 
-func (h *Hub) upgradeConnection(w http.ResponseWriter, r *http.Request) (net.Conn, error) {
-	if r.Header.Get("Upgrade") != "websocket" {
-		return nil, fmt.Errorf("not a websocket upgrade")
+// errUnsupportedVersion 客户端使用的协议版本不是 13
+var errUnsupportedVersion = errors.New("unsupported websocket version")
+
+// upgradeConnection 完成握手 (RFC 6455 4.2), deflate 时接受 permessage-deflate.
+// 返回的 reader 包含握手请求之后客户端已经发来的数据
+func (h *Hub) upgradeConnection(w http.ResponseWriter, r *http.Request, deflate bool) (net.Conn, *bufio.Reader, error) {
+	if r.Method != "GET" || !headerHasToken(r.Header, "Upgrade", "websocket") ||
+		!headerHasToken(r.Header, "Connection", "upgrade") {
+		return nil, nil, fmt.Errorf("not a websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, nil, errUnsupportedVersion
 	}
 
 	key := r.Header.Get("Sec-WebSocket-Key")
 	if key == "" {
-		return nil, fmt.Errorf("missing Sec-WebSocket-Key")
+		return nil, nil, fmt.Errorf("missing Sec-WebSocket-Key")
 	}
 
 	acceptKey := computeAcceptKey(key)
 
 	hijacker, ok := w.(http.Hijacker)
 	if !ok {
-		return nil, fmt.Errorf("response writer doesn't support hijacking")
+		return nil, nil, fmt.Errorf("response writer doesn't support hijacking")
 	}
 
 	conn, bufrw, err := hijacker.Hijack()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
+	// 握手前的超时由 http.Server 设置, 之后由 readLoop 和 write 控制
+	conn.SetDeadline(time.Time{})
 
 	response := fmt.Sprintf(
 		"HTTP/1.1 101 Switching Protocols\r\n"+
 			"Upgrade: websocket\r\n"+
 			"Connection: Upgrade\r\n"+
-			"Sec-WebSocket-Accept: %s\r\n\r\n",
+			"Sec-WebSocket-Accept: %s\r\n",
 		acceptKey)
+	if deflate {
+		response += "Sec-WebSocket-Extensions: " + deflateResponse + "\r\n"
+	}
+	response += "\r\n"
 
 	if _, err := bufrw.WriteString(response); err != nil {
 		conn.Close()
-		return nil, err
+		return nil, nil, err
 	}
 
 	if err := bufrw.Flush(); err != nil {
 		conn.Close()
-		return nil, err
+		return nil, nil, err
 	}
 
-	return conn, nil
+	return conn, bufrw.Reader, nil
 }
 
-func (c *Client) sendMessage(message *LogMessage) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+// headerHasToken 逗号分隔的请求头中是否有 token, 不区分大小写
+func headerHasToken(header http.Header, name, token string) bool {
+	for _, value := range header.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
 
-	if c.closed {
-		return
+func (c *Client) sendMessage(message *LogMessage) {
+	if prepared := prepareMessage(message); prepared != nil {
+		c.send(prepared)
 	}
+}
 
+// prepareMessage 序列化一条日志, 失败时返回 nil
+func prepareMessage(message *LogMessage) *preparedMessage {
 	jsonData, err := json.Marshal(message)
 	if err != nil {
 		log.Printf("[ERROR] Failed to marshal WebSocket message: %v", err)
-		return
+		return nil
 	}
+	return newPreparedMessage(jsonData)
+}
 
-	frame := createTextFrame(jsonData)
-	if _, err := c.conn.Write(frame); err != nil {
-		log.Printf("[ERROR] Failed to write to WebSocket connection: %v", err)
-		// Mark as closed and remove from hub
-		c.closed = true
-		go c.hub.removeClient(c)
-	}
+// send writes a JSON message as a text frame, compressed when negotiated
+func (c *Client) send(message *preparedMessage) {
+	c.write(message.frame(c.deflate))
 }
 
 func computeAcceptKey(key string) string {
@@ -369,27 +512,3 @@ func computeAcceptKey(key string) string {
 	h.Write([]byte(key + websocketMagicString))
 	return base64.StdEncoding.EncodeToString(h.Sum(nil))
 }
-
-func createTextFrame(payload []byte) []byte {
-	payloadLen := len(payload)
-	var frame []byte
-
-	frame = append(frame, 0x81)
-
-	if payloadLen < 126 {
-		frame = append(frame, byte(payloadLen))
-	} else if payloadLen < 65536 {
-		frame = append(frame, 126)
-		lenBytes := make([]byte, 2)
-		binary.BigEndian.PutUint16(lenBytes, uint16(payloadLen))
-		frame = append(frame, lenBytes...)
-	} else {
-		frame = append(frame, 127)
-		lenBytes := make([]byte, 8)
-		binary.BigEndian.PutUint64(lenBytes, uint64(payloadLen))
-		frame = append(frame, lenBytes...)
-	}
-
-	frame = append(frame, payload...)
-	return frame
-}
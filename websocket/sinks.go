@@ -0,0 +1,42 @@
+package websocket
+
+import "log"
+
+// LogSink receives every request whether or not it is shown in the
+// dashboard, e.g. the logging.file access log. Bodies are already redacted
+// and empty for targets logged as metadata, sinks drop them when unwanted.
+type LogSink interface {
+	WriteLog(message *LogMessage) error
+	Close() error
+}
+
+// SetSinks replaces the log sinks, the previous ones are closed
+func (h *Hub) SetSinks(sinks []LogSink) {
+	h.sinksMu.Lock()
+	previous := h.sinks
+	h.sinks = sinks
+	h.sinksMu.Unlock()
+
+	for _, sink := range previous {
+		if err := sink.Close(); err != nil {
+			log.Printf("[WARN] Failed to close log sink: %v", err)
+		}
+	}
+}
+
+// WriteSinks sends message to every sink with the statistics removed
+func (h *Hub) WriteSinks(message *LogMessage) {
+	h.sinksMu.RLock()
+	defer h.sinksMu.RUnlock()
+
+	if len(h.sinks) == 0 {
+		return
+	}
+	entry := *message
+	entry.Stats = nil
+	for _, sink := range h.sinks {
+		if err := sink.WriteLog(&entry); err != nil {
+			log.Printf("[WARN] Failed to write log sink: %v", err)
+		}
+	}
+}
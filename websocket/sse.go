@@ -0,0 +1,188 @@
+package websocket
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	streamBuffer  = 256  // 每个 SSE 客户端缓存的事件数, 写满说明客户端跟不上, 断开后由它重连续传
+	replayLimit   = 1000 // 按 Last-Event-ID 续传时最多查找的历史记录
+	streamRetryMs = 3000 // 建议浏览器断开后重连的间隔
+)
+
+// Stream 一个 Server-Sent Events 客户端. 和 WebSocket 客户端共用广播路径,
+// 用于 WebSocket 升级被公司代理拦截的环境
+type Stream struct {
+	events       chan streamEvent
+	done         chan struct{}
+	once         sync.Once
+	subscription *Subscription // 请求参数给出的过滤条件, nil 时推送全部日志
+}
+
+// streamEvent 一条待发送的事件, 日志事件的 id 是请求 ID
+type streamEvent struct {
+	name string
+	id   string
+	data []byte
+}
+
+// encode 按 text/event-stream 格式编码. json.Marshal 的结果不含换行, 可以直接
+// 作为一行 data
+func (e streamEvent) encode() []byte {
+	var buf bytes.Buffer
+	if e.name != "" {
+		fmt.Fprintf(&buf, "event: %s\n", e.name)
+	}
+	if e.id != "" {
+		fmt.Fprintf(&buf, "id: %s\n", e.id)
+	}
+	buf.WriteString("data: ")
+	buf.Write(e.data)
+	buf.WriteString("\n\n")
+	return buf.Bytes()
+}
+
+// push 把事件放入缓存, 缓存已满时断开客户端, 不阻塞广播
+func (s *Stream) push(event streamEvent) {
+	select {
+	case <-s.done:
+	case s.events <- event:
+	default:
+		log.Printf("[WARN] SSE client too slow, closing stream")
+		s.close()
+	}
+}
+
+func (s *Stream) close() {
+	s.once.Do(func() { close(s.done) })
+}
+
+// ServeSSE 以 Server-Sent Events 推送日志, 统计和健康状态, 后两者分别以
+// stats 和 health 事件发送. 请求带有 Last-Event-ID 时先从历史记录补发之后
+// 的日志, 浏览器的 EventSource 重连时会自动带上它
+func (h *Hub) ServeSSE(w http.ResponseWriter, r *http.Request, sub *Subscription) {
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no") // 让 nginx 不缓冲响应
+	w.WriteHeader(http.StatusOK)
+
+	// 先加入 Hub 再读取历史, 补发期间到达的日志留在缓存中, 不会遗漏
+	stream := &Stream{
+		events:       make(chan streamEvent, streamBuffer),
+		done:         make(chan struct{}),
+		subscription: sub,
+	}
+	h.addStream(stream)
+	defer h.removeStream(stream)
+
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetryMs)
+	var replayed map[string]bool
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	if lastID != "" {
+		replayed = h.replay(w, lastID, sub)
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case event := <-stream.events:
+			if event.id != "" && replayed[event.id] {
+				continue
+			}
+			if _, err := w.Write(event.encode()); err != nil {
+				return
+			}
+		case <-ticker.C:
+			// 注释行, 防止中间的代理把连接当作空闲而断开
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-stream.done:
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// replay 按时间顺序补发 lastID 之后的日志, 返回补发过的请求 ID. 找不到
+// lastID 时 (已被清理或超出查找范围) 补发查找范围内的全部记录
+func (h *Hub) replay(w http.ResponseWriter, lastID string, sub *Subscription) map[string]bool {
+	history, err := h.GetHistory(replayLimit)
+	if err != nil {
+		log.Printf("[ERROR] Failed to get history for SSE resume: %v", err)
+		return nil
+	}
+
+	start := len(history)
+	for i, message := range history {
+		if message.RequestID == lastID {
+			start = i
+			break
+		}
+	}
+
+	replayed := map[string]bool{lastID: true}
+	for i := start - 1; i >= 0; i-- {
+		message := history[i]
+		wanted, bodies := subscriptionWants(sub, message)
+		if !wanted {
+			continue
+		}
+		if !bodies {
+			message = metadataOnly(message)
+		}
+		prepared := h.prepareLog(message)
+		if prepared == nil {
+			continue
+		}
+		replayed[message.RequestID] = true
+		w.Write(streamEvent{id: message.RequestID, data: prepared.data}.encode())
+	}
+	return replayed
+}
+
+func (h *Hub) addStream(stream *Stream) {
+	h.mu.Lock()
+	h.streams[stream] = true
+	total := len(h.streams)
+	h.mu.Unlock()
+
+	log.Printf("[INFO] SSE client connected. Total: %d", total)
+}
+
+func (h *Hub) removeStream(stream *Stream) {
+	h.mu.Lock()
+	delete(h.streams, stream)
+	total := len(h.streams)
+	h.mu.Unlock()
+
+	stream.close()
+	log.Printf("[INFO] SSE client disconnected. Total: %d", total)
+}
+
+// CloseStreams 结束所有 SSE 响应. http.Server.Shutdown 会等待进行中的请求,
+// 需要在关闭 Web 服务时调用
+func (h *Hub) CloseStreams() {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for stream := range h.streams {
+		stream.close()
+	}
+}
@@ -0,0 +1,139 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// StatsMessage 定时推送给所有客户端的运行状态, 没有请求时面板也能保持更新.
+// 日志消息没有 type 字段, 客户端据此区分两种消息
+type StatsMessage struct {
+	Type           string                     `json:"type"` // 固定为 "stats"
+	Time           time.Time                  `json:"time"`
+	ActiveRequests int64                      `json:"active_requests"` // 正在处理的代理请求
+	Clients        int                        `json:"clients"`         // 已连接的 WebSocket 和 SSE 客户端
+	RequestRate    float64                    `json:"request_rate"`    // 上次推送以来的每秒请求数
+	Upstreams      map[string]*UpstreamStatus `json:"upstreams"`       // 按上游地址的健康检查结果
+	Queues         map[string]int             `json:"queues"`          // 各队列中等待发送的消息数
+	Stats          *Statistics                `json:"stats"`
+}
+
+// UpstreamStatus 一个上游地址最近的健康检查结果
+type UpstreamStatus struct {
+	Healthy        bool      `json:"healthy"`
+	ResponseTimeMs float64   `json:"response_time_ms"`
+	ErrorCount     int       `json:"error_count"`
+	LastCheck      time.Time `json:"last_check"`
+}
+
+// StatsSource 填写只有服务端知道的状态: 正在处理的请求和上游健康状态
+type StatsSource func(msg *StatsMessage)
+
+// queuedSink 是缓冲日志的输出, 例如 Loki 和 Elasticsearch 导出, 队列长度随状态推送
+type queuedSink interface {
+	QueueName() string
+	QueueDepth() int
+}
+
+// StatsPusher 每隔 interval 向所有客户端推送一次 StatsMessage
+type StatsPusher struct {
+	hub      *Hub
+	interval time.Duration
+	source   StatsSource
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// NewStatsPusher 开始推送状态
+func NewStatsPusher(hub *Hub, interval time.Duration, source StatsSource) *StatsPusher {
+	p := &StatsPusher{
+		hub:      hub,
+		interval: interval,
+		source:   source,
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+func (p *StatsPusher) run() {
+	defer close(p.done)
+
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+
+	lastTotal, lastTime := p.hub.GetStats().TotalRequests, time.Now()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+
+		msg := p.hub.statsMessage()
+		if elapsed := msg.Time.Sub(lastTime).Seconds(); elapsed > 0 {
+			msg.RequestRate = float64(msg.Stats.TotalRequests-lastTotal) / elapsed
+		}
+		lastTotal, lastTime = msg.Stats.TotalRequests, msg.Time
+		if msg.Clients == 0 {
+			continue
+		}
+		if p.source != nil {
+			p.source(msg)
+		}
+		p.hub.broadcastJSON("stats", msg)
+	}
+}
+
+// Close 停止推送
+func (p *StatsPusher) Close() error {
+	close(p.stop)
+	<-p.done
+	return nil
+}
+
+// statsMessage 返回 Hub 自身掌握的状态
+func (h *Hub) statsMessage() *StatsMessage {
+	msg := &StatsMessage{
+		Type:      "stats",
+		Time:      time.Now(),
+		Upstreams: map[string]*UpstreamStatus{},
+		Queues:    map[string]int{"broadcast": len(h.broadcast)},
+		Stats:     h.GetStats(),
+	}
+
+	h.mu.RLock()
+	msg.Clients = len(h.clients) + len(h.streams)
+	h.mu.RUnlock()
+
+	h.sinksMu.RLock()
+	for _, sink := range h.sinks {
+		if queued, ok := sink.(queuedSink); ok {
+			msg.Queues[queued.QueueName()] = queued.QueueDepth()
+		}
+	}
+	h.sinksMu.RUnlock()
+	return msg
+}
+
+// broadcastJSON 直接发送给所有客户端, 不经过日志消息的广播队列. event 是
+// SSE 客户端收到的事件名, WebSocket 客户端按消息中的 type 区分
+func (h *Hub) broadcastJSON(event string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("[ERROR] Failed to marshal WebSocket message: %v", err)
+		return
+	}
+
+	prepared := newPreparedMessage(data)
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for client := range h.clients {
+		go client.send(prepared)
+	}
+	for stream := range h.streams {
+		stream.push(streamEvent{name: event, data: data})
+	}
+}
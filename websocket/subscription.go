@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+
+	"ccproxy/storage"
+)
+
+// Subscription 客户端发送的订阅消息, 之后只推送符合条件的请求日志, 统计和
+// 健康状态等消息不受影响. 所有条件都可以省略, 空的订阅恢复推送全部日志.
+// 没有订阅的客户端收到的日志不含请求体和响应体
+type Subscription struct {
+	Type       string `json:"type"`        // 固定为 "subscribe"
+	MinStatus  int    `json:"min_status"`  // 状态码不低于它, 例如 400 只推送失败的请求
+	Status     string `json:"status"`      // 精确的状态码如 429, 或 5xx 这样的类别
+	Method     string `json:"method"`      // 不区分大小写
+	PathPrefix string `json:"path_prefix"` // 请求路径的前缀
+	Target     string `json:"target"`      // 上游地址包含的内容
+	Bodies     *bool  `json:"bodies"`      // true 时推送请求体和响应体, 默认只推送元数据, 需要时通过 /api/history 获取
+}
+
+// Match 日志是否符合订阅条件
+func (s *Subscription) Match(message *LogMessage) bool {
+	if s.MinStatus > 0 && message.StatusCode < s.MinStatus {
+		return false
+	}
+	if s.PathPrefix != "" && !strings.HasPrefix(message.Path, s.PathPrefix) {
+		return false
+	}
+	filter := storage.HistoryFilter{Status: s.Status, Method: s.Method, Target: s.Target}
+	return filter.Match(message)
+}
+
+// withBodies 是否推送请求体和响应体. 几 MB 的对话内容实时发给每个客户端
+// 会拖慢面板, 默认不推送
+func (s *Subscription) withBodies() bool {
+	return s != nil && s.Bodies != nil && *s.Bodies
+}
+
+// subscribe 处理订阅消息
+func (c *Client) subscribe(data []byte) {
+	var sub Subscription
+	if err := json.Unmarshal(data, &sub); err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	c.subscription = &sub
+	c.mu.Unlock()
+	summary, _ := json.Marshal(sub)
+	log.Printf("[INFO] WebSocket client %s subscribed: %s", c.conn.RemoteAddr(), summary)
+}
+
+// wants 返回客户端是否要接收 message, 以及是否包含内容
+func (c *Client) wants(message *LogMessage) (bool, bool) {
+	c.mu.Lock()
+	sub := c.subscription
+	c.mu.Unlock()
+	return subscriptionWants(sub, message)
+}
+
+// subscriptionWants 按订阅条件 sub 判断, sub 为 nil 时接收全部日志, 不含内容
+func subscriptionWants(sub *Subscription, message *LogMessage) (bool, bool) {
+	if sub != nil && !sub.Match(message) {
+		return false, false
+	}
+	return true, sub.withBodies()
+}
+
+// metadataOnly 返回推送给不接收内容的客户端的副本, 有内容被省略时标记
+// bodies_omitted, 面板据此在查看详情时从 /api/history/{id} 加载
+func metadataOnly(message *LogMessage) *LogMessage {
+	entry := message.WithoutBodies()
+	entry.BodiesOmitted = message.RequestBody != "" || message.ResponseBody != ""
+	return entry
+}
@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"net/url"
+	"time"
+
+	"ccproxy/types"
+)
+
+// targetCounter 累计单个上游地址的统计, latency 为耗时总和, 用于计算平均值
+type targetCounter struct {
+	stats     types.TargetStats
+	latency   time.Duration
+	max       time.Duration
+	histogram latencyHistogram
+}
+
+// targetKey 返回统计使用的上游地址, 未转发的请求返回空字符串
+func targetKey(targetURL string) string {
+	u, err := url.Parse(targetURL)
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+// updateTargetStats 累计一条请求, 调用方持有 statsMu
+func (h *Hub) updateTargetStats(message *LogMessage) {
+	key := targetKey(message.TargetURL)
+	if key == "" {
+		return
+	}
+	counter := h.targets[key]
+	if counter == nil {
+		counter = &targetCounter{}
+		h.targets[key] = counter
+	}
+
+	counter.stats.TotalRequests++
+	if message.StatusCode >= 200 && message.StatusCode < 400 {
+		counter.stats.SuccessRequests++
+	} else {
+		counter.stats.ErrorRequests++
+	}
+	counter.stats.RequestBytes += message.RequestSize
+	counter.stats.ResponseBytes += message.ResponseSize
+	counter.stats.LastRequestTime = time.Now()
+	if d, err := time.ParseDuration(message.Duration); err == nil {
+		counter.latency += d
+		counter.max = max(counter.max, d)
+		counter.histogram.record(d)
+	}
+}
+
+// targetStats 返回各上游地址统计的拷贝, 调用方持有 statsMu
+func (h *Hub) targetStats() map[string]*types.TargetStats {
+	targets := make(map[string]*types.TargetStats, len(h.targets))
+	for key, counter := range h.targets {
+		stats := counter.stats
+		stats.AvgLatencyMs = milliseconds(counter.latency / time.Duration(stats.TotalRequests))
+		stats.MaxLatencyMs = milliseconds(counter.max)
+		stats.Latency = counter.histogram.percentiles()
+		targets[key] = &stats
+	}
+	return targets
+}
+
+// GetTargetStats 返回各上游地址的统计
+func (h *Hub) GetTargetStats() map[string]*types.TargetStats {
+	h.statsMu.RLock()
+	defer h.statsMu.RUnlock()
+	return h.targetStats()
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d.Microseconds()) / 1000
+}
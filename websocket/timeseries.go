@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"time"
+
+	"ccproxy/types"
+)
+
+// 时间序列按分钟累计, 保留最近 24 小时
+const (
+	SeriesResolution = time.Minute
+	SeriesRetention  = 24 * time.Hour
+	seriesLength     = int(SeriesRetention / SeriesResolution)
+)
+
+// latencyBounds 时间序列中耗时区间的上限, 超过最后一个上限的计入额外的区间
+var latencyBounds = []time.Duration{
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	2500 * time.Millisecond,
+	5 * time.Second,
+	10 * time.Second,
+	30 * time.Second,
+	time.Minute,
+}
+
+// seriesBucket 一分钟内的请求统计
+type seriesBucket struct {
+	start    time.Time // 所在的分钟, 为零值或早于 24 小时前时表示未使用
+	requests int64
+	errors   int64
+	latency  time.Duration // 耗时总和, 用于计算平均值
+	measured int64         // 记录了耗时的请求数
+	buckets  []int64
+}
+
+// timeSeries 按分钟循环使用的统计, 内存固定
+type timeSeries struct {
+	buckets [seriesLength]seriesBucket
+}
+
+func (s *timeSeries) record(at time.Time, failed bool, d time.Duration, measured bool) {
+	minute := at.Truncate(SeriesResolution)
+	b := &s.buckets[int(minute.Unix()/int64(SeriesResolution/time.Second))%seriesLength]
+	if !b.start.Equal(minute) {
+		*b = seriesBucket{start: minute, buckets: make([]int64, len(latencyBounds)+1)}
+	}
+
+	b.requests++
+	if failed {
+		b.errors++
+	}
+	if measured {
+		b.latency += d
+		b.measured++
+		i := 0
+		for i < len(latencyBounds) && d > latencyBounds[i] {
+			i++
+		}
+		b.buckets[i]++
+	}
+}
+
+// series 返回截至 now 的 window 内每个 step 的统计. step 是
+// SeriesResolution 的整数倍, window 是 step 的整数倍且不超过 SeriesRetention.
+func (s *timeSeries) series(now time.Time, window, step time.Duration) *types.MetricsSeries {
+	result := &types.MetricsSeries{
+		Window: window.String(),
+		Step:   step.String(),
+		Points: make([]types.MetricsPoint, 0, window/step),
+	}
+	for _, bound := range latencyBounds {
+		result.LatencyBucketsMs = append(result.LatencyBucketsMs, milliseconds(bound))
+	}
+
+	last := now.Truncate(step)
+	for start := last.Add(step - window); !start.After(last); start = start.Add(step) {
+		point := types.MetricsPoint{Time: start, LatencyBuckets: make([]int64, len(latencyBounds)+1)}
+		var latency time.Duration
+		var measured int64
+		for minute := start; minute.Before(start.Add(step)); minute = minute.Add(SeriesResolution) {
+			b := &s.buckets[int(minute.Unix()/int64(SeriesResolution/time.Second))%seriesLength]
+			if !b.start.Equal(minute) {
+				continue
+			}
+			point.Requests += b.requests
+			point.Errors += b.errors
+			latency += b.latency
+			measured += b.measured
+			for i, count := range b.buckets {
+				point.LatencyBuckets[i] += count
+			}
+		}
+
+		point.RequestRate = float64(point.Requests) / step.Seconds()
+		if point.Requests > 0 {
+			point.ErrorRate = float64(point.Errors) / float64(point.Requests)
+		}
+		if measured > 0 {
+			point.AvgLatencyMs = milliseconds(latency / time.Duration(measured))
+		}
+		result.Points = append(result.Points, point)
+	}
+	return result
+}
+
+// GetTimeSeries 返回最近 window 内按 step 汇总的请求统计, 参数的要求见 timeSeries.series
+func (h *Hub) GetTimeSeries(window, step time.Duration) *types.MetricsSeries {
+	h.statsMu.RLock()
+	defer h.statsMu.RUnlock()
+	return h.series.series(time.Now(), window, step)
+}